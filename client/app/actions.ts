@@ -5,29 +5,47 @@ import { redirect } from "next/navigation";
 
 const API_URL = process.env.API_URL || "http://0.0.0.0:8080";
 
+// poolQuery selects one of the server's cluster pools when it serves several,
+// starting a query string with "?" or continuing one with "&".
+function poolQuery(pool: string | undefined, sep = "?"): string {
+  return pool ? `${sep}pool=${encodeURIComponent(pool)}` : "";
+}
+
 interface ClaimResult {
   success: true;
   data: {
     webConsoleURL: string;
     aiConsoleURL: string;
+    consoles?: { name: string; url: string }[];
     kubeconfig: string;
     expiresAt: string;
+    graceEndsAt?: string;
+    magicLink?: string;
   };
 }
 
 interface ClaimError {
   success: false;
   error: string;
+  redirect?: string;
 }
 
 export interface AdminClaimInfo {
   name: string;
+  index?: string;
   pool: string;
   phone: string;
   authenticated: boolean;
   namespace: string;
   age: string;
+  assignedAt?: string;
   expiresAt?: string;
+  estimated?: boolean;
+  inGrace?: boolean;
+  reserved?: boolean;
+  parked?: boolean;
+  note?: string;
+  track?: string;
 }
 
 export interface AdminDeploymentInfo {
@@ -66,17 +84,18 @@ interface LoginError {
 }
 
 export async function loginAdmin(
-  password: string
+  password: string,
+  username = ""
 ): Promise<LoginResult | LoginError> {
   try {
     const res = await fetch(`${API_URL}/api/admin/login`, {
       method: "POST",
       headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({ password }),
+      body: JSON.stringify({ username, password }),
     });
 
     if (!res.ok) {
-      return { success: false, error: "Invalid password" };
+      return { success: false, error: username ? "Invalid username or password" : "Invalid password" };
     }
 
     const data = await res.json();
@@ -96,11 +115,23 @@ export async function loginAdmin(
 
 export async function logoutAdmin(): Promise<void> {
   const cookieStore = await cookies();
+  const token = cookieStore.get("prelude-admin-session")?.value || "";
+  if (token) {
+    try {
+      // Revoke the token server-side too, not just the cookie
+      await fetch(`${API_URL}/api/admin/logout`, {
+        method: "POST",
+        headers: { Authorization: `Bearer ${token}` },
+      });
+    } catch {
+      // server unreachable, the token expires on its own
+    }
+  }
   cookieStore.delete("prelude-admin-session");
   redirect("/admin/login");
 }
 
-export async function getAdminData(): Promise<AdminResult | AdminError> {
+export async function getAdminData(pool?: string): Promise<AdminResult | AdminError> {
   try {
     const cookieStore = await cookies();
     const token = cookieStore.get("prelude-admin-session")?.value || "";
@@ -108,10 +139,13 @@ export async function getAdminData(): Promise<AdminResult | AdminError> {
     if (token) {
       headers["Authorization"] = `Bearer ${token}`;
     }
-    const res = await fetch(`${API_URL}/api/admin`, { headers });
+    const res = await fetch(`${API_URL}/api/admin${poolQuery(pool)}`, { headers });
     if (res.status === 401) {
       return { success: false, error: "unauthorized" };
     }
+    if (res.status === 400) {
+      return { success: false, error: "This server runs several cluster pools, open /admin?pool=<name>" };
+    }
     if (!res.ok) {
       return { success: false, error: "Failed to fetch admin data" };
     }
@@ -122,24 +156,322 @@ export async function getAdminData(): Promise<AdminResult | AdminError> {
   }
 }
 
+export async function setAdminNote(
+  name: string,
+  note: string,
+  pool?: string
+): Promise<LoginResult | LoginError> {
+  try {
+    const cookieStore = await cookies();
+    const token = cookieStore.get("prelude-admin-session")?.value || "";
+    const res = await fetch(`${API_URL}/api/admin/note${poolQuery(pool)}`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        Authorization: `Bearer ${token}`,
+      },
+      body: JSON.stringify({ name, note }),
+    });
+    if (res.status === 401) {
+      return { success: false, error: "unauthorized" };
+    }
+    if (!res.ok) {
+      const body = await res.json();
+      return { success: false, error: body.message || "Failed to update note" };
+    }
+    return { success: true };
+  } catch {
+    return { success: false, error: "Failed to connect to cluster service" };
+  }
+}
+
+// setAdminParked parks a claim (keeping it out of assignment) or returns it
+// to the pool.
+export async function setAdminParked(
+  name: string,
+  parked: boolean,
+  pool?: string
+): Promise<LoginResult | LoginError> {
+  try {
+    const cookieStore = await cookies();
+    const token = cookieStore.get("prelude-admin-session")?.value || "";
+    const res = await fetch(`${API_URL}/api/admin/park${poolQuery(pool)}`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        Authorization: `Bearer ${token}`,
+      },
+      body: JSON.stringify({ name, parked }),
+    });
+    if (res.status === 401) {
+      return { success: false, error: "unauthorized" };
+    }
+    if (!res.ok) {
+      const body = await res.json();
+      return { success: false, error: body.message || "Failed to update hold" };
+    }
+    return { success: true };
+  } catch {
+    return { success: false, error: "Failed to connect to cluster service" };
+  }
+}
+
+// releaseAdminClaim frees a claim from its phone. The authenticator
+// re-verifies the cluster before it is handed out again.
+export async function releaseAdminClaim(
+  name: string,
+  pool?: string
+): Promise<LoginResult | LoginError> {
+  try {
+    const cookieStore = await cookies();
+    const token = cookieStore.get("prelude-admin-session")?.value || "";
+    const res = await fetch(`${API_URL}/api/admin/release${poolQuery(pool)}`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        Authorization: `Bearer ${token}`,
+      },
+      body: JSON.stringify({ name }),
+    });
+    if (res.status === 401) {
+      return { success: false, error: "unauthorized" };
+    }
+    if (!res.ok) {
+      const body = await res.json();
+      return { success: false, error: body.message || "Failed to release cluster" };
+    }
+    return { success: true };
+  } catch {
+    return { success: false, error: "Failed to connect to cluster service" };
+  }
+}
+
+// rebindAdminClaim moves a claim's phone onto a fresh cluster by replacing
+// the claim. The phone sees cluster_authenticating until the new one is ready.
+export async function rebindAdminClaim(
+  name: string,
+  pool?: string
+): Promise<LoginResult | LoginError> {
+  try {
+    const cookieStore = await cookies();
+    const token = cookieStore.get("prelude-admin-session")?.value || "";
+    const res = await fetch(`${API_URL}/api/admin/rebind${poolQuery(pool)}`, {
+      method: "POST",
+      headers: {
+        "Content-Type": "application/json",
+        Authorization: `Bearer ${token}`,
+      },
+      body: JSON.stringify({ name }),
+    });
+    if (res.status === 401) {
+      return { success: false, error: "unauthorized" };
+    }
+    if (!res.ok) {
+      const body = await res.json();
+      return { success: false, error: body.message || "Failed to rebind cluster" };
+    }
+    return { success: true };
+  } catch {
+    return { success: false, error: "Failed to connect to cluster service" };
+  }
+}
+
+// validatePhoneNumber asks the server whether a phone would be accepted by a
+// claim, without touching any cluster. Returns null when it can't be checked.
+export async function validatePhoneNumber(
+  phone: string,
+  recaptchaToken: string,
+  fingerprint: string
+): Promise<{ valid: boolean; reason?: string } | null> {
+  try {
+    const res = await fetch(`${API_URL}/api/validate`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ phone, recaptchaToken, fingerprint }),
+    });
+    if (!res.ok) {
+      return null;
+    }
+    return await res.json();
+  } catch {
+    return null;
+  }
+}
+
+// checkClaimExists reports whether a phone already has a cluster, so the page
+// can offer to resume it. Returns null when it can't be checked.
+export async function checkClaimExists(
+  phone: string,
+  pool?: string
+): Promise<{ exists: boolean; authenticated: boolean; ready: boolean } | null> {
+  try {
+    const res = await fetch(`${API_URL}/api/claim/exists?phone=${encodeURIComponent(phone)}${poolQuery(pool, "&")}`);
+    if (!res.ok) {
+      return null;
+    }
+    return await res.json();
+  } catch {
+    return null;
+  }
+}
+
+export async function exportAssignments(pool?: string): Promise<
+  { success: true; csv: string; filename: string } | LoginError
+> {
+  try {
+    const cookieStore = await cookies();
+    const token = cookieStore.get("prelude-admin-session")?.value || "";
+    const res = await fetch(`${API_URL}/api/admin/export?format=csv${poolQuery(pool, "&")}`, {
+      headers: { Authorization: `Bearer ${token}` },
+    });
+    if (res.status === 401) {
+      return { success: false, error: "unauthorized" };
+    }
+    if (!res.ok) {
+      return { success: false, error: "Failed to export assignments" };
+    }
+    const disposition = res.headers.get("Content-Disposition") || "";
+    const match = disposition.match(/filename="([^"]+)"/);
+    return {
+      success: true,
+      csv: await res.text(),
+      filename: match ? match[1] : "prelude-assignments.csv",
+    };
+  } catch {
+    return { success: false, error: "Failed to connect to cluster service" };
+  }
+}
+
+// extendClaim asks the server to extend the phone's cluster, proving
+// ownership with the admin password chosen at claim time.
+export async function extendClaim(
+  phone: string,
+  password: string,
+  duration: string,
+  recaptchaToken: string,
+  pool?: string
+): Promise<{ success: true; expiresAt: string; graceEndsAt?: string } | ClaimError> {
+  try {
+    const res = await fetch(`${API_URL}/api/extend${poolQuery(pool)}`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ phone, password, duration, recaptchaToken }),
+    });
+    if (!res.ok) {
+      const body = await res.json();
+      return { success: false, error: body.error || "Failed to extend cluster" };
+    }
+    const body = await res.json();
+    return { success: true, expiresAt: body.expiresAt, graceEndsAt: body.graceEndsAt };
+  } catch {
+    return { success: false, error: "Failed to connect to cluster service" };
+  }
+}
+
+// redeemMagicLink exchanges a one-time magic link token for the cluster's
+// console URL and generated admin credentials. The token is spent either way.
+export async function redeemMagicLink(
+  token: string
+): Promise<
+  { success: true; username: string; password: string; webConsoleURL: string } | ClaimError
+> {
+  try {
+    const res = await fetch(`${API_URL}/api/magic`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ token }),
+    });
+    if (!res.ok) {
+      return { success: false, error: "invalid_magic_link" };
+    }
+    const body = await res.json();
+    return { success: true, ...body };
+  } catch {
+    return { success: false, error: "Failed to connect to cluster service" };
+  }
+}
+
+// claimErrorCodes are the claim errors the claim page explains itself.
+const claimErrorCodes = new Set([
+  "all_clusters_in_use",
+  "cluster_unavailable",
+  "suspicious_activity",
+  "console_not_ready",
+  "reservation_expired",
+  "rate_limited",
+  "warming_up",
+  "cluster_authenticating",
+  "device_already_claimed",
+]);
+
+// reserveCluster holds a cluster for the phone without setting credentials,
+// for two-phase claims. confirmed means the phone's cluster was already
+// committed and can be fetched with claimCluster right away.
+export async function reserveCluster(
+  phone: string,
+  recaptchaToken: string,
+  fingerprint: string,
+  track?: string,
+  pool?: string
+): Promise<
+  { success: true; reservation?: string; reservedUntil?: string; confirmed?: boolean } | ClaimError
+> {
+  try {
+    const res = await fetch(`${API_URL}/api/claim?phase=reserve${poolQuery(pool, "&")}`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ phone, recaptchaToken, fingerprint, track }),
+    });
+    if (!res.ok) {
+      try {
+        const body = await res.json();
+        if (body.error === "pool_required" || body.error === "unknown_pool") {
+          return { success: false, error: "unknown_pool" };
+        }
+        if (body.error) {
+          // The claim page has its own text for these, otherwise show the server's
+          const error = claimErrorCodes.has(body.error) ? body.error : body.message || body.error;
+          return { success: false, error, redirect: body.redirect };
+        }
+      } catch {
+        // not JSON, fall through
+      }
+      return { success: false, error: "Failed to reserve cluster" };
+    }
+    if (res.status === 202) {
+      const body = await res.json();
+      return { success: false, error: body.error || "console_not_ready" };
+    }
+    return { success: true, ...(await res.json()) };
+  } catch {
+    return { success: false, error: "Failed to connect to cluster service" };
+  }
+}
+
 export async function claimCluster(
   phone: string,
   password: string,
   recaptchaToken: string,
-  fingerprint: string
+  fingerprint: string,
+  track?: string,
+  reservation?: string,
+  pool?: string
 ): Promise<ClaimResult | ClaimError> {
   try {
-    const res = await fetch(`${API_URL}/api/claim`, {
+    // With a reservation this is the confirm phase of a two-phase claim
+    const query =
+      reservation !== undefined ? `?phase=confirm${poolQuery(pool, "&")}` : poolQuery(pool);
+    const res = await fetch(`${API_URL}/api/claim${query}`, {
       method: "POST",
       headers: { "Content-Type": "application/json" },
-      body: JSON.stringify({ phone, password, recaptchaToken, fingerprint }),
+      body: JSON.stringify({ phone, password, recaptchaToken, fingerprint, track, reservation }),
     });
 
     if (!res.ok) {
       try {
         const body = await res.json();
         if (body.error === "all_clusters_in_use") {
-          return { success: false, error: "all_clusters_in_use" };
+          return { success: false, error: "all_clusters_in_use", redirect: body.redirect };
         }
         if (body.error === "device_already_claimed") {
           return { success: false, error: "device_already_claimed" };
@@ -147,12 +479,35 @@ export async function claimCluster(
         if (body.error === "cluster_unavailable") {
           return { success: false, error: "cluster_unavailable" };
         }
+        if (body.error === "suspicious_activity") {
+          return { success: false, error: "suspicious_activity" };
+        }
+        if (body.error === "warming_up") {
+          return { success: false, error: "warming_up" };
+        }
+        if (body.error === "rate_limited") {
+          return { success: false, error: "rate_limited" };
+        }
+        if (body.error === "pool_required" || body.error === "unknown_pool") {
+          return { success: false, error: "unknown_pool" };
+        }
+        if (body.error === "reservation_expired" || body.error === "invalid_reservation") {
+          return { success: false, error: "reservation_expired" };
+        }
+        if (body.message) {
+          return { success: false, error: body.message };
+        }
       } catch {
         // not JSON, fall through
       }
       return { success: false, error: "Failed to claim cluster" };
     }
 
+    if (res.status === 202) {
+      const body = await res.json();
+      return { success: false, error: body.error || "console_not_ready" };
+    }
+
     const data = await res.json();
     return { success: true, data };
   } catch {
@@ -24,6 +24,7 @@ function EyeOffIcon() {
 }
 
 export default function AdminLoginPage() {
+  const [username, setUsername] = useState("");
   const [password, setPassword] = useState("");
   const [showPassword, setShowPassword] = useState(false);
   const [error, setError] = useState("");
@@ -36,7 +37,7 @@ export default function AdminLoginPage() {
     setLoading(true);
 
     try {
-      const result = await loginAdmin(password);
+      const result = await loginAdmin(password, username.trim());
       if (result.success) {
         router.push("/admin");
       } else {
@@ -82,11 +83,21 @@ export default function AdminLoginPage() {
               Admin Dashboard
             </h1>
             <p className="font-rh-text text-rh-gray-40 text-base mb-8">
-              Enter the admin password to access the cluster dashboard.
+              Enter your operator name and password, the shared admin password with no name, or your OpenShift token from <code>oc whoami -t</code> to access the cluster dashboard.
             </p>
 
             <form onSubmit={handleSubmit}>
               <div className="flex flex-col gap-4">
+                <label htmlFor="admin-username" className="sr-only">Operator name</label>
+                <input
+                  id="admin-username"
+                  type="text"
+                  value={username}
+                  onChange={(e) => setUsername(e.target.value)}
+                  placeholder="Operator name (optional)"
+                  autoComplete="username"
+                  className="w-full px-5 py-4 bg-rh-gray-90 border border-rh-gray-70 text-white font-rh-text text-base placeholder-rh-gray-50 focus:outline-none focus:border-rh-red-50 focus:ring-1 focus:ring-rh-red-50 transition-colors"
+                />
                 <div className="relative">
                   <label htmlFor="admin-password" className="sr-only">Admin password</label>
                   <input
@@ -96,8 +107,9 @@ export default function AdminLoginPage() {
                     onChange={(e) => setPassword(e.target.value)}
                     placeholder="Admin password"
                     className="w-full px-5 py-4 pr-12 bg-rh-gray-90 border border-rh-gray-70 text-white font-rh-text text-base placeholder-rh-gray-50 focus:outline-none focus:border-rh-red-50 focus:ring-1 focus:ring-rh-red-50 transition-colors"
-                    required
+                    autoComplete="current-password"
                     autoFocus
+                    required
                   />
                   <button
                     type="button"
@@ -5,6 +5,11 @@ import { useRouter } from "next/navigation";
 import {
   getAdminData,
   logoutAdmin,
+  setAdminNote,
+  setAdminParked,
+  releaseAdminClaim,
+  rebindAdminClaim,
+  exportAssignments,
   AdminClaimInfo,
   AdminDeploymentInfo,
 } from "../actions";
@@ -19,6 +24,12 @@ function RefreshIcon() {
   );
 }
 
+// pagePool is the cluster pool picked with /admin?pool=<name>, needed when
+// the server runs several pools.
+function pagePool(): string | undefined {
+  return new URLSearchParams(window.location.search).get("pool") || undefined;
+}
+
 export default function AdminPage() {
   const [claims, setClaims] = useState<AdminClaimInfo[]>([]);
   const [deployments, setDeployments] = useState<AdminDeploymentInfo[]>([]);
@@ -31,7 +42,7 @@ export default function AdminPage() {
   const fetchData = useCallback(async () => {
     setLoading(true);
     setError("");
-    const result = await getAdminData();
+    const result = await getAdminData(pagePool());
     if (result.success) {
       setClaims(result.data.clusterClaims);
       setDeployments(result.data.clusterDeployments);
@@ -46,6 +57,86 @@ export default function AdminPage() {
     setLoading(false);
   }, [router]);
 
+  async function editNote(claim: AdminClaimInfo) {
+    const note = window.prompt(`Note for ${claim.name} (leave empty to clear)`, claim.note || "");
+    if (note === null) {
+      return;
+    }
+    const result = await setAdminNote(claim.name, note, claim.pool);
+    if (!result.success) {
+      if (result.error === "unauthorized") {
+        router.push("/admin/login");
+        return;
+      }
+      setError(result.error);
+      return;
+    }
+    fetchData();
+  }
+
+  async function togglePark(claim: AdminClaimInfo) {
+    const result = await setAdminParked(claim.name, !claim.parked, claim.pool);
+    if (!result.success) {
+      if (result.error === "unauthorized") {
+        router.push("/admin/login");
+        return;
+      }
+      setError(result.error);
+      return;
+    }
+    fetchData();
+  }
+
+  async function releaseClaim(claim: AdminClaimInfo) {
+    if (!window.confirm(`Release ${claim.name} from ${claim.phone}? They lose access to the cluster.`)) {
+      return;
+    }
+    const result = await releaseAdminClaim(claim.name, claim.pool);
+    if (!result.success) {
+      if (result.error === "unauthorized") {
+        router.push("/admin/login");
+        return;
+      }
+      setError(result.error);
+      return;
+    }
+    fetchData();
+  }
+
+  async function rebindClaim(claim: AdminClaimInfo) {
+    if (!window.confirm(`Move ${claim.phone} from ${claim.name} to a fresh cluster? The current cluster is deleted.`)) {
+      return;
+    }
+    const result = await rebindAdminClaim(claim.name, claim.pool);
+    if (!result.success) {
+      if (result.error === "unauthorized") {
+        router.push("/admin/login");
+        return;
+      }
+      setError(result.error);
+      return;
+    }
+    fetchData();
+  }
+
+  async function downloadExport() {
+    const result = await exportAssignments(pagePool());
+    if (!result.success) {
+      if (result.error === "unauthorized") {
+        router.push("/admin/login");
+        return;
+      }
+      setError(result.error);
+      return;
+    }
+    const url = URL.createObjectURL(new Blob([result.csv], { type: "text/csv" }));
+    const link = document.createElement("a");
+    link.href = url;
+    link.download = result.filename;
+    link.click();
+    URL.revokeObjectURL(url);
+  }
+
   useEffect(() => {
     fetchData();
     const interval = setInterval(fetchData, 30000);
@@ -113,16 +204,24 @@ export default function AdminPage() {
                 </p>
               )}
             </div>
-            <button
-              onClick={fetchData}
-              disabled={loading}
-              className="flex items-center gap-2 px-4 py-2 bg-rh-gray-80 text-rh-gray-30 font-rh-text text-sm font-medium border border-rh-gray-70 hover:border-rh-gray-50 hover:text-white disabled:opacity-50 transition-colors"
-            >
-              <span className={loading ? "animate-spin" : ""}>
-                <RefreshIcon />
-              </span>
-              <span>Refresh</span>
-            </button>
+            <div className="flex items-center gap-3">
+              <button
+                onClick={downloadExport}
+                className="flex items-center gap-2 px-4 py-2 bg-rh-gray-80 text-rh-gray-30 font-rh-text text-sm font-medium border border-rh-gray-70 hover:border-rh-gray-50 hover:text-white transition-colors"
+              >
+                <span>Export CSV</span>
+              </button>
+              <button
+                onClick={fetchData}
+                disabled={loading}
+                className="flex items-center gap-2 px-4 py-2 bg-rh-gray-80 text-rh-gray-30 font-rh-text text-sm font-medium border border-rh-gray-70 hover:border-rh-gray-50 hover:text-white disabled:opacity-50 transition-colors"
+              >
+                <span className={loading ? "animate-spin" : ""}>
+                  <RefreshIcon />
+                </span>
+                <span>Refresh</span>
+              </button>
+            </div>
           </div>
 
           {/* Summary Tiles */}
@@ -186,20 +285,29 @@ export default function AdminPage() {
                     <th className="text-left px-6 py-3 font-rh-text font-semibold text-rh-gray-60 uppercase text-xs tracking-wider">Namespace</th>
                     <th className="text-left px-6 py-3 font-rh-text font-semibold text-rh-gray-60 uppercase text-xs tracking-wider">Expires</th>
                     <th className="text-left px-6 py-3 font-rh-text font-semibold text-rh-gray-60 uppercase text-xs tracking-wider">Age</th>
+                    <th className="text-left px-6 py-3 font-rh-text font-semibold text-rh-gray-60 uppercase text-xs tracking-wider">Note</th>
                   </tr>
                 </thead>
                 <tbody>
                   {claims.length === 0 && !loading && (
                     <tr>
-                      <td colSpan={7} className="px-6 py-8 text-center font-rh-text text-rh-gray-50">
+                      <td colSpan={8} className="px-6 py-8 text-center font-rh-text text-rh-gray-50">
                         No cluster claims found
                       </td>
                     </tr>
                   )}
                   {claims.map((claim) => (
                     <tr key={claim.name} className="border-b border-rh-gray-20 last:border-b-0 hover:bg-rh-gray-10/50">
-                      <td className="px-6 py-3 font-rh-text font-medium text-rh-gray-95">{claim.name}</td>
-                      <td className="px-6 py-3 font-mono text-rh-gray-60 text-xs">{claim.phone || "\u2014"}</td>
+                      <td className="px-6 py-3 font-rh-text font-medium text-rh-gray-95">
+                        {claim.name}
+                        {claim.index && <span className="ml-2 font-rh-text text-rh-gray-50 text-xs">#{claim.index}</span>}
+                      </td>
+                      <td className="px-6 py-3 font-mono text-rh-gray-60 text-xs">
+                        {claim.phone || "\u2014"}
+                        {claim.track && <span className="ml-2 text-rh-gray-50">({claim.track})</span>}
+                        {claim.reserved && <span className="ml-2 text-rh-gray-50" title="Reserved, waiting for confirmation">(reserved)</span>}
+                        {claim.parked && <span className="ml-2 text-rh-red-50" title="Held back from assignment by an admin">(parked)</span>}
+                      </td>
                       <td className="px-6 py-3">
                         {claim.authenticated ? (
                           <span className="inline-flex items-center gap-1.5 px-2.5 py-0.5 text-xs font-rh-text font-medium bg-green-50 text-green-700 border border-green-200">
@@ -226,7 +334,7 @@ export default function AdminPage() {
                       <td className="px-6 py-3 font-mono text-rh-gray-60 text-xs">{claim.namespace || "\u2014"}</td>
                       <td className="px-6 py-3 font-rh-text text-rh-gray-60 text-xs">
                         {claim.expiresAt
-                          ? new Date(claim.expiresAt).toLocaleString(undefined, {
+                          ? (claim.estimated ? "~" : "") + new Date(claim.expiresAt).toLocaleString(undefined, {
                               month: "short",
                               day: "numeric",
                               hour: "2-digit",
@@ -235,8 +343,44 @@ export default function AdminPage() {
                               timeZoneName: "short",
                             })
                           : "\u2014"}
+                        {claim.estimated && <span className="ml-1 text-rh-gray-40" title="Estimated from --default-lifetime">(est.)</span>}
+                        {claim.inGrace && <span className="ml-1 text-rh-red-50" title="Past expiry, within --expiry-grace">(in grace)</span>}
                       </td>
                       <td className="px-6 py-3 font-rh-text text-rh-gray-60">{claim.age}</td>
+                      <td className="px-6 py-3 font-rh-text text-rh-gray-60 text-xs">
+                        <button
+                          onClick={() => editNote(claim)}
+                          className="text-left hover:text-rh-gray-95 transition-colors"
+                          title="Edit note"
+                        >
+                          {claim.note || "\u2014"}
+                        </button>
+                        <button
+                          onClick={() => togglePark(claim)}
+                          className="ml-3 text-rh-gray-50 hover:text-rh-gray-95 transition-colors"
+                          title={claim.parked ? "Return this cluster to the pool" : "Keep this cluster out of assignment"}
+                        >
+                          {claim.parked ? "Unpark" : "Park"}
+                        </button>
+                        {claim.phone && (
+                          <button
+                            onClick={() => releaseClaim(claim)}
+                            className="ml-3 text-rh-gray-50 hover:text-rh-red-50 transition-colors"
+                            title="Free this cluster from its phone"
+                          >
+                            Release
+                          </button>
+                        )}
+                        {claim.phone && (
+                          <button
+                            onClick={() => rebindClaim(claim)}
+                            className="ml-3 text-rh-gray-50 hover:text-rh-red-50 transition-colors"
+                            title="Replace this phone's cluster with a fresh one"
+                          >
+                            Rebind
+                          </button>
+                        )}
+                      </td>
                     </tr>
                   ))}
                 </tbody>
@@ -0,0 +1,108 @@
+"use client";
+
+import { createContext, useContext, useEffect, useState } from "react";
+import { GoogleReCaptchaProvider, useGoogleReCaptcha } from "react-google-recaptcha-v3";
+
+// executeCaptcha returns a token for the given action, or is undefined until
+// the provider has loaded (or when no captcha is configured).
+type ExecuteCaptcha = ((action: string) => Promise<string>) | undefined;
+
+const CaptchaContext = createContext<ExecuteCaptcha>(undefined);
+
+export function useCaptcha() {
+  return { executeCaptcha: useContext(CaptchaContext) };
+}
+
+interface HCaptchaAPI {
+  render(container: HTMLElement, params: { sitekey: string; size: "invisible" }): string;
+  execute(widgetId: string, options: { async: true }): Promise<{ response: string }>;
+  reset(widgetId: string): void;
+}
+
+declare global {
+  interface Window {
+    hcaptcha?: HCaptchaAPI;
+    preludeHCaptchaLoaded?: () => void;
+  }
+}
+
+function ReCaptchaBridge({ children }: { children: React.ReactNode }) {
+  const { executeRecaptcha } = useGoogleReCaptcha();
+  return <CaptchaContext.Provider value={executeRecaptcha}>{children}</CaptchaContext.Provider>;
+}
+
+// HCaptchaProvider loads hCaptcha's script and renders one invisible widget.
+// hCaptcha has no actions, so the action name is ignored.
+function HCaptchaProvider({ siteKey, children }: { siteKey: string; children: React.ReactNode }) {
+  const [execute, setExecute] = useState<ExecuteCaptcha>(undefined);
+
+  useEffect(() => {
+    const container = document.createElement("div");
+    document.body.appendChild(container);
+
+    window.preludeHCaptchaLoaded = () => {
+      const hcaptcha = window.hcaptcha;
+      if (!hcaptcha) {
+        return;
+      }
+      const widgetId = hcaptcha.render(container, { sitekey: siteKey, size: "invisible" });
+      setExecute(() => async () => {
+        try {
+          const { response } = await hcaptcha.execute(widgetId, { async: true });
+          return response;
+        } finally {
+          // Tokens are single use, so the next call needs a fresh challenge
+          hcaptcha.reset(widgetId);
+        }
+      });
+    };
+
+    const script = document.createElement("script");
+    script.src = "https://js.hcaptcha.com/1/api.js?render=explicit&onload=preludeHCaptchaLoaded";
+    script.async = true;
+    document.head.appendChild(script);
+
+    return () => {
+      script.remove();
+      container.remove();
+      delete window.preludeHCaptchaLoaded;
+    };
+  }, [siteKey]);
+
+  return <CaptchaContext.Provider value={execute}>{children}</CaptchaContext.Provider>;
+}
+
+export default function CaptchaProvider({
+  children,
+}: {
+  children: React.ReactNode;
+}) {
+  const [provider, setProvider] = useState("");
+  const [siteKey, setSiteKey] = useState("");
+
+  useEffect(() => {
+    fetch("/api/config")
+      .then((res) => res.json())
+      .then((data) => {
+        if (data.captchaSiteKey) {
+          setProvider(data.captchaProvider || "recaptcha");
+          setSiteKey(data.captchaSiteKey);
+        }
+      })
+      .catch(() => {});
+  }, []);
+
+  if (!siteKey) {
+    return <>{children}</>;
+  }
+
+  if (provider === "hcaptcha") {
+    return <HCaptchaProvider siteKey={siteKey}>{children}</HCaptchaProvider>;
+  }
+
+  return (
+    <GoogleReCaptchaProvider reCaptchaKey={siteKey}>
+      <ReCaptchaBridge>{children}</ReCaptchaBridge>
+    </GoogleReCaptchaProvider>
+  );
+}
@@ -37,5 +37,6 @@ export async function getFingerprint(): Promise<string> {
   const hashArray = Array.from(new Uint8Array(hashBuffer));
   const hashHex = hashArray.map((b) => b.toString(16).padStart(2, "0")).join("");
 
-  return hashHex.slice(0, 16);
+  // The server truncates to its --fingerprint-length (max 32)
+  return hashHex.slice(0, 32);
 }
@@ -1,7 +1,7 @@
 import type { Metadata } from "next";
 import Script from "next/script";
 import "./globals.css";
-import ReCaptchaProvider from "./recaptcha-provider";
+import CaptchaProvider from "./captcha-provider";
 
 export const metadata: Metadata = {
   title: "Prelude - Cluster Access",
@@ -30,7 +30,7 @@ export default function RootLayout({
         </Script>
       </head>
       <body className="bg-rh-gray-10 min-h-screen font-rh-text text-rh-gray-95">
-        <ReCaptchaProvider>{children}</ReCaptchaProvider>
+        <CaptchaProvider>{children}</CaptchaProvider>
       </body>
     </html>
   );
@@ -0,0 +1,126 @@
+"use client";
+
+import { useEffect, useRef, useState } from "react";
+import { redeemMagicLink } from "../actions";
+
+interface Credentials {
+  username: string;
+  password: string;
+  webConsoleURL: string;
+}
+
+export default function MagicLinkPage() {
+  const [credentials, setCredentials] = useState<Credentials | null>(null);
+  const [error, setError] = useState("");
+  const [copied, setCopied] = useState(false);
+  // Tokens are single use, so never redeem twice (e.g. a re-run effect)
+  const redeemed = useRef(false);
+
+  useEffect(() => {
+    if (redeemed.current) {
+      return;
+    }
+    redeemed.current = true;
+    const token = new URLSearchParams(window.location.search).get("token") || "";
+    if (!token) {
+      setError("This link is missing its token.");
+      return;
+    }
+    redeemMagicLink(token).then((result) => {
+      if (result.success) {
+        setCredentials(result);
+      } else if (result.error === "invalid_magic_link") {
+        setError("This link has already been used or has expired. Claim again with your phone number for a new one.");
+      } else {
+        setError(result.error);
+      }
+    });
+  }, []);
+
+  async function copyPassword() {
+    if (!credentials) {
+      return;
+    }
+    try {
+      await navigator.clipboard.writeText(credentials.password);
+      setCopied(true);
+      setTimeout(() => setCopied(false), 2000);
+    } catch {
+      // clipboard unavailable, the password is shown anyway
+    }
+  }
+
+  return (
+    <div className="min-h-screen">
+      {/* Navigation Bar */}
+      <nav className="bg-rh-gray-95 border-b border-rh-gray-80">
+        <div className="max-w-7xl mx-auto px-6 lg:px-8">
+          <div className="flex items-center justify-between h-16">
+            <div className="flex items-center gap-3">
+              <div className="w-9 h-9 relative flex items-center justify-center">
+                <div className="absolute w-full h-1 bg-rh-red-50 top-1.5 rounded-sm" />
+                <div className="w-6 h-5 bg-rh-red-50 rounded-sm mt-1" />
+              </div>
+              <a href="/" className="font-rh-display text-white text-xl font-bold tracking-tight hover:text-rh-gray-30 transition-colors">
+                Prelude
+              </a>
+            </div>
+          </div>
+        </div>
+      </nav>
+
+      <section className="bg-rh-gray-95">
+        <div className="max-w-7xl mx-auto px-6 lg:px-8 py-20 lg:py-28">
+          <div className="max-w-md mx-auto">
+            <div className="w-10 h-0.5 bg-rh-red-50 mb-6" />
+            <h1 className="font-rh-display text-white text-2xl sm:text-3xl font-bold tracking-tight mb-3">
+              Your cluster login
+            </h1>
+
+            {!credentials && !error && (
+              <p className="font-rh-text text-rh-gray-40 text-base">Checking your link...</p>
+            )}
+
+            {credentials && (
+              <div className="flex flex-col gap-4">
+                <p className="font-rh-text text-rh-gray-40 text-base">
+                  This password is shown only once. Save it before leaving this page.
+                </p>
+                <div className="px-5 py-4 bg-rh-gray-90 border border-rh-gray-70">
+                  <p className="font-rh-text text-rh-gray-50 text-sm">Username</p>
+                  <p className="font-mono text-white text-base">{credentials.username}</p>
+                  <p className="mt-3 font-rh-text text-rh-gray-50 text-sm">Password</p>
+                  <div className="flex items-center justify-between gap-3">
+                    <p className="font-mono text-white text-base break-all">{credentials.password}</p>
+                    <button
+                      type="button"
+                      onClick={copyPassword}
+                      className="px-3 py-1.5 text-sm font-rh-text font-medium text-rh-gray-40 border border-rh-gray-70 hover:text-white transition-colors"
+                    >
+                      {copied ? "Copied" : "Copy"}
+                    </button>
+                  </div>
+                </div>
+                <a
+                  href={credentials.webConsoleURL}
+                  target="_blank"
+                  rel="noopener noreferrer"
+                  className="flex items-center justify-center px-8 py-4 bg-rh-red-50 text-white font-rh-text font-bold text-base hover:bg-rh-red-60 transition-all duration-200"
+                >
+                  Open the OpenShift console
+                </a>
+              </div>
+            )}
+
+            {error && (
+              <div className="flex items-start gap-3 px-5 py-4 bg-rh-red-80 border border-rh-red-70">
+                <p className="font-rh-text text-rh-red-30 text-sm leading-relaxed">{error}</p>
+              </div>
+            )}
+          </div>
+        </div>
+        <div className="h-px bg-gradient-to-r from-rh-red-50 via-rh-red-50/20 to-transparent" />
+      </section>
+    </div>
+  );
+}
@@ -1,17 +1,20 @@
 "use client";
 
 import { useState, useEffect, useRef } from "react";
-import { useGoogleReCaptcha } from "react-google-recaptcha-v3";
 import { RecaptchaVerifier, signInWithPhoneNumber, ConfirmationResult } from "firebase/auth";
 import { auth } from "./firebase";
-import { claimCluster } from "./actions";
+import { checkClaimExists, claimCluster, extendClaim, reserveCluster, validatePhoneNumber } from "./actions";
 import { getFingerprint } from "./fingerprint";
+import { useCaptcha } from "./captcha-provider";
 
 interface ClusterInfo {
   webConsoleURL: string;
   aiConsoleURL: string;
+  consoles?: { name: string; url: string }[];
   kubeconfig: string;
   expiresAt: string;
+  graceEndsAt?: string;
+  magicLink?: string;
 }
 
 function CopyIcon() {
@@ -86,9 +89,10 @@ function ClockIcon() {
   );
 }
 
-function CountdownTimer({ expiresAt }: { expiresAt: string }) {
+function CountdownTimer({ expiresAt, graceEndsAt }: { expiresAt: string; graceEndsAt?: string }) {
   const [remaining, setRemaining] = useState("");
   const [expired, setExpired] = useState(false);
+  const [graceMinutes, setGraceMinutes] = useState(0);
 
   useEffect(() => {
     function update() {
@@ -99,10 +103,13 @@ function CountdownTimer({ expiresAt }: { expiresAt: string }) {
       if (diff <= 0) {
         setRemaining("0h 0m 0s");
         setExpired(true);
+        const graceLeft = graceEndsAt ? new Date(graceEndsAt).getTime() - now : 0;
+        setGraceMinutes(graceLeft > 0 ? Math.ceil(graceLeft / (1000 * 60)) : 0);
         return;
       }
 
       setExpired(false);
+      setGraceMinutes(0);
       const days = Math.floor(diff / (1000 * 60 * 60 * 24));
       const hours = Math.floor((diff % (1000 * 60 * 60 * 24)) / (1000 * 60 * 60));
       const minutes = Math.floor((diff % (1000 * 60 * 60)) / (1000 * 60));
@@ -119,7 +126,7 @@ function CountdownTimer({ expiresAt }: { expiresAt: string }) {
     update();
     const interval = setInterval(update, 1000);
     return () => clearInterval(interval);
-  }, [expiresAt]);
+  }, [expiresAt, graceEndsAt]);
 
   const expiryDate = new Date(expiresAt);
   const formatted = expiryDate.toLocaleString(undefined, {
@@ -143,7 +150,12 @@ function CountdownTimer({ expiresAt }: { expiresAt: string }) {
       <div className={`font-mono text-2xl font-bold tracking-wider ${expired ? "text-rh-red-50" : "text-rh-gray-95"}`}>
         {remaining}
       </div>
-      {expired && (
+      {expired && graceMinutes > 0 && (
+        <p className="mt-2 font-rh-text text-sm text-rh-red-50">
+          Expired, {graceMinutes} {graceMinutes === 1 ? "minute" : "minutes"} of grace left before this cluster is reclaimed.
+        </p>
+      )}
+      {expired && graceMinutes === 0 && (
         <p className="mt-2 font-rh-text text-sm text-rh-red-50">
           This cluster has expired and will be reclaimed.
         </p>
@@ -371,15 +383,27 @@ export default function Home() {
   const [showPassword, setShowPassword] = useState(false);
   const [hideKubeconfig, setHideKubeconfig] = useState(false);
   const [hideConsole, setHideConsole] = useState(false);
+  const [extendEnabled, setExtendEnabled] = useState(false);
+  const [magicLinkEnabled, setMagicLinkEnabled] = useState(false);
+  const [twoPhaseClaim, setTwoPhaseClaim] = useState(false);
+  const [reservation, setReservation] = useState<{ token: string; until: string } | null>(null);
+  const [tracks, setTracks] = useState<string[]>([]);
+  const [track, setTrack] = useState("");
+  const [pool, setPool] = useState("");
+  const [extending, setExtending] = useState(false);
+  const [extendMessage, setExtendMessage] = useState("");
   const [cluster, setCluster] = useState<ClusterInfo | null>(null);
   const [error, setError] = useState("");
+  const [overflowRedirect, setOverflowRedirect] = useState("");
   const [loading, setLoading] = useState(false);
   const [copied, setCopied] = useState<string | null>(null);
   const [step, setStep] = useState<"input" | "verify">("input");
   const [verificationCode, setVerificationCode] = useState("");
   const [confirmationResult, setConfirmationResult] = useState<ConfirmationResult | null>(null);
+  const [verified, setVerified] = useState(false);
+  const [resuming, setResuming] = useState(false);
   const recaptchaVerifierRef = useRef<RecaptchaVerifier | null>(null);
-  const { executeRecaptcha } = useGoogleReCaptcha();
+  const { executeCaptcha } = useCaptcha();
 
   // Fetch runtime config from server
   useEffect(() => {
@@ -392,10 +416,28 @@ export default function Home() {
         if (data.hideConsole) {
           setHideConsole(true);
         }
+        if (data.extendEnabled) {
+          setExtendEnabled(true);
+        }
+        if (data.magicLinkEnabled) {
+          setMagicLinkEnabled(true);
+        }
+        if (data.twoPhaseClaim) {
+          setTwoPhaseClaim(true);
+        }
+        if (Array.isArray(data.tracks) && data.tracks.length > 0) {
+          setTracks(data.tracks);
+          setTrack(data.tracks[0]);
+        }
       })
       .catch(() => {});
   }, []);
 
+  // Servers with several cluster pools are linked as /?pool=<name>
+  useEffect(() => {
+    setPool(new URLSearchParams(window.location.search).get("pool") || "");
+  }, []);
+
   // Close country dropdown on outside click
   useEffect(() => {
     function handleClickOutside(e: MouseEvent) {
@@ -450,6 +492,36 @@ export default function Home() {
 
     setLoading(true);
 
+    // Check with the server before spending an SMS on a phone it would reject
+    let fingerprint = "";
+    try {
+      fingerprint = await getFingerprint();
+    } catch {
+      // Fingerprint not available, continue without it
+    }
+    let validateToken = "";
+    try {
+      if (executeCaptcha) {
+        validateToken = await executeCaptcha("validate");
+      }
+    } catch {
+      // Captcha not available, continue without token
+    }
+    const validation = await validatePhoneNumber(fullPhoneNumber, validateToken, fingerprint);
+    if (validation && !validation.valid) {
+      setError(
+        validation.reason === "suspicious_activity"
+          ? "Too many phone numbers have been used from this device. Please try again later."
+          : validation.reason === "rate_limited"
+            ? "Too many attempts. Please wait a few seconds and try again."
+            : "Invalid phone number. Please check the number and try again."
+      );
+      setLoading(false);
+      return;
+    }
+    const existing = await checkClaimExists(fullPhoneNumber, pool || undefined);
+    setResuming(!!existing?.exists);
+
     try {
       // Clean up any existing verifier
       if (recaptchaVerifierRef.current) {
@@ -499,43 +571,129 @@ export default function Home() {
 
     try {
       await confirmationResult.confirm(verificationCode);
+      setVerified(true);
+      await submitClaim();
+    } catch (err: unknown) {
+      const firebaseError = err as { code?: string; message?: string };
+      if (firebaseError.code === "auth/invalid-verification-code") {
+        setError("Invalid verification code. Please check and try again.");
+      } else if (firebaseError.code === "auth/code-expired") {
+        setError("Verification code has expired. Please go back and resend.");
+      } else {
+        setError(firebaseError.message || "Verification failed. Please try again.");
+      }
+    } finally {
+      setLoading(false);
+    }
+  }
 
-      // Phone verified — proceed with claim
-      let fingerprint = "";
-      try {
-        fingerprint = await getFingerprint();
-      } catch {
-        // Fingerprint not available, continue without it
+  // Retry the claim for an already verified phone. When the server has moved
+  // the phone off a dead cluster, this picks up the replacement and sets the
+  // same admin password on it, without another SMS round trip.
+  async function handleRetryClaim() {
+    setError("");
+    setLoading(true);
+    try {
+      await submitClaim();
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  async function submitClaim(confirmToken?: string) {
+    let fingerprint = "";
+    try {
+      fingerprint = await getFingerprint();
+    } catch {
+      // Fingerprint not available, continue without it
+    }
+
+    let recaptchaToken = "";
+    try {
+      if (executeCaptcha) {
+        recaptchaToken = await executeCaptcha("claim");
       }
+    } catch {
+      // Captcha not available, continue without token
+    }
+
+    // Two-phase claims hold a cluster first and only commit it once the
+    // user confirms, so an accidental click doesn't burn a cluster
+    if (twoPhaseClaim && confirmToken === undefined) {
+      const reserved = await reserveCluster(fullPhoneNumber, recaptchaToken, fingerprint, track, pool || undefined);
+      if (!reserved.success) {
+        setOverflowRedirect(reserved.redirect || "");
+        setError(reserved.error);
+        return;
+      }
+      if (!reserved.confirmed) {
+        setReservation({ token: reserved.reservation || "", until: reserved.reservedUntil || "" });
+        return;
+      }
+      confirmToken = "";
+    }
+
+    const result = await claimCluster(
+      fullPhoneNumber,
+      password,
+      recaptchaToken,
+      fingerprint,
+      track,
+      confirmToken,
+      pool || undefined
+    );
+    setReservation(null);
 
+    if (!result.success) {
+      setOverflowRedirect(result.redirect || "");
+      setError(result.error);
+      return;
+    }
+
+    setCluster(result.data);
+  }
+
+  async function handleConfirmReservation() {
+    if (!reservation) return;
+    setError("");
+    setLoading(true);
+    try {
+      await submitClaim(reservation.token);
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  async function handleExtend() {
+    if (!cluster) return;
+    setExtending(true);
+    setExtendMessage("");
+    try {
       let recaptchaToken = "";
       try {
-        if (executeRecaptcha) {
-          recaptchaToken = await executeRecaptcha("claim");
+        if (executeCaptcha) {
+          recaptchaToken = await executeCaptcha("extend");
         }
       } catch {
-        // reCAPTCHA not available, continue without token
+        // Captcha not available, continue without token
       }
-
-      const result = await claimCluster(fullPhoneNumber, password, recaptchaToken, fingerprint);
-
+      const result = await extendClaim(fullPhoneNumber, password, "1h", recaptchaToken, pool || undefined);
       if (!result.success) {
-        setError(result.error);
+        if (result.error === "max_lifetime_reached") {
+          setExtendMessage("This cluster has reached its maximum lifetime.");
+        } else if (result.error === "rate_limited") {
+          setExtendMessage("Too many attempts. Please try again later.");
+        } else if (result.error === "invalid_password") {
+          setExtendMessage("The admin password no longer matches this cluster.");
+        } else {
+          setExtendMessage("Could not extend the cluster. Please try again.");
+        }
         return;
       }
-
-      setCluster(result.data);
-    } catch (err: unknown) {
-      const firebaseError = err as { code?: string; message?: string };
-      if (firebaseError.code === "auth/invalid-verification-code") {
-        setError("Invalid verification code. Please check and try again.");
-      } else if (firebaseError.code === "auth/code-expired") {
-        setError("Verification code has expired. Please go back and resend.");
-      } else {
-        setError(firebaseError.message || "Verification failed. Please try again.");
-      }
+      setCluster({ ...cluster, expiresAt: result.expiresAt, graceEndsAt: result.graceEndsAt });
+      setExtendMessage("Cluster lifetime extended.");
     } finally {
-      setLoading(false);
+      setExtending(false);
     }
   }
 
@@ -543,6 +701,7 @@ export default function Home() {
     setStep("input");
     setVerificationCode("");
     setConfirmationResult(null);
+    setVerified(false);
     setError("");
   }
 
@@ -698,9 +857,9 @@ export default function Home() {
                         type={showPassword ? "text" : "password"}
                         value={password}
                         onChange={(e) => setPassword(e.target.value)}
-                        placeholder="Admin password"
+                        placeholder={magicLinkEnabled ? "Admin password (optional)" : "Admin password"}
                         className="w-full px-5 py-4 pr-12 bg-rh-gray-90 border border-rh-gray-70 text-white font-rh-text text-base placeholder-rh-gray-50 focus:outline-none focus:border-rh-red-50 focus:ring-1 focus:ring-rh-red-50 transition-colors"
-                        required
+                        required={!magicLinkEnabled}
                       />
                       <button
                         type="button"
@@ -712,6 +871,21 @@ export default function Home() {
                       </button>
                     </div>
                   </div>
+                  {tracks.length > 0 && (
+                    <div>
+                      <label htmlFor="track" className="sr-only">Track</label>
+                      <select
+                        id="track"
+                        value={track}
+                        onChange={(e) => setTrack(e.target.value)}
+                        className="w-full px-5 py-4 bg-rh-gray-90 border border-rh-gray-70 text-white font-rh-text text-base focus:outline-none focus:border-rh-red-50 focus:ring-1 focus:ring-rh-red-50 transition-colors"
+                      >
+                        {tracks.map((t) => (
+                          <option key={t} value={t}>{t}</option>
+                        ))}
+                      </select>
+                    </div>
+                  )}
                   <button
                     id="send-code-button"
                     type="submit"
@@ -789,7 +963,7 @@ export default function Home() {
                       </>
                     ) : (
                       <>
-                        <span>Verify & get cluster</span>
+                        <span>{resuming ? "Verify & resume cluster" : "Verify & get cluster"}</span>
                         <ArrowIcon />
                       </>
                     )}
@@ -806,11 +980,62 @@ export default function Home() {
                     <p className="font-rh-text text-white text-lg leading-relaxed">
                       Sorry, all of our clusters are in use at the moment, try again later.
                     </p>
+                    {overflowRedirect && (
+                      <a
+                        href={overflowRedirect}
+                        className="inline-flex items-center gap-2 mt-4 px-6 py-3 bg-rh-red-50 text-white font-rh-text font-bold text-sm hover:bg-rh-red-60 transition-colors"
+                      >
+                        <span>Join the waitlist</span>
+                        <ArrowIcon />
+                      </a>
+                    )}
                   </div>
                 ) : error === "cluster_unavailable" ? (
                   <div className="px-6 py-5 bg-rh-gray-80 border border-rh-gray-70 text-center">
                     <p className="font-rh-text text-white text-lg leading-relaxed">
-                      The assigned cluster is no longer available. Please try again to get a new cluster.
+                      Your cluster stopped responding and has been released. Try again to get a new one with the same admin password.
+                    </p>
+                  </div>
+                ) : error === "suspicious_activity" ? (
+                  <div className="px-6 py-5 bg-rh-gray-80 border border-rh-gray-70 text-center">
+                    <p className="font-rh-text text-white text-lg leading-relaxed">
+                      We couldn&apos;t verify this request. Please try again later.
+                    </p>
+                  </div>
+                ) : error === "console_not_ready" ? (
+                  <div className="px-6 py-5 bg-rh-gray-80 border border-rh-gray-70 text-center">
+                    <p className="font-rh-text text-white text-lg leading-relaxed">
+                      Your cluster console is still starting up. Please try again in a moment.
+                    </p>
+                  </div>
+                ) : error === "reservation_expired" ? (
+                  <div className="px-6 py-5 bg-rh-gray-80 border border-rh-gray-70 text-center">
+                    <p className="font-rh-text text-white text-lg leading-relaxed">
+                      Your reservation expired before it was confirmed and the cluster went back to the pool. Try again to reserve another.
+                    </p>
+                  </div>
+                ) : error === "rate_limited" ? (
+                  <div className="px-6 py-5 bg-rh-gray-80 border border-rh-gray-70 text-center">
+                    <p className="font-rh-text text-white text-lg leading-relaxed">
+                      Too many attempts. Please wait a few seconds and try again.
+                    </p>
+                  </div>
+                ) : error === "unknown_pool" ? (
+                  <div className="px-6 py-5 bg-rh-gray-80 border border-rh-gray-70 text-center">
+                    <p className="font-rh-text text-white text-lg leading-relaxed">
+                      This link doesn&apos;t match a workshop running here. Please use the link for your workshop.
+                    </p>
+                  </div>
+                ) : error === "warming_up" ? (
+                  <div className="px-6 py-5 bg-rh-gray-80 border border-rh-gray-70 text-center">
+                    <p className="font-rh-text text-white text-lg leading-relaxed">
+                      We&apos;re still getting the first clusters ready. Please try again in a few minutes.
+                    </p>
+                  </div>
+                ) : error === "cluster_authenticating" ? (
+                  <div className="px-6 py-5 bg-rh-gray-80 border border-rh-gray-70 text-center">
+                    <p className="font-rh-text text-white text-lg leading-relaxed">
+                      Your cluster is still setting up. Please try again in a few minutes.
                     </p>
                   </div>
                 ) : error === "device_already_claimed" ? (
@@ -834,6 +1059,32 @@ export default function Home() {
                     <p className="font-rh-text text-rh-red-30 text-sm leading-relaxed">{error}</p>
                   </div>
                 )}
+                {verified && ["cluster_unavailable", "console_not_ready", "cluster_authenticating", "reservation_expired"].includes(error) && (
+                  <button
+                    onClick={handleRetryClaim}
+                    disabled={loading}
+                    className="mt-4 w-full px-8 py-3 border border-rh-gray-70 text-white font-rh-text font-bold text-sm hover:border-rh-gray-50 disabled:text-rh-gray-50 transition-colors"
+                  >
+                    {loading ? "Trying again..." : "Try again"}
+                  </button>
+                )}
+              </div>
+            )}
+
+            {reservation && !cluster && (
+              <div className="mt-6 px-6 py-5 bg-rh-gray-80 border border-rh-gray-70 text-center">
+                <p className="font-rh-text text-white text-lg leading-relaxed">
+                  A cluster is reserved for you until{" "}
+                  {new Date(reservation.until).toLocaleTimeString(undefined, { hour: "2-digit", minute: "2-digit" })}.
+                  Confirm to start using it; otherwise it goes back to the pool.
+                </p>
+                <button
+                  onClick={handleConfirmReservation}
+                  disabled={loading}
+                  className="mt-4 w-full px-8 py-3 bg-rh-red-50 text-white font-rh-text font-bold text-sm hover:bg-rh-red-60 disabled:bg-rh-gray-70 disabled:text-rh-gray-50 transition-colors"
+                >
+                  {loading ? "Starting your cluster..." : "Confirm and start my cluster"}
+                </button>
               </div>
             )}
           </div>
@@ -859,6 +1110,37 @@ export default function Home() {
             </div>
 
             <div className="grid gap-6 lg:grid-cols-2">
+              {/* Magic Link Card (no password was chosen) */}
+              {cluster.magicLink && (
+                <div
+                  className="bg-white border border-rh-gray-20 animate-fade-in-up lg:col-span-2"
+                  style={{ animationDelay: '0.05s' }}
+                >
+                  <div className="border-b border-rh-gray-20 px-6 py-4 flex items-center gap-3">
+                    <div className="w-2 h-2 rounded-full bg-rh-red-50 animate-pulse-red" />
+                    <h3 className="font-rh-display text-rh-gray-95 text-base font-bold">
+                      One-time Login Link
+                    </h3>
+                  </div>
+                  <div className="px-6 py-5">
+                    <a
+                      href={cluster.magicLink}
+                      target="_blank"
+                      rel="noopener noreferrer"
+                      className="group inline-flex items-center gap-2 font-rh-text text-rh-red-50 hover:text-rh-red-60 text-base transition-colors"
+                    >
+                      <span className="underline underline-offset-2 decoration-rh-red-50/40 group-hover:decoration-rh-red-60">
+                        Reveal your generated admin password
+                      </span>
+                      <ExternalIcon />
+                    </a>
+                    <p className="mt-3 font-rh-text text-sm text-rh-gray-60">
+                      The link works once and expires in 10 minutes. Claim again with the same phone for a new one.
+                    </p>
+                  </div>
+                </div>
+              )}
+
               {/* AI Console URL Card */}
               <div
                 className="bg-white border border-rh-gray-20 animate-fade-in-up"
@@ -937,6 +1219,46 @@ export default function Home() {
               </div>
               )}
 
+              {/* Additional Console Cards (configured via CONSOLES) */}
+              {(cluster.consoles || [])
+                .filter((c) => c.name !== "web" && c.name !== "ai")
+                .map((c) => (
+                <div
+                  key={c.name}
+                  className="bg-white border border-rh-gray-20 animate-fade-in-up"
+                  style={{ animationDelay: '0.15s' }}
+                >
+                  <div className="border-b border-rh-gray-20 px-6 py-4 flex items-center justify-between">
+                    <div className="flex items-center gap-3">
+                      <div className="w-2 h-2 rounded-full bg-rh-red-50 animate-pulse-red" />
+                      <h3 className="font-rh-display text-rh-gray-95 text-base font-bold">
+                        {c.name}
+                      </h3>
+                    </div>
+                    <button
+                      onClick={() => copyToClipboard(c.url, `console-${c.name}`)}
+                      className="flex items-center gap-2 px-3 py-1.5 text-sm font-rh-text font-medium text-rh-gray-60 border border-rh-gray-20 hover:border-rh-gray-50 hover:text-rh-gray-95 transition-colors"
+                    >
+                      {copied === `console-${c.name}` ? <CheckIcon /> : <CopyIcon />}
+                      <span>{copied === `console-${c.name}` ? "Copied" : "Copy"}</span>
+                    </button>
+                  </div>
+                  <div className="px-6 py-5">
+                    <a
+                      href={c.url}
+                      target="_blank"
+                      rel="noopener noreferrer"
+                      className="group inline-flex items-center gap-2 font-rh-text text-rh-red-50 hover:text-rh-red-60 text-base break-all transition-colors"
+                    >
+                      <span className="underline underline-offset-2 decoration-rh-red-50/40 group-hover:decoration-rh-red-60">
+                        {c.url}
+                      </span>
+                      <ExternalIcon />
+                    </a>
+                  </div>
+                </div>
+              ))}
+
               {/* Cluster Lifetime Card */}
               <div
                 className="bg-white border border-rh-gray-20 animate-fade-in-up"
@@ -950,7 +1272,21 @@ export default function Home() {
                     </h3>
                   </div>
                 </div>
-                <CountdownTimer expiresAt={cluster.expiresAt} />
+                <CountdownTimer expiresAt={cluster.expiresAt} graceEndsAt={cluster.graceEndsAt} />
+                {extendEnabled && (
+                  <div className="px-6 pb-5 flex items-center gap-3">
+                    <button
+                      onClick={handleExtend}
+                      disabled={extending}
+                      className="px-3 py-1.5 text-sm font-rh-text font-medium text-rh-gray-60 border border-rh-gray-20 hover:border-rh-gray-50 hover:text-rh-gray-95 transition-colors disabled:opacity-50"
+                    >
+                      {extending ? "Extending..." : "Extend by 1h"}
+                    </button>
+                    {extendMessage && (
+                      <span className="font-rh-text text-sm text-rh-gray-60">{extendMessage}</span>
+                    )}
+                  </div>
+                )}
               </div>
 
               {/* Kubeconfig Card */}
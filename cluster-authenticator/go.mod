@@ -3,6 +3,7 @@ module github.com/prelude/cluster-authenticator
 go 1.24.12
 
 require (
+	github.com/prelude/internal v0.0.0
 	k8s.io/api v0.32.3
 	k8s.io/apimachinery v0.32.3
 	k8s.io/client-go v0.32.3
@@ -48,3 +49,5 @@ require (
 	sigs.k8s.io/structured-merge-diff/v4 v4.4.2 // indirect
 	sigs.k8s.io/yaml v1.4.0 // indirect
 )
+
+replace github.com/prelude/internal => ../internal
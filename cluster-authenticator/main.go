@@ -9,31 +9,47 @@ import (
 	"crypto/x509/pkix"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/prelude/internal/duration"
+	"github.com/prelude/internal/hub"
+	"github.com/prelude/internal/logsample"
+	"github.com/prelude/internal/pools"
 	certificatesv1 "k8s.io/api/certificates/v1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
 )
 
 var (
@@ -57,7 +73,7 @@ var (
 		Version:  "v1",
 		Resource: "certificates",
 	}
-	clusterPoolNamespace = "cluster-pools"
+	clusterPoolNamespace   = "cluster-pools"
 	keycloakRealmImportGVR = schema.GroupVersionResource{
 		Group:    "k8s.keycloak.org",
 		Version:  "v2alpha1",
@@ -145,26 +161,207 @@ var consoleGVR = schema.GroupVersionResource{
 var keycloakURL string
 var keycloakClientSecret string
 var preludeUserPassword string
+var spokeManifestsDir string
+var skipCSRApproval bool
+
+// Condition recorded when the authenticator approves a spoke CSR, so
+// approvals can be told apart across installs in the spoke's audit trail.
+var csrApprovalReason string
+var csrApprovalMessage string
+
+// csrDryRun creates each CSR and checks the approval with a server-side dry
+// run, then stops before approving or waiting for a certificate.
+var csrDryRun bool
+
+// errCSRDryRun ends a cluster's flow after its CSRs in --csr-dry-run mode.
+var errCSRDryRun = errors.New("CSR dry run, not approved")
+
+// dryRunDone holds the claims whose CSR dry run has finished. They stay
+// unauthenticated, so without it every pass would recreate their CSRs.
+var dryRunDone sync.Map
+var requireClusterOperators bool
+var skipStabilityWait bool
+
+// Identities minted via spoke CSRs: the admin identity is used for the
+// authenticator's own spoke operations, the user identity is handed out.
+var adminCN string
+var adminCSRName string
+var userCN string
+var userCSRName string
+var userOrg string
+var userRole string
+
+// userOrganizations is the organization (group) of the user identity's
+// certificate, none when --user-org is empty.
+func userOrganizations() []string {
+	if userOrg == "" {
+		return nil
+	}
+	return []string{userOrg}
+}
+
+// Target of the marker configmap created on each spoke for ACM policies.
+var spokeConfigMapName string
+var spokeConfigMapNamespace string
+
+// spokeFieldManager is the server-side apply field manager used for spoke resources.
+const spokeFieldManager = "prelude-authenticator"
+
+// version is set at build time with -ldflags "-X main.version=<version>".
+var version = "dev"
+
+// userAgent identifies this component in hub and spoke API server audit logs.
+var userAgent = "prelude-authenticator/" + version
+
+// Explicit hub connection settings, taking precedence over kubeconfig when
+// hubServer is set.
+var hubServer string
+var hubClientCert string
+var hubClientKey string
+var hubCA string
+
+// spokeCAData is an extra CA bundle (--spoke-ca-file) trusted by verified
+// spoke clients alongside the spoke kubeconfig's own CA, for spokes fronted
+// by a TLS terminator with a corporate CA.
+var spokeCAData []byte
 
 func main() {
-	clusterPool := flag.String("cluster-pool", os.Getenv("CLUSTER_POOL"), "ClusterPool name to filter by (required)")
+	clusterPool := flag.String("cluster-pool", os.Getenv("CLUSTER_POOL"), "ClusterPool name to filter by (required unless --pool-selector is set)")
+	poolSelector := flag.String("pool-selector", os.Getenv("POOL_SELECTOR"), "Label selector for ClusterPools; every match is worked on instead of --cluster-pool, re-resolved every --pool-resolve-interval")
+	poolResolveIntervalStr := flag.String("pool-resolve-interval", os.Getenv("POOL_RESOLVE_INTERVAL"), "How often --pool-selector is re-resolved (default 5m)")
+	flag.StringVar(&spokeManifestsDir, "spoke-manifests-dir", os.Getenv("SPOKE_MANIFESTS_DIR"), "Directory of YAML manifests to server-side apply on each spoke after the built-in resources")
+	flag.StringVar(&hubServer, "hub-server", os.Getenv("HUB_SERVER"), "Hub API server URL; with --hub-client-cert/--hub-client-key, used instead of a kubeconfig")
+	flag.StringVar(&hubClientCert, "hub-client-cert", os.Getenv("HUB_CLIENT_CERT"), "Client certificate file for --hub-server")
+	flag.StringVar(&hubClientKey, "hub-client-key", os.Getenv("HUB_CLIENT_KEY"), "Client key file for --hub-server")
+	flag.StringVar(&hubCA, "hub-ca", os.Getenv("HUB_CA"), "CA bundle file for --hub-server (default system roots)")
+	spokeCAFile := flag.String("spoke-ca-file", os.Getenv("SPOKE_CA_FILE"), "Additional CA bundle file to trust when connecting to spoke clusters")
+	flag.StringVar(&adminCN, "admin-cn", envOrDefault("ADMIN_CN", "system:admin"), "Common name of the admin identity minted on each spoke")
+	flag.StringVar(&adminCSRName, "admin-csr-name", envOrDefault("ADMIN_CSR_NAME", "auth2kube-systemadmin-access"), "CertificateSigningRequest name used for the admin identity")
+	flag.StringVar(&userCN, "user-cn", envOrDefault("USER_CN", "admin"), "Common name of the user identity minted on each spoke and handed out")
+	flag.StringVar(&userOrg, "user-org", envOrDefault("USER_ORG", "admin"), "Organization (group) of the user identity minted on each spoke; empty for none")
+	flag.StringVar(&userRole, "user-role", envOrDefault("USER_ROLE", "cluster-admin"), "ClusterRole bound to the user identity on each spoke")
+	flag.StringVar(&userCSRName, "user-csr-name", envOrDefault("USER_CSR_NAME", "auth2kube-admin-access"), "CertificateSigningRequest name used for the user identity")
+	flag.StringVar(&spokeConfigMapName, "spoke-configmap-name", envOrDefault("SPOKE_CONFIGMAP_NAME", "prelude"), "Name of the configmap created on each spoke for ACM policies to key off")
+	flag.StringVar(&spokeConfigMapNamespace, "spoke-configmap-namespace", envOrDefault("SPOKE_CONFIGMAP_NAMESPACE", "openshift-config"), "Namespace of the configmap created on each spoke")
+	flag.BoolVar(&skipStabilityWait, "skip-stability-wait", os.Getenv("SKIP_STABILITY_WAIT") == "true", "Skip waiting for ClusterOperators and certificates to be stable (trusted demo environments only)")
+	flag.BoolVar(&requireClusterOperators, "require-clusteroperators", os.Getenv("REQUIRE_CLUSTEROPERATORS") == "true", "Abort a cluster's stability wait immediately if the spoke doesn't serve the ClusterOperator API")
+	flag.BoolVar(&skipCSRApproval, "skip-csr-approval", os.Getenv("SKIP_CSR_APPROVAL") == "true", "When approving a spoke CSR is forbidden, wait for it to be approved externally instead of failing")
+	flag.StringVar(&csrApprovalReason, "csr-approval-reason", envOrDefault("CSR_APPROVAL_REASON", "PreludeAuthenticator"), "Reason recorded on the Approved condition of spoke CSRs")
+	flag.StringVar(&csrApprovalMessage, "csr-approval-message", envOrDefault("CSR_APPROVAL_MESSAGE", "Approved by cluster-authenticator"), "Message recorded on the Approved condition of spoke CSRs")
+	flag.BoolVar(&csrDryRun, "csr-dry-run", os.Getenv("CSR_DRY_RUN") == "true", "Create spoke CSRs and dry-run their approval without approving, to validate RBAC and signer setup; clusters are never authenticated")
+	claimNamespace := flag.String("claim-namespace", os.Getenv("CLAIM_NAMESPACE"), "Hub namespace holding the ClusterPools and ClusterClaims not listed in --pool-namespace (default cluster-pools)")
+	poolNamespace := flag.String("pool-namespace", os.Getenv("POOL_NAMESPACE"), "Comma-separated pool=namespace mapping of ClusterPool and ClusterClaim namespaces (e.g. poolA=ns-a,poolB=ns-b), as given to the server")
+	skipPoolCheck := flag.Bool("skip-pool-check", os.Getenv("SKIP_POOL_CHECK") == "true", "Start even if the ClusterPool doesn't exist yet (bootstrap)")
+	hiveGroup := flag.String("hive-group", os.Getenv("HIVE_GROUP"), "API group of the Hive ClusterClaim/ClusterDeployment resources (default hive.openshift.io)")
+	hiveVersion := flag.String("hive-version", os.Getenv("HIVE_VERSION"), "API version of the Hive ClusterClaim/ClusterDeployment resources (default v1)")
+	logSampleIntervalStr := flag.String("log-sample-interval", os.Getenv("LOG_SAMPLE_INTERVAL"), "Log repeated reconcile and stability messages at most this often unless their state changes (e.g. 30s, default 1m, 0 logs every poll)")
 	flag.Parse()
 
-	if *clusterPool == "" {
+	if *logSampleIntervalStr != "" {
+		d, err := duration.Parse(*logSampleIntervalStr)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid --log-sample-interval %q", *logSampleIntervalStr)
+		}
+		logs.SetInterval(d)
+	}
+
+	// Allow adapting to Hive API group/version changes without a rebuild
+	if *hiveGroup != "" {
+		clusterClaimGVR.Group = *hiveGroup
+		clusterDeploymentGVR.Group = *hiveGroup
+	}
+	if *hiveVersion != "" {
+		clusterClaimGVR.Version = *hiveVersion
+		clusterDeploymentGVR.Version = *hiveVersion
+	}
+	if *hiveGroup != "" || *hiveVersion != "" {
+		log.Printf("Using Hive API %s/%s", clusterClaimGVR.Group, clusterClaimGVR.Version)
+	}
+
+	if *clusterPool == "" && *poolSelector == "" {
 		log.Fatalf("--cluster-pool flag or CLUSTER_POOL environment variable is required")
 	}
+	if *clusterPool != "" && *poolSelector != "" {
+		log.Fatalf("--cluster-pool and --pool-selector are mutually exclusive")
+	}
+	if *poolSelector != "" {
+		if _, err := labels.Parse(*poolSelector); err != nil {
+			log.Fatalf("Invalid --pool-selector %q: %v", *poolSelector, err)
+		}
+	}
+	if *claimNamespace != "" {
+		clusterPoolNamespace = *claimNamespace
+	}
+	mapped, err := pools.ParseNamespaces(*poolNamespace)
+	if err != nil {
+		log.Fatalf("Invalid --pool-namespace: %v", err)
+	}
+	poolNamespaces = mapped
+	poolResolveInterval := 5 * time.Minute
+	if *poolResolveIntervalStr != "" {
+		d, err := duration.Parse(*poolResolveIntervalStr)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid --pool-resolve-interval value %q", *poolResolveIntervalStr)
+		}
+		poolResolveInterval = d
+	}
 
-	log.Printf("Cluster pool: %s", *clusterPool)
+	if *poolSelector != "" {
+		log.Printf("Cluster pools: all matching %s (re-resolved every %v)", *poolSelector, poolResolveInterval)
+	} else {
+		log.Printf("Cluster pool: %s (namespace %s)", *clusterPool, claimNamespaces().Of(*clusterPool))
+	}
+	if *spokeCAFile != "" {
+		if err := loadSpokeCA(*spokeCAFile); err != nil {
+			log.Fatalf("Invalid --spoke-ca-file: %v", err)
+		}
+		log.Printf("Trusting additional spoke CA bundle %s", *spokeCAFile)
+	}
+	log.Printf("Spoke identities: admin CN=%s (CSR %s), user CN=%s O=%s (CSR %s)", adminCN, adminCSRName, userCN, userOrg, userCSRName)
+	log.Printf("Spoke configmap: %s/%s", spokeConfigMapNamespace, spokeConfigMapName)
+	if csrApprovalReason == "" {
+		log.Fatalf("--csr-approval-reason must not be empty")
+	}
+	log.Printf("CSR approval: reason=%s message=%q", csrApprovalReason, csrApprovalMessage)
+	if csrDryRun {
+		log.Printf("WARNING: --csr-dry-run is set, CSRs are created but never approved and no cluster will be authenticated")
+	}
+	if skipStabilityWait {
+		log.Printf("WARNING: --skip-stability-wait is set, cluster stability will NOT be verified before issuing credentials")
+	}
+	if spokeManifestsDir != "" {
+		if _, err := os.Stat(spokeManifestsDir); err != nil {
+			log.Fatalf("Invalid --spoke-manifests-dir: %v", err)
+		}
+		log.Printf("Spoke manifests directory: %s", spokeManifestsDir)
+	}
 
 	config, err := buildConfig()
 	if err != nil {
 		log.Fatalf("Error building kubeconfig: %v", err)
 	}
+	config.UserAgent = userAgent
 
 	hubDynClient, err := dynamic.NewForConfig(config)
 	if err != nil {
 		log.Fatalf("Error creating dynamic client: %v", err)
 	}
+	claimStore = dynamicClaimStore{hubDynClient}
+
+	// Fail fast on a misnamed pool instead of silently doing nothing
+	if *poolSelector != "" && !*skipPoolCheck {
+		matched, err := pools.Matching(context.Background(), hubDynClient, clusterPoolGVR(), claimNamespaces(), *poolSelector)
+		if err != nil {
+			log.Fatalf("Error resolving --pool-selector: %v", err)
+		}
+		if len(matched) == 0 {
+			log.Fatalf("No ClusterPool matches --pool-selector %q (use --skip-pool-check if the pool is created later)", *poolSelector)
+		}
+	} else if !*skipPoolCheck {
+		if err := checkClusterPool(context.Background(), hubDynClient, claimNamespaces().Of(*clusterPool), *clusterPool); err != nil {
+			log.Fatalf("%v (use --skip-pool-check if the pool is created later)", err)
+		}
+	}
 
 	hubClientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -183,8 +380,6 @@ func main() {
 		cancel()
 	}()
 
-	go checkSignerExpiry(ctx, hubDynClient, hubClientset, *clusterPool)
-
 	keycloakURL = os.Getenv("KEYCLOAK_URL")
 	keycloakClientSecret = os.Getenv("KEYCLOAK_CLIENT_SECRET")
 	preludeUserPassword = os.Getenv("PRELUDE_USER_PASSWORD")
@@ -200,7 +395,17 @@ func main() {
 		log.Printf("SSO setup disabled (KEYCLOAK_URL not set)")
 	}
 
-	reconcile(ctx, hubDynClient, hubClientset, *clusterPool)
+	// runPool authenticates one pool's claims until ctx is cancelled. With
+	// --pool-selector it runs once per matching pool, concurrently.
+	runPool := func(ctx context.Context, pool string) {
+		go checkSignerExpiry(ctx, hubDynClient, hubClientset, pool)
+		reconcile(ctx, hubDynClient, hubClientset, pool)
+	}
+	if *poolSelector != "" {
+		pools.Follow(ctx, hubDynClient, clusterPoolGVR(), claimNamespaces(), *poolSelector, poolResolveInterval, runPool)
+	} else {
+		runPool(ctx, *clusterPool)
+	}
 	log.Printf("Cluster authenticator shutting down")
 }
 
@@ -216,19 +421,19 @@ func reconcile(ctx context.Context, hubDynClient dynamic.Interface, hubClientset
 
 		// Watch for ClusterClaim changes, then re-reconcile
 		var timeoutSecs int64 = 30
-		list, err := hubDynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).List(ctx, metav1.ListOptions{})
+		list, err := claimStore.ListClaims(ctx, pool)
 		if err != nil {
-			log.Printf("Error listing ClusterClaims: %v", err)
+			logs.Printf("reconcile-error/"+pool, err.Error(), "Error listing ClusterClaims: %v", err)
 			sleepOrDone(ctx, 10*time.Second)
 			continue
 		}
 
-		watcher, err := hubDynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).Watch(ctx, metav1.ListOptions{
+		watcher, err := claimStore.WatchClaims(ctx, pool, metav1.ListOptions{
 			TimeoutSeconds:  &timeoutSecs,
 			ResourceVersion: list.GetResourceVersion(),
 		})
 		if err != nil {
-			log.Printf("Error watching ClusterClaims: %v", err)
+			logs.Printf("reconcile-error/"+pool, err.Error(), "Error watching ClusterClaims: %v", err)
 			sleepOrDone(ctx, 10*time.Second)
 			continue
 		}
@@ -242,15 +447,29 @@ func reconcile(ctx context.Context, hubDynClient dynamic.Interface, hubClientset
 	}
 }
 
-// inFlight tracks claims currently being processed to avoid duplicate goroutines.
+// inFlight tracks claims currently being worked on (authentication or cert
+// renewal), so a given claim only ever has one flow issuing CSRs and writing
+// its secrets at a time.
 var inFlight sync.Map
 
+// acquireClaim marks claimName as in flight, returning false if another flow
+// already holds it. Callers must releaseClaim when done.
+func acquireClaim(claimName string) bool {
+	_, loaded := inFlight.LoadOrStore(claimName, true)
+	return !loaded
+}
+
+// releaseClaim clears the in-flight mark set by acquireClaim.
+func releaseClaim(claimName string) {
+	inFlight.Delete(claimName)
+}
+
 // processUnauthenticatedClaims finds bound ClusterClaims without the
 // prelude-auth=done label and launches a goroutine for each.
 func processUnauthenticatedClaims(ctx context.Context, hubDynClient dynamic.Interface, hubClientset kubernetes.Interface, pool string) {
-	claims, err := hubDynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).List(ctx, metav1.ListOptions{})
+	claims, err := claimStore.ListClaims(ctx, pool)
 	if err != nil {
-		log.Printf("Error listing ClusterClaims: %v", err)
+		logs.Printf("process-error/"+pool, err.Error(), "Error listing ClusterClaims: %v", err)
 		return
 	}
 
@@ -259,7 +478,7 @@ func processUnauthenticatedClaims(ctx context.Context, hubDynClient dynamic.Inte
 			return
 		}
 
-		if !claimMatchesPool(claim.Object, pool) {
+		if !claimMatchesPool(claim.Object, pool) || claimDeleting(claim.Object) {
 			continue
 		}
 
@@ -277,22 +496,29 @@ func processUnauthenticatedClaims(ctx context.Context, hubDynClient dynamic.Inte
 
 		claimName := claim.GetName()
 
-		// Skip if already being processed
-		if _, loaded := inFlight.LoadOrStore(claimName, true); loaded {
+		// Skip if already dry-run or being processed
+		if _, done := dryRunDone.Load(claimName); done {
+			continue
+		}
+		if !acquireClaim(claimName) {
 			continue
 		}
 
 		log.Printf("Processing unauthenticated claim %s (cluster: %s)", claimName, clusterName)
 
 		go func(claimName, clusterName string) {
-			defer inFlight.Delete(claimName)
+			defer releaseClaim(claimName)
 
-			if err := authenticateCluster(ctx, hubDynClient, hubClientset, claimName, clusterName); err != nil {
+			if err := authenticateCluster(ctx, hubDynClient, hubClientset, claimName, clusterName); errors.Is(err, errCSRDryRun) {
+				log.Printf("CSR dry run finished for cluster %s (claim %s), leaving it unauthenticated and not retrying it", clusterName, claimName)
+				dryRunDone.Store(claimName, true)
+				return
+			} else if err != nil {
 				log.Printf("Error authenticating cluster %s (claim %s): %v", clusterName, claimName, err)
 				return
 			}
 
-			if err := labelClaimAuthenticated(ctx, hubDynClient, claimName); err != nil {
+			if err := claimStore.SetAuthenticated(ctx, pool, claimName); err != nil {
 				log.Printf("Error labeling claim %s as authenticated: %v", claimName, err)
 				return
 			}
@@ -322,15 +548,16 @@ func authenticateCluster(ctx context.Context, hubDynClient dynamic.Interface, hu
 		return fmt.Errorf("getting admin kubeconfig secret: %w", err)
 	}
 
-	spokeKubeconfigData := extractKubeconfig(adminSecret)
-	if spokeKubeconfigData == "" {
-		return fmt.Errorf("admin kubeconfig secret %s has no kubeconfig data", adminSecretName)
+	spokeKubeconfigData, err := extractKubeconfig(adminSecret)
+	if err != nil {
+		return fmt.Errorf("reading admin kubeconfig: %w", err)
 	}
 
 	spokeConfig, err := clientcmd.RESTConfigFromKubeConfig([]byte(spokeKubeconfigData))
 	if err != nil {
-		return fmt.Errorf("building spoke REST config: %w", err)
+		return fmt.Errorf("building spoke REST config from secret %s/%s: %w", clusterName, adminSecretName, err)
 	}
+	spokeConfig.UserAgent = userAgent
 	// The old admin kubeconfig has a stale CA cert that doesn't match the
 	// cluster's new Let's Encrypt certificates, so skip TLS verification.
 	// The whole purpose of this authenticator is to regenerate kubeconfigs
@@ -351,40 +578,62 @@ func authenticateCluster(ctx context.Context, hubDynClient dynamic.Interface, hu
 
 	// Step 2: Create KeycloakRealmImport on hub (if SSO enabled)
 	// Done before stability check so the authentication operator can find the realm
-	if keycloakURL != "" {
+	if keycloakURL != "" && csrDryRun {
+		log.Printf("[%s] CSR dry run, not creating KeycloakRealmImport", clusterName)
+	} else if keycloakURL != "" {
 		log.Printf("[%s] Creating KeycloakRealmImport", clusterName)
 		if err := createKeycloakRealm(ctx, hubDynClient, hubClientset, clusterName, keycloakURL, keycloakClientSecret, preludeUserPassword); err != nil {
 			log.Printf("Warning: [%s] SSO realm creation failed: %v", clusterName, err)
 		}
 	}
 
-	// Step 3: Wait for stable cluster
-	log.Printf("[%s] Waiting for cluster to stabilize", clusterName)
-	if err := waitForStableCluster(ctx, spokeDynClient, clusterName); err != nil {
-		return fmt.Errorf("waiting for stable cluster: %w", err)
-	}
-	log.Printf("[%s] Cluster is stable", clusterName)
-
-	// Step 3: Regenerate system:admin kubeconfig via CSR
-	log.Printf("[%s] Regenerating system:admin kubeconfig", clusterName)
-	adminKubeconfig, err := regenerateKubeconfig(ctx, spokeClientset, spokeConfig, "system:admin", "auth2kube-systemadmin-access", nil)
-	if err != nil {
-		return fmt.Errorf("regenerating system:admin kubeconfig: %w", err)
+	// Step 3: Wait for stable cluster. When skipped, a not-yet-ready spoke
+	// makes a later step fail instead, and the claim is retried on the next
+	// pass since it isn't labeled prelude-auth=done.
+	if skipStabilityWait {
+		log.Printf("[%s] WARNING: skipping stability wait, cluster stability is not verified", clusterName)
+	} else {
+		log.Printf("[%s] Waiting for cluster to stabilize", clusterName)
+		if err := waitForStableCluster(ctx, spokeDynClient, clusterName, logStabilityProgress(clusterName)); err != nil {
+			return fmt.Errorf("waiting for stable cluster: %w", err)
+		}
+		log.Printf("[%s] Cluster is stable", clusterName)
+	}
+
+	// Step 3: Regenerate the admin kubeconfig via CSR
+	log.Printf("[%s] Regenerating %s kubeconfig", clusterName, adminCN)
+	adminKubeconfig, err := regenerateKubeconfig(ctx, spokeClientset, spokeConfig, adminCN, adminCSRName, nil)
+	if csrDryRun {
+		// The user identity's CSR is dry-run too, it's the one whose
+		// organization and RBAC are configurable. The claim only counts as
+		// dry-run once both approvals would succeed, otherwise it's retried.
+		log.Printf("[%s] Regenerating %s user kubeconfig", clusterName, userCN)
+		_, userErr := regenerateKubeconfig(ctx, spokeClientset, spokeConfig, userCN, userCSRName, userOrganizations())
+		if !errors.Is(err, errCSRDryRun) {
+			if !errors.Is(userErr, errCSRDryRun) {
+				log.Printf("[%s] Error regenerating %s user kubeconfig: %v", clusterName, userCN, userErr)
+			}
+			return fmt.Errorf("regenerating %s kubeconfig: %w", adminCN, err)
+		}
+		if !errors.Is(userErr, errCSRDryRun) {
+			return fmt.Errorf("regenerating %s user kubeconfig: %w", userCN, userErr)
+		}
+		return errCSRDryRun
+	} else if err != nil {
+		return fmt.Errorf("regenerating %s kubeconfig: %w", adminCN, err)
 	}
 
 	// Step 4: Update admin kubeconfig secret on hub
 	log.Printf("[%s] Updating admin kubeconfig secret on hub", clusterName)
-	adminSecret.Data["kubeconfig"] = []byte(adminKubeconfig)
-	adminSecret.Data["raw-kubeconfig"] = []byte(adminKubeconfig)
-	if _, err := hubClientset.CoreV1().Secrets(clusterName).Update(ctx, adminSecret, metav1.UpdateOptions{}); err != nil {
+	if err := updateKubeconfigSecret(ctx, hubClientset, clusterName, adminSecretName, adminKubeconfig); err != nil {
 		return fmt.Errorf("updating admin kubeconfig secret: %w", err)
 	}
 
-	// Step 5: Regenerate admin user kubeconfig via CSR
-	log.Printf("[%s] Regenerating admin user kubeconfig", clusterName)
-	userKubeconfig, err := regenerateKubeconfig(ctx, spokeClientset, spokeConfig, "admin", "auth2kube-admin-access", []string{"admin"})
+	// Step 5: Regenerate the user kubeconfig via CSR
+	log.Printf("[%s] Regenerating %s user kubeconfig", clusterName, userCN)
+	userKubeconfig, err := regenerateKubeconfig(ctx, spokeClientset, spokeConfig, userCN, userCSRName, userOrganizations())
 	if err != nil {
-		return fmt.Errorf("regenerating admin user kubeconfig: %w", err)
+		return fmt.Errorf("regenerating %s user kubeconfig: %w", userCN, err)
 	}
 
 	// Step 6: Create/update user kubeconfig secret on hub
@@ -394,12 +643,16 @@ func authenticateCluster(ctx context.Context, hubDynClient dynamic.Interface, hu
 		return fmt.Errorf("creating/updating user kubeconfig secret: %w", err)
 	}
 
-	// Step 7: Create spoke resources using the NEW system:admin kubeconfig
+	// Step 7: Create spoke resources using the NEW admin kubeconfig
 	log.Printf("[%s] Creating spoke resources", clusterName)
 	newSpokeConfig, err := clientcmd.RESTConfigFromKubeConfig([]byte(adminKubeconfig))
 	if err != nil {
 		return fmt.Errorf("building new spoke REST config: %w", err)
 	}
+	newSpokeConfig.UserAgent = userAgent
+	if err := withSpokeCA(newSpokeConfig); err != nil {
+		return err
+	}
 	newSpokeClientset, err := kubernetes.NewForConfig(newSpokeConfig)
 	if err != nil {
 		return fmt.Errorf("creating new spoke client: %w", err)
@@ -407,6 +660,12 @@ func authenticateCluster(ctx context.Context, hubDynClient dynamic.Interface, hu
 	if err := createSpokeResources(ctx, newSpokeClientset, clusterName); err != nil {
 		return fmt.Errorf("creating spoke resources: %w", err)
 	}
+	if spokeManifestsDir != "" {
+		log.Printf("[%s] Applying spoke manifests from %s", clusterName, spokeManifestsDir)
+		if err := applySpokeManifests(ctx, newSpokeConfig, spokeManifestsDir, clusterName); err != nil {
+			return fmt.Errorf("applying spoke manifests: %w", err)
+		}
+	}
 
 	// Step 9: Patch spoke console for SSO logout redirect (if SSO enabled)
 	if keycloakURL != "" {
@@ -422,16 +681,53 @@ func authenticateCluster(ctx context.Context, hubDynClient dynamic.Interface, hu
 	return nil
 }
 
+// stabilityProgress describes one poll of waitForStableCluster.
+type stabilityProgress struct {
+	StableOperators int
+	TotalOperators  int
+	CertsReady      bool
+	StableFor       time.Duration // zero while the cluster is not stable
+}
+
+// logStabilityProgress returns the default waitForStableCluster progress
+// callback, which logs a poll when the operator or cert counts change and
+// otherwise at the log sampling interval.
+func logStabilityProgress(clusterName string) func(stabilityProgress) {
+	return func(p stabilityProgress) {
+		state := fmt.Sprintf("%d/%d %v %v", p.StableOperators, p.TotalOperators, p.CertsReady, p.StableFor > 0)
+		logs.Printf("stability/"+clusterName, state, "[%s] Stability: %d/%d ClusterOperators stable, certs ready=%v, stable for %v",
+			clusterName, p.StableOperators, p.TotalOperators, p.CertsReady, p.StableFor.Truncate(time.Second))
+	}
+}
+
+// Poll interval bounds for waitForStableCluster.
+const (
+	stabilityPollMin = 5 * time.Second
+	stabilityPollMax = 30 * time.Second
+)
+
 // waitForStableCluster waits for all ClusterOperators to be stable for a
 // minimum period, equivalent to: oc adm wait-for-stable-cluster --minimum-stable-period=60s --timeout=30m
-func waitForStableCluster(ctx context.Context, spokeDynClient dynamic.Interface, clusterName string) error {
+// progress is invoked after each successful poll so callers can surface the
+// wait (annotations, metrics); cancel ctx to abort it.
+func waitForStableCluster(ctx context.Context, spokeDynClient dynamic.Interface, clusterName string, progress func(stabilityProgress)) error {
 	timeout := 30 * time.Minute
 	stablePeriod := 60 * time.Second
 	unreachableTimeout := 1 * time.Minute
 	deadline := time.Now().Add(timeout)
 
+	// Poll adaptively: back off towards stabilityPollMax while the cluster is
+	// far from stable, and drop back to stabilityPollMin once all but a couple
+	// of operators are stable so we notice the finish promptly.
+	interval := stabilityPollMin
+
 	var stableSince *time.Time
 	var unreachableSince *time.Time
+
+	// Start the next wait for this cluster with fresh log sampling
+	defer logs.Reset("stability/" + clusterName)
+	defer logs.Reset("stability-error/" + clusterName)
+	defer logs.ResetPrefix("stability/" + clusterName + "/")
 	everReached := false
 
 	for {
@@ -442,9 +738,21 @@ func waitForStableCluster(ctx context.Context, spokeDynClient dynamic.Interface,
 			return fmt.Errorf("timed out waiting for cluster %s to stabilize after %v", clusterName, timeout)
 		}
 
-		opsStable, err := areClusterOperatorsStable(ctx, spokeDynClient, clusterName)
+		stableOps, totalOps, err := areClusterOperatorsStable(ctx, spokeDynClient, clusterName)
+		if errors.Is(err, errClusterOperatorsUnavailable) {
+			// The spoke answered, it just isn't serving ClusterOperators
+			if requireClusterOperators {
+				return fmt.Errorf("cluster %s: %w", clusterName, err)
+			}
+			logs.Printf("stability-error/"+clusterName, err.Error(), "[%s] Warning: %v; check the pool's cluster type (--require-clusteroperators aborts instead of waiting)", clusterName, err)
+			everReached = true
+			unreachableSince = nil
+			stableSince = nil
+			sleepOrDone(ctx, stabilityPollMax)
+			continue
+		}
 		if err != nil {
-			log.Printf("[%s] Error checking cluster stability: %v", clusterName, err)
+			logs.Printf("stability-error/"+clusterName, err.Error(), "[%s] Error checking cluster stability: %v", clusterName, err)
 			stableSince = nil
 			if !everReached {
 				now := time.Now()
@@ -461,7 +769,7 @@ func waitForStableCluster(ctx context.Context, spokeDynClient dynamic.Interface,
 		unreachableSince = nil
 
 		certsReady := areCertificatesReady(ctx, spokeDynClient, clusterName)
-		stable := opsStable && certsReady
+		stable := totalOps > 0 && stableOps == totalOps && certsReady
 
 		if stable {
 			now := time.Now()
@@ -469,9 +777,6 @@ func waitForStableCluster(ctx context.Context, spokeDynClient dynamic.Interface,
 				stableSince = &now
 				log.Printf("[%s] Cluster stable (operators + certs), waiting for %v stable period", clusterName, stablePeriod)
 			}
-			if time.Since(*stableSince) >= stablePeriod {
-				return nil
-			}
 		} else {
 			if stableSince != nil {
 				log.Printf("[%s] Cluster became unstable, resetting stable period", clusterName)
@@ -479,36 +784,62 @@ func waitForStableCluster(ctx context.Context, spokeDynClient dynamic.Interface,
 			stableSince = nil
 		}
 
-		sleepOrDone(ctx, 10*time.Second)
+		if progress != nil {
+			p := stabilityProgress{StableOperators: stableOps, TotalOperators: totalOps, CertsReady: certsReady}
+			if stableSince != nil {
+				p.StableFor = time.Since(*stableSince)
+			}
+			progress(p)
+		}
+
+		if stableSince != nil && time.Since(*stableSince) >= stablePeriod {
+			return nil
+		}
+
+		if stable || (totalOps > 0 && totalOps-stableOps <= 2) {
+			interval = stabilityPollMin
+		} else {
+			interval *= 2
+			if interval > stabilityPollMax {
+				interval = stabilityPollMax
+			}
+		}
+		sleepOrDone(ctx, wait.Jitter(interval, 0.2))
 	}
 }
 
-// areClusterOperatorsStable checks if all ClusterOperators have
-// Available=True, Progressing=False, Degraded=False.
-func areClusterOperatorsStable(ctx context.Context, spokeDynClient dynamic.Interface, clusterName string) (bool, error) {
+// errClusterOperatorsUnavailable means the spoke doesn't serve the
+// config.openshift.io ClusterOperator API at all, as opposed to having none yet.
+var errClusterOperatorsUnavailable = errors.New("ClusterOperator API not served (not an OpenShift cluster?)")
+
+// areClusterOperatorsStable counts the ClusterOperators with
+// Available=True, Progressing=False, Degraded=False, returning that count and
+// the total number of ClusterOperators.
+func areClusterOperatorsStable(ctx context.Context, spokeDynClient dynamic.Interface, clusterName string) (int, int, error) {
 	list, err := spokeDynClient.Resource(clusterOperatorGVR).List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return false, fmt.Errorf("listing ClusterOperators: %w", err)
+	if k8serrors.IsNotFound(err) {
+		return 0, 0, fmt.Errorf("%w: %v", errClusterOperatorsUnavailable, err)
+	} else if err != nil {
+		return 0, 0, fmt.Errorf("listing ClusterOperators: %w", err)
 	}
 
 	if len(list.Items) == 0 {
-		log.Printf("[%s] No ClusterOperators found", clusterName)
-		return false, nil
+		logs.Printf("stability/"+clusterName+"/operators", "none", "[%s] No ClusterOperators found yet, cluster still coming up", clusterName)
+		return 0, 0, nil
 	}
 
-	allStable := true
+	stable := 0
 	for _, co := range list.Items {
 		name := co.GetName()
+		key := "stability/" + clusterName + "/operator/" + name
 		status, ok := co.Object["status"].(map[string]interface{})
 		if !ok {
-			log.Printf("[%s] ClusterOperator %s has no status", clusterName, name)
-			allStable = false
+			logs.Printf(key, "no-status", "[%s] ClusterOperator %s has no status", clusterName, name)
 			continue
 		}
 		conditions, ok := status["conditions"].([]interface{})
 		if !ok {
-			log.Printf("[%s] ClusterOperator %s has no conditions", clusterName, name)
-			allStable = false
+			logs.Printf(key, "no-conditions", "[%s] ClusterOperator %s has no conditions", clusterName, name)
 			continue
 		}
 
@@ -524,17 +855,16 @@ func areClusterOperatorsStable(ctx context.Context, spokeDynClient dynamic.Inter
 		}
 
 		if condMap["Available"] != "True" || condMap["Progressing"] != "False" || condMap["Degraded"] != "False" {
-			log.Printf("[%s] ClusterOperator %s not stable: Available=%s Progressing=%s Degraded=%s",
+			state := condMap["Available"] + "/" + condMap["Progressing"] + "/" + condMap["Degraded"]
+			logs.Printf(key, state, "[%s] ClusterOperator %s not stable: Available=%s Progressing=%s Degraded=%s",
 				clusterName, name, condMap["Available"], condMap["Progressing"], condMap["Degraded"])
-			allStable = false
+			continue
 		}
+		logs.Reset(key)
+		stable++
 	}
 
-	if allStable {
-		log.Printf("[%s] All %d ClusterOperators stable", clusterName, len(list.Items))
-	}
-
-	return allStable, nil
+	return stable, len(list.Items), nil
 }
 
 // areCertificatesReady checks if the ingress and api certificates have Ready=True.
@@ -549,9 +879,10 @@ func areCertificatesReady(ctx context.Context, spokeDynClient dynamic.Interface,
 
 	allReady := true
 	for _, c := range certs {
+		key := "stability/" + clusterName + "/certificate/" + c.namespace + "/" + c.name
 		cert, err := spokeDynClient.Resource(certificateGVR).Namespace(c.namespace).Get(ctx, c.name, metav1.GetOptions{})
 		if err != nil {
-			log.Printf("[%s] Certificate %s/%s not found: %v", clusterName, c.namespace, c.name, err)
+			logs.Printf(key, "not-found", "[%s] Certificate %s/%s not found: %v", clusterName, c.namespace, c.name, err)
 			allReady = false
 			continue
 		}
@@ -571,13 +902,15 @@ func areCertificatesReady(ctx context.Context, spokeDynClient dynamic.Interface,
 		}
 
 		if !ready {
-			log.Printf("[%s] Certificate %s/%s not ready", clusterName, c.namespace, c.name)
+			logs.Printf(key, "not-ready", "[%s] Certificate %s/%s not ready", clusterName, c.namespace, c.name)
 			allReady = false
+		} else {
+			logs.Reset(key)
 		}
 	}
 
 	if allReady {
-		log.Printf("[%s] All certificates ready", clusterName)
+		logs.Printf("stability/"+clusterName+"/certificates", "ready", "[%s] All certificates ready", clusterName)
 	}
 
 	return allReady
@@ -631,21 +964,44 @@ func regenerateKubeconfig(ctx context.Context, spokeClientset kubernetes.Interfa
 
 	// Approve CSR
 	createdCSR.Status.Conditions = append(createdCSR.Status.Conditions, certificatesv1.CertificateSigningRequestCondition{
-		Type:               certificatesv1.CertificateApproved,
-		Status:             corev1.ConditionTrue,
-		Reason:             "PreludeAuthenticator",
-		Message:            "Approved by cluster-authenticator",
-		LastUpdateTime:     metav1.Now(),
+		Type:           certificatesv1.CertificateApproved,
+		Status:         corev1.ConditionTrue,
+		Reason:         csrApprovalReason,
+		Message:        csrApprovalMessage,
+		LastUpdateTime: metav1.Now(),
 	})
+
+	// A dry-run approval goes through the same authorization and admission
+	// checks as the real one, without the signer ever seeing an approved CSR
+	if csrDryRun {
+		_, err = spokeClientset.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csrName, createdCSR, metav1.UpdateOptions{DryRun: []string{metav1.DryRunAll}})
+		if err != nil {
+			// Not errCSRDryRun, so the claim is dry-run again on the next pass
+			return "", fmt.Errorf("CSR %s dry run: approval would fail: %w", csrName, err)
+		}
+		log.Printf("CSR %s dry run: would approve CN=%s organizations=%v signer=%s reason=%s", csrName, cn, organizations, k8sCSR.Spec.SignerName, csrApprovalReason)
+		return "", errCSRDryRun
+	}
+
+	// Wait up to a minute for the signed certificate, longer when waiting on
+	// someone else to approve the CSR
+	attempts := 30
 	_, err = spokeClientset.CertificatesV1().CertificateSigningRequests().UpdateApproval(ctx, csrName, createdCSR, metav1.UpdateOptions{})
-	if err != nil {
+	if k8serrors.IsForbidden(err) {
+		if !skipCSRApproval {
+			return "", fmt.Errorf("approving CSR %s: missing approve permission on certificatesigningrequests (grant approve on signer kubernetes.io/kube-apiserver-client or set --skip-csr-approval): %w", csrName, err)
+		}
+		log.Printf("CSR %s: missing approve permission on certificatesigningrequests, waiting for external approval", csrName)
+		attempts = 300
+	} else if err != nil {
 		return "", fmt.Errorf("approving CSR: %w", err)
+	} else {
+		log.Printf("CSR %s approved", csrName)
 	}
-	log.Printf("CSR %s approved", csrName)
 
 	// Wait for signed certificate
 	var certPEM []byte
-	for i := 0; i < 30; i++ {
+	for i := 0; i < attempts; i++ {
 		csr, err := spokeClientset.CertificatesV1().CertificateSigningRequests().Get(ctx, csrName, metav1.GetOptions{})
 		if err != nil {
 			return "", fmt.Errorf("getting CSR status: %w", err)
@@ -678,6 +1034,42 @@ func regenerateKubeconfig(ctx context.Context, spokeClientset kubernetes.Interfa
 	return kubeconfig, nil
 }
 
+// loadSpokeCA reads and validates the --spoke-ca-file bundle.
+func loadSpokeCA(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading spoke CA file: %w", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in spoke CA file %s", path)
+	}
+	spokeCAData = data
+	return nil
+}
+
+// withSpokeCA adds the --spoke-ca-file bundle to the CAs a spoke REST config
+// already trusts. Configs that skip verification are left alone.
+func withSpokeCA(cfg *rest.Config) error {
+	if len(spokeCAData) == 0 || cfg.TLSClientConfig.Insecure {
+		return nil
+	}
+	caData := cfg.TLSClientConfig.CAData
+	if len(caData) == 0 && cfg.TLSClientConfig.CAFile != "" {
+		data, err := os.ReadFile(cfg.TLSClientConfig.CAFile)
+		if err != nil {
+			return fmt.Errorf("reading spoke CA: %w", err)
+		}
+		caData = data
+	}
+	merged := append([]byte{}, caData...)
+	if len(merged) > 0 && merged[len(merged)-1] != '\n' {
+		merged = append(merged, '\n')
+	}
+	cfg.TLSClientConfig.CAData = append(merged, spokeCAData...)
+	cfg.TLSClientConfig.CAFile = ""
+	return nil
+}
+
 // extractCACert extracts the CA certificate from a TLS connection to the API server.
 func extractCACert(host string) ([]byte, error) {
 	// Strip scheme if present
@@ -785,53 +1177,263 @@ func createOrUpdateSecret(ctx context.Context, hubClientset kubernetes.Interface
 		return err
 	}
 
-	secret.Data["kubeconfig"] = []byte(kubeconfig)
-	secret.Data["raw-kubeconfig"] = []byte(kubeconfig)
-	_, err = hubClientset.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
-	return err
+	return updateKubeconfigSecret(ctx, hubClientset, namespace, name, kubeconfig)
+}
+
+// updateKubeconfigSecret sets the kubeconfig keys on an existing hub secret,
+// re-reading and re-applying them on conflict so a concurrent write to the
+// secret doesn't fail the whole auth flow (and waste a CSR cycle).
+func updateKubeconfigSecret(ctx context.Context, hubClientset kubernetes.Interface, namespace, name, kubeconfig string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		secret, err := hubClientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if secret.Data == nil {
+			secret.Data = make(map[string][]byte)
+		}
+		secret.Data["kubeconfig"] = []byte(kubeconfig)
+		secret.Data["raw-kubeconfig"] = []byte(kubeconfig)
+		_, err = hubClientset.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
 }
 
 // createSpokeResources creates the prerequisite resources on the spoke cluster
 // for ACM policy deployment.
 func createSpokeResources(ctx context.Context, spokeClientset kubernetes.Interface, clusterName string) error {
-	// Create configmap prelude in openshift-config (if not exists)
-	_, err := spokeClientset.CoreV1().ConfigMaps("openshift-config").Get(ctx, "prelude", metav1.GetOptions{})
-	if k8serrors.IsNotFound(err) {
-		cm := &corev1.ConfigMap{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      "prelude",
-				Namespace: "openshift-config",
+	// Server-side apply both resources as prelude-authenticator so concurrent
+	// reconcilers (ACM policies) never race us into AlreadyExists/Conflict.
+	// Neither is forced: we only claim fields nobody else has set differently.
+	force := false
+	opts := metav1.PatchOptions{FieldManager: spokeFieldManager, Force: &force}
+
+	// Marker configmap (default prelude in openshift-config). Only the name is
+	// applied, so it starts out empty and data added by others is left alone.
+	cm, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":      spokeConfigMapName,
+			"namespace": spokeConfigMapNamespace,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("encoding %s configmap: %w", spokeConfigMapName, err)
+	}
+	if _, err := spokeClientset.CoreV1().ConfigMaps(spokeConfigMapNamespace).Patch(ctx, spokeConfigMapName, types.ApplyPatchType, cm, opts); err != nil {
+		return fmt.Errorf("applying %s configmap in %s: %w", spokeConfigMapName, spokeConfigMapNamespace, err)
+	}
+	log.Printf("[%s] Applied %s configmap in %s", clusterName, spokeConfigMapName, spokeConfigMapNamespace)
+
+	// Ensure the handed-out user identity is bound to its role, so the user
+	// kubeconfig is usable on freshly pooled clusters lacking the binding
+	bindingName := "prelude-user-" + strings.ReplaceAll(userCN, ":", "-")
+	crb, err := json.Marshal(map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "ClusterRoleBinding",
+		"metadata": map[string]interface{}{
+			"name": bindingName,
+		},
+		"roleRef": map[string]interface{}{
+			"apiGroup": rbacv1.GroupName,
+			"kind":     "ClusterRole",
+			"name":     userRole,
+		},
+		"subjects": []interface{}{
+			map[string]interface{}{
+				"apiGroup": rbacv1.GroupName,
+				"kind":     rbacv1.UserKind,
+				"name":     userCN,
 			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("encoding user clusterrolebinding: %w", err)
+	}
+	bindings := spokeClientset.RbacV1().ClusterRoleBindings()
+	_, err = bindings.Patch(ctx, bindingName, types.ApplyPatchType, crb, opts)
+	if k8serrors.IsInvalid(err) {
+		// Field conflicts are reported before validation, so this is our own
+		// binding with a roleRef from an earlier --user-role. roleRef is
+		// immutable: replace the binding.
+		log.Printf("[%s] Clusterrolebinding %s binds a different role, recreating it with %s: %v", clusterName, bindingName, userRole, err)
+		if err := bindings.Delete(ctx, bindingName, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+			return fmt.Errorf("deleting user clusterrolebinding to change its role: %w", err)
 		}
-		if _, err := spokeClientset.CoreV1().ConfigMaps("openshift-config").Create(ctx, cm, metav1.CreateOptions{}); err != nil {
-			return fmt.Errorf("creating prelude configmap: %w", err)
-		}
-		log.Printf("[%s] Created prelude configmap in openshift-config", clusterName)
+		_, err = bindings.Patch(ctx, bindingName, types.ApplyPatchType, crb, opts)
+	}
+	if k8serrors.IsConflict(err) {
+		// Another manager set different subjects or roleRef: leave the
+		// existing binding as is
+		log.Printf("[%s] Clusterrolebinding %s already exists with different settings, leaving it as is: %v", clusterName, bindingName, err)
 	} else if err != nil {
-		return fmt.Errorf("checking prelude configmap: %w", err)
+		return fmt.Errorf("applying user clusterrolebinding: %w", err)
 	} else {
-		log.Printf("[%s] Prelude configmap already exists in openshift-config", clusterName)
+		log.Printf("[%s] Applied clusterrolebinding %s granting %s to %s", clusterName, bindingName, userRole, userCN)
 	}
 
 	return nil
 }
 
-// labelClaimAuthenticated sets the prelude-authenticated=true label on a ClusterClaim.
-func labelClaimAuthenticated(ctx context.Context, hubDynClient dynamic.Interface, claimName string) error {
-	claim, err := hubDynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).Get(ctx, claimName, metav1.GetOptions{})
+// applySpokeManifests server-side applies every object found in the YAML/JSON
+// files of dir (sorted by file name) to the spoke cluster. Applying is
+// idempotent, so re-running it on an already bootstrapped cluster is harmless.
+func applySpokeManifests(ctx context.Context, spokeConfig *rest.Config, dir, clusterName string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", dir, err)
+	}
+	var files []string
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".yaml", ".yml", ".json":
+			files = append(files, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	if len(files) == 0 {
+		log.Printf("[%s] No manifests found in %s", clusterName, dir)
+		return nil
+	}
+
+	spokeDynClient, err := dynamic.NewForConfig(spokeConfig)
 	if err != nil {
-		return fmt.Errorf("getting claim: %w", err)
+		return fmt.Errorf("creating spoke dynamic client: %w", err)
 	}
-	labels := claim.GetLabels()
-	if labels == nil {
-		labels = make(map[string]string)
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(spokeConfig)
+	if err != nil {
+		return fmt.Errorf("creating spoke discovery client: %w", err)
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+
+	force := true
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", file, err)
+		}
+		decoder := yaml.NewYAMLOrJSONDecoder(f, 4096)
+		for {
+			obj := &unstructured.Unstructured{}
+			if err := decoder.Decode(&obj.Object); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				f.Close()
+				return fmt.Errorf("parsing %s: %w", file, err)
+			}
+			if len(obj.Object) == 0 {
+				continue
+			}
+
+			gvk := obj.GroupVersionKind()
+			mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("resolving %s %s in %s: %w", gvk.Kind, obj.GetName(), file, err)
+			}
+
+			var resource dynamic.ResourceInterface = spokeDynClient.Resource(mapping.Resource)
+			if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+				if obj.GetNamespace() == "" {
+					obj.SetNamespace("default")
+				}
+				resource = spokeDynClient.Resource(mapping.Resource).Namespace(obj.GetNamespace())
+			}
+
+			data, err := obj.MarshalJSON()
+			if err != nil {
+				f.Close()
+				return fmt.Errorf("encoding %s %s: %w", gvk.Kind, obj.GetName(), err)
+			}
+			if _, err := resource.Patch(ctx, obj.GetName(), types.ApplyPatchType, data, metav1.PatchOptions{
+				FieldManager: spokeFieldManager,
+				Force:        &force,
+			}); err != nil {
+				f.Close()
+				return fmt.Errorf("applying %s %s from %s: %w", gvk.Kind, obj.GetName(), file, err)
+			}
+			if obj.GetNamespace() != "" {
+				log.Printf("[%s] Applied %s %s/%s", clusterName, gvk.Kind, obj.GetNamespace(), obj.GetName())
+			} else {
+				log.Printf("[%s] Applied %s %s", clusterName, gvk.Kind, obj.GetName())
+			}
+		}
+		f.Close()
 	}
-	labels["prelude-auth"] = "done"
-	claim.SetLabels(labels)
-	_, err = hubDynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).Update(ctx, claim, metav1.UpdateOptions{})
+	return nil
+}
+
+// ClaimStore is the set of ClusterClaim operations the authenticator performs.
+// Every claim read and write goes through it; dynamicClaimStore is the hub
+// implementation. Each call works on the claim namespace of pool.
+type ClaimStore interface {
+	ListClaims(ctx context.Context, pool string) (*unstructured.UnstructuredList, error)
+	WatchClaims(ctx context.Context, pool string, opts metav1.ListOptions) (watch.Interface, error)
+	// SetAuthenticated sets the prelude-auth=done label that hands the claim
+	// to the server.
+	SetAuthenticated(ctx context.Context, pool, name string) error
+}
+
+// claimStore is set in main once the hub dynamic client is built.
+var claimStore ClaimStore
+
+// dynamicClaimStore implements ClaimStore with the dynamic client against
+// each pool's claim namespace.
+type dynamicClaimStore struct {
+	dynClient dynamic.Interface
+}
+
+func (s dynamicClaimStore) claims(pool string) dynamic.ResourceInterface {
+	return s.dynClient.Resource(clusterClaimGVR).Namespace(claimNamespaces().Of(pool))
+}
+
+func (s dynamicClaimStore) ListClaims(ctx context.Context, pool string) (*unstructured.UnstructuredList, error) {
+	return s.claims(pool).List(ctx, metav1.ListOptions{})
+}
+
+func (s dynamicClaimStore) WatchClaims(ctx context.Context, pool string, opts metav1.ListOptions) (watch.Interface, error) {
+	return s.claims(pool).Watch(ctx, opts)
+}
+
+func (s dynamicClaimStore) SetAuthenticated(ctx context.Context, pool, name string) error {
+	// Merge patch touching only our label, so it can't clobber the server's
+	// concurrent phone/fingerprint label writes on the same claim
+	patch := []byte(`{"metadata":{"labels":{"prelude-auth":"done"}}}`)
+	_, err := s.claims(pool).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
 	return err
 }
 
+// poolNamespaces maps a ClusterPool name to the hub namespace holding it and
+// its ClusterClaims; pools not listed use clusterPoolNamespace.
+var poolNamespaces = map[string]string{}
+
+// claimNamespaces returns the hub namespaces of the pools and their claims.
+func claimNamespaces() pools.Namespaces {
+	return pools.Namespaces{Default: clusterPoolNamespace, Mapped: poolNamespaces}
+}
+
+// clusterPoolGVR is the Hive ClusterPool resource, in the group and version
+// configured for ClusterClaims.
+func clusterPoolGVR() schema.GroupVersionResource {
+	return clusterClaimGVR.GroupVersion().WithResource("clusterpools")
+}
+
+// checkClusterPool verifies the ClusterPool exists in the hub namespace.
+func checkClusterPool(ctx context.Context, dynClient dynamic.Interface, namespace, pool string) error {
+	if _, err := dynClient.Resource(clusterPoolGVR()).Namespace(namespace).Get(ctx, pool, metav1.GetOptions{}); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return fmt.Errorf("ClusterPool %s not found in namespace %s", pool, namespace)
+		}
+		return fmt.Errorf("checking ClusterPool %s: %w", pool, err)
+	}
+	return nil
+}
+
 // claimMatchesPool checks if a ClusterClaim belongs to the specified ClusterPool.
 func claimMatchesPool(obj map[string]interface{}, poolName string) bool {
 	spec, ok := obj["spec"].(map[string]interface{})
@@ -845,6 +1447,17 @@ func claimMatchesPool(obj map[string]interface{}, poolName string) bool {
 	return name == poolName
 }
 
+// claimDeleting reports whether a ClusterClaim has a deletionTimestamp, i.e. it
+// is being torn down and must not be authenticated.
+func claimDeleting(obj map[string]interface{}) bool {
+	metadata, ok := obj["metadata"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = metadata["deletionTimestamp"]
+	return ok
+}
+
 // getSpecNamespace returns spec.namespace from a ClusterClaim, or empty if not set.
 func getSpecNamespace(obj map[string]interface{}) string {
 	spec, ok := obj["spec"].(map[string]interface{})
@@ -881,23 +1494,37 @@ func getAdminKubeconfigSecretName(obj map[string]interface{}) string {
 }
 
 // extractKubeconfig reads kubeconfig data from a Secret, handling common key names
-// and base64-encoded values.
-func extractKubeconfig(secret *corev1.Secret) string {
-	var data string
-	if raw, ok := secret.Data["kubeconfig"]; ok {
-		data = string(raw)
-	} else if raw, ok := secret.Data["raw-kubeconfig"]; ok {
-		data = string(raw)
+// and base64-encoded values. The error names the Secret and key it read, so a
+// bad Secret is identifiable from the log.
+func extractKubeconfig(secret *corev1.Secret) (string, error) {
+	key := ""
+	if _, ok := secret.Data["kubeconfig"]; ok {
+		key = "kubeconfig"
+	} else if _, ok := secret.Data["raw-kubeconfig"]; ok {
+		key = "raw-kubeconfig"
 	} else {
-		for _, v := range secret.Data {
-			data = string(v)
-			break
+		// Lowest key, so the same Secret always yields the same one
+		for k := range secret.Data {
+			if key == "" || k < key {
+				key = k
+			}
 		}
 	}
+	if key == "" {
+		return "", fmt.Errorf("secret %s/%s has no data", secret.Namespace, secret.Name)
+	}
+	data := string(secret.Data[key])
 	if decoded, err := base64.StdEncoding.DecodeString(data); err == nil && len(decoded) > 0 && strings.Contains(string(decoded), "apiVersion") {
 		data = string(decoded)
 	}
-	return data
+	cfg, err := clientcmd.Load([]byte(data))
+	if err != nil {
+		return "", fmt.Errorf("secret %s/%s key %s isn't a valid kubeconfig: %w", secret.Namespace, secret.Name, key, err)
+	}
+	if len(cfg.Clusters) == 0 {
+		return "", fmt.Errorf("secret %s/%s key %s isn't a valid kubeconfig: no clusters", secret.Namespace, secret.Name, key)
+	}
+	return data, nil
 }
 
 // checkSignerExpiry periodically checks available clusters for CSR signer
@@ -913,7 +1540,7 @@ func checkSignerExpiry(ctx context.Context, hubDynClient dynamic.Interface, hubC
 	// Run immediately on startup, then every 10 minutes
 	for {
 		log.Printf("Checking CSR signer expiry for available clusters")
-		claims, err := hubDynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).List(ctx, metav1.ListOptions{})
+		claims, err := claimStore.ListClaims(ctx, pool)
 		if err != nil {
 			log.Printf("Warning: error listing ClusterClaims for signer check: %v", err)
 		} else {
@@ -922,7 +1549,7 @@ func checkSignerExpiry(ctx context.Context, hubDynClient dynamic.Interface, hubC
 					return
 				}
 
-				if !claimMatchesPool(claim.Object, pool) {
+				if !claimMatchesPool(claim.Object, pool) || claimDeleting(claim.Object) {
 					continue
 				}
 
@@ -946,8 +1573,13 @@ func checkSignerExpiry(ctx context.Context, hubDynClient dynamic.Interface, hubC
 					continue
 				}
 
+				// Skip claims an authentication flow is currently working on
 				claimName := claim.GetName()
+				if !acquireClaim(claimName) {
+					continue
+				}
 				rolled, err := checkAndRenewCerts(ctx, hubDynClient, hubClientset, claimName, clusterName)
+				releaseClaim(claimName)
 				if err != nil {
 					log.Printf("Warning: [%s] signer expiry check failed: %v", clusterName, err)
 				} else if rolled {
@@ -985,9 +1617,9 @@ func checkAndRenewCerts(ctx context.Context, hubDynClient dynamic.Interface, hub
 		return false, fmt.Errorf("getting admin kubeconfig secret: %w", err)
 	}
 
-	spokeKubeconfigData := extractKubeconfig(adminSecret)
-	if spokeKubeconfigData == "" {
-		return false, fmt.Errorf("admin kubeconfig secret %s has no kubeconfig data", adminSecretName)
+	spokeKubeconfigData, err := extractKubeconfig(adminSecret)
+	if err != nil {
+		return false, fmt.Errorf("reading admin kubeconfig: %w", err)
 	}
 
 	// Check admin kubeconfig client cert expiry first (cheap, no spoke call needed)
@@ -1011,8 +1643,9 @@ func checkAndRenewCerts(ctx context.Context, hubDynClient dynamic.Interface, hub
 	// Build spoke client to check CSR signer
 	spokeConfig, err := clientcmd.RESTConfigFromKubeConfig([]byte(spokeKubeconfigData))
 	if err != nil {
-		return false, fmt.Errorf("building spoke REST config: %w", err)
+		return false, fmt.Errorf("building spoke REST config from secret %s/%s: %w", clusterName, adminSecretName, err)
 	}
+	spokeConfig.UserAgent = userAgent
 	spokeConfig.TLSClientConfig.Insecure = true
 	spokeConfig.TLSClientConfig.CAData = nil
 	spokeConfig.TLSClientConfig.CAFile = ""
@@ -1067,11 +1700,11 @@ func checkAndRenewCerts(ctx context.Context, hubDynClient dynamic.Interface, hub
 
 	log.Printf("[%s] CSR signer has rolled (expires %s), signer-signer has rolled (expires %s), regenerating kubeconfig certs", clusterName, signerExpiry.Format(time.RFC3339), signerSignerExpiry.Format(time.RFC3339))
 
-	// Regenerate system:admin kubeconfig
-	log.Printf("[%s] Regenerating system:admin kubeconfig", clusterName)
-	adminKubeconfig, err := regenerateKubeconfig(ctx, spokeClientset, spokeConfig, "system:admin", "auth2kube-systemadmin-access", nil)
+	// Regenerate the admin kubeconfig
+	log.Printf("[%s] Regenerating %s kubeconfig", clusterName, adminCN)
+	adminKubeconfig, err := regenerateKubeconfig(ctx, spokeClientset, spokeConfig, adminCN, adminCSRName, nil)
 	if err != nil {
-		return false, fmt.Errorf("regenerating system:admin kubeconfig: %w", err)
+		return false, fmt.Errorf("regenerating %s kubeconfig: %w", adminCN, err)
 	}
 
 	// Verify the issued client cert has a long expiry (> 25 days)
@@ -1081,25 +1714,23 @@ func checkAndRenewCerts(ctx context.Context, hubDynClient dynamic.Interface, hub
 	if err != nil {
 		log.Printf("[%s] Warning: could not check issued cert expiry: %v", clusterName, err)
 	} else if time.Until(adminCertExpiry) <= 25*24*time.Hour {
-		log.Printf("[%s] Issued system:admin cert expires at %s (within 25 days) — kube-controller-manager hasn't loaded new signer yet, will retry", clusterName, adminCertExpiry.Format(time.RFC3339))
+		log.Printf("[%s] Issued %s cert expires at %s (within 25 days) — kube-controller-manager hasn't loaded new signer yet, will retry", clusterName, adminCN, adminCertExpiry.Format(time.RFC3339))
 		return false, nil
 	} else {
-		log.Printf("[%s] Issued system:admin cert expires at %s (long-lived, OK)", clusterName, adminCertExpiry.Format(time.RFC3339))
+		log.Printf("[%s] Issued %s cert expires at %s (long-lived, OK)", clusterName, adminCN, adminCertExpiry.Format(time.RFC3339))
 	}
 
 	// Update admin kubeconfig secret on hub
 	log.Printf("[%s] Updating admin kubeconfig secret on hub", clusterName)
-	adminSecret.Data["kubeconfig"] = []byte(adminKubeconfig)
-	adminSecret.Data["raw-kubeconfig"] = []byte(adminKubeconfig)
-	if _, err := hubClientset.CoreV1().Secrets(clusterName).Update(ctx, adminSecret, metav1.UpdateOptions{}); err != nil {
+	if err := updateKubeconfigSecret(ctx, hubClientset, clusterName, adminSecretName, adminKubeconfig); err != nil {
 		return false, fmt.Errorf("updating admin kubeconfig secret: %w", err)
 	}
 
-	// Regenerate admin user kubeconfig
-	log.Printf("[%s] Regenerating admin user kubeconfig", clusterName)
-	userKubeconfig, err := regenerateKubeconfig(ctx, spokeClientset, spokeConfig, "admin", "auth2kube-admin-access", []string{"admin"})
+	// Regenerate the user kubeconfig
+	log.Printf("[%s] Regenerating %s user kubeconfig", clusterName, userCN)
+	userKubeconfig, err := regenerateKubeconfig(ctx, spokeClientset, spokeConfig, userCN, userCSRName, userOrganizations())
 	if err != nil {
-		return false, fmt.Errorf("regenerating admin user kubeconfig: %w", err)
+		return false, fmt.Errorf("regenerating %s user kubeconfig: %w", userCN, err)
 	}
 
 	// Create/update user kubeconfig secret on hub
@@ -1159,6 +1790,9 @@ func sleepOrDone(ctx context.Context, d time.Duration) {
 	}
 }
 
+// logs samples the repetitive reconcile log lines, set from --log-sample-interval.
+var logs = logsample.New(time.Minute)
+
 // createKeycloakRealm creates or updates a KeycloakRealmImport CR on the hub cluster.
 func createKeycloakRealm(ctx context.Context, hubDynClient dynamic.Interface, hubClientset kubernetes.Interface, clusterName, keycloakURL, clientSecret, preludePassword string) error {
 	// Generate random initial password (overwritten by server at claim time)
@@ -1241,9 +1875,20 @@ func patchSpokeConsoleLogout(ctx context.Context, spokeDynClient dynamic.Interfa
 	return nil
 }
 
+// envOrDefault returns the value of the environment variable key, or def if unset.
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
 // buildConfig returns a Kubernetes REST config. It uses the KUBECONFIG env var
 // or ~/.kube/config if available, otherwise falls back to in-cluster config.
 func buildConfig() (*rest.Config, error) {
+	if hubServer != "" {
+		return hub.CertConfig(hubServer, hubClientCert, hubClientKey, hubCA)
+	}
 	kubeconfig := os.Getenv("KUBECONFIG")
 	if kubeconfig == "" {
 		home, err := os.UserHomeDir()
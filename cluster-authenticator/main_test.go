@@ -0,0 +1,525 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var secretsGVR = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+func TestUpdateKubeconfigSecretRetriesConflict(t *testing.T) {
+	clientset := kubefake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "spoke-admin-kubeconfig", Namespace: "spoke"},
+		Data:       map[string][]byte{"kubeconfig": []byte("old")},
+	})
+
+	// Another writer updates the secret between our read and our write, so
+	// the first Update conflicts
+	updates := 0
+	clientset.PrependReactor("update", "secrets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		updates++
+		if updates > 1 {
+			return false, nil, nil
+		}
+		obj, err := clientset.Tracker().Get(secretsGVR, "spoke", "spoke-admin-kubeconfig")
+		if err != nil {
+			return true, nil, err
+		}
+		secret := obj.(*corev1.Secret)
+		secret.Data["other"] = []byte("kept")
+		if err := clientset.Tracker().Update(secretsGVR, secret, "spoke"); err != nil {
+			return true, nil, err
+		}
+		return true, nil, k8serrors.NewConflict(secretsGVR.GroupResource(), secret.Name, errors.New("the object has been modified"))
+	})
+
+	if err := updateKubeconfigSecret(context.Background(), clientset, "spoke", "spoke-admin-kubeconfig", "new"); err != nil {
+		t.Fatalf("update failed on a benign conflict: %v", err)
+	}
+	if updates != 2 {
+		t.Errorf("Update called %d times, want a single retry", updates)
+	}
+
+	secret, err := clientset.CoreV1().Secrets("spoke").Get(context.Background(), "spoke-admin-kubeconfig", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"kubeconfig", "raw-kubeconfig"} {
+		if got := string(secret.Data[key]); got != "new" {
+			t.Errorf("%s = %q, want the new kubeconfig", key, got)
+		}
+	}
+	if got := string(secret.Data["other"]); got != "kept" {
+		t.Errorf("the other writer's key was lost: %q", got)
+	}
+}
+
+func TestUpdateKubeconfigSecretGivesUp(t *testing.T) {
+	clientset := kubefake.NewSimpleClientset(&corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "spoke-admin-kubeconfig", Namespace: "spoke"},
+	})
+	clientset.PrependReactor("update", "secrets", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8serrors.NewConflict(secretsGVR.GroupResource(), "spoke-admin-kubeconfig", errors.New("the object has been modified"))
+	})
+
+	err := updateKubeconfigSecret(context.Background(), clientset, "spoke", "spoke-admin-kubeconfig", "new")
+	if !k8serrors.IsConflict(err) {
+		t.Errorf("err = %v, want the conflict once retries run out", err)
+	}
+}
+
+const testPool = "prelude-test"
+
+// newTestHub returns a fake hub holding an unauthenticated claim bound to
+// cluster "spoke" and installs a claimStore over it for the test. Each GET of
+// the claim's ClusterDeployment, the first step of authenticating it, is sent
+// on the returned channel and answered NotFound.
+func newTestHub(t *testing.T) (*dynamicfake.FakeDynamicClient, <-chan string) {
+	claim := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": clusterClaimGVR.GroupVersion().String(),
+		"kind":       "ClusterClaim",
+		"metadata": map[string]interface{}{
+			"name":      "prelude-001",
+			"namespace": claimNamespaces().Of(testPool),
+		},
+		"spec": map[string]interface{}{
+			"clusterPoolName": testPool,
+			"namespace":       "spoke",
+		},
+	}}
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		clusterClaimGVR:      "ClusterClaimList",
+		clusterDeploymentGVR: "ClusterDeploymentList",
+	}, claim)
+	gets := make(chan string, 10)
+	dynClient.PrependReactor("get", "clusterdeployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		name := action.(clienttesting.GetAction).GetName()
+		gets <- name
+		return true, nil, k8serrors.NewNotFound(clusterDeploymentGVR.GroupResource(), name)
+	})
+	previous := claimStore
+	claimStore = dynamicClaimStore{dynClient}
+	t.Cleanup(func() { claimStore = previous })
+	return dynClient, gets
+}
+
+func TestAcquireClaimOnce(t *testing.T) {
+	const claimName = "prelude-acquire"
+	acquired := make(chan bool, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			acquired <- acquireClaim(claimName)
+		}()
+	}
+	wg.Wait()
+	close(acquired)
+	won := 0
+	for ok := range acquired {
+		if ok {
+			won++
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d flows acquired the claim, want 1", won)
+	}
+	releaseClaim(claimName)
+	if !acquireClaim(claimName) {
+		t.Error("claim could not be acquired again after release")
+	}
+	releaseClaim(claimName)
+}
+
+func TestProcessUnauthenticatedClaimsSkipsInFlight(t *testing.T) {
+	dynClient, gets := newTestHub(t)
+	clientset := kubefake.NewSimpleClientset()
+	ctx := context.Background()
+
+	// A previous pass is still authenticating the claim
+	if !acquireClaim("prelude-001") {
+		t.Fatal("claim already in flight")
+	}
+	processUnauthenticatedClaims(ctx, dynClient, clientset, testPool)
+	select {
+	case name := <-gets:
+		t.Fatalf("a second flow started on in-flight cluster %s", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Once that pass is done, the next one picks the claim up
+	releaseClaim("prelude-001")
+	processUnauthenticatedClaims(ctx, dynClient, clientset, testPool)
+	select {
+	case name := <-gets:
+		if name != "spoke" {
+			t.Errorf("authenticated cluster %s, want spoke", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the released claim was never authenticated")
+	}
+	// Wait for that flow to fail and release the claim
+	for i := 0; i < 100 && !acquireClaim("prelude-001"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	releaseClaim("prelude-001")
+}
+
+// forbidApproval makes approving CSRs on clientset forbidden, as on a spoke
+// where the authenticator may create CSRs but lacks the approve verb.
+func forbidApproval(clientset *kubefake.Clientset) {
+	clientset.PrependReactor("update", "certificatesigningrequests", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "approval" {
+			return false, nil, nil
+		}
+		return true, nil, k8serrors.NewForbidden(schema.GroupResource{Group: "certificates.k8s.io", Resource: "certificatesigningrequests"}, "prelude-test", errors.New("cannot approve"))
+	})
+}
+
+func TestRegenerateKubeconfigForbiddenApproval(t *testing.T) {
+	clientset := kubefake.NewSimpleClientset()
+	forbidApproval(clientset)
+
+	_, err := regenerateKubeconfig(context.Background(), clientset, &rest.Config{Host: "https://127.0.0.1:1"}, "prelude-user", "prelude-test", []string{"admin"})
+	if err == nil || !strings.Contains(err.Error(), "missing approve permission on certificatesigningrequests") {
+		t.Fatalf("err = %v, want the missing approve permission error", err)
+	}
+	if !k8serrors.IsForbidden(errors.Unwrap(err)) {
+		t.Errorf("err = %v, want it to wrap the Forbidden error", err)
+	}
+}
+
+func TestRegenerateKubeconfigSkipCSRApproval(t *testing.T) {
+	previous := skipCSRApproval
+	skipCSRApproval = true
+	t.Cleanup(func() { skipCSRApproval = previous })
+
+	clientset := kubefake.NewSimpleClientset()
+	forbidApproval(clientset)
+	// Someone else approves the CSR and the signer issues the certificate
+	clientset.PrependReactor("get", "certificatesigningrequests", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		csr := &certificatesv1.CertificateSigningRequest{ObjectMeta: metav1.ObjectMeta{Name: action.(clienttesting.GetAction).GetName()}}
+		csr.Status.Certificate = []byte("issued")
+		return true, csr, nil
+	})
+
+	// Past the approval, the flow goes on to read the spoke's CA, which
+	// fails against this unreachable host
+	_, err := regenerateKubeconfig(context.Background(), clientset, &rest.Config{Host: "https://127.0.0.1:1"}, "prelude-user", "prelude-test", []string{"admin"})
+	if err == nil || !strings.Contains(err.Error(), "extracting CA cert") {
+		t.Fatalf("err = %v, want the flow to continue past the forbidden approval", err)
+	}
+}
+
+func TestRegenerateKubeconfigDryRun(t *testing.T) {
+	previous := csrDryRun
+	csrDryRun = true
+	t.Cleanup(func() { csrDryRun = previous })
+
+	clientset := kubefake.NewSimpleClientset()
+	_, err := regenerateKubeconfig(context.Background(), clientset, &rest.Config{Host: "https://127.0.0.1:1"}, "prelude-user", "prelude-test", []string{"admin"})
+	if !errors.Is(err, errCSRDryRun) {
+		t.Fatalf("err = %v, want errCSRDryRun", err)
+	}
+
+	// A failed dry-run approval is an error, so the claim is retried rather
+	// than recorded as dry-run
+	forbidApproval(clientset)
+	_, err = regenerateKubeconfig(context.Background(), clientset, &rest.Config{Host: "https://127.0.0.1:1"}, "prelude-user", "prelude-test", []string{"admin"})
+	if err == nil || errors.Is(err, errCSRDryRun) || !k8serrors.IsForbidden(errors.Unwrap(err)) {
+		t.Fatalf("err = %v, want the Forbidden dry-run approval error", err)
+	}
+}
+
+// testClusterOperator builds a ClusterOperator, stable or still progressing.
+func testClusterOperator(name string, stable bool) *unstructured.Unstructured {
+	progressing := "False"
+	if !stable {
+		progressing = "True"
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": clusterOperatorGVR.GroupVersion().String(),
+		"kind":       "ClusterOperator",
+		"metadata":   map[string]interface{}{"name": name},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Available", "status": "True"},
+				map[string]interface{}{"type": "Progressing", "status": progressing},
+				map[string]interface{}{"type": "Degraded", "status": "False"},
+			},
+		},
+	}}
+}
+
+// testCertificate builds a Ready cert-manager Certificate.
+func testCertificate(namespace, name string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": certificateGVR.GroupVersion().String(),
+		"kind":       "Certificate",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True"},
+			},
+		},
+	}}
+}
+
+// newTestSpoke returns a fake spoke holding objs.
+func newTestSpoke(objs ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		clusterOperatorGVR: "ClusterOperatorList",
+		certificateGVR:     "CertificateList",
+	}, objs...)
+}
+
+func TestWaitForStableClusterReportsProgress(t *testing.T) {
+	spoke := newTestSpoke(
+		testClusterOperator("authentication", true),
+		testClusterOperator("ingress", false),
+		testCertificate("openshift-ingress", "apps-cert"),
+		testCertificate("openshift-config", "api-cert"),
+	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var reports []stabilityProgress
+	err := waitForStableCluster(ctx, spoke, "spoke", func(p stabilityProgress) {
+		reports = append(reports, p)
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want the wait to end with the cancelled context", err)
+	}
+	if len(reports) != 1 {
+		t.Fatalf("progress called %d times before cancel, want once per poll", len(reports))
+	}
+	want := stabilityProgress{StableOperators: 1, TotalOperators: 2, CertsReady: true}
+	if reports[0] != want {
+		t.Errorf("progress = %+v, want %+v", reports[0], want)
+	}
+}
+
+func TestAreClusterOperatorsStableAPINotServed(t *testing.T) {
+	spoke := newTestSpoke()
+	spoke.PrependReactor("list", "clusteroperators", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8serrors.NewNotFound(clusterOperatorGVR.GroupResource(), "")
+	})
+
+	_, _, err := areClusterOperatorsStable(context.Background(), spoke, "spoke")
+	if !errors.Is(err, errClusterOperatorsUnavailable) {
+		t.Fatalf("err = %v, want errClusterOperatorsUnavailable", err)
+	}
+
+	// With --require-clusteroperators the wait gives up at once instead of
+	// running into the 30 minute timeout
+	previous := requireClusterOperators
+	requireClusterOperators = true
+	t.Cleanup(func() { requireClusterOperators = previous })
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := waitForStableCluster(ctx, spoke, "spoke", nil); !errors.Is(err, errClusterOperatorsUnavailable) {
+		t.Errorf("waitForStableCluster err = %v, want errClusterOperatorsUnavailable", err)
+	}
+}
+
+func TestAreClusterOperatorsStableEmptyList(t *testing.T) {
+	stable, total, err := areClusterOperatorsStable(context.Background(), newTestSpoke(), "spoke")
+	if err != nil || stable != 0 || total != 0 {
+		t.Errorf("got %d/%d, %v, want 0/0 and no error while the cluster comes up", stable, total, err)
+	}
+}
+
+// useUserIdentity sets the --user-cn and --user-role the spoke resources are
+// created for, and the default spoke configmap, for the test.
+func useUserIdentity(t *testing.T, cn, role string) {
+	previousCN, previousRole, previousCM, previousNS := userCN, userRole, spokeConfigMapName, spokeConfigMapNamespace
+	userCN, userRole, spokeConfigMapName, spokeConfigMapNamespace = cn, role, "prelude", "openshift-config"
+	t.Cleanup(func() {
+		userCN, userRole, spokeConfigMapName, spokeConfigMapNamespace = previousCN, previousRole, previousCM, previousNS
+	})
+}
+
+func TestCreateSpokeResourcesOtherManager(t *testing.T) {
+	useUserIdentity(t, "admin", "cluster-admin")
+	ctx := context.Background()
+	clientset := kubefake.NewClientset()
+
+	// ACM got there first: the marker configmap carries its data, and the
+	// binding points the user at a different role
+	otherManager := metav1.CreateOptions{FieldManager: "acm-policy"}
+	if _, err := clientset.CoreV1().ConfigMaps(spokeConfigMapNamespace).Create(ctx, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: spokeConfigMapName, Namespace: spokeConfigMapNamespace},
+		Data:       map[string]string{"owner": "acm"},
+	}, otherManager); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clientset.RbacV1().ClusterRoleBindings().Create(ctx, &rbacv1.ClusterRoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "prelude-user-admin"},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: "view"},
+		Subjects:   []rbacv1.Subject{{APIGroup: rbacv1.GroupName, Kind: rbacv1.UserKind, Name: "someone-else"}},
+	}, otherManager); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := createSpokeResources(ctx, clientset, "spoke"); err != nil {
+		t.Fatalf("createSpokeResources failed on resources owned by another manager: %v", err)
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps(spokeConfigMapNamespace).Get(ctx, spokeConfigMapName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cm.Data["owner"] != "acm" {
+		t.Errorf("the other manager's configmap data was lost: %v", cm.Data)
+	}
+	crb, err := clientset.RbacV1().ClusterRoleBindings().Get(ctx, "prelude-user-admin", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crb.RoleRef.Name != "view" || len(crb.Subjects) != 1 || crb.Subjects[0].Name != "someone-else" {
+		t.Errorf("the other manager's binding was overwritten: %+v %+v", crb.RoleRef, crb.Subjects)
+	}
+}
+
+func TestCreateSpokeResourcesFresh(t *testing.T) {
+	useUserIdentity(t, "prelude:user", "cluster-admin")
+	ctx := context.Background()
+	clientset := kubefake.NewClientset()
+
+	// Applying twice is idempotent
+	for i := 0; i < 2; i++ {
+		if err := createSpokeResources(ctx, clientset, "spoke"); err != nil {
+			t.Fatalf("pass %d: %v", i+1, err)
+		}
+	}
+	if _, err := clientset.CoreV1().ConfigMaps(spokeConfigMapNamespace).Get(ctx, spokeConfigMapName, metav1.GetOptions{}); err != nil {
+		t.Errorf("marker configmap not created: %v", err)
+	}
+	crb, err := clientset.RbacV1().ClusterRoleBindings().Get(ctx, "prelude-user-prelude-user", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("user binding not created: %v", err)
+	}
+	if crb.RoleRef.Name != "cluster-admin" || len(crb.Subjects) != 1 || crb.Subjects[0].Name != "prelude:user" {
+		t.Errorf("binding = %+v %+v, want prelude:user bound to cluster-admin", crb.RoleRef, crb.Subjects)
+	}
+}
+
+func TestCreateSpokeResourcesRoleChanged(t *testing.T) {
+	useUserIdentity(t, "admin", "view")
+	ctx := context.Background()
+	clientset := kubefake.NewClientset()
+	if err := createSpokeResources(ctx, clientset, "spoke"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake does not validate, so reject a changed roleRef as the API
+	// server would
+	crbGVR := rbacv1.SchemeGroupVersion.WithResource("clusterrolebindings")
+	clientset.PrependReactor("patch", "clusterrolebindings", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		patch := action.(clienttesting.PatchAction)
+		existing, err := clientset.Tracker().Get(crbGVR, "", patch.GetName())
+		if err != nil {
+			return false, nil, nil
+		}
+		if !strings.Contains(string(patch.GetPatch()), `"name":"`+existing.(*rbacv1.ClusterRoleBinding).RoleRef.Name+`"`) {
+			return true, nil, k8serrors.NewInvalid(rbacv1.SchemeGroupVersion.WithKind("ClusterRoleBinding").GroupKind(), patch.GetName(), nil)
+		}
+		return false, nil, nil
+	})
+
+	userRole = "cluster-admin"
+	if err := createSpokeResources(ctx, clientset, "spoke"); err != nil {
+		t.Fatalf("changing the user role: %v", err)
+	}
+	crb, err := clientset.RbacV1().ClusterRoleBindings().Get(ctx, "prelude-user-admin", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("user binding not recreated: %v", err)
+	}
+	if crb.RoleRef.Name != "cluster-admin" || len(crb.Subjects) != 1 || crb.Subjects[0].Name != "admin" {
+		t.Errorf("binding = %+v %+v, want admin bound to cluster-admin", crb.RoleRef, crb.Subjects)
+	}
+}
+
+func TestProcessUnauthenticatedClaimsMappedNamespace(t *testing.T) {
+	previous := poolNamespaces
+	poolNamespaces = map[string]string{testPool: "ns-a"}
+	t.Cleanup(func() { poolNamespaces = previous })
+	dynClient, gets := newTestHub(t)
+
+	processUnauthenticatedClaims(context.Background(), dynClient, kubefake.NewSimpleClientset(), testPool)
+	select {
+	case name := <-gets:
+		if name != "spoke" {
+			t.Errorf("authenticating cluster %s, want spoke", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the claim in the pool's mapped namespace was not picked up")
+	}
+}
+
+func TestProcessUnauthenticatedClaimsSkipsDeleting(t *testing.T) {
+	dynClient, gets := newTestHub(t)
+	obj, err := dynClient.Tracker().Get(clusterClaimGVR, clusterPoolNamespace, "prelude-001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claim := obj.(*unstructured.Unstructured)
+	now := metav1.Now()
+	claim.SetDeletionTimestamp(&now)
+	if err := dynClient.Tracker().Update(clusterClaimGVR, claim, clusterPoolNamespace); err != nil {
+		t.Fatal(err)
+	}
+
+	processUnauthenticatedClaims(context.Background(), dynClient, kubefake.NewSimpleClientset(), testPool)
+	select {
+	case name := <-gets:
+		t.Fatalf("started authenticating cluster %s of a claim being deleted", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestProcessUnauthenticatedClaimsSkipsDryRunDone(t *testing.T) {
+	dynClient, gets := newTestHub(t)
+	dryRunDone.Store("prelude-001", true)
+	t.Cleanup(func() { dryRunDone.Delete("prelude-001") })
+
+	processUnauthenticatedClaims(context.Background(), dynClient, kubefake.NewSimpleClientset(), testPool)
+	select {
+	case name := <-gets:
+		t.Fatalf("dry-ran cluster %s again", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestUserOrganizations(t *testing.T) {
+	previous := userOrg
+	t.Cleanup(func() { userOrg = previous })
+
+	userOrg = "admin"
+	if got := userOrganizations(); len(got) != 1 || got[0] != "admin" {
+		t.Errorf("userOrganizations() = %v, want [admin]", got)
+	}
+	userOrg = ""
+	if got := userOrganizations(); got != nil {
+		t.Errorf("userOrganizations() = %v with --user-org empty, want no group", got)
+	}
+}
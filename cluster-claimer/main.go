@@ -2,18 +2,32 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
+	"text/template"
 	"time"
 
+	"github.com/prelude/internal/duration"
+	"github.com/prelude/internal/hub"
+	"github.com/prelude/internal/logsample"
+	"github.com/prelude/internal/pools"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
@@ -34,17 +48,153 @@ var (
 	clusterPoolNamespace = "cluster-pools"
 )
 
+// poolNamespaces maps a ClusterPool name to the hub namespace holding it and
+// its ClusterClaims; pools not listed use clusterPoolNamespace.
+var poolNamespaces = map[string]string{}
+
+// claimNamespaces returns the hub namespaces of the pools and their claims.
+func claimNamespaces() pools.Namespaces {
+	return pools.Namespaces{Default: clusterPoolNamespace, Mapped: poolNamespaces}
+}
+
+// reconcileTriggers holds, per pool being reconciled, the channel that wakes
+// its reconcile loop early when a manual reconcile is requested via the
+// trigger endpoint. Each is buffered so requests never block and coalesce
+// while a pass is already pending.
+var reconcileTriggers = struct {
+	sync.Mutex
+	m map[string]chan struct{}
+}{m: map[string]chan struct{}{}}
+
+// claimPendingTimeout, when set, is how long a created ClusterClaim may stay
+// unbound before the claimer deletes it. Hive's spec.lifetime only counts
+// from assignment, so it never reaps a claim stuck Pending.
+var claimPendingTimeout time.Duration
+
+// reassignCooldown mirrors the server's --reassign-cooldown: claims it released
+// recently aren't handed out yet, so they don't count as available.
+var reassignCooldown time.Duration
+
+// countUnauthenticated counts bound claims the authenticator hasn't finished
+// with yet as available capacity, so a slow authenticator doesn't look like
+// an empty pool and trigger scale-ups that can't help.
+var countUnauthenticated bool
+
+// defaultClaimNameTemplate zero-pads the index so claim names sort in order
+// in oc get and UIs (prelude-002 before prelude-010).
+const defaultClaimNameTemplate = `prelude-{{printf "%03d" .Index}}`
+
+// claimNameTemplate renders the name of each created ClusterClaim, set from
+// --claim-name-template.
+var claimNameTemplate = template.Must(template.New("claim-name").Parse(defaultClaimNameTemplate))
+
+// claimNameData is what claimNameTemplate is rendered with.
+type claimNameData struct {
+	Index int
+	Pool  string
+}
+
+// shutdownGrace bounds how long shutdown waits for a claim create that is
+// already in flight, kept under the pod's default 30s termination grace.
+const shutdownGrace = 20 * time.Second
+
+// pendingTimeoutAnnotation marks claims the claimer deletes if still unbound
+// after claimPendingTimeout. Claims created by older claimers also carry the
+// timeout in spec.lifetime.
+const pendingTimeoutAnnotation = "prelude-pending-timeout"
+
+// version is set at build time with -ldflags "-X main.version=<version>".
+var version = "dev"
+
+// userAgent identifies this component in hub API server audit logs.
+var userAgent = "prelude-claimer/" + version
+
+// Explicit hub connection settings, taking precedence over kubeconfig when
+// hubServer is set.
+var hubServer string
+var hubClientCert string
+var hubClientKey string
+var hubCA string
+
 func main() {
-	clusterPool := flag.String("cluster-pool", os.Getenv("CLUSTER_POOL"), "ClusterPool name to filter by (required)")
+	clusterPool := flag.String("cluster-pool", os.Getenv("CLUSTER_POOL"), "ClusterPool name to filter by (required unless --pool-selector is set)")
+	poolSelector := flag.String("pool-selector", os.Getenv("POOL_SELECTOR"), "Label selector for ClusterPools; every match is worked on instead of --cluster-pool, re-resolved every --pool-resolve-interval")
+	poolResolveIntervalStr := flag.String("pool-resolve-interval", os.Getenv("POOL_RESOLVE_INTERVAL"), "How often --pool-selector is re-resolved (default 5m)")
 	clusterClaimLimitStr := flag.String("cluster-claim-limit", os.Getenv("CLUSTER_CLAIM_LIMIT"), "Base number of ClusterClaims to create (default 4)")
 	clusterClaimMaxStr := flag.String("cluster-claim-max", os.Getenv("CLUSTER_CLAIM_MAX"), "Maximum number of ClusterClaims when scaling up (default 10)")
 	clusterClaimIncrementStr := flag.String("cluster-claim-increment", os.Getenv("CLUSTER_CLAIM_INCREMENT"), "Number of ClusterClaims to add when scaling up (default 1)")
 	clusterClaimAvailableThresholdStr := flag.String("cluster-claim-available-threshold", os.Getenv("CLUSTER_CLAIM_AVAILABLE_THRESHOLD"), "Available cluster count at which to trigger scale-up (default 1)")
+	triggerAddr := flag.String("trigger-addr", os.Getenv("TRIGGER_ADDR"), "Listen address for the POST /reconcile endpoint that triggers an immediate claim reconcile (disabled if empty)")
+	claimNameTemplateStr := flag.String("claim-name-template", os.Getenv("CLAIM_NAME_TEMPLATE"), "Go template for created ClusterClaim names, with .Index and .Pool (default "+defaultClaimNameTemplate+")")
+	fixedClaims := flag.Bool("fixed-claims", os.Getenv("FIXED_CLAIMS") == "true", "Maintain exactly --cluster-claim-limit claims, disabling dynamic scaling")
+	flag.BoolVar(&countUnauthenticated, "count-unauthenticated", os.Getenv("COUNT_UNAUTHENTICATED") == "true", "Count bound claims still waiting on the authenticator as available when deciding to scale up")
+	flag.StringVar(&hubServer, "hub-server", os.Getenv("HUB_SERVER"), "Hub API server URL; with --hub-client-cert/--hub-client-key, used instead of a kubeconfig")
+	flag.StringVar(&hubClientCert, "hub-client-cert", os.Getenv("HUB_CLIENT_CERT"), "Client certificate file for --hub-server")
+	flag.StringVar(&hubClientKey, "hub-client-key", os.Getenv("HUB_CLIENT_KEY"), "Client key file for --hub-server")
+	flag.StringVar(&hubCA, "hub-ca", os.Getenv("HUB_CA"), "CA bundle file for --hub-server (default system roots)")
+	reassignCooldownStr := flag.String("reassign-cooldown", os.Getenv("REASSIGN_COOLDOWN"), "Don't count claims the server released less than this long ago as available (e.g. 15m, match the server's --reassign-cooldown)")
+	claimPendingTimeoutStr := flag.String("claim-pending-timeout", os.Getenv("CLAIM_PENDING_TIMEOUT"), "Delete created ClusterClaims still Pending after this long (e.g. 2h, unset by default)")
+	claimNamespace := flag.String("claim-namespace", os.Getenv("CLAIM_NAMESPACE"), "Hub namespace holding the ClusterPools and ClusterClaims not listed in --pool-namespace (default cluster-pools)")
+	poolNamespace := flag.String("pool-namespace", os.Getenv("POOL_NAMESPACE"), "Comma-separated pool=namespace mapping of ClusterPool and ClusterClaim namespaces (e.g. poolA=ns-a,poolB=ns-b), as given to the server")
+	skipPoolCheck := flag.Bool("skip-pool-check", os.Getenv("SKIP_POOL_CHECK") == "true", "Start even if the ClusterPool doesn't exist yet (bootstrap)")
+	hiveGroup := flag.String("hive-group", os.Getenv("HIVE_GROUP"), "API group of the Hive ClusterClaim/ClusterDeployment resources (default hive.openshift.io)")
+	hiveVersion := flag.String("hive-version", os.Getenv("HIVE_VERSION"), "API version of the Hive ClusterClaim/ClusterDeployment resources (default v1)")
+	logSampleIntervalStr := flag.String("log-sample-interval", os.Getenv("LOG_SAMPLE_INTERVAL"), "Log repeated reconcile messages at most this often unless their state changes (e.g. 30s, default 1m, 0 logs every pass)")
 	flag.Parse()
 
-	if *clusterPool == "" {
+	if *logSampleIntervalStr != "" {
+		d, err := duration.Parse(*logSampleIntervalStr)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid --log-sample-interval %q", *logSampleIntervalStr)
+		}
+		logs.SetInterval(d)
+	}
+
+	// Allow adapting to Hive API group/version changes without a rebuild
+	if *hiveGroup != "" {
+		clusterClaimGVR.Group = *hiveGroup
+		clusterDeploymentGVR.Group = *hiveGroup
+	}
+	if *hiveVersion != "" {
+		clusterClaimGVR.Version = *hiveVersion
+		clusterDeploymentGVR.Version = *hiveVersion
+	}
+	if *hiveGroup != "" || *hiveVersion != "" {
+		log.Printf("Using Hive API %s/%s", clusterClaimGVR.Group, clusterClaimGVR.Version)
+	}
+
+	if *clusterPool == "" && *poolSelector == "" {
 		log.Fatalf("--cluster-pool flag or CLUSTER_POOL environment variable is required")
 	}
+	if *clusterPool != "" && *poolSelector != "" {
+		log.Fatalf("--cluster-pool and --pool-selector are mutually exclusive")
+	}
+	if *poolSelector != "" {
+		if _, err := labels.Parse(*poolSelector); err != nil {
+			log.Fatalf("Invalid --pool-selector %q: %v", *poolSelector, err)
+		}
+	}
+	if *claimNamespace != "" {
+		clusterPoolNamespace = *claimNamespace
+	}
+	mapped, err := pools.ParseNamespaces(*poolNamespace)
+	if err != nil {
+		log.Fatalf("Invalid --pool-namespace: %v", err)
+	}
+	poolNamespaces = mapped
+	poolResolveInterval := 5 * time.Minute
+	if *poolResolveIntervalStr != "" {
+		d, err := duration.Parse(*poolResolveIntervalStr)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid --pool-resolve-interval value %q", *poolResolveIntervalStr)
+		}
+		poolResolveInterval = d
+	}
+	// Selected pools are only known later, so check the template with a stand-in
+	templatePool := *clusterPool
+	if templatePool == "" {
+		templatePool = "pool"
+	}
 
 	claimLimit := 4
 	if *clusterClaimLimitStr != "" {
@@ -76,26 +226,81 @@ func main() {
 		}
 	}
 
-	if claimMax < claimLimit {
+	if *reassignCooldownStr != "" {
+		d, err := duration.Parse(*reassignCooldownStr)
+		if err != nil {
+			log.Fatalf("Invalid --reassign-cooldown value %q: %v", *reassignCooldownStr, err)
+		}
+		reassignCooldown = d
+		log.Printf("Reassign cooldown: claims released within %v don't count as available", reassignCooldown)
+	}
+	if countUnauthenticated {
+		log.Printf("Bound claims waiting on the authenticator count as available for scaling")
+	}
+	if *claimPendingTimeoutStr != "" {
+		d, err := duration.Parse(*claimPendingTimeoutStr)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid --claim-pending-timeout value %q", *claimPendingTimeoutStr)
+		}
+		claimPendingTimeout = d
+		log.Printf("Claim pending timeout: %v (claims still unbound after it are deleted)", claimPendingTimeout)
+	}
+
+	if *claimNameTemplateStr != "" {
+		if err := setClaimNameTemplate(*claimNameTemplateStr, templatePool); err != nil {
+			log.Fatalf("Invalid --claim-name-template value %q: %v", *claimNameTemplateStr, err)
+		}
+	}
+	first, err := claimName(1, templatePool)
+	if err != nil {
+		log.Fatalf("Rendering claim name: %v", err)
+	}
+	log.Printf("Claim names: %s, ...", first)
+
+	if claimMax < claimLimit || *fixedClaims {
 		claimMax = claimLimit
 	}
 
-	log.Printf("Cluster pool: %s", *clusterPool)
-	log.Printf("Cluster claim limit: %d (max: %d, increment: %d, available threshold: %d)", claimLimit, claimMax, claimIncrement, availableThreshold)
+	if *poolSelector != "" {
+		log.Printf("Cluster pools: all matching %s (re-resolved every %v)", *poolSelector, poolResolveInterval)
+	} else {
+		log.Printf("Cluster pool: %s (namespace %s)", *clusterPool, claimNamespaces().Of(*clusterPool))
+	}
+	if *fixedClaims {
+		log.Printf("Cluster claim limit: fixed at %d (dynamic scaling disabled)", claimLimit)
+	} else {
+		log.Printf("Cluster claim limit: %d (max: %d, increment: %d, available threshold: %d)", claimLimit, claimMax, claimIncrement, availableThreshold)
+	}
 
 	config, err := buildConfig()
 	if err != nil {
 		log.Fatalf("Error building kubeconfig: %v", err)
 	}
+	config.UserAgent = userAgent
 
 	dynClient, err := dynamic.NewForConfig(config)
 	if err != nil {
 		log.Fatalf("Error creating dynamic client: %v", err)
 	}
+	claimStore = dynamicClaimStore{dynClient}
+
+	// Fail fast on a misnamed pool instead of silently doing nothing
+	if *poolSelector != "" && !*skipPoolCheck {
+		matched, err := pools.Matching(context.Background(), dynClient, clusterPoolGVR(), claimNamespaces(), *poolSelector)
+		if err != nil {
+			log.Fatalf("Error resolving --pool-selector: %v", err)
+		}
+		if len(matched) == 0 {
+			log.Fatalf("No ClusterPool matches --pool-selector %q (use --skip-pool-check if the pool is created later)", *poolSelector)
+		}
+	} else if !*skipPoolCheck {
+		if err := checkClusterPool(context.Background(), dynClient, claimNamespaces().Of(*clusterPool), *clusterPool); err != nil {
+			log.Fatalf("%v (use --skip-pool-check if the pool is created later)", err)
+		}
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	pool := *clusterPool
 
 	// Handle shutdown signals
 	sig := make(chan os.Signal, 1)
@@ -106,69 +311,163 @@ func main() {
 		cancel()
 	}()
 
-	// Step 1: Wait for at least one provisioned ClusterDeployment
+	// Optional endpoint to trigger an immediate reconcile, e.g. after adding pool capacity
+	if *triggerAddr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/reconcile", handleReconcileTrigger)
+		go func() {
+			log.Printf("Reconcile trigger listening on %s", *triggerAddr)
+			if err := http.ListenAndServe(*triggerAddr, mux); err != nil {
+				log.Printf("Reconcile trigger server error: %v", err)
+			}
+		}()
+	}
+
+	// With --pool-selector every matching pool is claimed from concurrently.
+	// A pool that fails is logged and retried on the next resolve, leaving
+	// the others running; a single --cluster-pool failing is fatal.
+	var totalCreated int
+	var totalMu sync.Mutex
+	claimFrom := func(ctx context.Context, pool string) error {
+		created, err := runPool(ctx, dynClient, pool, claimLimit, claimMax, claimIncrement, availableThreshold, *fixedClaims)
+		totalMu.Lock()
+		totalCreated += created
+		totalMu.Unlock()
+		return err
+	}
+
+	// The work runs on its own goroutine so shutdown can wait, boundedly,
+	// for a claim create that is in flight when the signal arrives.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if *poolSelector != "" {
+			pools.Follow(ctx, dynClient, clusterPoolGVR(), claimNamespaces(), *poolSelector, poolResolveInterval, func(ctx context.Context, pool string) {
+				if err := claimFrom(ctx, pool); err != nil {
+					log.Printf("[%s] %v", pool, err)
+				}
+			})
+		} else if err := claimFrom(ctx, *clusterPool); err != nil {
+			log.Fatalf("%v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Cluster claimer shutting down, waiting up to %v for in-flight creates", shutdownGrace)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		log.Printf("Cluster claimer shut down cleanly, %d claim(s) created this run", totalCreated)
+	case <-time.After(shutdownGrace):
+		log.Printf("Cluster claimer shutdown timed out after %v, a claim create may not have completed", shutdownGrace)
+	}
+}
+
+// runPool claims from one pool until ctx is cancelled: it waits for the
+// pool's first provisioned ClusterDeployment, then runs the reconcile loop.
+// It returns the number of claims created, and an error if the pool never
+// provisioned.
+func runPool(ctx context.Context, dynClient dynamic.Interface, pool string, baseLimit, maxLimit, increment, availableThreshold int, fixed bool) (int, error) {
 	log.Printf("Waiting for cluster pool %s to be provisioned...", pool)
 	if err := waitForProvisioned(ctx, dynClient, pool); err != nil {
-		log.Fatalf("Error waiting for provisioned: %v", err)
+		if ctx.Err() != nil {
+			log.Printf("Stopped waiting for pool %s before it was provisioned", pool)
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error waiting for provisioned: %w", err)
 	}
-
-	// Step 2: Reconcile loop — watch for changes and create claims as needed
-	reconcile(ctx, dynClient, pool, claimLimit, claimMax, claimIncrement, availableThreshold)
-	log.Printf("Cluster claimer shutting down")
+	return reconcile(ctx, dynClient, pool, baseLimit, maxLimit, increment, availableThreshold, fixed), nil
 }
 
 // reconcile continuously watches ClusterDeployments and creates ClusterClaims
 // as new deployments become provisioned, up to the claim limit. The effective
 // limit starts at baseLimit and increases when no clusters are available,
 // up to maxLimit. It scales back down to baseLimit after clusters have been
-// available for 10 minutes (hysteresis).
-func reconcile(ctx context.Context, dynClient dynamic.Interface, pool string, baseLimit, maxLimit, increment, availableThreshold int) {
+// available for 10 minutes (hysteresis). In fixed mode the limit never changes
+// and the scaling logic is skipped entirely. It returns the number of claims
+// created once ctx is cancelled.
+func reconcile(ctx context.Context, dynClient dynamic.Interface, pool string, baseLimit, maxLimit, increment, availableThreshold int, fixed bool) int {
 	labelSelector := fmt.Sprintf("hive.openshift.io/clusterpool-name=%s", pool)
 	effectiveLimit := baseLimit
 	var availableSince time.Time // when available clusters were first seen
-	var lastScaleUp time.Time   // when we last scaled up (25min cooldown)
+	var lastScaleUp time.Time    // when we last scaled up (25min cooldown)
+	lastCounts := ""             // last logged claim counts, to log only on change
+	totalCreated := 0
+
+	reconcileNow := make(chan struct{}, 1)
+	reconcileTriggers.Lock()
+	reconcileTriggers.m[pool] = reconcileNow
+	reconcileTriggers.Unlock()
+	defer func() {
+		reconcileTriggers.Lock()
+		delete(reconcileTriggers.m, pool)
+		reconcileTriggers.Unlock()
+	}()
 
 	for {
 		if ctx.Err() != nil {
-			return
+			return totalCreated
 		}
 
-		// Dynamic scaling of effective limit
-		available, ready, err := countAvailableAndReadyClaims(ctx, dynClient, pool)
-		if err != nil {
-			log.Printf("Error counting available claims: %v", err)
-		} else if available <= availableThreshold && ready > 0 {
-			// Available clusters at or below threshold — scale up (with 25min cooldown) and reset scale-down timer
-			availableSince = time.Time{}
-			if effectiveLimit < maxLimit {
-				if !lastScaleUp.IsZero() && time.Since(lastScaleUp) < 25*time.Minute {
-					log.Printf("No available clusters, waiting for previous scale-up to take effect (%s ago)", time.Since(lastScaleUp).Truncate(time.Second))
-				} else {
-					prev := effectiveLimit
-					effectiveLimit += increment
-					if effectiveLimit > maxLimit {
-						effectiveLimit = maxLimit
-					}
-					lastScaleUp = time.Now()
-					log.Printf("No available clusters, increasing claim limit from %d to %d (max: %d)", prev, effectiveLimit, maxLimit)
+		// Dynamic scaling of effective limit (skipped in fixed mode)
+		if !fixed {
+			available, ready, boundUnauthenticated, err := countAvailableAndReadyClaims(ctx, pool)
+			if err == nil {
+				counts := fmt.Sprintf("available=%d ready=%d bound-unauthenticated=%d", available, ready, boundUnauthenticated)
+				if counts != lastCounts {
+					log.Printf("[%s] Claims: %s", pool, counts)
+					lastCounts = counts
+				}
+				if countUnauthenticated {
+					// These will become available once the authenticator catches up
+					available += boundUnauthenticated
 				}
 			}
-		} else {
-			// Clusters are available — track for hysteresis and scale down after 10min
-			if availableSince.IsZero() {
-				availableSince = time.Now()
-				log.Printf("Available clusters detected (%d), starting hysteresis timer", available)
-			} else if effectiveLimit > baseLimit && time.Since(availableSince) >= 10*time.Minute {
-				log.Printf("Clusters available for 10+ minutes, scaling claim limit back from %d to %d", effectiveLimit, baseLimit)
-				effectiveLimit = baseLimit
+			if err != nil {
+				log.Printf("Error counting available claims: %v", err)
+			} else if available <= availableThreshold && ready > 0 {
+				// Available clusters at or below threshold — scale up (with 25min cooldown) and reset scale-down timer
 				availableSince = time.Time{}
+				if effectiveLimit < maxLimit {
+					if !lastScaleUp.IsZero() && time.Since(lastScaleUp) < 25*time.Minute {
+						logs.Printf("scale-up-cooldown/"+pool, fmt.Sprint(effectiveLimit), "[%s] No available clusters, waiting for previous scale-up to take effect (%s ago)", pool, time.Since(lastScaleUp).Truncate(time.Second))
+					} else {
+						prev := effectiveLimit
+						effectiveLimit += increment
+						if effectiveLimit > maxLimit {
+							effectiveLimit = maxLimit
+						}
+						lastScaleUp = time.Now()
+						log.Printf("[%s] No available clusters, increasing claim limit from %d to %d (max: %d)", pool, prev, effectiveLimit, maxLimit)
+					}
+				}
+			} else {
+				// Clusters are available — track for hysteresis and scale down after 10min
+				if availableSince.IsZero() {
+					availableSince = time.Now()
+					log.Printf("[%s] Available clusters detected (%d), starting hysteresis timer", pool, available)
+				} else if effectiveLimit > baseLimit && time.Since(availableSince) >= 10*time.Minute {
+					log.Printf("[%s] Clusters available for 10+ minutes, scaling claim limit back from %d to %d", pool, effectiveLimit, baseLimit)
+					effectiveLimit = baseLimit
+					availableSince = time.Time{}
+				}
 			}
 		}
 
+		// Drop the pending timeout from claims that have bound since the last
+		// pass, and delete those that didn't bind in time
+		enforcePendingTimeouts(ctx, pool)
+
 		// Check and create any needed claims
 		created := createNeededClaims(ctx, dynClient, pool, effectiveLimit)
+		totalCreated += created
 		if created > 0 {
-			log.Printf("Reconcile: created %d claim(s)", created)
+			log.Printf("[%s] Reconcile: created %d claim(s)", pool, created)
 		}
 
 		// Watch for ClusterDeployment changes, then re-reconcile
@@ -177,7 +476,7 @@ func reconcile(ctx context.Context, dynClient dynamic.Interface, pool string, ba
 			LabelSelector: labelSelector,
 		})
 		if err != nil {
-			log.Printf("Error listing ClusterDeployments: %v", err)
+			logs.Printf("reconcile-error/"+pool, err.Error(), "Error listing ClusterDeployments: %v", err)
 			sleepOrDone(ctx, 10*time.Second)
 			continue
 		}
@@ -188,23 +487,112 @@ func reconcile(ctx context.Context, dynClient dynamic.Interface, pool string, ba
 			ResourceVersion: list.GetResourceVersion(),
 		})
 		if err != nil {
-			log.Printf("Error watching ClusterDeployments: %v", err)
+			logs.Printf("reconcile-error/"+pool, err.Error(), "Error watching ClusterDeployments: %v", err)
 			sleepOrDone(ctx, 10*time.Second)
 			continue
 		}
 
-		for event := range watcher.ResultChan() {
-			if event.Type == watch.Added || event.Type == watch.Modified {
-				if u, ok := event.Object.(*unstructured.Unstructured); ok {
-					if isProvisioned(u.Object) {
-						log.Printf("ClusterDeployment %s/%s changed, re-reconciling", u.GetNamespace(), u.GetName())
-						break
+		// Also watch the pool's ClusterClaims so releases and deletions wake us
+		// up. If that watch can't be started, fall back to deployments only: a
+		// nil channel never fires in the select below.
+		var claimWatcher watch.Interface
+		var claimEvents <-chan watch.Event
+		assigned := map[string]bool{}
+		claimList, err := claimStore.ListClaims(ctx, pool)
+		if err == nil {
+			for _, claim := range claimList.Items {
+				assigned[claim.GetName()] = claim.GetLabels()["prelude"] != ""
+			}
+			claimWatcher, err = claimStore.WatchClaims(ctx, pool, metav1.ListOptions{
+				TimeoutSeconds:  &timeoutSecs,
+				ResourceVersion: claimList.GetResourceVersion(),
+			})
+		}
+		if err != nil {
+			log.Printf("Error watching ClusterClaims, reacting to ClusterDeployment changes only: %v", err)
+		} else {
+			claimEvents = claimWatcher.ResultChan()
+		}
+
+	watchLoop:
+		for {
+			select {
+			case event, ok := <-claimEvents:
+				if !ok {
+					break watchLoop
+				}
+				if u, ok := event.Object.(*unstructured.Unstructured); ok && claimMatchesPool(u.Object, pool) {
+					if event.Type == watch.Deleted {
+						log.Printf("ClusterClaim %s deleted, re-reconciling", u.GetName())
+						break watchLoop
+					}
+					if event.Type == watch.Modified && claimDeleting(u.Object) {
+						log.Printf("ClusterClaim %s is being deleted, re-reconciling", u.GetName())
+						break watchLoop
+					}
+					if claimReleased(assigned, event.Type, u) {
+						log.Printf("ClusterClaim %s was released, re-reconciling", u.GetName())
+						break watchLoop
 					}
 				}
+			case event, ok := <-watcher.ResultChan():
+				if !ok {
+					break watchLoop
+				}
+				if event.Type == watch.Added || event.Type == watch.Modified {
+					if u, ok := event.Object.(*unstructured.Unstructured); ok {
+						if isProvisioned(u.Object) {
+							log.Printf("ClusterDeployment %s/%s changed, re-reconciling", u.GetNamespace(), u.GetName())
+							break watchLoop
+						}
+					}
+				}
+			case <-reconcileNow:
+				log.Printf("Manual reconcile requested, re-reconciling")
+				break watchLoop
+			case <-ctx.Done():
+				break watchLoop
 			}
 		}
 		watcher.Stop()
+		if claimWatcher != nil {
+			claimWatcher.Stop()
+		}
+	}
+}
+
+// claimReleased reports whether a claim event takes the claim's prelude label
+// from set to unset, i.e. frees it for another phone. assigned holds whether
+// each claim, by name, was last seen assigned, and is updated from the event.
+// Other changes to unassigned claims, such as the authenticator's labels,
+// don't count.
+func claimReleased(assigned map[string]bool, eventType watch.EventType, claim *unstructured.Unstructured) bool {
+	name := claim.GetName()
+	was := assigned[name]
+	now := claim.GetLabels()["prelude"] != ""
+	assigned[name] = now
+	return eventType == watch.Modified && was && !now
+}
+
+// handleReconcileTrigger requests an immediate reconcile pass of every pool:
+// POST /reconcile. The pass runs on each reconcile loop itself, so it never
+// races with a pass already in progress.
+func handleReconcileTrigger(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
+	reconcileTriggers.Lock()
+	for pool, reconcileNow := range reconcileTriggers.m {
+		select {
+		case reconcileNow <- struct{}{}:
+			log.Printf("Manual reconcile triggered for pool %s", pool)
+		default:
+			// A reconcile is already pending
+		}
+	}
+	reconcileTriggers.Unlock()
+	w.WriteHeader(http.StatusAccepted)
 }
 
 // createNeededClaims checks how many claims are needed and creates them.
@@ -219,7 +607,7 @@ func createNeededClaims(ctx context.Context, dynClient dynamic.Interface, pool s
 		return 0
 	}
 
-	existingNames, err := existingClaimNames(ctx, dynClient, pool)
+	existingNames, existingIndices, err := existingClaimNames(ctx, pool)
 	if err != nil {
 		log.Printf("Error listing existing claim names: %v", err)
 		return 0
@@ -227,12 +615,25 @@ func createNeededClaims(ctx context.Context, dynClient dynamic.Interface, pool s
 
 	created := 0
 	for i := 1; created < needed; i++ {
-		name := fmt.Sprintf("prelude%d", i)
-		if existingNames[name] {
+		name, err := claimName(i, pool)
+		if err != nil {
+			log.Printf("Error rendering claim name: %v", err)
+			return created
+		}
+		if existingNames[name] || existingIndices[i] {
 			continue
 		}
+		// Don't start new creates once shutting down
+		if ctx.Err() != nil {
+			return created
+		}
 		log.Printf("Creating ClusterClaim %s for pool %s", name, pool)
-		if err := createClusterClaim(ctx, dynClient, name, pool); err != nil {
+		// A started create runs to completion even if shutdown begins, so the
+		// process never exits not knowing whether the claim exists
+		createCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownGrace)
+		err = createClusterClaim(createCtx, name, pool, i)
+		cancel()
+		if err != nil {
 			log.Printf("Error creating cluster claim: %v", err)
 			return created
 		}
@@ -249,6 +650,9 @@ func sleepOrDone(ctx context.Context, d time.Duration) {
 	}
 }
 
+// logs samples the repetitive reconcile log lines, set from --log-sample-interval.
+var logs = logsample.New(time.Minute)
+
 // claimsNeeded returns how many new ClusterClaims are needed by comparing
 // the number of provisioned ClusterDeployments to existing ClusterClaims for the pool,
 // capped by the cluster claim limit.
@@ -258,12 +662,13 @@ func claimsNeeded(ctx context.Context, dynClient dynamic.Interface, pool string,
 		return 0, err
 	}
 
-	claimCount, err := countClaimsForPool(ctx, dynClient, pool)
+	claimCount, err := countClaimsForPool(ctx, pool)
 	if err != nil {
 		return 0, err
 	}
 
-	log.Printf("Provisioned ClusterDeployments: %d, existing ClusterClaims: %d, claim limit: %d", provisionedCount, claimCount, claimLimit)
+	counts := fmt.Sprintf("%d/%d/%d", provisionedCount, claimCount, claimLimit)
+	logs.Printf("claims-needed/"+pool, counts, "[%s] Provisioned ClusterDeployments: %d, existing ClusterClaims: %d, claim limit: %d", pool, provisionedCount, claimCount, claimLimit)
 
 	// Cap the target number of claims at the limit
 	target := provisionedCount
@@ -299,15 +704,15 @@ func countProvisionedDeployments(ctx context.Context, dynClient dynamic.Interfac
 }
 
 // countClaimsForPool counts existing ClusterClaims that reference the specified pool.
-func countClaimsForPool(ctx context.Context, dynClient dynamic.Interface, pool string) (int, error) {
-	claims, err := dynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).List(ctx, metav1.ListOptions{})
+func countClaimsForPool(ctx context.Context, pool string) (int, error) {
+	claims, err := claimStore.ListClaims(ctx, pool)
 	if err != nil {
 		return 0, fmt.Errorf("listing ClusterClaims: %w", err)
 	}
 
 	count := 0
 	for _, claim := range claims.Items {
-		if claimMatchesPool(claim.Object, pool) {
+		if claimMatchesPool(claim.Object, pool) && !claimDeleting(claim.Object) {
 			count++
 		}
 	}
@@ -316,42 +721,137 @@ func countClaimsForPool(ctx context.Context, dynClient dynamic.Interface, pool s
 
 // countAvailableAndReadyClaims counts ClusterClaims that are authenticated (prelude-auth=done)
 // but not yet claimed by a user (no prelude phone label), and also returns the total
-// number of ready (authenticated) clusters including claimed ones.
-func countAvailableAndReadyClaims(ctx context.Context, dynClient dynamic.Interface, pool string) (available int, ready int, err error) {
-	claims, err := dynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).List(ctx, metav1.ListOptions{})
+// number of ready (authenticated) clusters including claimed ones, and the number of
+// claims bound to a cluster that the authenticator hasn't finished with yet.
+func countAvailableAndReadyClaims(ctx context.Context, pool string) (available, ready, boundUnauthenticated int, err error) {
+	claims, err := claimStore.ListClaims(ctx, pool)
 	if err != nil {
-		return 0, 0, fmt.Errorf("listing ClusterClaims: %w", err)
+		return 0, 0, 0, fmt.Errorf("listing ClusterClaims: %w", err)
 	}
 
 	for _, claim := range claims.Items {
-		if !claimMatchesPool(claim.Object, pool) {
+		if !claimMatchesPool(claim.Object, pool) || claimDeleting(claim.Object) {
 			continue
 		}
 		labels := claim.GetLabels()
 		if labels["prelude-auth"] == "done" {
 			ready++
-			if labels["prelude"] == "" {
+			// Claims an admin parked with prelude.io/reserved=true are held back
+			if labels["prelude"] == "" && !claimCoolingDown(claim.GetAnnotations()) && claim.GetAnnotations()["prelude.io/reserved"] != "true" {
 				available++
 			}
+		} else if ns, _, _ := unstructured.NestedString(claim.Object, "spec", "namespace"); ns != "" {
+			boundUnauthenticated++
 		}
 	}
-	return available, ready, nil
+	return available, ready, boundUnauthenticated, nil
+}
+
+// claimCoolingDown reports whether the server released a claim less than
+// reassignCooldown ago.
+func claimCoolingDown(annotations map[string]string) bool {
+	if reassignCooldown <= 0 {
+		return false
+	}
+	ts, err := strconv.ParseInt(annotations["prelude-released-at"], 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Since(time.Unix(ts, 0)) < reassignCooldown
 }
 
-// existingClaimNames returns the set of ClusterClaim names that already exist for the pool.
-func existingClaimNames(ctx context.Context, dynClient dynamic.Interface, pool string) (map[string]bool, error) {
-	claims, err := dynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).List(ctx, metav1.ListOptions{})
+// existingClaimNames returns the set of ClusterClaim names already taken in the
+// namespace, by any pool, along with the set of the pool's indices already
+// taken via the prelude-index label. Names are checked across pools so pools
+// selected by --pool-selector can share a name template without colliding.
+func existingClaimNames(ctx context.Context, pool string) (map[string]bool, map[int]bool, error) {
+	claims, err := claimStore.ListClaims(ctx, pool)
 	if err != nil {
-		return nil, fmt.Errorf("listing ClusterClaims: %w", err)
+		return nil, nil, fmt.Errorf("listing ClusterClaims: %w", err)
 	}
 
 	names := make(map[string]bool)
+	indices := make(map[int]bool)
 	for _, claim := range claims.Items {
+		names[claim.GetName()] = true
 		if claimMatchesPool(claim.Object, pool) {
-			names[claim.GetName()] = true
+			if idx, err := strconv.Atoi(claim.GetLabels()["prelude-index"]); err == nil {
+				indices[idx] = true
+			} else if idx, ok := legacyClaimIndex(claim.GetName()); ok {
+				indices[idx] = true
+			}
+		}
+	}
+	return names, indices, nil
+}
+
+// legacyClaimIndex returns the index of a claim named prelude<N> by the old
+// naming scheme, created before the prelude-index label existed, so such
+// claims keep their number when names come from a different template.
+func legacyClaimIndex(name string) (int, bool) {
+	suffix := strings.TrimPrefix(name, "prelude")
+	if suffix == name {
+		return 0, false
+	}
+	idx, err := strconv.Atoi(suffix)
+	if err != nil || idx <= 0 {
+		return 0, false
+	}
+	return idx, true
+}
+
+// claimName renders the name of the claim with the given index.
+func claimName(index int, pool string) (string, error) {
+	var b strings.Builder
+	if err := claimNameTemplate.Execute(&b, claimNameData{Index: index, Pool: pool}); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// setClaimNameTemplate parses a --claim-name-template and checks it renders
+// valid object names that differ by index, so gap-filling can't collide.
+func setClaimNameTemplate(text, pool string) error {
+	tmpl, err := template.New("claim-name").Parse(text)
+	if err != nil {
+		return err
+	}
+	prev := claimNameTemplate
+	claimNameTemplate = tmpl
+	names := map[string]bool{}
+	for _, index := range []int{1, 2, 10, 100} {
+		name, err := claimName(index, pool)
+		if err == nil {
+			if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+				err = fmt.Errorf("name %q: %s", name, strings.Join(errs, "; "))
+			} else if names[name] {
+				err = fmt.Errorf("renders %q for more than one index, include .Index", name)
+			}
+		}
+		if err != nil {
+			claimNameTemplate = prev
+			return err
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// clusterPoolGVR is the Hive ClusterPool resource, in the group and version
+// configured for ClusterClaims.
+func clusterPoolGVR() schema.GroupVersionResource {
+	return clusterClaimGVR.GroupVersion().WithResource("clusterpools")
+}
+
+// checkClusterPool verifies the ClusterPool exists in the hub namespace.
+func checkClusterPool(ctx context.Context, dynClient dynamic.Interface, namespace, pool string) error {
+	if _, err := dynClient.Resource(clusterPoolGVR()).Namespace(namespace).Get(ctx, pool, metav1.GetOptions{}); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return fmt.Errorf("ClusterPool %s not found in namespace %s", pool, namespace)
 		}
+		return fmt.Errorf("checking ClusterPool %s: %w", pool, err)
 	}
-	return names, nil
+	return nil
 }
 
 // claimMatchesPool checks if a ClusterClaim belongs to the specified ClusterPool.
@@ -367,21 +867,39 @@ func claimMatchesPool(obj map[string]interface{}, poolName string) bool {
 	return name == poolName
 }
 
+// claimDeleting reports whether a ClusterClaim has a deletionTimestamp, i.e. it
+// is being torn down and must not be counted as available or patched.
+func claimDeleting(obj map[string]interface{}) bool {
+	metadata, ok := obj["metadata"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = metadata["deletionTimestamp"]
+	return ok
+}
+
+// provisionTimeout bounds how long waitForProvisioned waits for a pool.
+var provisionTimeout = 100 * time.Minute
+
 // waitForProvisioned watches ClusterDeployments matching the cluster pool label
-// and waits until at least one has the Provisioned condition set to True.
+// and waits until at least one has the Provisioned condition set to True. It
+// returns ctx's error once ctx is cancelled, and an error after
+// provisionTimeout.
 func waitForProvisioned(ctx context.Context, dynClient dynamic.Interface, pool string) error {
 	labelSelector := fmt.Sprintf("hive.openshift.io/clusterpool-name=%s", pool)
-	timeout := 100 * time.Minute
-	deadline := time.Now().Add(timeout)
+	waitCtx, cancel := context.WithTimeout(ctx, provisionTimeout)
+	defer cancel()
 
-	for time.Now().Before(deadline) {
+	for waitCtx.Err() == nil {
 		// Check current state
-		list, err := dynClient.Resource(clusterDeploymentGVR).Namespace("").List(ctx, metav1.ListOptions{
+		list, err := dynClient.Resource(clusterDeploymentGVR).Namespace("").List(waitCtx, metav1.ListOptions{
 			LabelSelector: labelSelector,
 		})
 		if err != nil {
-			log.Printf("Error listing ClusterDeployments: %v", err)
-			time.Sleep(10 * time.Second)
+			if waitCtx.Err() == nil {
+				logs.Printf("wait-provisioned-error/"+pool, err.Error(), "Error listing ClusterDeployments: %v", err)
+				sleepOrDone(waitCtx, 10*time.Second)
+			}
 			continue
 		}
 
@@ -394,27 +912,36 @@ func waitForProvisioned(ctx context.Context, dynClient dynamic.Interface, pool s
 
 		// Watch for changes with a 30s timeout
 		var timeoutSecs int64 = 30
-		watcher, err := dynClient.Resource(clusterDeploymentGVR).Namespace("").Watch(ctx, metav1.ListOptions{
+		watcher, err := dynClient.Resource(clusterDeploymentGVR).Namespace("").Watch(waitCtx, metav1.ListOptions{
 			LabelSelector:   labelSelector,
 			TimeoutSeconds:  &timeoutSecs,
 			ResourceVersion: list.GetResourceVersion(),
 		})
 		if err != nil {
-			log.Printf("Error watching ClusterDeployments: %v", err)
-			time.Sleep(10 * time.Second)
+			if waitCtx.Err() == nil {
+				logs.Printf("wait-provisioned-error/"+pool, err.Error(), "Error watching ClusterDeployments: %v", err)
+				sleepOrDone(waitCtx, 10*time.Second)
+			}
 			continue
 		}
 
 		provisioned := false
-		for event := range watcher.ResultChan() {
-			if event.Type == watch.Added || event.Type == watch.Modified {
-				if u, ok := event.Object.(*unstructured.Unstructured); ok {
-					if isProvisioned(u.Object) {
+	watchLoop:
+		for {
+			select {
+			case event, ok := <-watcher.ResultChan():
+				if !ok {
+					break watchLoop
+				}
+				if event.Type == watch.Added || event.Type == watch.Modified {
+					if u, ok := event.Object.(*unstructured.Unstructured); ok && isProvisioned(u.Object) {
 						log.Printf("ClusterDeployment %s/%s is now provisioned", u.GetNamespace(), u.GetName())
 						provisioned = true
-						break
+						break watchLoop
 					}
 				}
+			case <-waitCtx.Done():
+				break watchLoop
 			}
 		}
 		watcher.Stop()
@@ -422,11 +949,15 @@ func waitForProvisioned(ctx context.Context, dynClient dynamic.Interface, pool s
 		if provisioned {
 			return nil
 		}
-
-		log.Printf("Waiting for cluster pool %s to be provisioned...", pool)
+		if waitCtx.Err() == nil {
+			logs.Printf("wait-provisioned/"+pool, "", "Waiting for cluster pool %s to be provisioned...", pool)
+		}
 	}
 
-	return fmt.Errorf("timed out waiting for cluster pool %s to be provisioned after %v", pool, timeout)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return fmt.Errorf("timed out waiting for cluster pool %s to be provisioned after %v", pool, provisionTimeout)
 }
 
 // isProvisioned checks if a ClusterDeployment has the Provisioned condition set to True.
@@ -451,15 +982,19 @@ func isProvisioned(obj map[string]interface{}) bool {
 	return false
 }
 
-// createClusterClaim creates a ClusterClaim resource in the cluster-pools namespace.
-func createClusterClaim(ctx context.Context, dynClient dynamic.Interface, name, pool string) error {
+// createClusterClaim creates a ClusterClaim resource in the pool's claim namespace.
+// The claim is labeled prelude-index=<index> so it has a stable, human-friendly number.
+func createClusterClaim(ctx context.Context, name, pool string, index int) error {
 	claim := &unstructured.Unstructured{
 		Object: map[string]interface{}{
-			"apiVersion": "hive.openshift.io/v1",
+			"apiVersion": clusterClaimGVR.GroupVersion().String(),
 			"kind":       "ClusterClaim",
 			"metadata": map[string]interface{}{
 				"name":      name,
-				"namespace": clusterPoolNamespace,
+				"namespace": claimNamespaces().Of(pool),
+				"labels": map[string]interface{}{
+					"prelude-index": strconv.Itoa(index),
+				},
 			},
 			"spec": map[string]interface{}{
 				"clusterPoolName": pool,
@@ -474,17 +1009,129 @@ func createClusterClaim(ctx context.Context, dynClient dynamic.Interface, name,
 		},
 	}
 
-	_, err := dynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).Create(ctx, claim, metav1.CreateOptions{})
-	if err != nil {
+	if claimPendingTimeout > 0 {
+		claim.SetAnnotations(map[string]string{pendingTimeoutAnnotation: "true"})
+	}
+
+	if err := claimStore.CreateClaim(ctx, pool, claim); err != nil {
 		return fmt.Errorf("creating ClusterClaim %s: %w", name, err)
 	}
 	log.Printf("ClusterClaim %s created successfully", name)
 	return nil
 }
 
+// ClaimStore is the set of ClusterClaim operations the claimer performs. Every
+// claim read and write goes through it so the reconcile loop can run against
+// an in-memory store in tests; dynamicClaimStore is the hub implementation.
+// Each call works on the claim namespace of pool.
+type ClaimStore interface {
+	ListClaims(ctx context.Context, pool string) (*unstructured.UnstructuredList, error)
+	WatchClaims(ctx context.Context, pool string, opts metav1.ListOptions) (watch.Interface, error)
+	CreateClaim(ctx context.Context, pool string, claim *unstructured.Unstructured) error
+	// PatchClaim applies a JSON merge patch to the named claim.
+	PatchClaim(ctx context.Context, pool, name string, patch map[string]interface{}) error
+	// DeleteClaim deletes the named claim, only if it is still at resourceVersion.
+	DeleteClaim(ctx context.Context, pool, name, resourceVersion string) error
+}
+
+// claimStore is set in main once the dynamic client is built.
+var claimStore ClaimStore
+
+// dynamicClaimStore implements ClaimStore with the dynamic client against
+// each pool's claim namespace.
+type dynamicClaimStore struct {
+	dynClient dynamic.Interface
+}
+
+func (s dynamicClaimStore) claims(pool string) dynamic.ResourceInterface {
+	return s.dynClient.Resource(clusterClaimGVR).Namespace(claimNamespaces().Of(pool))
+}
+
+func (s dynamicClaimStore) ListClaims(ctx context.Context, pool string) (*unstructured.UnstructuredList, error) {
+	return s.claims(pool).List(ctx, metav1.ListOptions{})
+}
+
+func (s dynamicClaimStore) WatchClaims(ctx context.Context, pool string, opts metav1.ListOptions) (watch.Interface, error) {
+	return s.claims(pool).Watch(ctx, opts)
+}
+
+func (s dynamicClaimStore) CreateClaim(ctx context.Context, pool string, claim *unstructured.Unstructured) error {
+	_, err := s.claims(pool).Create(ctx, claim, metav1.CreateOptions{})
+	return err
+}
+
+func (s dynamicClaimStore) PatchClaim(ctx context.Context, pool, name string, patch map[string]interface{}) error {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	_, err = s.claims(pool).Patch(ctx, name, types.MergePatchType, data, metav1.PatchOptions{})
+	return err
+}
+
+func (s dynamicClaimStore) DeleteClaim(ctx context.Context, pool, name, resourceVersion string) error {
+	return s.claims(pool).Delete(ctx, name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{ResourceVersion: &resourceVersion},
+	})
+}
+
+// enforcePendingTimeouts deletes pool claims marked with the pending timeout
+// that are still unbound (no spec.namespace) claimPendingTimeout after their
+// creation, and removes the marker from those that have bound. Claims
+// created by older claimers also lose their pending-timeout spec.lifetime
+// once bound, unless already assigned to a phone, which keeps the lifetime
+// the server gave it. Both writes carry a resourceVersion precondition, so
+// they can't race a binding or an assignment; a conflicting claim is retried
+// on the next pass.
+func enforcePendingTimeouts(ctx context.Context, pool string) {
+	claims, err := claimStore.ListClaims(ctx, pool)
+	if err != nil {
+		log.Printf("Error listing ClusterClaims for pending timeouts: %v", err)
+		return
+	}
+
+	for _, claim := range claims.Items {
+		if !claimMatchesPool(claim.Object, pool) || claimDeleting(claim.Object) || claim.GetAnnotations()[pendingTimeoutAnnotation] == "" {
+			continue
+		}
+		if ns, _, _ := unstructured.NestedString(claim.Object, "spec", "namespace"); ns == "" {
+			age := time.Since(claim.GetCreationTimestamp().Time)
+			if claimPendingTimeout <= 0 || age < claimPendingTimeout {
+				continue
+			}
+			if err := claimStore.DeleteClaim(ctx, pool, claim.GetName(), claim.GetResourceVersion()); err != nil {
+				log.Printf("Error deleting pending ClusterClaim %s: %v", claim.GetName(), err)
+				continue
+			}
+			log.Printf("ClusterClaim %s still Pending after %v, deleted", claim.GetName(), age.Truncate(time.Second))
+			continue
+		}
+
+		patch := map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"resourceVersion": claim.GetResourceVersion(),
+				"annotations": map[string]interface{}{
+					pendingTimeoutAnnotation: nil,
+				},
+			},
+		}
+		if claim.GetLabels()["prelude"] == "" {
+			patch["spec"] = map[string]interface{}{"lifetime": nil}
+		}
+		if err := claimStore.PatchClaim(ctx, pool, claim.GetName(), patch); err != nil {
+			log.Printf("Error clearing pending timeout on ClusterClaim %s: %v", claim.GetName(), err)
+			continue
+		}
+		log.Printf("ClusterClaim %s bound, cleared pending timeout", claim.GetName())
+	}
+}
+
 // buildConfig returns a Kubernetes REST config. It uses the KUBECONFIG env var
 // or ~/.kube/config if available, otherwise falls back to in-cluster config.
 func buildConfig() (*rest.Config, error) {
+	if hubServer != "" {
+		return hub.CertConfig(hubServer, hubClientCert, hubClientKey, hubCA)
+	}
 	kubeconfig := os.Getenv("KUBECONFIG")
 	if kubeconfig == "" {
 		home, err := os.UserHomeDir()
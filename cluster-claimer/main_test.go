@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+const testPool = "prelude-test"
+
+// testClaim builds a pool claim with the given labels.
+func testClaim(name string, labels map[string]string) *unstructured.Unstructured {
+	claim := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": clusterClaimGVR.GroupVersion().String(),
+		"kind":       "ClusterClaim",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": clusterPoolNamespace,
+		},
+		"spec": map[string]interface{}{
+			"clusterPoolName": testPool,
+		},
+	}}
+	claim.SetLabels(labels)
+	return claim
+}
+
+// testDeployment builds a provisioned ClusterDeployment of the pool.
+func testDeployment(cluster string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": clusterDeploymentGVR.GroupVersion().String(),
+		"kind":       "ClusterDeployment",
+		"metadata": map[string]interface{}{
+			"name":      cluster,
+			"namespace": cluster,
+			"labels": map[string]interface{}{
+				"hive.openshift.io/clusterpool-name": testPool,
+			},
+		},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Provisioned", "status": "True"},
+			},
+		},
+	}}
+}
+
+// newTestHub returns a fake hub holding objs and installs a claimStore over it
+// for the test.
+func newTestHub(t *testing.T, objs ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		clusterClaimGVR:      "ClusterClaimList",
+		clusterDeploymentGVR: "ClusterDeploymentList",
+	}, objs...)
+	previous := claimStore
+	claimStore = dynamicClaimStore{dynClient}
+	t.Cleanup(func() { claimStore = previous })
+	return dynClient
+}
+
+// testDeployments returns n provisioned ClusterDeployments.
+func testDeployments(n int) []runtime.Object {
+	var objs []runtime.Object
+	for i := 0; i < n; i++ {
+		objs = append(objs, testDeployment("cluster-"+strconv.Itoa(i)))
+	}
+	return objs
+}
+
+// nameIndex parses the numeric suffix of a claim name.
+func nameIndex(name string) (int, bool) {
+	i := strings.LastIndexFunc(name, func(r rune) bool { return r < '0' || r > '9' })
+	n, err := strconv.Atoi(name[i+1:])
+	return n, err == nil
+}
+
+func TestCreateNeededClaimsIndexMatchesName(t *testing.T) {
+	// prelude-002 exists with its label, prelude4 predates the label and the
+	// current naming
+	objs := append(testDeployments(5),
+		testClaim("prelude-002", map[string]string{"prelude-index": "2"}),
+		testClaim("prelude4", nil),
+	)
+	dynClient := newTestHub(t, objs...)
+	ctx := context.Background()
+
+	if created := createNeededClaims(ctx, dynClient, testPool, 5); created != 3 {
+		t.Fatalf("created %d claims, want 3", created)
+	}
+
+	claims, err := claimStore.ListClaims(ctx, testPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, claim := range claims.Items {
+		name := claim.GetName()
+		seen[name] = true
+		if name == "prelude4" {
+			continue
+		}
+		want, _ := nameIndex(name)
+		if index, err := strconv.Atoi(claim.GetLabels()["prelude-index"]); err != nil || index != want {
+			t.Errorf("claim %s has prelude-index %q, want %d", name, claim.GetLabels()["prelude-index"], want)
+		}
+	}
+	for _, name := range []string{"prelude-001", "prelude-002", "prelude-003", "prelude4", "prelude-005"} {
+		if !seen[name] {
+			t.Errorf("claim %s missing, gaps were not filled in order", name)
+		}
+	}
+}
+
+func TestCreateNeededClaimsCustomTemplate(t *testing.T) {
+	previous := claimNameTemplate
+	if err := setClaimNameTemplate("{{.Pool}}-{{.Index}}", testPool); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { claimNameTemplate = previous })
+	dynClient := newTestHub(t, testDeployments(2)...)
+	ctx := context.Background()
+
+	if created := createNeededClaims(ctx, dynClient, testPool, 2); created != 2 {
+		t.Fatalf("created %d claims, want 2", created)
+	}
+	claims, err := claimStore.ListClaims(ctx, testPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, claim := range claims.Items {
+		want, _ := nameIndex(claim.GetName())
+		if index := claim.GetLabels()["prelude-index"]; index != strconv.Itoa(want) {
+			t.Errorf("claim %s has prelude-index %q, want %d", claim.GetName(), index, want)
+		}
+	}
+}
+
+// runScalingReconcile runs reconcile for a moment on a pool with five
+// provisioned clusters, of which the two claimed are assigned, so none is
+// available: the condition that scales the claim limit up.
+func runScalingReconcile(t *testing.T, fixed bool) (created, claims int) {
+	objs := append(testDeployments(5),
+		testClaim("prelude-001", map[string]string{"prelude-index": "1", "prelude-auth": "done", "prelude": "15551230001"}),
+		testClaim("prelude-002", map[string]string{"prelude-index": "2", "prelude-auth": "done", "prelude": "15551230002"}),
+	)
+	dynClient := newTestHub(t, objs...)
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	created = reconcile(ctx, dynClient, testPool, 2, 4, 2, 0, fixed)
+	list, err := claimStore.ListClaims(context.Background(), testPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return created, len(list.Items)
+}
+
+func TestReconcileFixedClaimsNeverScales(t *testing.T) {
+	created, claims := runScalingReconcile(t, true)
+	if created != 0 || claims != 2 {
+		t.Errorf("fixed mode created %d claims (%d total), want exactly the 2 configured", created, claims)
+	}
+}
+
+func TestReconcileScalesUpWithoutAvailable(t *testing.T) {
+	created, claims := runScalingReconcile(t, false)
+	if created != 2 || claims != 4 {
+		t.Errorf("scaling mode created %d claims (%d total), want a scale-up to the max of 4", created, claims)
+	}
+}
+
+func TestCountClaimsSkipsDeleting(t *testing.T) {
+	deleting := testClaim("prelude-002", map[string]string{"prelude-auth": "done"})
+	now := metav1.Now()
+	deleting.SetDeletionTimestamp(&now)
+	newTestHub(t,
+		testClaim("prelude-001", map[string]string{"prelude-auth": "done"}),
+		deleting,
+	)
+	ctx := context.Background()
+
+	count, err := countClaimsForPool(ctx, testPool)
+	if err != nil || count != 1 {
+		t.Errorf("countClaimsForPool = %d, %v, want 1 without the claim being deleted", count, err)
+	}
+	available, ready, _, err := countAvailableAndReadyClaims(ctx, testPool)
+	if err != nil || available != 1 || ready != 1 {
+		t.Errorf("countAvailableAndReadyClaims = %d available, %d ready, %v, want 1 and 1", available, ready, err)
+	}
+}
+
+func TestClaimNameTemplate(t *testing.T) {
+	previous := claimNameTemplate
+	t.Cleanup(func() { claimNameTemplate = previous })
+
+	tests := []struct {
+		template string
+		index    int
+		want     string
+	}{
+		{template: defaultClaimNameTemplate, index: 1, want: "prelude-001"},
+		{template: defaultClaimNameTemplate, index: 42, want: "prelude-042"},
+		{template: defaultClaimNameTemplate, index: 1000, want: "prelude-1000"},
+		{template: "{{.Pool}}-{{.Index}}", index: 7, want: "prelude-test-7"},
+		{template: `c{{printf "%02d" .Index}}`, index: 3, want: "c03"},
+	}
+	for _, tt := range tests {
+		if err := setClaimNameTemplate(tt.template, testPool); err != nil {
+			t.Fatalf("setClaimNameTemplate(%q): %v", tt.template, err)
+		}
+		got, err := claimName(tt.index, testPool)
+		if err != nil || got != tt.want {
+			t.Errorf("%q with index %d = %q, %v, want %q", tt.template, tt.index, got, err, tt.want)
+		}
+	}
+
+	// Zero-padded names sort in index order
+	if err := setClaimNameTemplate(defaultClaimNameTemplate, testPool); err != nil {
+		t.Fatal(err)
+	}
+	two, _ := claimName(2, testPool)
+	ten, _ := claimName(10, testPool)
+	if two >= ten {
+		t.Errorf("%s sorts after %s", two, ten)
+	}
+}
+
+func TestSetClaimNameTemplateRejects(t *testing.T) {
+	previous := claimNameTemplate
+	t.Cleanup(func() { claimNameTemplate = previous })
+
+	for _, text := range []string{
+		"{{.Index",            // doesn't parse
+		"prelude",             // same name for every index
+		"Prelude-{{.Index}}",  // not a valid object name
+		"{{.Missing}}",        // unknown field
+		"prelude_{{.Index}}_", // not a valid object name
+	} {
+		if err := setClaimNameTemplate(text, testPool); err == nil {
+			t.Errorf("setClaimNameTemplate(%q) accepted an unusable template", text)
+		}
+		if claimNameTemplate != previous {
+			t.Fatalf("rejected template %q replaced the current one", text)
+		}
+	}
+}
+
+func TestExistingClaimNamesGaps(t *testing.T) {
+	newTestHub(t,
+		testClaim("prelude-001", map[string]string{"prelude-index": "1"}),
+		testClaim("renamed", map[string]string{"prelude-index": "3"}),
+		testClaim("prelude5", nil),
+	)
+	// Another pool's claims take names, not indices
+	other := testClaim("prelude-002", map[string]string{"prelude-index": "2"})
+	unstructured.SetNestedField(other.Object, "other-pool", "spec", "clusterPoolName")
+	if err := claimStore.CreateClaim(context.Background(), "other-pool", other); err != nil {
+		t.Fatal(err)
+	}
+	names, indices, err := existingClaimNames(context.Background(), testPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"prelude-001", "renamed", "prelude5", "prelude-002"} {
+		if !names[name] {
+			t.Errorf("name %s not reported as taken", name)
+		}
+	}
+	for i := 1; i <= 6; i++ {
+		want := i == 1 || i == 3 || i == 5
+		if indices[i] != want {
+			t.Errorf("index %d taken = %v, want %v", i, indices[i], want)
+		}
+	}
+}
+
+func TestCreateClusterClaimHiveAPIVersion(t *testing.T) {
+	previous := clusterClaimGVR
+	t.Cleanup(func() { clusterClaimGVR = previous })
+	// As set by --hive-group and --hive-version
+	clusterClaimGVR.Group, clusterClaimGVR.Version = "hive.example.com", "v1beta1"
+	newTestHub(t)
+
+	if err := createClusterClaim(context.Background(), "prelude-001", testPool, 1); err != nil {
+		t.Fatal(err)
+	}
+	claims, err := claimStore.ListClaims(context.Background(), testPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(claims.Items) != 1 {
+		t.Fatalf("%d claims, want 1", len(claims.Items))
+	}
+	if got := claims.Items[0].GetAPIVersion(); got != "hive.example.com/v1beta1" {
+		t.Errorf("apiVersion = %q, want the configured Hive group and version", got)
+	}
+}
+
+func TestCreateClusterClaimMappedNamespace(t *testing.T) {
+	previous := poolNamespaces
+	poolNamespaces = map[string]string{testPool: "ns-a"}
+	t.Cleanup(func() { poolNamespaces = previous })
+	dynClient := newTestHub(t)
+
+	if err := createClusterClaim(context.Background(), "prelude-001", testPool, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dynClient.Tracker().Get(clusterClaimGVR, "ns-a", "prelude-001"); err != nil {
+		t.Errorf("claim not created in the pool's mapped namespace: %v", err)
+	}
+	if _, err := dynClient.Tracker().Get(clusterClaimGVR, clusterPoolNamespace, "prelude-001"); err == nil {
+		t.Errorf("claim created in the default namespace %s", clusterPoolNamespace)
+	}
+	if count, err := countClaimsForPool(context.Background(), testPool); err != nil || count != 1 {
+		t.Errorf("countClaimsForPool = %d, %v, want the claim in ns-a counted", count, err)
+	}
+}
+
+func TestClaimReleased(t *testing.T) {
+	assigned := map[string]bool{"prelude-001": true}
+	steps := []struct {
+		event  watch.EventType
+		labels map[string]string
+		want   bool
+	}{
+		// The authenticator labeling an unassigned claim is no release
+		{event: watch.Added, labels: nil, want: false},
+		{event: watch.Modified, labels: map[string]string{"prelude-auth": "done"}, want: false},
+		{event: watch.Modified, labels: map[string]string{"prelude-auth": "done", "prelude": "15551230001"}, want: false},
+		{event: watch.Modified, labels: map[string]string{"prelude-auth": "done", "prelude": "15551230001", "prelude-fp": "abc"}, want: false},
+		{event: watch.Modified, labels: nil, want: true},
+		{event: watch.Modified, labels: nil, want: false},
+	}
+	for i, step := range steps {
+		if got := claimReleased(assigned, step.event, testClaim("prelude-002", step.labels)); got != step.want {
+			t.Errorf("step %d: %s with labels %v released = %v, want %v", i, step.event, step.labels, got, step.want)
+		}
+	}
+
+	// Claims listed before the watch start from their listed assignment
+	if !claimReleased(assigned, watch.Modified, testClaim("prelude-001", map[string]string{"prelude-auth": "done"})) {
+		t.Error("release of a claim assigned when listed not detected")
+	}
+}
+
+func TestEnforcePendingTimeouts(t *testing.T) {
+	previous := claimPendingTimeout
+	claimPendingTimeout = time.Hour
+	t.Cleanup(func() { claimPendingTimeout = previous })
+
+	claim := func(name string, age time.Duration, marked, bound bool) *unstructured.Unstructured {
+		c := testClaim(name, nil)
+		c.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-age)))
+		if marked {
+			c.SetAnnotations(map[string]string{pendingTimeoutAnnotation: "true"})
+		}
+		if bound {
+			unstructured.SetNestedField(c.Object, "cluster-"+name, "spec", "namespace")
+		}
+		return c
+	}
+	newTestHub(t,
+		claim("stuck", 2*time.Hour, true, false),
+		claim("young", time.Minute, true, false),
+		claim("bound", 2*time.Hour, true, true),
+		claim("unmarked", 2*time.Hour, false, false),
+	)
+	ctx := context.Background()
+
+	enforcePendingTimeouts(ctx, testPool)
+
+	list, err := claimStore.ListClaims(ctx, testPool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]*unstructured.Unstructured{}
+	for i := range list.Items {
+		got[list.Items[i].GetName()] = &list.Items[i]
+	}
+	if _, ok := got["stuck"]; ok {
+		t.Error("claim unbound past the pending timeout not deleted")
+	}
+	for _, name := range []string{"young", "bound", "unmarked"} {
+		if _, ok := got[name]; !ok {
+			t.Errorf("claim %s deleted", name)
+		}
+	}
+	if c, ok := got["bound"]; ok && c.GetAnnotations()[pendingTimeoutAnnotation] != "" {
+		t.Error("bound claim kept its pending timeout marker")
+	}
+}
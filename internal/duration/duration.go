@@ -0,0 +1,120 @@
+// Package duration parses and formats the durations the prelude binaries
+// read from flags and write to or show about ClusterClaims. The helpers
+// deliberately differ about days: Parse accepts them for operator input,
+// Format never emits them because its output goes into spec.lifetime, and
+// FormatAge uses them since it is only displayed.
+package duration
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse parses a non-negative duration in Go syntax that may also use d
+// (days). Every duration flag of every binary is parsed with it, so all accept
+// the same forms. Examples: "2h", "30m", "90s", "1.5h", "1d", "1d12h", "2h30m".
+func Parse(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("invalid duration: empty")
+	}
+	// As in Go syntax, a bare zero needs no unit
+	if s == "0" {
+		return 0, nil
+	}
+	isNumber := func(r rune) bool { return (r >= '0' && r <= '9') || r == '.' }
+	var total time.Duration
+	for rest := s; rest != ""; {
+		// Split off one number and its unit
+		i := strings.IndexFunc(rest, func(r rune) bool { return !isNumber(r) })
+		if i < 0 {
+			return 0, fmt.Errorf("invalid duration (trailing number without unit): %s", s)
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid duration: %s", s)
+		}
+		j := strings.IndexFunc(rest[i:], isNumber)
+		if j < 0 {
+			j = len(rest) - i
+		}
+		number, unit := rest[:i], rest[i:i+j]
+		rest = rest[i+j:]
+
+		var d time.Duration
+		if unit == "d" {
+			n, err := strconv.ParseFloat(number, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration: %s", s)
+			}
+			if n*float64(24*time.Hour) >= math.MaxInt64 {
+				return 0, fmt.Errorf("duration out of range: %s", s)
+			}
+			d = time.Duration(n * float64(24*time.Hour))
+		} else {
+			var err error
+			if d, err = time.ParseDuration(number + unit); err != nil {
+				return 0, fmt.Errorf("invalid duration unit %q in: %s", unit, s)
+			}
+		}
+		if d > math.MaxInt64-total {
+			return 0, fmt.Errorf("duration out of range: %s", s)
+		}
+		total += d
+	}
+	return total, nil
+}
+
+// Format formats a duration using h, m units (no days, since Kubernetes
+// duration fields only accept standard Go duration units: ns, us, ms, s, m, h).
+// Partial minutes round up, so a lifetime written with it is never shorter
+// than asked for.
+func Format(d time.Duration) string {
+	if d <= 0 {
+		return "0m"
+	}
+	// Rounding up the largest durations would overflow, truncate those
+	if d <= math.MaxInt64-time.Minute {
+		d += time.Minute - 1
+	}
+	d = d.Truncate(time.Minute)
+	var parts []string
+	hours := int(d.Hours())
+	if hours > 0 {
+		parts = append(parts, fmt.Sprintf("%dh", hours))
+		d -= time.Duration(hours) * time.Hour
+	}
+	minutes := int(d.Minutes())
+	if minutes > 0 {
+		parts = append(parts, fmt.Sprintf("%dm", minutes))
+	}
+	return strings.Join(parts, "")
+}
+
+// FormatAge formats an elapsed time for display, e.g. "45s", "2h5m", "3d4h".
+// Negative durations (clock skew) show as "0s".
+func FormatAge(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	if d < time.Minute {
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	}
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+	if days > 0 {
+		if hours > 0 {
+			return fmt.Sprintf("%dd%dh", days, hours)
+		}
+		return fmt.Sprintf("%dd", days)
+	}
+	if hours > 0 {
+		if minutes > 0 {
+			return fmt.Sprintf("%dh%dm", hours, minutes)
+		}
+		return fmt.Sprintf("%dh", hours)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
@@ -0,0 +1,110 @@
+package duration
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "0m", want: 0},
+		{in: "30m", want: 30 * time.Minute},
+		{in: "2h", want: 2 * time.Hour},
+		{in: "1d", want: 24 * time.Hour},
+		{in: "1d12h", want: 36 * time.Hour},
+		{in: "2h30m", want: 150 * time.Minute},
+		{in: "90m", want: 90 * time.Minute},
+		{in: "", wantErr: true},
+		{in: "-1h", wantErr: true},
+		{in: "2", wantErr: true},
+		{in: "0", want: 0},
+		{in: "h", wantErr: true},
+		{in: "30s", want: 30 * time.Second},
+		{in: "1.5h", want: 90 * time.Minute},
+		{in: "2h0m0s", want: 2 * time.Hour},
+		{in: "1.5d", want: 36 * time.Hour},
+		{in: "500ms", want: 500 * time.Millisecond},
+		{in: "1x", wantErr: true},
+		{in: "1.2.3h", wantErr: true},
+		{in: "106752d", wantErr: true},
+		{in: "99999999999999999999h", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := Parse(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Parse(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{in: 0, want: "0m"},
+		{in: -time.Hour, want: "0m"},
+		// Partial minutes round up, so a lifetime is never shorter than asked
+		{in: time.Second, want: "1m"},
+		{in: 59 * time.Second, want: "1m"},
+		{in: time.Minute, want: "1m"},
+		{in: time.Minute + time.Nanosecond, want: "2m"},
+		{in: 2 * time.Hour, want: "2h"},
+		{in: 2*time.Hour + 30*time.Minute, want: "2h30m"},
+		// No days: spec.lifetime only takes Go duration units
+		{in: 36 * time.Hour, want: "36h"},
+		{in: math.MaxInt64, want: "2562047h47m"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.in); got != tt.want {
+			t.Errorf("Format(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	for _, s := range []string{"1m", "45m", "2h", "2h30m", "36h"} {
+		d, err := Parse(s)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", s, err)
+		}
+		if got := Format(d); got != s {
+			t.Errorf("Format(Parse(%q)) = %q", s, got)
+		}
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	tests := []struct {
+		in   time.Duration
+		want string
+	}{
+		{in: 0, want: "0s"},
+		{in: -time.Hour, want: "0s"},
+		{in: 45 * time.Second, want: "45s"},
+		{in: 59*time.Second + 999*time.Millisecond, want: "59s"},
+		{in: time.Minute, want: "1m"},
+		{in: 90 * time.Second, want: "1m"},
+		{in: 2 * time.Hour, want: "2h"},
+		{in: 2*time.Hour + 5*time.Minute, want: "2h5m"},
+		{in: 24 * time.Hour, want: "1d"},
+		{in: 76*time.Hour + 30*time.Minute, want: "3d4h"},
+		{in: math.MaxInt64, want: "106751d23h"},
+	}
+	for _, tt := range tests {
+		if got := FormatAge(tt.in); got != tt.want {
+			t.Errorf("FormatAge(%v) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// Package hub builds the REST config the prelude binaries use to reach the
+// Hive hub cluster without a kubeconfig.
+package hub
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+
+	"k8s.io/client-go/rest"
+)
+
+// CertConfig builds a rest.Config for server authenticating with the TLS
+// client certificate in certFile and keyFile, trusting caFile or, when it is
+// empty, the system roots. It fails if the cert/key pair or CA bundle won't
+// load.
+func CertConfig(server, certFile, keyFile, caFile string) (*rest.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("--hub-server requires --hub-client-cert and --hub-client-key")
+	}
+	certData, err := os.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading hub client cert: %w", err)
+	}
+	keyData, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading hub client key: %w", err)
+	}
+	if _, err := tls.X509KeyPair(certData, keyData); err != nil {
+		return nil, fmt.Errorf("loading hub client cert/key: %w", err)
+	}
+	tlsConfig := rest.TLSClientConfig{CertData: certData, KeyData: keyData}
+	if caFile != "" {
+		caData, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading hub CA: %w", err)
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(caData) {
+			return nil, fmt.Errorf("no certificates found in hub CA %s", caFile)
+		}
+		tlsConfig.CAData = caData
+	}
+	log.Printf("Using client certificate auth to hub %s", server)
+	return &rest.Config{Host: server, TLSClientConfig: tlsConfig}, nil
+}
@@ -0,0 +1,83 @@
+// Package logsample rate-limits log lines that repeat on every pass of a
+// reconcile or poll loop, so that they log on state changes rather than on
+// every pass.
+package logsample
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sampler rate-limits repeated log lines. A line is logged the first time
+// its key is seen, whenever its state changes, and otherwise at most once
+// per interval, noting how many repeats were dropped. An interval of 0 logs
+// everything.
+type Sampler struct {
+	mu       sync.Mutex
+	interval time.Duration
+	lines    map[string]sampledLine
+}
+
+// sampledLine is what a Sampler remembers about a key.
+type sampledLine struct {
+	state      string
+	logged     time.Time
+	suppressed int
+}
+
+// New returns a Sampler logging repeats at most once per interval.
+func New(interval time.Duration) *Sampler {
+	return &Sampler{interval: interval, lines: map[string]sampledLine{}}
+}
+
+// SetInterval changes the sampling interval, e.g. from a flag.
+func (s *Sampler) SetInterval(interval time.Duration) {
+	s.mu.Lock()
+	s.interval = interval
+	s.mu.Unlock()
+}
+
+// Printf logs like log.Printf, unless key last logged with the same state
+// less than the sampling interval ago.
+func (s *Sampler) Printf(key, state, format string, args ...interface{}) {
+	s.mu.Lock()
+	prev, seen := s.lines[key]
+	now := time.Now()
+	if s.interval > 0 && seen && prev.state == state && now.Sub(prev.logged) < s.interval {
+		prev.suppressed++
+		s.lines[key] = prev
+		s.mu.Unlock()
+		return
+	}
+	s.lines[key] = sampledLine{state: state, logged: now}
+	s.mu.Unlock()
+
+	msg := fmt.Sprintf(format, args...)
+	if seen && prev.suppressed > 0 {
+		msg = fmt.Sprintf("%s (%d similar suppressed)", msg, prev.suppressed)
+	}
+	log.Print(msg)
+}
+
+// Reset forgets key, so the next line under it logs immediately. Call it
+// when the condition being logged clears.
+func (s *Sampler) Reset(key string) {
+	s.mu.Lock()
+	delete(s.lines, key)
+	s.mu.Unlock()
+}
+
+// ResetPrefix forgets every key starting with prefix, for conditions logged
+// under one key per item.
+func (s *Sampler) ResetPrefix(prefix string) {
+	s.mu.Lock()
+	for key := range s.lines {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.lines, key)
+		}
+	}
+	s.mu.Unlock()
+}
@@ -0,0 +1,59 @@
+package logsample
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSampler(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	flags := log.Flags()
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+	lines := func() []string {
+		defer buf.Reset()
+		return strings.Split(strings.TrimSpace(buf.String()), "\n")
+	}
+
+	s := New(time.Hour)
+	s.Printf("pool", "1", "count %d", 1)
+	s.Printf("pool", "1", "count %d", 1)
+	s.Printf("pool", "1", "count %d", 1)
+	if got := lines(); len(got) != 1 || got[0] != "count 1" {
+		t.Fatalf("repeats logged %q, want only the first line", got)
+	}
+
+	// A new state logs at once, noting the dropped repeats
+	s.Printf("pool", "2", "count %d", 2)
+	if got := lines(); len(got) != 1 || got[0] != "count 2 (2 similar suppressed)" {
+		t.Fatalf("state change logged %q", got)
+	}
+
+	s.Printf("op/a", "x", "a")
+	s.Printf("op/b", "x", "b")
+	s.ResetPrefix("op/")
+	s.Reset("pool")
+	buf.Reset()
+	s.Printf("op/a", "x", "a")
+	s.Printf("op/b", "x", "b")
+	s.Printf("pool", "2", "count %d", 2)
+	if got := lines(); len(got) != 3 {
+		t.Fatalf("after reset logged %q, want every key again", got)
+	}
+
+	// An interval of 0 logs everything
+	s.SetInterval(0)
+	s.Printf("pool", "2", "count %d", 2)
+	s.Printf("pool", "2", "count %d", 2)
+	if got := lines(); len(got) != 2 {
+		t.Fatalf("with interval 0 logged %q, want both lines", got)
+	}
+}
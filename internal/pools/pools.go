@@ -0,0 +1,157 @@
+// Package pools resolves the Hive ClusterPools matching a --pool-selector
+// and keeps work running on each of them as they come and go.
+package pools
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// Namespaces says which hub namespace holds each pool's ClusterPool and
+// ClusterClaims: the one Mapped to it (--pool-namespace), else Default
+// (--claim-namespace).
+type Namespaces struct {
+	Default string
+	Mapped  map[string]string
+}
+
+// Of returns the namespace of pool.
+func (n Namespaces) Of(pool string) string {
+	if ns, ok := n.Mapped[pool]; ok {
+		return ns
+	}
+	return n.Default
+}
+
+// all returns Default and the mapped namespaces, without duplicates.
+func (n Namespaces) all() []string {
+	namespaces := []string{n.Default}
+	for _, ns := range n.Mapped {
+		if !slices.Contains(namespaces, ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces[1:])
+	return namespaces
+}
+
+// ParseNamespaces parses a comma-separated pool=namespace list, as passed to
+// --pool-namespace. A pool may be mapped only once.
+func ParseNamespaces(spec string) (map[string]string, error) {
+	mapped := map[string]string{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		pool, ns, ok := strings.Cut(entry, "=")
+		pool, ns = strings.TrimSpace(pool), strings.TrimSpace(ns)
+		if !ok || pool == "" || ns == "" {
+			return nil, fmt.Errorf("expected pool=namespace, got %q", entry)
+		}
+		if _, dup := mapped[pool]; dup {
+			return nil, fmt.Errorf("pool %s is mapped more than once", pool)
+		}
+		mapped[pool] = ns
+	}
+	return mapped, nil
+}
+
+// Matching returns the names of the ClusterPools (resource poolGVR) matching
+// selector, sorted, leaving out pools that are being deleted. It looks in
+// every namespace of namespaces and keeps a pool only if it lives in its own
+// namespace, so a pool mapped elsewhere isn't picked up twice.
+func Matching(ctx context.Context, dynClient dynamic.Interface, poolGVR schema.GroupVersionResource, namespaces Namespaces, selector string) ([]string, error) {
+	var pools []string
+	for _, ns := range namespaces.all() {
+		list, err := dynClient.Resource(poolGVR).Namespace(ns).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return nil, fmt.Errorf("listing ClusterPools in %s: %w", ns, err)
+		}
+		for _, p := range list.Items {
+			if p.GetDeletionTimestamp() == nil && namespaces.Of(p.GetName()) == ns && !slices.Contains(pools, p.GetName()) {
+				pools = append(pools, p.GetName())
+			}
+		}
+	}
+	sort.Strings(pools)
+	return pools, nil
+}
+
+// Follow runs run on every ClusterPool in namespaces matching selector, each
+// on its own goroutine, re-resolving every interval. A pool that starts
+// matching is started, and restarted if its run returns while it still
+// matches; for one that stops matching, run's context is cancelled and
+// Follow waits for it to return. Follow returns after ctx is cancelled and
+// every run has returned.
+func Follow(ctx context.Context, dynClient dynamic.Interface, poolGVR schema.GroupVersionResource, namespaces Namespaces, selector string, interval time.Duration, run func(ctx context.Context, pool string)) {
+	type poolRun struct {
+		stop context.CancelFunc
+		done chan struct{}
+	}
+	running := map[string]poolRun{}
+	waiting := false
+	for {
+		pools, err := Matching(ctx, dynClient, poolGVR, namespaces, selector)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error resolving --pool-selector: %v", err)
+		} else if err == nil {
+			matched := map[string]bool{}
+			for _, pool := range pools {
+				matched[pool] = true
+			}
+			for pool, r := range running {
+				if !matched[pool] {
+					log.Printf("Pool selector %s no longer matches pool %s, stopping work on it", selector, pool)
+					r.stop()
+					<-r.done
+					delete(running, pool)
+				}
+			}
+			for _, pool := range pools {
+				if r, ok := running[pool]; ok {
+					select {
+					case <-r.done:
+						// run gave up on the pool, retry it
+						r.stop()
+						log.Printf("Work on pool %s stopped, restarting it", pool)
+					default:
+						continue
+					}
+				} else {
+					log.Printf("Pool selector %s matches pool %s, starting work on it", selector, pool)
+				}
+				runCtx, cancel := context.WithCancel(ctx)
+				r := poolRun{stop: cancel, done: make(chan struct{})}
+				running[pool] = r
+				go func() {
+					defer close(r.done)
+					run(runCtx, pool)
+				}()
+			}
+			// Log the wait once, not on every resolve
+			if len(pools) == 0 && !waiting {
+				log.Printf("No ClusterPool matches --pool-selector %s, waiting", selector)
+			}
+			waiting = len(pools) == 0
+		}
+
+		select {
+		case <-ctx.Done():
+			for _, r := range running {
+				<-r.done
+			}
+			return
+		case <-time.After(interval):
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package pools
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+const namespace = "cluster-pools"
+
+var poolGVR = schema.GroupVersionResource{Group: "hive.openshift.io", Version: "v1", Resource: "clusterpools"}
+
+// testPoolObject builds a ClusterPool with the given labels.
+func testPoolObject(name string, labels map[string]string) *unstructured.Unstructured {
+	pool := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": poolGVR.GroupVersion().String(),
+		"kind":       "ClusterPool",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": namespace,
+		},
+	}}
+	pool.SetLabels(labels)
+	return pool
+}
+
+func TestFollowRunsEveryMatch(t *testing.T) {
+	event := map[string]string{"prelude.io/event": "summit"}
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		poolGVR: "ClusterPoolList",
+	}, testPoolObject("summit-a", event), testPoolObject("summit-b", event), testPoolObject("other", nil))
+
+	started, stopped := make(chan string, 10), make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		Follow(ctx, dynClient, poolGVR, Namespaces{Default: namespace}, "prelude.io/event=summit", 10*time.Millisecond, func(ctx context.Context, pool string) {
+			started <- pool
+			<-ctx.Done()
+			stopped <- pool
+		})
+	}()
+
+	receive := func(ch chan string, what string) string {
+		select {
+		case pool := <-ch:
+			return pool
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for a pool to be %s", what)
+			return ""
+		}
+	}
+	got := map[string]bool{receive(started, "started"): true, receive(started, "started"): true}
+	if !got["summit-a"] || !got["summit-b"] {
+		t.Fatalf("started %v, want both matching pools", got)
+	}
+
+	// A pool that stops matching is stopped, the other keeps running
+	if err := dynClient.Resource(poolGVR).Namespace(namespace).Delete(ctx, "summit-a", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if pool := receive(stopped, "stopped"); pool != "summit-a" {
+		t.Fatalf("stopped %s, want summit-a", pool)
+	}
+
+	cancel()
+	if pool := receive(stopped, "stopped"); pool != "summit-b" {
+		t.Fatalf("stopped %s, want summit-b", pool)
+	}
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Follow did not return after cancel")
+	}
+	select {
+	case pool := <-started:
+		t.Errorf("pool %s started again", pool)
+	default:
+	}
+}
+
+func TestFollowRestartsReturnedRun(t *testing.T) {
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		poolGVR: "ClusterPoolList",
+	}, testPoolObject("summit-a", map[string]string{"prelude.io/event": "summit"}))
+
+	started := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		Follow(ctx, dynClient, poolGVR, Namespaces{Default: namespace}, "prelude.io/event=summit", 10*time.Millisecond, func(ctx context.Context, pool string) {
+			// Give up straight away, as a pool that never provisions would
+			started <- pool
+		})
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case pool := <-started:
+			if pool != "summit-a" {
+				t.Fatalf("started %s, want summit-a", pool)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("run %d on a still matching pool was not started", i+1)
+		}
+	}
+
+	cancel()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Follow did not return after cancel")
+	}
+}
+
+func TestParseNamespaces(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    map[string]string
+		wantErr bool
+	}{
+		{spec: "", want: map[string]string{}},
+		{spec: "poolA=ns-a, poolB = ns-b,", want: map[string]string{"poolA": "ns-a", "poolB": "ns-b"}},
+		{spec: "poolA", wantErr: true},
+		{spec: "poolA=", wantErr: true},
+		{spec: "=ns-a", wantErr: true},
+		{spec: "poolA=ns-a,poolA=ns-b", wantErr: true},
+		{spec: "poolA=ns-a,poolA=ns-a", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseNamespaces(tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseNamespaces(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !maps.Equal(got, tt.want) {
+			t.Errorf("ParseNamespaces(%q) = %v, want %v", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestMatchingMappedNamespaces(t *testing.T) {
+	event := map[string]string{"prelude.io/event": "summit"}
+	inNamespace := func(pool *unstructured.Unstructured, ns string) *unstructured.Unstructured {
+		pool.SetNamespace(ns)
+		return pool
+	}
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		poolGVR: "ClusterPoolList",
+	},
+		testPoolObject("summit-a", event),
+		inNamespace(testPoolObject("summit-b", event), "ns-b"),
+		// Mapped to ns-b, so its copy in the default namespace is not used
+		testPoolObject("summit-c", event),
+		inNamespace(testPoolObject("summit-d", event), "ns-d"),
+	)
+	namespaces := Namespaces{Default: namespace, Mapped: map[string]string{"summit-b": "ns-b", "summit-c": "ns-b"}}
+
+	got, err := Matching(context.Background(), dynClient, poolGVR, namespaces, "prelude.io/event=summit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"summit-a", "summit-b"}; !slices.Equal(got, want) {
+		t.Errorf("Matching = %v, want %v", got, want)
+	}
+	if ns := namespaces.Of("summit-a"); ns != namespace {
+		t.Errorf("unmapped pool is in %s, want the default %s", ns, namespace)
+	}
+}
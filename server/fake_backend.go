@@ -0,0 +1,203 @@
+//go:build fakebackend
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeBackendBuilt reports that --fake-backend is compiled in. The fake hub
+// clients are only linked into builds with -tags fakebackend, so release
+// images don't carry them.
+const fakeBackendBuilt = true
+
+// fakeClusterCount synthetic clusters back --fake-backend. Each is
+// authenticated fakeReadyDelay after startup, and again that long after the
+// admin or its expiry releases it, as the authenticator would verify it.
+const fakeClusterCount = 4
+const fakeReadyDelay = 15 * time.Second
+
+// fakePool is the pool name the synthetic clusters are served under.
+const fakePool = "fake"
+
+// runFakeBackend serves the client from an in-memory pool of synthetic
+// clusters instead of a Hive pool, for frontend development without a hub.
+// The real handlers run over a memClaimStore and fake hub clients holding the
+// clusters' ClusterDeployments and kubeconfig secrets, so nothing here talks
+// to Kubernetes or a spoke.
+func runFakeBackend(lifetime string) {
+	log.Printf("WARNING: fake backend mode, serving %d synthetic clusters from memory; nothing is real", fakeClusterCount)
+	if adminAuthMode == "oauth" {
+		log.Fatalf("--fake-backend needs --admin-auth-mode=password, there is no hub to review tokens against")
+	}
+	// Nothing behind the synthetic clusters answers
+	probeConsole, magicLinkEnabled = false, false
+	keycloakURL, captchaSecretKey = "", ""
+
+	store, dynClient, clientset := newFakeHub()
+	claimStore = store
+	setServedPools([]string{fakePool})
+
+	go func() {
+		unauthenticated := map[string]time.Time{}
+		for {
+			runFakeAuthenticator(context.Background(), unauthenticated)
+			if twoPhaseClaim {
+				releaseExpiredReservations(context.Background(), fakePool)
+			}
+			refreshStats(dynClient, lifetime)
+			time.Sleep(time.Second)
+		}
+	}()
+
+	serve(newAPIMux(dynClient, clientset, lifetime))
+}
+
+// newFakeHub builds the synthetic pool: bound claims, not yet authenticated,
+// in a memClaimStore, and fake hub clients holding their ClusterDeployments and
+// kubeconfig secrets.
+func newFakeHub() (*memClaimStore, dynamic.Interface, kubernetes.Interface) {
+	store := newMemClaimStore()
+	var deployments, secrets []runtime.Object
+	for i := 1; i <= fakeClusterCount; i++ {
+		cluster := fmt.Sprintf("fake-%03d", i)
+		claim := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": clusterClaimGVR.GroupVersion().String(),
+			"kind":       "ClusterClaim",
+			"metadata": map[string]interface{}{
+				"name": cluster,
+			},
+			"spec": map[string]interface{}{
+				"clusterPoolName": fakePool,
+				"namespace":       cluster,
+			},
+		}}
+		claim.SetLabels(map[string]string{"prelude-index": strconv.Itoa(i)})
+		if _, err := store.CreateClaim(context.Background(), fakePool, claim); err != nil {
+			log.Fatalf("Error creating fake claim %s: %v", cluster, err)
+		}
+		deployments = append(deployments, fakeDeployment(cluster))
+		for _, kind := range []string{"admin", "user"} {
+			secrets = append(secrets, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: cluster + "-" + kind + "-kubeconfig", Namespace: cluster},
+				Data:       map[string][]byte{"kubeconfig": []byte(fakeKubeconfig(cluster, kind))},
+			})
+		}
+	}
+	dynClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		clusterClaimGVR:      "ClusterClaimList",
+		clusterDeploymentGVR: "ClusterDeploymentList",
+	}, deployments...)
+	return store, dynClient, kubefake.NewSimpleClientset(secrets...)
+}
+
+// fakeKubeconfig is a synthetic cluster's kubeconfig for user, pointing at
+// an API server that doesn't exist.
+func fakeKubeconfig(cluster, user string) string {
+	return fmt.Sprintf(`# fake kubeconfig, --fake-backend is active
+apiVersion: v1
+kind: Config
+clusters:
+- name: %[1]s
+  cluster:
+    server: https://api.%[1]s.example.com:6443
+contexts:
+- name: %[1]s
+  context:
+    cluster: %[1]s
+    user: %[2]s
+current-context: %[1]s
+users:
+- name: %[2]s
+  user:
+    token: fake
+`, cluster, user)
+}
+
+// fakeDeployment is the provisioned, running ClusterDeployment behind a
+// synthetic cluster, with example.com console URLs.
+func fakeDeployment(cluster string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": clusterDeploymentGVR.GroupVersion().String(),
+		"kind":       "ClusterDeployment",
+		"metadata": map[string]interface{}{
+			"name":              cluster,
+			"namespace":         cluster,
+			"creationTimestamp": metav1.Now().UTC().Format(time.RFC3339),
+			"labels": map[string]interface{}{
+				"hive.openshift.io/clusterpool-name": fakePool,
+			},
+		},
+		"spec": map[string]interface{}{
+			"platform": map[string]interface{}{"none": map[string]interface{}{}},
+			"clusterMetadata": map[string]interface{}{
+				"adminKubeconfigSecretRef": map[string]interface{}{
+					"name": cluster + "-admin-kubeconfig",
+				},
+			},
+		},
+		"status": map[string]interface{}{
+			"webConsoleURL":  "https://console-openshift-console.apps." + cluster + ".example.com",
+			"installVersion": "4.99.0-fake",
+			"powerState":     "Running",
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Provisioned", "status": "True"},
+			},
+		},
+	}}
+}
+
+// runFakeAuthenticator stands in for Hive and the authenticator on the fake
+// pool: claims seen unauthenticated for fakeReadyDelay are authenticated, and
+// assignments past their expiry are released. unauthenticated tracks when
+// each claim was first seen unauthenticated, across calls.
+func runFakeAuthenticator(ctx context.Context, unauthenticated map[string]time.Time) {
+	claims, err := claimStore.ListClaims(ctx, fakePool, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Fake: error listing claims: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, claim := range claims.Items {
+		name := claim.GetName()
+		labels := claim.GetLabels()
+		if labels["prelude-auth"] != "done" {
+			since, ok := unauthenticated[name]
+			if !ok {
+				unauthenticated[name] = now
+			} else if now.Sub(since) >= fakeReadyDelay {
+				if err := claimStore.SetAuthenticated(ctx, fakePool, name); err != nil {
+					log.Printf("Fake: error authenticating %s: %v", name, err)
+					continue
+				}
+				delete(unauthenticated, name)
+				log.Printf("Fake: %s authenticated", name)
+			}
+			continue
+		}
+		if labels["prelude"] == "" {
+			continue
+		}
+		if expires, _ := claimExpiry(claim.Object, claim.GetCreationTimestamp().Time); !expires.IsZero() && now.After(expires) {
+			if err := claimStore.ReleaseClaim(ctx, fakePool, name); err != nil {
+				log.Printf("Fake: error releasing expired %s: %v", name, err)
+				continue
+			}
+			log.Printf("Fake: assignment of %s to phone %s expired, released", name, labels["prelude"])
+		}
+	}
+}
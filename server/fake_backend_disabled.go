@@ -0,0 +1,15 @@
+//go:build !fakebackend
+
+package main
+
+import "log"
+
+// fakeBackendBuilt reports that --fake-backend is compiled in, which needs
+// -tags fakebackend.
+const fakeBackendBuilt = false
+
+// runFakeBackend is never reached without -tags fakebackend, main rejects
+// --fake-backend first.
+func runFakeBackend(lifetime string) {
+	log.Fatalf("--fake-backend is only available in servers built with -tags fakebackend")
+}
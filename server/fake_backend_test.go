@@ -0,0 +1,91 @@
+//go:build fakebackend
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFakeBackendServesRealHandlers(t *testing.T) {
+	store, dynClient, clientset := newFakeHub()
+	useClaimStore(t, store)
+	previousPools := servedPools()
+	setServedPools([]string{fakePool})
+	t.Cleanup(func() { setServedPools(previousPools) })
+	mux := newAPIMux(dynClient, clientset, "2h")
+	ctx := context.Background()
+
+	// The seeded clusters aren't ready until fakeReadyDelay has passed
+	body, _ := json.Marshal(claimRequest{Phone: "15551230001", Password: "secret"})
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/claim", strings.NewReader(string(body))))
+	if w.Code == http.StatusOK {
+		t.Fatal("claim succeeded before any fake cluster was authenticated")
+	}
+	unauthenticated := map[string]time.Time{}
+	runFakeAuthenticator(ctx, unauthenticated)
+	for name := range unauthenticated {
+		unauthenticated[name] = time.Now().Add(-fakeReadyDelay)
+	}
+	runFakeAuthenticator(ctx, unauthenticated)
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/claim", strings.NewReader(string(body))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("claim status %d, body %s", w.Code, w.Body.String())
+	}
+	var resp claimResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	list, err := store.ListClaims(ctx, fakePool, metav1.ListOptions{LabelSelector: "prelude=15551230001"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 1 {
+		t.Fatalf("%d claims assigned to the phone, want 1", len(list.Items))
+	}
+	name := list.Items[0].GetName()
+	if want := "https://console-openshift-console.apps." + name + ".example.com"; resp.WebConsoleURL != want {
+		t.Errorf("console URL %q, want %q", resp.WebConsoleURL, want)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/claim/exists?phone=15551230001", nil))
+	var exists claimExistsResponse
+	if err := json.NewDecoder(w.Body).Decode(&exists); err != nil || !exists.Exists {
+		t.Fatalf("exists = %+v, %v, want the phone's cluster", exists, err)
+	}
+
+	// Past its expiry the assignment is released, and the cluster comes back
+	// fakeReadyDelay later
+	store.Lock()
+	store.claims[store.key(fakePool, name)].SetCreationTimestamp(metav1.NewTime(time.Now().Add(-3 * time.Hour)))
+	store.Unlock()
+	runFakeAuthenticator(ctx, unauthenticated)
+	claim, err := store.GetClaim(ctx, fakePool, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if phone := claim.GetLabels()["prelude"]; phone != "" {
+		t.Fatalf("expired claim still assigned to %s", phone)
+	}
+	runFakeAuthenticator(ctx, unauthenticated)
+	unauthenticated[name] = time.Now().Add(-fakeReadyDelay)
+	runFakeAuthenticator(ctx, unauthenticated)
+	claim, err = store.GetClaim(ctx, fakePool, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claim.GetLabels()["prelude-auth"] != "done" {
+		t.Error("released claim not authenticated again after fakeReadyDelay")
+	}
+}
@@ -1,35 +1,63 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"maps"
+	"math"
 	mathrand "math/rand/v2"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/prelude/internal/duration"
+	"github.com/prelude/internal/hub"
+	"github.com/prelude/internal/pools"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 )
 
@@ -44,25 +72,168 @@ var (
 		Version:  "v1",
 		Resource: "clusterdeployments",
 	}
-	clusterPoolNamespace  = "cluster-pools"
-	recaptchaVerifyURL    = "https://www.google.com/recaptcha/api/siteverify"
-	recaptchaMinScore     = 0.5
+	clusterPoolNamespace = "cluster-pools"
+	recaptchaVerifyURL   = "https://www.google.com/recaptcha/api/siteverify"
+	hcaptchaVerifyURL    = "https://api.hcaptcha.com/siteverify"
+	recaptchaMinScore    = 0.5
+
+	// reCAPTCHA score the claim's assigning request passed with, kept for
+	// post-event abuse review
+	recaptchaScoreAnnotation = "prelude.io/recaptcha-score"
+
+	// Operational note admins can attach to a claim from the admin page
+	noteAnnotation = "prelude.io/note"
+	noteMaxLength  = 256
+
+	// Manual hold admins put on a claim (for a VIP, or debugging) to keep it
+	// out of assignment. Unrelated to two-phase claim reservations.
+	parkedAnnotation = "prelude.io/reserved"
+
+	// Spoke circuit breaker: after spokeBreakerThreshold consecutive failures,
+	// calls to that spoke are skipped for spokeBreakerCooldown.
+	spokeBreakerThreshold = 3
+	spokeBreakerCooldown  = 2 * time.Minute
 )
 
-var recaptchaSecretKey string
-var recaptchaSiteKey string
+var captchaSecretKey string
+var captchaSiteKey string
 var hideKubeconfig bool
+
+// adminKubeconfigFallback hands out the admin kubeconfig when a cluster's user
+// kubeconfig secret doesn't exist, instead of failing the claim.
+var adminKubeconfigFallback bool
 var hideConsole bool
+var probeConsole bool
+var unreachableGrace time.Duration
+var assignRetries int
+
+// claimWeight, set by --assign-strategy, biases the choice between available
+// claims by their cluster's remaining lifetime. nil means uniform random.
+var claimWeight func(remaining time.Duration) float64
+
+// clusterTTL is the pool TTL used to estimate a cluster's remaining lifetime
+// when its ClusterDeployment has no hive.openshift.io/delete-after annotation.
+var clusterTTL time.Duration
+var verbose bool
+var defaultLifetime time.Duration
+
+// tracks, set by --tracks, is the allowlist of attendee tracks a claim may
+// carry in its prelude-track label. Empty means tracks are ignored.
+var tracks []string
+
+// noTrack is the track metric label for claims without an allowed track.
+const noTrack = "none"
+
+// assignmentTrack returns the metric label for a claim's track. Anything not
+// in the --tracks allowlist collapses to noTrack, keeping cardinality bounded.
+func assignmentTrack(track string) string {
+	if track == "" || !slices.Contains(tracks, track) {
+		return noTrack
+	}
+	return track
+}
+
+// poolNamespaces maps a ClusterPool name to the hub namespace holding its
+// ClusterClaims; pools not listed use clusterPoolNamespace.
+var poolNamespaces = map[string]string{}
+
+// poolSet holds the ClusterPools this server hands out, from the
+// comma-separated --cluster-pool or resolved from --pool-selector. Requests
+// select one with the pool query parameter, which may be omitted when only
+// one pool is configured. Read it with servedPools.
+var poolSet struct {
+	sync.RWMutex
+	list []string
+}
+
+// poolSelector, when set, is the label selector the served pools are
+// periodically re-resolved from, every poolResolveInterval.
+var poolSelector string
+var poolResolveInterval = 5 * time.Minute
 
 var adminPassword string
 var maasURL string
 var maasToken string
 var keycloakURL string
 var keycloakClientSecret string
+var spokeBreaker = newCircuitBreaker()
+
+// fingerprintLength is how many hex characters of the browser fingerprint are
+// kept. It is stored as a label value, which caps it well above the 32 a
+// 128-bit prefix of the client's SHA-256 needs.
+var fingerprintLength int
+
+// fingerprintPhones tracks the distinct phone numbers each fingerprint has
+// presented (with a passing captcha) within fingerprintPhoneWindow.
+var fingerprintPhoneLimit int
+var fingerprintPhoneWindow time.Duration
+var fingerprintPhones = struct {
+	sync.Mutex
+	m map[string]map[string]time.Time
+}{m: make(map[string]map[string]time.Time)}
+
+// adminTokens holds the password-mode admin tokens, keyed by their SHA-256
+// so the --admin-token-secret copy holds no usable token.
 var adminTokens = struct {
 	sync.RWMutex
-	m map[string]bool
-}{m: make(map[string]bool)}
+	m map[string]adminToken
+}{m: make(map[string]adminToken)}
+
+// adminToken is the operator a token was issued to, and when.
+type adminToken struct {
+	user   string
+	issued time.Time
+}
+
+// adminUsers maps operator names to their passwords, from --admin-users and
+// --admin-users-file. ADMIN_PASSWORD is the implicit operator
+// defaultAdminUser. Empty when password-mode admin auth is disabled.
+var adminUsers = struct {
+	sync.RWMutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+const defaultAdminUser = "admin"
+
+// adminUsersFile is re-read every adminUsersReload, so an operator removed
+// from a mounted Secret is locked out without a restart.
+var adminUsersFile string
+
+const adminUsersReload = 30 * time.Second
+
+// The Secret persisting adminTokens across restarts (--admin-token-secret),
+// empty to keep them in memory only.
+var adminTokenSecretNamespace string
+var adminTokenSecretName string
+
+// adminTokenTTL is how long a password-mode admin token is valid after it
+// was issued (--admin-token-ttl).
+var adminTokenTTL = 8 * time.Hour
+
+// In --admin-auth-mode=oauth, admin requests carry an OpenShift bearer token
+// that is checked with a TokenReview and a SubjectAccessReview for what the
+// request does: adminVerb on adminResource in the claim namespace of the
+// request's pool for the read-only views, update or delete on clusterclaims
+// there for changes, and get on secrets in the cluster namespace for the
+// admin kubeconfig. Results are cached for oauthReviewTTL, keyed by the
+// token's hash and the reviewed attributes.
+var adminAuthMode string
+var adminVerb string
+var adminResource string
+var adminAuthClient kubernetes.Interface
+
+const oauthReviewTTL = time.Minute
+
+var oauthReviews = struct {
+	sync.Mutex
+	m map[string]oauthReview
+}{m: make(map[string]oauthReview)}
+
+type oauthReview struct {
+	user    string
+	allowed bool
+	expires time.Time
+}
 
 var (
 	metricDeployments = prometheus.NewGauge(prometheus.GaugeOpts{
@@ -85,6 +256,14 @@ var (
 		Name: "prelude_clusters_claimed",
 		Help: "Number of ready ClusterClaims with a phone label",
 	})
+	metricInGrace = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "prelude_clusters_in_grace",
+		Help: "Number of claimed clusters past their expiry but within the expiry grace",
+	})
+	metricClaimedByTrack = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "prelude_clusters_claimed_by_track",
+		Help: "Number of claimed clusters per attendee track",
+	}, []string{"track"})
 	metricClaimedInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "prelude_claimed_cluster_info",
 		Help: "Claimed cluster info (value=1 per claimed cluster)",
@@ -121,16 +300,52 @@ var (
 		Name: "prelude_claimed_duration_gt_1w",
 		Help: "Number of clusters claimed more than 1w ago",
 	})
+	metricClaimTransitions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "prelude_claim_transitions_total",
+		Help: "Claim lifecycle transitions (assigned, released, unavailable, unbound)",
+	}, []string{"type"})
+	metricClaimAssignments = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "prelude_claim_assignments_total",
+		Help: "Clusters assigned to a phone, by pool and attendee track",
+	}, []string{"pool", "track"})
+	metricTimeToReady = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "prelude_time_to_ready_seconds",
+		Help:    "Time from a cluster's assignment to a phone until its claim first returned credentials",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 10),
+	})
+	metricClaimResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "prelude_claims_total",
+		Help: "Claim requests by outcome (success, conflict, exhausted, unavailable)",
+	}, []string{"result"})
+	metricRecaptchaFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "prelude_recaptcha_failures_total",
+		Help: "Captcha verifications (reCAPTCHA or hCaptcha) that failed, including low scores and backend errors",
+	})
+	metricRecaptchaScore = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "prelude_recaptcha_score",
+		Help:    "reCAPTCHA v3 scores of verified requests, by whether they met the minimum score",
+		Buckets: prometheus.LinearBuckets(0.1, 0.1, 10),
+	}, []string{"result"})
 )
 
 func init() {
-	prometheus.MustRegister(metricDeployments, metricClaims, metricReady, metricAvailable, metricClaimed)
-	prometheus.MustRegister(metricClaimedInfo, metricClaimedTimestamp)
+	prometheus.MustRegister(metricDeployments, metricClaims, metricReady, metricAvailable, metricClaimed, metricInGrace)
+	prometheus.MustRegister(metricClaimedInfo, metricClaimedTimestamp, metricClaimedByTrack)
 	prometheus.MustRegister(metricClaimedDuration1h, metricClaimedDuration3h, metricClaimedDuration6h,
 		metricClaimedDuration12h, metricClaimedDuration24h, metricClaimedDuration1w, metricClaimedDurationGt1w)
+	prometheus.MustRegister(metricClaimTransitions, metricClaimAssignments, metricRecaptchaScore, metricTimeToReady)
+	prometheus.MustRegister(metricClaimResults, metricRecaptchaFailures)
+	// Pre-create each transition and result so the series exist at zero
+	for _, t := range []string{"assigned", "released", "unavailable", "unbound"} {
+		metricClaimTransitions.WithLabelValues(t)
+	}
+	for _, r := range []string{"success", "conflict", "exhausted", "unavailable"} {
+		metricClaimResults.WithLabelValues(r)
+	}
 }
 
 type adminLoginRequest struct {
+	Username string `json:"username"`
 	Password string `json:"password"`
 }
 
@@ -139,6 +354,8 @@ type claimRequest struct {
 	Password       string `json:"password"`
 	RecaptchaToken string `json:"recaptchaToken"`
 	Fingerprint    string `json:"fingerprint"`
+	Track          string `json:"track"`
+	Reservation    string `json:"reservation"`
 }
 
 // sanitizePhone converts a phone number into a valid Kubernetes label value.
@@ -159,152 +376,648 @@ func sanitizePhone(phone string) string {
 	return result
 }
 
-// sanitizeFingerprint validates and truncates a browser fingerprint to hex chars only, max 16 chars.
+// validatePhone sanitizes a phone number and checks it can be stored as the
+// prelude label value. It returns the sanitized phone, or a reason code when
+// the phone is unusable.
+func validatePhone(raw string) (phone, reason string) {
+	phone = sanitizePhone(strings.TrimSpace(raw))
+	if phone == "" {
+		return "", "phone_required"
+	}
+	if errs := validation.IsValidLabelValue(phone); len(errs) > 0 {
+		return "", "invalid_phone"
+	}
+	return phone, ""
+}
+
+// sanitizeFingerprint validates and truncates a browser fingerprint to hex chars only, max fingerprintLength chars.
 func sanitizeFingerprint(fp string) string {
 	var b strings.Builder
 	for _, r := range fp {
+		if b.Len() >= fingerprintLength {
+			break
+		}
 		if (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F') {
 			b.WriteRune(r)
 		}
-		if b.Len() >= 16 {
-			break
-		}
 	}
 	return b.String()
 }
 
-// parseDuration parses a duration string supporting d (days), h (hours), and m (minutes).
-// Examples: "2h", "30m", "1d", "1d12h", "2h30m".
-func parseDuration(s string) (time.Duration, error) {
-	var total time.Duration
-	current := ""
-	for _, c := range s {
-		if c >= '0' && c <= '9' {
-			current += string(c)
-		} else {
-			if current == "" {
-				return 0, fmt.Errorf("invalid duration: %s", s)
-			}
-			n, err := strconv.Atoi(current)
-			if err != nil {
-				return 0, fmt.Errorf("invalid duration: %s", s)
-			}
-			switch c {
-			case 'd':
-				total += time.Duration(n) * 24 * time.Hour
-			case 'h':
-				total += time.Duration(n) * time.Hour
-			case 'm':
-				total += time.Duration(n) * time.Minute
-			default:
-				return 0, fmt.Errorf("invalid duration unit %q in: %s", string(c), s)
-			}
-			current = ""
+// consoleRule derives one console link from the cluster's web console URL:
+// search is replaced by replace (when search is set), then path is appended.
+type consoleRule struct {
+	Name    string `json:"name"`
+	Search  string `json:"search,omitempty"`
+	Replace string `json:"replace,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Track   string `json:"track,omitempty"`
+}
+
+type consoleLink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// consoleRules are the links returned with a claim. The "web" and "ai" rules
+// also fill the webConsoleURL/aiConsoleURL response fields.
+var consoleRules = []consoleRule{
+	{Name: "web"},
+	{Name: "ai", Path: "/rhai-workshop"},
+}
+
+// deriveConsoles applies consoleRules to a web console URL. Rules with a track
+// only apply to claims on that track; a later rule replaces an earlier one of
+// the same name, so a track rule can override a default link.
+func deriveConsoles(webConsoleURL, track string) []consoleLink {
+	links := make([]consoleLink, 0, len(consoleRules))
+	index := map[string]int{}
+	for _, rule := range consoleRules {
+		if rule.Track != "" && rule.Track != track {
+			continue
 		}
+		u := webConsoleURL
+		if rule.Search != "" {
+			u = strings.Replace(u, rule.Search, rule.Replace, 1)
+		}
+		link := consoleLink{Name: rule.Name, URL: u + rule.Path}
+		if i, ok := index[rule.Name]; ok {
+			links[i] = link
+			continue
+		}
+		index[rule.Name] = len(links)
+		links = append(links, link)
 	}
-	if current != "" {
-		return 0, fmt.Errorf("invalid duration (trailing number without unit): %s", s)
-	}
-	return total, nil
+	return links
+}
+
+type claimResponse struct {
+	WebConsoleURL     string        `json:"webConsoleURL"`
+	AIConsoleURL      string        `json:"aiConsoleURL"`
+	Consoles          []consoleLink `json:"consoles"`
+	Kubeconfig        string        `json:"kubeconfig"`
+	LoginCommand      string        `json:"loginCommand,omitempty"`
+	LoginURL          string        `json:"loginURL,omitempty"`
+	LoginInstructions string        `json:"loginInstructions,omitempty"`
+	ExpiresAt         string        `json:"expiresAt"`
+	GraceEndsAt       string        `json:"graceEndsAt,omitempty"`
+	MagicLink         string        `json:"magicLink,omitempty"`
+}
+
+type captchaResponse struct {
+	Success bool    `json:"success"`
+	Score   float64 `json:"score"`
+}
+
+// captchaProvider is a bot check backend. Both providers take the same
+// siteverify form and answer with the same success/score fields, but judge the
+// verdict differently.
+type captchaProvider interface {
+	// name is the provider's display name in logs.
+	name() string
+	// verifyURL is the provider's siteverify endpoint.
+	verifyURL() string
+	// check turns a siteverify verdict into nil or the reason it was rejected.
+	// It also returns the verdict's score for the claim annotation, empty when
+	// the provider doesn't score.
+	check(result captchaResponse) (string, error)
 }
 
-// formatDuration formats a duration using h, m units (no days, since Kubernetes
-// duration fields only accept standard Go duration units: ns, us, ms, s, m, h).
-func formatDuration(d time.Duration) string {
-	if d <= 0 {
-		return "0m"
+// recaptchaProvider is Google reCAPTCHA v3, whose verdicts carry a score from
+// 0 (bot) to 1 (human) that must reach recaptchaMinScore.
+type recaptchaProvider struct{}
+
+func (recaptchaProvider) name() string      { return "reCAPTCHA" }
+func (recaptchaProvider) verifyURL() string { return recaptchaVerifyURL }
+
+func (recaptchaProvider) check(result captchaResponse) (string, error) {
+	if !result.Success {
+		return "", fmt.Errorf("recaptcha verification failed")
 	}
-	var parts []string
-	hours := int(d.Hours())
-	if hours > 0 {
-		parts = append(parts, fmt.Sprintf("%dh", hours))
-		d -= time.Duration(hours) * time.Hour
+	if result.Score < recaptchaMinScore {
+		metricRecaptchaScore.WithLabelValues("fail").Observe(result.Score)
+		return "", fmt.Errorf("recaptcha score %.2f below threshold %.2f", result.Score, recaptchaMinScore)
 	}
-	minutes := int(d.Minutes())
-	if minutes > 0 {
-		parts = append(parts, fmt.Sprintf("%dm", minutes))
+	metricRecaptchaScore.WithLabelValues("pass").Observe(result.Score)
+	if result.Score < recaptchaMinScore+0.2 {
+		log.Printf("reCAPTCHA score %.2f passed close to threshold %.2f", result.Score, recaptchaMinScore)
 	}
-	if len(parts) == 0 {
-		return "1m"
+	return strconv.FormatFloat(result.Score, 'f', -1, 64), nil
+}
+
+// hcaptchaProvider is hCaptcha, for regions where Google is blocked. Only the
+// success verdict is checked: scores are an Enterprise feature, and there they
+// measure risk rather than humanity.
+type hcaptchaProvider struct{}
+
+func (hcaptchaProvider) name() string      { return "hCaptcha" }
+func (hcaptchaProvider) verifyURL() string { return hcaptchaVerifyURL }
+
+func (hcaptchaProvider) check(result captchaResponse) (string, error) {
+	if !result.Success {
+		return "", fmt.Errorf("hcaptcha verification failed")
 	}
-	return strings.Join(parts, "")
+	return "", nil
 }
 
-type claimResponse struct {
-	WebConsoleURL string `json:"webConsoleURL"`
-	AIConsoleURL  string `json:"aiConsoleURL"`
-	Kubeconfig    string `json:"kubeconfig"`
-	ExpiresAt     string `json:"expiresAt"`
+// captchaProviders maps --captcha-provider values to their provider and the
+// environment variables holding its keys.
+var captchaProviders = map[string]struct {
+	provider  captchaProvider
+	siteKey   string
+	secretKey string
+}{
+	"recaptcha": {recaptchaProvider{}, "RECAPTCHA_SITE_KEY", "RECAPTCHA_SECRET_KEY"},
+	"hcaptcha":  {hcaptchaProvider{}, "HCAPTCHA_SITE_KEY", "HCAPTCHA_SECRET_KEY"},
 }
 
-type recaptchaResponse struct {
-	Success bool    `json:"success"`
-	Score   float64 `json:"score"`
+// captcha is the provider selected with --captcha-provider. Verification is
+// enabled when captchaSecretKey is set.
+var captcha captchaProvider = recaptchaProvider{}
+var captchaProviderName string
+
+// Captcha backend settings, shared by both providers. After
+// captchaBreakerThreshold consecutive siteverify failures (not verdicts) the
+// backend is considered down for captchaBreakerCooldown: tokens are rejected
+// without calling it, or accepted unverified with --recaptcha-fail-open.
+var recaptchaTimeout time.Duration
+var recaptchaRetries int
+var recaptchaFailOpen bool
+
+// captchaSlots bounds concurrent siteverify calls to --recaptcha-concurrency
+// (nil when unbounded), so a claim burst doesn't open hundreds of outbound
+// connections at once. A request waits up to recaptchaTimeout for a slot.
+var recaptchaConcurrency int
+var captchaSlots chan struct{}
+
+const (
+	captchaBreakerThreshold = 3
+	captchaBreakerCooldown  = time.Minute
+)
+
+var captchaBackend struct {
+	sync.Mutex
+	failures  int
+	openUntil time.Time
 }
 
-func verifyRecaptcha(token string) error {
-	resp, err := http.PostForm(recaptchaVerifyURL, url.Values{
-		"secret":   {recaptchaSecretKey},
-		"response": {token},
-	})
-	if err != nil {
-		return fmt.Errorf("recaptcha request failed: %w", err)
-	}
-	defer resp.Body.Close()
+// verifyCaptcha checks a client token with the configured provider. It
+// returns the verdict's score, empty when there is none (hCaptcha, or a
+// token accepted unverified with --recaptcha-fail-open).
+func verifyCaptcha(token string) (score string, err error) {
+	defer func() {
+		if err != nil {
+			metricRecaptchaFailures.Inc()
+		}
+	}()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return fmt.Errorf("reading recaptcha response: %w", err)
+	if !captchaBackendAllow() {
+		if recaptchaFailOpen {
+			log.Printf("WARNING: %s backend down, accepting token WITHOUT verification (--recaptcha-fail-open)", captcha.name())
+			return "", nil
+		}
+		return "", fmt.Errorf("%s backend unavailable, circuit open", captcha.name())
 	}
 
-	var result recaptchaResponse
-	if err := json.Unmarshal(body, &result); err != nil {
-		return fmt.Errorf("parsing recaptcha response: %w", err)
+	// A full queue is our own load, not a backend failure, so it doesn't
+	// count towards the circuit
+	if captchaSlots != nil {
+		select {
+		case captchaSlots <- struct{}{}:
+			defer func() { <-captchaSlots }()
+		case <-time.After(recaptchaTimeout):
+			return "", fmt.Errorf("%s verification queue full after waiting %v", captcha.name(), recaptchaTimeout)
+		}
 	}
 
-	if !result.Success {
-		return fmt.Errorf("recaptcha verification failed")
+	result, err := siteverify(token)
+	captchaBackendRecord(err)
+	if err != nil {
+		return "", err
 	}
 
-	if result.Score < recaptchaMinScore {
-		return fmt.Errorf("recaptcha score %.2f below threshold %.2f", result.Score, recaptchaMinScore)
+	return captcha.check(result)
+}
+
+// siteverify asks the captcha provider to verify a token, retrying request
+// failures and 5xx answers up to recaptchaRetries times.
+func siteverify(token string) (captchaResponse, error) {
+	client := &http.Client{Timeout: recaptchaTimeout}
+	var result captchaResponse
+	var err error
+	for attempt := 0; attempt <= recaptchaRetries; attempt++ {
+		var resp *http.Response
+		resp, err = client.PostForm(captcha.verifyURL(), url.Values{
+			"secret":   {captchaSecretKey},
+			"response": {token},
+		})
+		if err != nil {
+			err = fmt.Errorf("siteverify request failed: %w", err)
+			continue
+		}
+		body, readErr := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			err = fmt.Errorf("siteverify request failed: status %d", resp.StatusCode)
+			continue
+		}
+		if readErr != nil {
+			return result, fmt.Errorf("reading siteverify response: %w", readErr)
+		}
+		if resp.StatusCode != http.StatusOK {
+			return result, fmt.Errorf("siteverify request failed: status %d", resp.StatusCode)
+		}
+		if err := json.Unmarshal(body, &result); err != nil {
+			return result, fmt.Errorf("parsing siteverify response: %w", err)
+		}
+		return result, nil
 	}
+	return result, err
+}
 
-	return nil
+// captchaBackendAllow reports whether siteverify should be called. Once the
+// cooldown has passed a call is let through again to probe the backend.
+func captchaBackendAllow() bool {
+	captchaBackend.Lock()
+	defer captchaBackend.Unlock()
+	return time.Now().After(captchaBackend.openUntil)
+}
+
+// captchaBackendRecord updates the captcha circuit with a siteverify
+// result. A success closes it; captchaBreakerThreshold consecutive failures
+// open it for captchaBreakerCooldown.
+func captchaBackendRecord(err error) {
+	captchaBackend.Lock()
+	defer captchaBackend.Unlock()
+	if err == nil {
+		if captchaBackend.failures >= captchaBreakerThreshold {
+			log.Printf("%s circuit closed, siteverify is responding again", captcha.name())
+		}
+		captchaBackend.failures = 0
+		captchaBackend.openUntil = time.Time{}
+		return
+	}
+	captchaBackend.failures++
+	if captchaBackend.failures >= captchaBreakerThreshold {
+		captchaBackend.openUntil = time.Now().Add(captchaBreakerCooldown)
+		if recaptchaFailOpen {
+			log.Printf("WARNING: %s circuit open for %v after %d consecutive failures, claims are NOT bot-checked until it recovers: %v", captcha.name(), captchaBreakerCooldown, captchaBackend.failures, err)
+		} else {
+			log.Printf("%s circuit open for %v after %d consecutive failures, rejecting claims until it recovers: %v", captcha.name(), captchaBreakerCooldown, captchaBackend.failures, err)
+		}
+	}
 }
 
+// version is set at build time with -ldflags "-X main.version=<version>".
+var version = "dev"
+
+// userAgent identifies this component in hub and spoke API server audit logs.
+var userAgent = "prelude-server/" + version
+
+// Explicit hub connection settings, taking precedence over kubeconfig when
+// hubServer is set.
+var hubServer string
+var hubClientCert string
+var hubClientKey string
+var hubCA string
+
+// spokeCAData is an extra CA bundle (--spoke-ca-file) trusted by spoke
+// clients alongside each spoke kubeconfig's own CA, for spokes fronted by a
+// TLS terminator with a corporate CA.
+var spokeCAData []byte
+
+// listenAddr is the host:port the API server binds (--listen-addr).
+var listenAddr = ":8080"
+
+// shutdownGrace is how long in-flight requests get to finish after
+// SIGINT/SIGTERM before the listener is closed (--shutdown-grace).
+var shutdownGrace = 15 * time.Second
+
 func main() {
-	clusterPool := flag.String("cluster-pool", os.Getenv("CLUSTER_POOL"), "ClusterPool name to filter ClusterClaims by (required)")
+	clusterPool := flag.String("cluster-pool", os.Getenv("CLUSTER_POOL"), "Comma-separated ClusterPool names to serve; requests pick one with ?pool= when there are several (required unless --pool-selector is set)")
+	flag.StringVar(&poolSelector, "pool-selector", os.Getenv("POOL_SELECTOR"), "Label selector for ClusterPools to serve instead of --cluster-pool, re-resolved every --pool-resolve-interval (e.g. prelude.io/event=summit)")
+	poolResolveIntervalStr := flag.String("pool-resolve-interval", os.Getenv("POOL_RESOLVE_INTERVAL"), "How often --pool-selector is re-resolved to pick up new pools (default 5m)")
+	listenAddrStr := flag.String("listen-addr", os.Getenv("LISTEN_ADDR"), "Address the API server listens on, as host:port (default :8080)")
+	shutdownGraceStr := flag.String("shutdown-grace", os.Getenv("SHUTDOWN_GRACE"), "How long in-flight requests get to finish on SIGINT/SIGTERM before connections are closed (default 15s)")
 	clusterLifetime := flag.String("cluster-lifetime", os.Getenv("CLUSTER_LIFETIME"), "Lifetime to set on claimed ClusterClaims (e.g. 2h)")
+	defaultLifetimeStr := flag.String("default-lifetime", os.Getenv("DEFAULT_LIFETIME"), "Pool default lifetime used to show an estimated expiry for claims without spec.lifetime (e.g. 8h)")
+	migrateLabelsFrom := flag.String("migrate-labels-from", "", "One-shot: rename <prefix>, <prefix>-auth and <prefix>-fp labels on pool claims to the prelude labels, then exit")
+	migrateLabelsDryRun := flag.Bool("migrate-labels-dry-run", false, "With --migrate-labels-from, only log the changes that would be made")
+	adminUsersFlag := flag.String("admin-users", os.Getenv("ADMIN_USERS"), "Named admin operators as user=password,... in addition to ADMIN_PASSWORD (user admin)")
+	flag.StringVar(&adminUsersFile, "admin-users-file", os.Getenv("ADMIN_USERS_FILE"), "File of user=password lines for named admin operators, re-read every 30s so removing a line revokes that operator")
+	adminTokenTTLStr := flag.String("admin-token-ttl", os.Getenv("ADMIN_TOKEN_TTL"), "How long a password-mode admin token is valid after login (default 8h)")
+	adminTokenSecret := flag.String("admin-token-secret", os.Getenv("ADMIN_TOKEN_SECRET"), "namespace/name of a Secret persisting password-mode admin tokens across restarts (default in memory only)")
+	flag.StringVar(&adminAuthMode, "admin-auth-mode", os.Getenv("ADMIN_AUTH_MODE"), "Admin endpoint authentication: password (default, ADMIN_PASSWORD) or oauth (OpenShift bearer token checked with Token/SubjectAccessReview)")
+	flag.StringVar(&adminVerb, "admin-verb", os.Getenv("ADMIN_VERB"), "With --admin-auth-mode=oauth, the verb users need on --admin-resource in the claim namespace to log in and view claims (default get)")
+	flag.StringVar(&adminResource, "admin-resource", os.Getenv("ADMIN_RESOURCE"), "With --admin-auth-mode=oauth, the Hive resource checked for admin access (default clusterclaims)")
+	expiryWarningStr := flag.String("expiry-warning", os.Getenv("EXPIRY_WARNING"), "Notify --expiry-webhook this long before a claimed cluster expires (e.g. 15m, 0 disables)")
+	flag.StringVar(&expiryWebhook, "expiry-webhook", os.Getenv("EXPIRY_WEBHOOK"), "URL POSTed a JSON notification once per claim when it comes within --expiry-warning of expiry")
+	maxLifetimeStr := flag.String("max-lifetime", os.Getenv("MAX_LIFETIME"), "Enable POST /api/extend, letting users extend their claim up to this long after assignment (e.g. 8h, requires Keycloak)")
+	expiryGraceStr := flag.String("expiry-grace", os.Getenv("EXPIRY_GRACE"), "Keep claims usable for this long past their expiry before Hive deletes them (e.g. 15m, default none)")
+	reassignCooldownStr := flag.String("reassign-cooldown", os.Getenv("REASSIGN_COOLDOWN"), "Keep a released claim out of assignment for this long after release (e.g. 15m, default immediate)")
+	flag.BoolVar(&twoPhaseClaim, "two-phase-claim", os.Getenv("TWO_PHASE_CLAIM") == "true", "Enable /api/claim?phase=reserve|confirm, so a cluster is only committed once the user confirms")
+	flag.BoolVar(&magicLinkEnabled, "magic-link", os.Getenv("MAGIC_LINK") == "true", "Let /api/claim omit the password and return a one-time link revealing a generated one (requires Keycloak)")
+	flag.BoolVar(&adminKubeconfigFallback, "admin-kubeconfig-fallback", os.Getenv("ADMIN_KUBECONFIG_FALLBACK") == "true", "Return the admin kubeconfig when a cluster's user kubeconfig secret is missing, instead of failing the claim")
+	flag.StringVar(&overflowRedirectURL, "overflow-redirect-url", os.Getenv("OVERFLOW_REDIRECT_URL"), "Waitlist/signup URL returned as redirect with all_clusters_in_use")
+	tracksFlag := flag.String("tracks", os.Getenv("TRACKS"), "Comma-separated attendee tracks a claim may select (e.g. developer,admin), stored in the prelude-track label")
+	flag.StringVar(&captchaProviderName, "captcha-provider", os.Getenv("CAPTCHA_PROVIDER"), "Bot check provider: recaptcha (default) or hcaptcha")
+	recaptchaTimeoutStr := flag.String("recaptcha-timeout", os.Getenv("RECAPTCHA_TIMEOUT"), "Timeout for each captcha siteverify request (default 5s)")
+	recaptchaRetriesStr := flag.String("recaptcha-retries", os.Getenv("RECAPTCHA_RETRIES"), "How many times a failed captcha siteverify request is retried (default 1)")
+	recaptchaConcurrencyStr := flag.String("recaptcha-concurrency", os.Getenv("RECAPTCHA_CONCURRENCY"), "Maximum concurrent captcha siteverify requests, further ones wait up to --recaptcha-timeout for a slot (default 20, 0 is unbounded)")
+	flag.BoolVar(&recaptchaFailOpen, "recaptcha-fail-open", os.Getenv("RECAPTCHA_FAIL_OPEN") == "true", "Accept captcha tokens unverified while siteverify is failing, instead of rejecting claims")
+	flag.BoolVar(&readyGate, "ready-gate", os.Getenv("READY_GATE") == "true", "Report not-ready on /readyz and answer /api/claim with warming_up until the pool has an authenticated cluster")
+	fingerprintLengthStr := flag.String("fingerprint-length", os.Getenv("FINGERPRINT_LENGTH"), "Number of hex characters of the browser fingerprint kept in the prelude-fp label (1-32, default 16)")
+	fingerprintPhoneLimitStr := flag.String("fingerprint-phone-limit", os.Getenv("FINGERPRINT_PHONE_LIMIT"), "Reject claims from a fingerprint presenting more than this many distinct phones within --fingerprint-phone-window (default 0, disabled; requires a captcha provider)")
+	fingerprintPhoneWindowStr := flag.String("fingerprint-phone-window", os.Getenv("FINGERPRINT_PHONE_WINDOW"), "Sliding window for --fingerprint-phone-limit (default 1h)")
+	claimNamespaceFlag := flag.String("claim-namespace", os.Getenv("CLAIM_NAMESPACE"), "Hub namespace holding ClusterClaims for pools not listed in --pool-namespace (default cluster-pools)")
+	poolNamespace := flag.String("pool-namespace", os.Getenv("POOL_NAMESPACE"), "Comma-separated pool=namespace mapping of ClusterClaim namespaces (e.g. poolA=ns-a,poolB=ns-b)")
+	flag.BoolVar(&probeConsole, "probe-console", os.Getenv("PROBE_CONSOLE") == "true", "Probe the web console URL before returning a claim, replying console_not_ready while it is not serving")
+	flag.BoolVar(&verbose, "verbose", os.Getenv("VERBOSE") == "true", "Log the full claim decision trace for every request")
+	flag.BoolVar(&verbose, "v", os.Getenv("VERBOSE") == "true", "Shorthand for --verbose")
+	assignStrategy := flag.String("assign-strategy", os.Getenv("ASSIGN_STRATEGY"), "How to pick among available clusters: random (default), most-remaining or least-remaining lifetime")
+	clusterTTLStr := flag.String("cluster-ttl", os.Getenv("CLUSTER_TTL"), "Pool TTL for estimating remaining cluster lifetime when ClusterDeployments lack hive.openshift.io/delete-after (default none)")
+	claimRateIntervalStr := flag.String("claim-rate-interval", os.Getenv("CLAIM_RATE_INTERVAL"), "Sustained rate of /api/claim attempts allowed per phone, one per this interval (default 5s)")
+	claimRateBurstStr := flag.String("claim-rate-burst", os.Getenv("CLAIM_RATE_BURST"), "/api/claim attempts a phone may make in quick succession before being rate limited (default 3, 0 disables)")
+	assignRetriesStr := flag.String("assign-retries", os.Getenv("ASSIGN_RETRIES"), "How many resourceVersion conflicts a single claim request tolerates while assigning a cluster before answering all_clusters_in_use (default 5)")
+	unreachableGraceStr := flag.String("unreachable-grace", os.Getenv("UNREACHABLE_GRACE"), "With --probe-console, how long a claimed cluster may stay unreachable across attempts before its phone assignment is released (default 2m)")
+	flag.StringVar(&hubServer, "hub-server", os.Getenv("HUB_SERVER"), "Hub API server URL; with --hub-client-cert/--hub-client-key, used instead of a kubeconfig")
+	flag.StringVar(&hubClientCert, "hub-client-cert", os.Getenv("HUB_CLIENT_CERT"), "Client certificate file for --hub-server")
+	flag.StringVar(&hubClientKey, "hub-client-key", os.Getenv("HUB_CLIENT_KEY"), "Client key file for --hub-server")
+	flag.StringVar(&hubCA, "hub-ca", os.Getenv("HUB_CA"), "CA bundle file for --hub-server (default system roots)")
+	spokeCAFile := flag.String("spoke-ca-file", os.Getenv("SPOKE_CA_FILE"), "Additional CA bundle file to trust when connecting to spoke clusters")
+	fakeBackend := flag.Bool("fake-backend", os.Getenv("FAKE_BACKEND") == "true", "Serve the client from an in-memory pool of synthetic clusters, with no hub or Kubernetes access (frontend development only)")
+	upstream := flag.String("upstream", os.Getenv("UPSTREAM"), "Comma-separated prelude server URLs to federate; the server then aggregates their stats and proxies claims instead of talking to Hive")
+	skipPoolCheck := flag.Bool("skip-pool-check", os.Getenv("SKIP_POOL_CHECK") == "true", "Start even if the ClusterPool doesn't exist yet (bootstrap)")
+	hiveGroup := flag.String("hive-group", os.Getenv("HIVE_GROUP"), "API group of the Hive ClusterClaim/ClusterDeployment resources (default hive.openshift.io)")
+	hiveVersion := flag.String("hive-version", os.Getenv("HIVE_VERSION"), "API version of the Hive ClusterClaim/ClusterDeployment resources (default v1)")
 	flag.Parse()
 
-	if *clusterPool == "" {
+	if *fakeBackend && !fakeBackendBuilt {
+		log.Fatalf("--fake-backend is only available in servers built with -tags fakebackend")
+	}
+
+	// Allow adapting to Hive API group/version changes without a rebuild
+	if *hiveGroup != "" {
+		clusterClaimGVR.Group = *hiveGroup
+		clusterDeploymentGVR.Group = *hiveGroup
+	}
+	if *hiveVersion != "" {
+		clusterClaimGVR.Version = *hiveVersion
+		clusterDeploymentGVR.Version = *hiveVersion
+	}
+	if *hiveGroup != "" || *hiveVersion != "" {
+		log.Printf("Using Hive API %s/%s", clusterClaimGVR.Group, clusterClaimGVR.Version)
+	}
+
+	if *clusterPool == "" && poolSelector == "" && *upstream == "" && !*fakeBackend {
 		log.Fatalf("--cluster-pool flag or CLUSTER_POOL environment variable is required")
 	}
+	if *clusterPool != "" && poolSelector != "" {
+		log.Fatalf("--cluster-pool and --pool-selector are mutually exclusive")
+	}
+	if poolSelector != "" {
+		if _, err := labels.Parse(poolSelector); err != nil {
+			log.Fatalf("Invalid --pool-selector %q: %v", poolSelector, err)
+		}
+	}
+	if *poolResolveIntervalStr != "" {
+		d, err := duration.Parse(*poolResolveIntervalStr)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid --pool-resolve-interval value %q", *poolResolveIntervalStr)
+		}
+		poolResolveInterval = d
+	}
+	var initialPools []string
+	for _, p := range strings.Split(*clusterPool, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if slices.Contains(initialPools, p) {
+			log.Fatalf("Invalid --cluster-pool: %s listed twice", p)
+		}
+		initialPools = append(initialPools, p)
+	}
+	if len(initialPools) == 0 && poolSelector == "" && *upstream == "" && !*fakeBackend {
+		log.Fatalf("Invalid --cluster-pool %q, no pool names", *clusterPool)
+	}
+
+	if *listenAddrStr != "" {
+		if _, _, err := net.SplitHostPort(*listenAddrStr); err != nil {
+			log.Fatalf("Invalid --listen-addr value %q (want host:port, e.g. :8080 or 127.0.0.1:8080): %v", *listenAddrStr, err)
+		}
+		listenAddr = *listenAddrStr
+	}
+	if *adminTokenTTLStr != "" {
+		d, err := duration.Parse(*adminTokenTTLStr)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid --admin-token-ttl value %q", *adminTokenTTLStr)
+		}
+		adminTokenTTL = d
+	}
+	if *shutdownGraceStr != "" {
+		d, err := duration.Parse(*shutdownGraceStr)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid --shutdown-grace value %q", *shutdownGraceStr)
+		}
+		shutdownGrace = d
+	}
 	if *clusterLifetime == "" {
 		*clusterLifetime = "2h"
 	}
-	recaptchaSecretKey = os.Getenv("RECAPTCHA_SECRET_KEY")
-	recaptchaSiteKey = os.Getenv("RECAPTCHA_SITE_KEY")
+	if *clusterTTLStr != "" {
+		d, err := duration.Parse(*clusterTTLStr)
+		if err != nil {
+			log.Fatalf("Invalid --cluster-ttl value %q", *clusterTTLStr)
+		}
+		clusterTTL = d
+	}
+	switch *assignStrategy {
+	case "", "random":
+	case "most-remaining":
+		claimWeight = weightMostRemaining
+	case "least-remaining":
+		claimWeight = weightLeastRemaining
+	default:
+		log.Fatalf("Invalid --assign-strategy %q, must be random, most-remaining or least-remaining", *assignStrategy)
+	}
+	if claimWeight != nil {
+		log.Printf("Assign strategy: %s (cluster TTL fallback %v)", *assignStrategy, clusterTTL)
+	}
+	for _, t := range strings.Split(*tracksFlag, ",") {
+		if t = strings.TrimSpace(t); t == "" {
+			continue
+		}
+		if errs := validation.IsValidLabelValue(t); len(errs) > 0 {
+			log.Fatalf("Invalid --tracks entry %q: %s", t, strings.Join(errs, "; "))
+		}
+		tracks = append(tracks, t)
+	}
+	if len(tracks) > 0 {
+		log.Printf("Attendee tracks: %s", strings.Join(tracks, ", "))
+	}
+	if overflowRedirectURL != "" {
+		if u, err := url.Parse(overflowRedirectURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			log.Fatalf("Invalid --overflow-redirect-url %q, must be an absolute http(s) URL", overflowRedirectURL)
+		}
+		log.Printf("Overflow redirect: %s", overflowRedirectURL)
+	}
+	if *claimNamespaceFlag != "" {
+		clusterPoolNamespace = *claimNamespaceFlag
+	}
+	mapped, err := pools.ParseNamespaces(*poolNamespace)
+	if err != nil {
+		log.Fatalf("Invalid --pool-namespace: %v", err)
+	}
+	poolNamespaces = mapped
+	if captchaProviderName == "" {
+		captchaProviderName = "recaptcha"
+	}
+	provider, ok := captchaProviders[captchaProviderName]
+	if !ok {
+		log.Fatalf("Invalid --captcha-provider %q, must be recaptcha or hcaptcha", captchaProviderName)
+	}
+	captcha = provider.provider
+	captchaSecretKey = os.Getenv(provider.secretKey)
+	captchaSiteKey = os.Getenv(provider.siteKey)
 	hideKubeconfig = os.Getenv("HIDE_KUBECONFIG") == "true"
 	if hideKubeconfig {
 		log.Printf("Kubeconfig display hidden from client")
 	}
+	if adminKubeconfigFallback {
+		log.Printf("WARNING: admin kubeconfig fallback enabled, users get cluster-admin credentials when their user kubeconfig is missing")
+	}
+	if *spokeCAFile != "" {
+		if err := loadSpokeCA(*spokeCAFile); err != nil {
+			log.Fatalf("Invalid --spoke-ca-file: %v", err)
+		}
+		log.Printf("Trusting additional spoke CA bundle %s", *spokeCAFile)
+	}
 	hideConsole = os.Getenv("HIDE_OPENSHIFT_CONSOLE") == "true"
 	if hideConsole {
 		log.Printf("OpenShift Console URL display hidden from client")
 	}
-	if recaptchaSecretKey != "" {
-		log.Printf("reCAPTCHA verification enabled")
+	// The grace only applies to probe failures, without the probe it would
+	// silently do nothing
+	if *unreachableGraceStr != "" && !probeConsole {
+		log.Fatalf("--unreachable-grace requires --probe-console")
+	}
+	unreachableGrace = 2 * time.Minute
+	if *unreachableGraceStr != "" {
+		d, err := duration.Parse(*unreachableGraceStr)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid --unreachable-grace value %q", *unreachableGraceStr)
+		}
+		unreachableGrace = d
+	}
+	if probeConsole {
+		log.Printf("Web console probe enabled (unreachable grace %v)", unreachableGrace)
+	}
+	recaptchaTimeout = 5 * time.Second
+	if *recaptchaTimeoutStr != "" {
+		d, err := duration.Parse(*recaptchaTimeoutStr)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid --recaptcha-timeout value %q", *recaptchaTimeoutStr)
+		}
+		recaptchaTimeout = d
+	}
+	recaptchaRetries = 1
+	if *recaptchaRetriesStr != "" {
+		n, err := strconv.Atoi(*recaptchaRetriesStr)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid --recaptcha-retries value %q", *recaptchaRetriesStr)
+		}
+		recaptchaRetries = n
+	}
+	recaptchaConcurrency = 20
+	if *recaptchaConcurrencyStr != "" {
+		n, err := strconv.Atoi(*recaptchaConcurrencyStr)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid --recaptcha-concurrency value %q", *recaptchaConcurrencyStr)
+		}
+		recaptchaConcurrency = n
+	}
+	if captchaSecretKey != "" {
+		log.Printf("%s verification enabled (timeout %v, %d retries)", captcha.name(), recaptchaTimeout, recaptchaRetries)
+		if recaptchaConcurrency > 0 {
+			captchaSlots = make(chan struct{}, recaptchaConcurrency)
+			log.Printf("%s verification limited to %d concurrent requests", captcha.name(), recaptchaConcurrency)
+		}
+		if recaptchaFailOpen {
+			log.Printf("WARNING: %s fails open, claims skip verification while siteverify is down", captcha.name())
+		}
 	} else {
-		log.Printf("reCAPTCHA verification disabled (RECAPTCHA_SECRET_KEY not set)")
+		log.Printf("%s verification disabled (%s not set)", captcha.name(), provider.secretKey)
+	}
+
+	fingerprintLength = 16
+	if *fingerprintLengthStr != "" {
+		n, err := strconv.Atoi(*fingerprintLengthStr)
+		if err != nil || n < 1 || n > 32 {
+			log.Fatalf("Invalid --fingerprint-length value %q, must be between 1 and 32", *fingerprintLengthStr)
+		}
+		fingerprintLength = n
+	}
+	if fingerprintLength != 16 {
+		log.Printf("Fingerprint length: %d hex characters", fingerprintLength)
+	}
+
+	if *fingerprintPhoneLimitStr != "" {
+		n, err := strconv.Atoi(*fingerprintPhoneLimitStr)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid --fingerprint-phone-limit value %q", *fingerprintPhoneLimitStr)
+		}
+		fingerprintPhoneLimit = n
+	}
+	fingerprintPhoneWindow = time.Hour
+	if *fingerprintPhoneWindowStr != "" {
+		d, err := duration.Parse(*fingerprintPhoneWindowStr)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid --fingerprint-phone-window value %q", *fingerprintPhoneWindowStr)
+		}
+		fingerprintPhoneWindow = d
+	}
+	if fingerprintPhoneLimit > 0 {
+		if captchaSecretKey == "" {
+			log.Printf("Fingerprint phone limit ignored (requires captcha verification)")
+		} else {
+			log.Printf("Fingerprint phone limit enabled (%d distinct phones per %v)", fingerprintPhoneLimit, fingerprintPhoneWindow)
+		}
 	}
 
 	adminPassword = os.Getenv("ADMIN_PASSWORD")
-	if adminPassword != "" {
-		log.Printf("Admin page authentication enabled")
-	} else {
-		log.Printf("Admin page authentication disabled (ADMIN_PASSWORD not set)")
+	switch adminAuthMode {
+	case "", "password":
+		adminAuthMode = "password"
+		if err := loadAdminUsers(*adminUsersFlag); err != nil {
+			log.Fatalf("Error loading admin operators: %v", err)
+		}
+		if adminPasswordAuth() {
+			adminUsers.RLock()
+			log.Printf("Admin page authentication enabled (%d operators)", len(adminUsers.m))
+			adminUsers.RUnlock()
+		} else {
+			log.Printf("Admin page authentication disabled (ADMIN_PASSWORD, --admin-users and --admin-users-file not set)")
+		}
+		if adminUsersFile != "" {
+			go func() {
+				for {
+					time.Sleep(adminUsersReload)
+					if err := loadAdminUsers(*adminUsersFlag); err != nil {
+						log.Printf("Error reloading admin operators, keeping the previous set: %v", err)
+					}
+				}
+			}()
+		}
+	case "oauth":
+		if adminVerb == "" {
+			adminVerb = "get"
+		}
+		if adminResource == "" {
+			adminResource = "clusterclaims"
+		}
+		log.Printf("Admin page authentication via OpenShift tokens (viewing requires %s %s in each pool's claim namespace, changes update or delete clusterclaims there)", adminVerb, adminResource)
+	default:
+		log.Fatalf("Invalid --admin-auth-mode %q, must be password or oauth", adminAuthMode)
 	}
 
 	maasURL = os.Getenv("MAAS_URL")
@@ -323,45 +1036,309 @@ func main() {
 		log.Printf("Keycloak password update disabled (KEYCLOAK_URL or KEYCLOAK_CLIENT_SECRET not set)")
 	}
 
-	log.Printf("Filtering ClusterClaims by clusterPoolName: %s", *clusterPool)
+	// Console link rules, JSON list of {name, search, replace, path}
+	if consolesJSON := os.Getenv("CONSOLES"); consolesJSON != "" {
+		var rules []consoleRule
+		if err := json.Unmarshal([]byte(consolesJSON), &rules); err != nil {
+			log.Fatalf("Invalid CONSOLES value: %v", err)
+		}
+		for _, rule := range rules {
+			if rule.Name == "" {
+				log.Fatalf("Invalid CONSOLES value: every rule needs a name")
+			}
+		}
+		consoleRules = rules
+	}
+	var consoleNames []string
+	for _, rule := range consoleRules {
+		consoleNames = append(consoleNames, rule.Name)
+	}
+	log.Printf("Console links: %s", strings.Join(consoleNames, ", "))
+
 	log.Printf("Cluster lifetime: %s", *clusterLifetime)
+	if *defaultLifetimeStr != "" {
+		d, err := duration.Parse(*defaultLifetimeStr)
+		if err != nil {
+			log.Fatalf("Invalid --default-lifetime value %q: %v", *defaultLifetimeStr, err)
+		}
+		defaultLifetime = d
+		log.Printf("Default lifetime for expiry estimates: %s", *defaultLifetimeStr)
+	}
+	if *expiryWarningStr != "" {
+		d, err := duration.Parse(*expiryWarningStr)
+		if err != nil {
+			log.Fatalf("Invalid --expiry-warning value %q: %v", *expiryWarningStr, err)
+		}
+		expiryWarning = d
+	}
+	if *expiryGraceStr != "" {
+		d, err := duration.Parse(*expiryGraceStr)
+		if err != nil {
+			log.Fatalf("Invalid --expiry-grace value %q: %v", *expiryGraceStr, err)
+		}
+		expiryGrace = d
+		log.Printf("Expiry grace: %s past expiry", duration.Format(expiryGrace))
+	}
+	claimRateInterval := 5 * time.Second
+	if *claimRateIntervalStr != "" {
+		d, err := duration.Parse(*claimRateIntervalStr)
+		if err != nil || d <= 0 {
+			log.Fatalf("Invalid --claim-rate-interval value %q", *claimRateIntervalStr)
+		}
+		claimRateInterval = d
+	}
+	assignRetries = 5
+	if *assignRetriesStr != "" {
+		n, err := strconv.Atoi(*assignRetriesStr)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid --assign-retries value %q", *assignRetriesStr)
+		}
+		assignRetries = n
+	}
+	claimRateBurst := 3
+	if *claimRateBurstStr != "" {
+		n, err := strconv.Atoi(*claimRateBurstStr)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid --claim-rate-burst value %q", *claimRateBurstStr)
+		}
+		claimRateBurst = n
+	}
+	if claimRateBurst > 0 {
+		// A bucket of n refilled over n intervals regains one attempt per interval
+		claimLimiter = newRateLimiter(claimRateBurst, time.Duration(claimRateBurst)*claimRateInterval)
+		log.Printf("Claim rate limit: bursts of %d attempts per phone, then one every %v", claimRateBurst, claimRateInterval)
+	} else {
+		log.Printf("Claim rate limit disabled")
+	}
+	if *reassignCooldownStr != "" {
+		d, err := duration.Parse(*reassignCooldownStr)
+		if err != nil {
+			log.Fatalf("Invalid --reassign-cooldown value %q: %v", *reassignCooldownStr, err)
+		}
+		reassignCooldown = d
+		log.Printf("Reassign cooldown: %s after release", duration.Format(reassignCooldown))
+	}
+	if *maxLifetimeStr != "" {
+		d, err := duration.Parse(*maxLifetimeStr)
+		if err != nil {
+			log.Fatalf("Invalid --max-lifetime value %q: %v", *maxLifetimeStr, err)
+		}
+		maxLifetime = d
+	}
+	if maxLifetime > 0 {
+		if keycloakURL == "" || keycloakClientSecret == "" {
+			log.Printf("Self-service extension disabled (--max-lifetime requires Keycloak to verify passwords)")
+			maxLifetime = 0
+		} else {
+			log.Printf("Self-service extension enabled (up to %s after assignment)", duration.Format(maxLifetime))
+		}
+	}
+	if twoPhaseClaim {
+		log.Printf("Two-phase claims enabled (reservations held for %s)", duration.Format(reservationTTL))
+	}
+	if magicLinkEnabled {
+		if *upstream != "" {
+			log.Printf("Magic links enabled (issued by the upstreams, which need --magic-link and Keycloak)")
+		} else if keycloakURL == "" || keycloakClientSecret == "" {
+			log.Printf("Magic links disabled (--magic-link requires Keycloak)")
+			magicLinkEnabled = false
+		} else {
+			log.Printf("Magic links enabled (valid for %s, single use)", duration.Format(magicLinkTTL))
+		}
+	}
+	if expiryWarning > 0 && expiryWebhook != "" {
+		log.Printf("Expiry notifications enabled (%v before expiry)", expiryWarning)
+	} else if expiryWarning > 0 || expiryWebhook != "" {
+		log.Printf("Expiry notifications disabled (need both --expiry-warning and --expiry-webhook)")
+	}
+
+	// Fake backend: no Hive access, synthetic clusters for frontend work
+	if *fakeBackend {
+		runFakeBackend(*clusterLifetime)
+		return
+	}
+
+	// Federation mode: no Hive access, aggregate downstream prelude servers
+	if *upstream != "" {
+		var upstreams []string
+		for _, u := range strings.Split(*upstream, ",") {
+			if u = strings.TrimRight(strings.TrimSpace(u), "/"); u != "" {
+				upstreams = append(upstreams, u)
+			}
+		}
+		runFederation(upstreams)
+		return
+	}
 
 	config, err := buildConfig()
 	if err != nil {
 		log.Fatalf("Error building kubeconfig: %v", err)
 	}
+	config.UserAgent = userAgent
 
 	dynClient, err := dynamic.NewForConfig(config)
 	if err != nil {
 		log.Fatalf("Error creating dynamic client: %v", err)
 	}
+	claimStore = dynamicClaimStore{dynClient}
+
+	// Fail fast on a misnamed pool instead of silently doing nothing
+	if poolSelector != "" {
+		initialPools, err = resolvePools(context.Background(), dynClient, poolSelector)
+		if err != nil {
+			log.Fatalf("Error resolving --pool-selector: %v", err)
+		}
+		if len(initialPools) == 0 && !*skipPoolCheck {
+			log.Fatalf("No ClusterPool matches --pool-selector %q (use --skip-pool-check if the pool is created later)", poolSelector)
+		}
+		log.Printf("Pool selector %s matches: %s", poolSelector, strings.Join(initialPools, ", "))
+	} else if !*skipPoolCheck {
+		for _, p := range initialPools {
+			if err := checkClusterPool(context.Background(), dynClient, claimNamespace(p), p); err != nil {
+				log.Fatalf("%v (use --skip-pool-check if the pool is created later)", err)
+			}
+		}
+	}
+	setServedPools(initialPools)
+	log.Printf("Filtering ClusterClaims by clusterPoolName: %s", strings.Join(initialPools, ", "))
 
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		log.Fatalf("Error creating kubernetes client: %v", err)
 	}
+	adminAuthClient = clientset
+
+	if *adminTokenSecret != "" && adminAuthMode == "password" && adminPasswordAuth() {
+		ns, name, ok := strings.Cut(*adminTokenSecret, "/")
+		if !ok || ns == "" || name == "" {
+			log.Fatalf("Invalid --admin-token-secret %q, must be namespace/name", *adminTokenSecret)
+		}
+		adminTokenSecretNamespace, adminTokenSecretName = ns, name
+		if err := loadAdminTokens(context.Background(), clientset); err != nil {
+			log.Fatalf("Error loading admin tokens from secret %s: %v", *adminTokenSecret, err)
+		}
+		adminTokens.RLock()
+		log.Printf("Admin tokens persisted in secret %s (%d loaded)", *adminTokenSecret, len(adminTokens.m))
+		adminTokens.RUnlock()
+	}
+
+	// Background goroutine to drop expired admin tokens
+	if adminAuthMode == "password" && adminPasswordAuth() {
+		log.Printf("Admin tokens expire %v after login", adminTokenTTL)
+		go func() {
+			for {
+				time.Sleep(5 * time.Minute)
+				sweepAdminTokens(context.Background(), clientset)
+			}
+		}()
+	}
 
-	pool := *clusterPool
 	lifetime := *clusterLifetime
 
-	// Background goroutine to update Prometheus metrics every 30s
+	if *migrateLabelsFrom != "" {
+		for _, p := range initialPools {
+			if err := migrateLabels(context.Background(), p, *migrateLabelsFrom, *migrateLabelsDryRun); err != nil {
+				log.Fatalf("Error migrating labels: %v", err)
+			}
+		}
+		return
+	}
+
+	// Serve ClusterDeployment reads from a watch-backed cache of the pools.
+	// Selected pools come and go, so then the cache holds every pool's.
+	cachedPools := initialPools
+	if poolSelector != "" {
+		cachedPools = nil
+	}
+	if err := startDeploymentCache(dynClient, cachedPools); err != nil {
+		log.Fatalf("Error starting ClusterDeployment cache: %v", err)
+	}
+
+	// Background goroutine to pick up pools created or deleted since startup
+	if poolSelector != "" {
+		go func() {
+			for {
+				time.Sleep(poolResolveInterval)
+				resolved, err := resolvePools(context.Background(), dynClient, poolSelector)
+				if err != nil {
+					log.Printf("Error resolving --pool-selector: %v", err)
+					continue
+				}
+				setServedPools(resolved)
+			}
+		}()
+	}
+
+	// Background goroutine to drop expired fingerprint/phone observations
+	if fingerprintPhoneLimit > 0 && captchaSecretKey != "" {
+		go func() {
+			for {
+				time.Sleep(time.Minute)
+				sweepFingerprintPhones()
+			}
+		}()
+	}
+
+	// Background goroutine to drop expired rate limiter entries
 	go func() {
 		for {
-			stats, err := computeClusterStats(dynClient, pool, lifetime)
-			if err != nil {
-				log.Printf("Error computing cluster stats for metrics: %v", err)
-			} else {
-				metricDeployments.Set(float64(stats.deployments))
-				metricClaims.Set(float64(stats.claims))
-				metricReady.Set(float64(stats.ready))
-				metricAvailable.Set(float64(stats.available))
-				metricClaimed.Set(float64(stats.claimed))
+			time.Sleep(time.Minute)
+			extendLimiter.sweep()
+			existsLimiter.sweep()
+			validateLimiter.sweep()
+			if claimLimiter != nil {
+				claimLimiter.sweep()
 			}
-			time.Sleep(30 * time.Second)
 		}
 	}()
 
-	// Metrics HTTP server on :9090
+	// Background goroutine to release phone labels stuck on unbound claims
+	go func() {
+		for {
+			time.Sleep(time.Minute)
+			for _, p := range servedPools() {
+				reconcileUnboundClaims(context.Background(), p)
+			}
+		}
+	}()
+
+	// Background goroutine to release reservations that were never confirmed
+	if twoPhaseClaim {
+		go func() {
+			for {
+				time.Sleep(30 * time.Second)
+				for _, p := range servedPools() {
+					releaseExpiredReservations(context.Background(), p)
+				}
+			}
+		}()
+	}
+
+	// Background goroutine to warn phones whose cluster is about to expire
+	if expiryWarning > 0 && expiryWebhook != "" {
+		go func() {
+			for {
+				for _, p := range servedPools() {
+					notifyExpiringClaims(context.Background(), p)
+				}
+				time.Sleep(time.Minute)
+			}
+		}()
+	}
+
+	if readyGate {
+		log.Printf("Ready gate enabled, waiting for an authenticated cluster before serving claims")
+	}
+
+	// Background goroutine to update Prometheus metrics every 30s
+	go func() {
+		for {
+			refreshStats(dynClient, lifetime)
+			time.Sleep(30 * time.Second)
+		}
+	}()
+
+	// Metrics HTTP server on :9090
 	go func() {
 		metricsMux := http.NewServeMux()
 		metricsMux.Handle("/metrics", promhttp.Handler())
@@ -371,31 +1348,174 @@ func main() {
 		}
 	}()
 
+	serve(newAPIMux(dynClient, clientset, lifetime))
+}
+
+// newAPIMux routes the client and API over the hub clients, real or (for
+// --fake-backend) in memory.
+func newAPIMux(dynClient dynamic.Interface, clientset kubernetes.Interface, lifetime string) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/config", handleConfig)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		handleReadyz(w, r)
+	})
+	mux.HandleFunc("/api/stats", handleStats)
+	mux.HandleFunc("/api/validate", handleValidate)
 	mux.HandleFunc("/api/claim", func(w http.ResponseWriter, r *http.Request) {
-		handleClaim(w, r, dynClient, clientset, pool, lifetime)
+		if pool, ok := requestPool(w, r); ok {
+			handleClaim(w, r, dynClient, clientset, pool, lifetime)
+		}
+	})
+	mux.HandleFunc("/api/claim/exists", func(w http.ResponseWriter, r *http.Request) {
+		if pool, ok := requestPool(w, r); ok {
+			handleClaimExists(w, r, dynClient, pool)
+		}
+	})
+	mux.HandleFunc("/api/extend", func(w http.ResponseWriter, r *http.Request) {
+		if pool, ok := requestPool(w, r); ok {
+			handleExtend(w, r, pool)
+		}
 	})
+	mux.HandleFunc("/api/magic", handleMagicLink)
 	mux.HandleFunc("/api/admin/login", handleAdminLogin)
+	mux.HandleFunc("/api/admin/logout", handleAdminLogout)
 	mux.HandleFunc("/api/admin", func(w http.ResponseWriter, r *http.Request) {
-		handleAdmin(w, r, dynClient, pool)
+		if pool, ok := requestPool(w, r); ok {
+			handleAdmin(w, r, dynClient, pool)
+		}
+	})
+	mux.HandleFunc("/api/admin/note", func(w http.ResponseWriter, r *http.Request) {
+		if pool, ok := requestPool(w, r); ok {
+			handleAdminNote(w, r, pool)
+		}
+	})
+	mux.HandleFunc("/api/admin/park", func(w http.ResponseWriter, r *http.Request) {
+		if pool, ok := requestPool(w, r); ok {
+			handleAdminPark(w, r, pool)
+		}
+	})
+	mux.HandleFunc("/api/admin/release", func(w http.ResponseWriter, r *http.Request) {
+		if pool, ok := requestPool(w, r); ok {
+			handleAdminRelease(w, r, pool)
+		}
+	})
+	mux.HandleFunc("/api/admin/rebind", func(w http.ResponseWriter, r *http.Request) {
+		if pool, ok := requestPool(w, r); ok {
+			handleAdminRebind(w, r, pool)
+		}
+	})
+	mux.HandleFunc("/api/admin/export", func(w http.ResponseWriter, r *http.Request) {
+		if pool, ok := requestPool(w, r); ok {
+			handleAdminExport(w, r, pool)
+		}
+	})
+	mux.HandleFunc("/api/admin/claim-by-cluster", func(w http.ResponseWriter, r *http.Request) {
+		if pool, ok := requestPool(w, r); ok {
+			handleAdminClaimByCluster(w, r, pool)
+		}
+	})
+	mux.HandleFunc("/api/admin/kubeconfig", func(w http.ResponseWriter, r *http.Request) {
+		if pool, ok := requestPool(w, r); ok {
+			handleAdminKubeconfig(w, r, dynClient, clientset, pool)
+		}
 	})
 
 	staticDir := filepath.Join("..", "client", "out")
 	mux.Handle("/", http.FileServer(http.Dir(staticDir)))
+	return mux
+}
 
-	addr := ":8080"
-	log.Printf("Server listening on %s", addr)
-	log.Fatal(http.ListenAndServe(addr, mux))
+// refreshStats recomputes each served pool's stats for /api/stats and the
+// Prometheus gauges, which are totals across all pools.
+func refreshStats(dynClient dynamic.Interface, lifetime string) {
+	// Reset claimed info/timestamp gauges to clear stale entries
+	metricClaimedInfo.Reset()
+	metricClaimedTimestamp.Reset()
+	metricClaimedByTrack.Reset()
+
+	var total clusterStats
+	failed := false
+	for _, p := range servedPools() {
+		stats, err := computeClusterStats(dynClient, p, lifetime)
+		if err != nil {
+			log.Printf("Error computing cluster stats for pool %s for metrics: %v", p, err)
+			failed = true
+			continue
+		}
+		latestStats.Lock()
+		latestStats.m[p] = statsResponse{
+			Pool:        p,
+			Deployments: stats.deployments,
+			Claims:      stats.claims,
+			Ready:       stats.ready,
+			Available:   stats.available,
+			Claimed:     stats.claimed,
+			InGrace:     stats.inGrace,
+			Tracks:      stats.tracks,
+		}
+		latestStats.Unlock()
+		total.deployments += stats.deployments
+		total.claims += stats.claims
+		total.ready += stats.ready
+		total.available += stats.available
+		total.claimed += stats.claimed
+		total.inGrace += stats.inGrace
+		for i, n := range stats.claimedDurations {
+			total.claimedDurations[i] += n
+		}
+	}
+	if !failed {
+		metricDeployments.Set(float64(total.deployments))
+		metricClaims.Set(float64(total.claims))
+		metricReady.Set(float64(total.ready))
+		metricAvailable.Set(float64(total.available))
+		metricClaimed.Set(float64(total.claimed))
+		metricInGrace.Set(float64(total.inGrace))
+		metricClaimedDuration1h.Set(total.claimedDurations[0])
+		metricClaimedDuration3h.Set(total.claimedDurations[1])
+		metricClaimedDuration6h.Set(total.claimedDurations[2])
+		metricClaimedDuration12h.Set(total.claimedDurations[3])
+		metricClaimedDuration24h.Set(total.claimedDurations[4])
+		metricClaimedDuration1w.Set(total.claimedDurations[5])
+		metricClaimedDurationGt1w.Set(total.claimedDurations[6])
+	}
 }
 
+// handleConfig serves the client configuration. It rarely changes, so it is
+// cacheable for a minute and carries an ETag for conditional requests.
 func handleConfig(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"recaptchaSiteKey": recaptchaSiteKey,
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"captchaProvider":  captchaProviderName,
+		"captchaSiteKey":   captchaSiteKey,
 		"hideKubeconfig":   hideKubeconfig,
 		"hideConsole":      hideConsole,
+		"extendEnabled":    maxLifetime > 0,
+		"magicLinkEnabled": magicLinkEnabled,
+		"twoPhaseClaim":    twoPhaseClaim,
+		"tracks":           tracks,
 	})
+	if err != nil {
+		log.Printf("Error encoding config: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to encode config")
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(body, '\n'))
 }
 
 func generateToken() (string, error) {
@@ -406,27 +1526,419 @@ func generateToken() (string, error) {
 	return hex.EncodeToString(b), nil
 }
 
-func validateAdminToken(r *http.Request) bool {
-	if adminPassword == "" {
-		return true
+func validateAdminToken(r *http.Request, pool string) bool {
+	_, ok := adminOperator(r, pool, "")
+	return ok
+}
+
+// adminOperator authenticates an admin request on pool and returns who made
+// it, for the audit log: the OpenShift user in oauth mode, the operator the
+// token was issued to in password mode, or "anonymous" when admin auth is
+// disabled. In oauth mode the user must be allowed verb on clusterclaims in
+// the pool's claim namespace, or the read-only adminAccess when verb is empty.
+func adminOperator(r *http.Request, pool, verb string) (string, bool) {
+	if adminAuthMode == "oauth" {
+		access := adminAccess(claimNamespace(pool))
+		if verb != "" {
+			access = authorizationv1.ResourceAttributes{Namespace: claimNamespace(pool), Verb: verb, Group: clusterClaimGVR.Group, Resource: "clusterclaims"}
+		}
+		return adminOAuthReview(r, access)
+	}
+	if !adminPasswordAuth() {
+		return "anonymous", true
 	}
 	auth := r.Header.Get("Authorization")
 	if !strings.HasPrefix(auth, "Bearer ") {
-		return false
+		return "", false
 	}
-	token := strings.TrimPrefix(auth, "Bearer ")
+	key := adminTokenKey(strings.TrimPrefix(auth, "Bearer "))
 	adminTokens.RLock()
-	defer adminTokens.RUnlock()
-	return adminTokens.m[token]
+	t, ok := adminTokens.m[key]
+	adminTokens.RUnlock()
+	if !ok {
+		return "", false
+	}
+	if !adminTokenValid(t) {
+		adminTokens.Lock()
+		delete(adminTokens.m, key)
+		adminTokens.Unlock()
+		return "", false
+	}
+	return t.user, true
+}
+
+// adminPasswordAuth reports whether password-mode admin auth is enabled.
+func adminPasswordAuth() bool {
+	adminUsers.RLock()
+	defer adminUsers.RUnlock()
+	return len(adminUsers.m) > 0
+}
+
+// adminTokenValid reports whether a token is unexpired and its operator is
+// still configured. Removing an operator thereby revokes all their tokens.
+func adminTokenValid(t adminToken) bool {
+	if time.Since(t.issued) >= adminTokenTTL {
+		return false
+	}
+	adminUsers.RLock()
+	defer adminUsers.RUnlock()
+	_, ok := adminUsers.m[t.user]
+	return ok
+}
+
+// parseAdminUsers parses "user=password" entries separated by commas or
+// newlines. Blank lines and lines starting with # are skipped.
+func parseAdminUsers(spec string) (map[string]string, error) {
+	users := map[string]string{}
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, password, ok := strings.Cut(entry, "=")
+		user = strings.TrimSpace(user)
+		if !ok || user == "" || password == "" {
+			return nil, fmt.Errorf("invalid entry %q, want user=password", user)
+		}
+		if strings.ContainsAny(user, " \t") {
+			return nil, fmt.Errorf("invalid user %q, must not contain spaces", user)
+		}
+		users[user] = password
+	}
+	return users, nil
+}
+
+// loadAdminUsers rebuilds adminUsers from ADMIN_PASSWORD, --admin-users and
+// --admin-users-file. Named operators override the implicit one.
+func loadAdminUsers(users string) error {
+	m := map[string]string{}
+	if adminPassword != "" {
+		m[defaultAdminUser] = adminPassword
+	}
+	named, err := parseAdminUsers(users)
+	if err != nil {
+		return fmt.Errorf("--admin-users: %w", err)
+	}
+	maps.Copy(m, named)
+	if adminUsersFile != "" {
+		data, err := os.ReadFile(adminUsersFile)
+		if err != nil {
+			return err
+		}
+		fromFile, err := parseAdminUsers(string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", adminUsersFile, err)
+		}
+		maps.Copy(m, fromFile)
+	}
+	adminUsers.Lock()
+	defer adminUsers.Unlock()
+	for user := range adminUsers.m {
+		if _, ok := m[user]; !ok {
+			log.Printf("Admin operator %s removed, their tokens are revoked", user)
+		}
+	}
+	for user := range m {
+		if _, ok := adminUsers.m[user]; !ok && len(adminUsers.m) > 0 {
+			log.Printf("Admin operator %s added", user)
+		}
+	}
+	adminUsers.m = m
+	return nil
+}
+
+// sweepAdminTokens drops expired admin tokens and those of removed operators,
+// from the --admin-token-secret Secret too (including ones issued by other
+// replicas).
+func sweepAdminTokens(ctx context.Context, clientset kubernetes.Interface) {
+	adminTokens.Lock()
+	for key, t := range adminTokens.m {
+		if !adminTokenValid(t) {
+			delete(adminTokens.m, key)
+		}
+	}
+	adminTokens.Unlock()
+	if adminTokenSecretName == "" {
+		return
+	}
+	removed, err := removeAdminTokens(ctx, clientset, func(key string, t adminToken) bool {
+		return !adminTokenValid(t)
+	})
+	if err != nil {
+		log.Printf("Admin tokens: error pruning secret %s/%s: %v", adminTokenSecretNamespace, adminTokenSecretName, err)
+	} else if removed > 0 {
+		log.Printf("Admin tokens: pruned %d expired or revoked from secret %s/%s", removed, adminTokenSecretNamespace, adminTokenSecretName)
+	}
+}
+
+// adminTokenKey is the adminTokens key for a token.
+func adminTokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// formatAdminToken and parseAdminToken convert a token to and from its
+// --admin-token-secret value, "<issue time> <operator>". Values written before
+// named operators have no operator and belong to defaultAdminUser.
+func formatAdminToken(t adminToken) []byte {
+	return []byte(t.issued.UTC().Format(time.RFC3339) + " " + t.user)
+}
+
+func parseAdminToken(value []byte) (adminToken, error) {
+	issuedStr, user, _ := strings.Cut(string(value), " ")
+	issued, err := time.Parse(time.RFC3339, issuedStr)
+	if err != nil {
+		return adminToken{}, err
+	}
+	if user == "" {
+		user = defaultAdminUser
+	}
+	return adminToken{user: user, issued: issued}, nil
+}
+
+// loadAdminTokens fills adminTokens from the --admin-token-secret Secret, one
+// data key per token holding its issue time and operator. A missing Secret is
+// an empty store; it is created on the first login.
+func loadAdminTokens(ctx context.Context, clientset kubernetes.Interface) error {
+	secret, err := clientset.CoreV1().Secrets(adminTokenSecretNamespace).Get(ctx, adminTokenSecretName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	adminTokens.Lock()
+	defer adminTokens.Unlock()
+	for key, value := range secret.Data {
+		t, err := parseAdminToken(value)
+		if err != nil {
+			log.Printf("Admin tokens: ignoring entry with invalid issue time %q", value)
+			continue
+		}
+		if !adminTokenValid(t) {
+			continue
+		}
+		adminTokens.m[key] = t
+	}
+	return nil
+}
+
+// persistAdminToken adds a token to the --admin-token-secret Secret. Logins on
+// other replicas write the same Secret, so conflicts are retried.
+func persistAdminToken(ctx context.Context, clientset kubernetes.Interface, key string, t adminToken) error {
+	secrets := clientset.CoreV1().Secrets(adminTokenSecretNamespace)
+	value := formatAdminToken(t)
+	for attempt := 1; ; attempt++ {
+		secret, err := secrets.Get(ctx, adminTokenSecretName, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			_, err = secrets.Create(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: adminTokenSecretName, Namespace: adminTokenSecretNamespace},
+				Data:       map[string][]byte{key: value},
+			}, metav1.CreateOptions{})
+		} else if err == nil {
+			if secret.Data == nil {
+				secret.Data = map[string][]byte{}
+			}
+			secret.Data[key] = value
+			_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		}
+		if err == nil || !(k8serrors.IsConflict(err) || k8serrors.IsAlreadyExists(err)) || attempt >= 3 {
+			return err
+		}
+	}
+}
+
+// removeAdminTokens deletes the --admin-token-secret entries remove matches
+// (entries with an unreadable issue time always go), retrying conflicts.
+// Returns how many were deleted.
+func removeAdminTokens(ctx context.Context, clientset kubernetes.Interface, remove func(key string, t adminToken) bool) (int, error) {
+	secrets := clientset.CoreV1().Secrets(adminTokenSecretNamespace)
+	for attempt := 1; ; attempt++ {
+		secret, err := secrets.Get(ctx, adminTokenSecretName, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			return 0, nil
+		} else if err != nil {
+			return 0, err
+		}
+		removed := 0
+		for key, value := range secret.Data {
+			t, err := parseAdminToken(value)
+			if err != nil || remove(key, t) {
+				delete(secret.Data, key)
+				removed++
+			}
+		}
+		if removed == 0 {
+			return 0, nil
+		}
+		_, err = secrets.Update(ctx, secret, metav1.UpdateOptions{})
+		if err == nil || !k8serrors.IsConflict(err) || attempt >= 3 {
+			return removed, err
+		}
+	}
+}
+
+// handleAdminLogout revokes the caller's password-mode admin token:
+// POST /api/admin/logout. OAuth tokens belong to OpenShift and are left
+// alone. It answers 204 whether or not the token was known.
+func handleAdminLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if adminAuthMode == "password" && token != "" {
+		key := adminTokenKey(token)
+		adminTokens.Lock()
+		t, known := adminTokens.m[key]
+		delete(adminTokens.m, key)
+		adminTokens.Unlock()
+		if known {
+			log.Printf("Admin logout for %s, token revoked", t.user)
+		}
+		if known && adminTokenSecretName != "" {
+			if _, err := removeAdminTokens(r.Context(), adminAuthClient, func(k string, _ adminToken) bool { return k == key }); err != nil {
+				log.Printf("Warning: revoked admin token not removed from secret %s/%s, it is valid again after a restart until it expires: %v", adminTokenSecretNamespace, adminTokenSecretName, err)
+			}
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminAccess is what an oauth admin needs for the read-only admin views
+// and to log in: adminVerb on adminResource in namespace.
+func adminAccess(namespace string) authorizationv1.ResourceAttributes {
+	return authorizationv1.ResourceAttributes{Namespace: namespace, Verb: adminVerb, Group: clusterClaimGVR.Group, Resource: adminResource}
+}
+
+// adminOAuthReview reviews the request's bearer token for access, returning
+// the username and whether access is allowed.
+func adminOAuthReview(r *http.Request, access authorizationv1.ResourceAttributes) (string, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		return "", false
+	}
+	return reviewOAuthToken(r.Context(), token, access)
+}
+
+// reviewOAuthToken authenticates an OpenShift bearer token with a TokenReview
+// and checks the user may do access with a SubjectAccessReview. It returns
+// the username and whether access is allowed.
+func reviewOAuthToken(ctx context.Context, token string, access authorizationv1.ResourceAttributes) (string, bool) {
+	sum := sha256.Sum256([]byte(token))
+	key := strings.Join([]string{hex.EncodeToString(sum[:]), access.Namespace, access.Verb, access.Group, access.Resource}, "/")
+	oauthReviews.Lock()
+	cached, ok := oauthReviews.m[key]
+	oauthReviews.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.user, cached.allowed
+	}
+
+	review, err := adminAuthClient.AuthenticationV1().TokenReviews().Create(ctx, &authenticationv1.TokenReview{
+		Spec: authenticationv1.TokenReviewSpec{Token: token},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		log.Printf("Admin: TokenReview failed: %v", err)
+		return "", false
+	}
+	if !review.Status.Authenticated {
+		return "", false
+	}
+	user := review.Status.User
+
+	extra := make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	sar, err := adminAuthClient.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:               user.Username,
+			UID:                user.UID,
+			Groups:             user.Groups,
+			Extra:              extra,
+			ResourceAttributes: &access,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		log.Printf("Admin: SubjectAccessReview for %s failed: %v", user.Username, err)
+		return user.Username, false
+	}
+
+	oauthReviews.Lock()
+	now := time.Now()
+	for k, v := range oauthReviews.m {
+		if now.After(v.expires) {
+			delete(oauthReviews.m, k)
+		}
+	}
+	oauthReviews.m[key] = oauthReview{user: user.Username, allowed: sar.Status.Allowed, expires: now.Add(oauthReviewTTL)}
+	oauthReviews.Unlock()
+	return user.Username, sar.Status.Allowed
+}
+
+// adminNamespaces returns the claim namespaces of the served pools, without
+// duplicates, or the default claim namespace before any pool is served.
+func adminNamespaces() []string {
+	var namespaces []string
+	for _, p := range servedPools() {
+		if ns := claimNamespace(p); !slices.Contains(namespaces, ns) {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	if len(namespaces) == 0 {
+		namespaces = []string{clusterPoolNamespace}
+	}
+	return namespaces
+}
+
+// unknownAdminPassword is compared against for unknown operator names.
+const unknownAdminPassword = "unknown-operator"
+
+// passwordMatches compares an admin password in constant time. Both sides are
+// hashed first, so the comparison doesn't depend on their lengths either.
+func passwordMatches(given, password string) bool {
+	a, b := sha256.Sum256([]byte(given)), sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
 }
 
 func handleAdminLogin(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	// OAuth mode: the "password" is an OpenShift token (oc whoami -t), which
+	// becomes the session token itself once the user passes the access review
+	if adminAuthMode == "oauth" {
+		var req adminLoginRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+			return
+		}
+		// Logging in needs access to one pool, each admin request is then
+		// checked against its own pool
+		token := strings.TrimSpace(req.Password)
+		user, allowed := "", false
+		if token != "" {
+			for _, ns := range adminNamespaces() {
+				if user, allowed = reviewOAuthToken(r.Context(), token, adminAccess(ns)); allowed || user == "" {
+					break
+				}
+			}
+		}
+		if !allowed {
+			if user != "" {
+				log.Printf("Admin login denied for %s (cannot %s %s in any pool's namespace)", user, adminVerb, adminResource)
+			}
+			writeJSONError(w, http.StatusUnauthorized, "invalid_token", "Invalid token or insufficient permissions")
+			return
+		}
+		log.Printf("Admin login successful for %s", user)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"token": token})
 		return
 	}
 
-	if adminPassword == "" {
+	if !adminPasswordAuth() {
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]string{"token": ""})
 		return
@@ -434,39 +1946,67 @@ func handleAdminLogin(w http.ResponseWriter, r *http.Request) {
 
 	var req adminLoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
 		return
 	}
 
-	if req.Password != adminPassword {
-		http.Error(w, "Invalid password", http.StatusUnauthorized)
+	// Without a username this is the shared ADMIN_PASSWORD
+	user := strings.TrimSpace(req.Username)
+	if user == "" {
+		user = defaultAdminUser
+	}
+	adminUsers.RLock()
+	password, ok := adminUsers.m[user]
+	adminUsers.RUnlock()
+	if !ok {
+		// Compare anyway, so timing doesn't tell which operators exist
+		password = unknownAdminPassword
+	}
+	if !passwordMatches(req.Password, password) || !ok {
+		log.Printf("Admin login failed for %q", user)
+		writeJSONError(w, http.StatusUnauthorized, "invalid_password", "Invalid username or password")
 		return
 	}
 
 	token, err := generateToken()
 	if err != nil {
 		log.Printf("Error generating admin token: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Internal server error")
 		return
 	}
 
+	t := adminToken{user: user, issued: time.Now()}
+	key := adminTokenKey(token)
 	adminTokens.Lock()
-	adminTokens.m[token] = true
+	adminTokens.m[key] = t
 	adminTokens.Unlock()
+	if adminTokenSecretName != "" {
+		if err := persistAdminToken(r.Context(), adminAuthClient, key, t); err != nil {
+			log.Printf("Warning: admin token not persisted to secret %s/%s, it is lost on restart: %v", adminTokenSecretNamespace, adminTokenSecretName, err)
+		}
+	}
 
-	log.Printf("Admin login successful, token issued")
+	log.Printf("Admin login successful for %s, token issued", user)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"token": token})
 }
 
 type adminClaimInfo struct {
 	Name          string `json:"name"`
+	Index         string `json:"index,omitempty"`
 	Pool          string `json:"pool"`
 	Phone         string `json:"phone"`
 	Authenticated bool   `json:"authenticated"`
 	Namespace     string `json:"namespace"`
 	Age           string `json:"age"`
+	AssignedAt    string `json:"assignedAt,omitempty"`
 	ExpiresAt     string `json:"expiresAt,omitempty"`
+	Estimated     bool   `json:"estimated,omitempty"`
+	InGrace       bool   `json:"inGrace,omitempty"`
+	Reserved      bool   `json:"reserved,omitempty"`
+	Parked        bool   `json:"parked,omitempty"`
+	Note          string `json:"note,omitempty"`
+	Track         string `json:"track,omitempty"`
 }
 
 type adminDeploymentInfo struct {
@@ -485,46 +2025,287 @@ type adminResponse struct {
 	ClusterDeployments []adminDeploymentInfo `json:"clusterDeployments"`
 }
 
-type clusterStats struct {
-	deployments int
-	claims      int
-	ready       int
-	available   int
-	claimed     int
+// statsResponse is the public availability summary served on /api/stats and
+// aggregated across upstreams in federation mode.
+type statsResponse struct {
+	Pool        string `json:"pool,omitempty"`
+	Deployments int    `json:"deployments"`
+	Claims      int    `json:"claims"`
+	Ready       int    `json:"ready"`
+	Available   int    `json:"available"`
+	Claimed     int    `json:"claimed"`
+
+	// InGrace counts claimed clusters past their expiry but inside the
+	// --expiry-grace window; they are included in Claimed
+	InGrace int `json:"inGrace"`
+
+	// Tracks counts claimed clusters per attendee track, with --tracks
+	Tracks map[string]int `json:"tracks,omitempty"`
 }
 
-func computeClusterStats(dynClient dynamic.Interface, pool string, clusterLifetime string) (clusterStats, error) {
-	ctx := context.Background()
-	var s clusterStats
-
-	configuredDuration, _ := parseDuration(clusterLifetime)
-
-	claims, err := dynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return s, fmt.Errorf("listing ClusterClaims: %w", err)
+// readyGate, when set, holds /readyz and /api/claim back until the pool has
+// at least one authenticated cluster. readyPools latches each pool once that
+// happens.
+var readyGate bool
+var readyPools sync.Map
+
+// waitForPoolReady polls until the pool has an authenticated claim, then
+// marks the server ready.
+func waitForPoolReady(pool string) {
+	for {
+		if !slices.Contains(servedPools(), pool) {
+			return
+		}
+		claims, err := claimStore.ListClaims(context.Background(), pool, metav1.ListOptions{
+			LabelSelector: "prelude-auth=done",
+		})
+		if err != nil {
+			log.Printf("Ready gate: error listing cluster claims: %v", err)
+		} else {
+			for _, claim := range claims.Items {
+				if claimMatchesPool(claim.Object, pool) && !claimDeleting(claim.Object) {
+					readyPools.Store(pool, true)
+					log.Printf("Ready gate: claim %s is authenticated, now serving claims from pool %s", claim.GetName(), pool)
+					return
+				}
+			}
+		}
+		time.Sleep(10 * time.Second)
 	}
+}
 
-	// Reset claimed info/timestamp gauges to clear stale entries
-	metricClaimedInfo.Reset()
-	metricClaimedTimestamp.Reset()
+// expiryWarning and expiryWebhook enable a one-time notification for each
+// claimed cluster entering its last expiryWarning. The webhook receives the
+// phone so it can relay an SMS; expiryNotifiedAnnotation records delivery.
+var expiryWarning time.Duration
+var expiryWebhook string
+
+const expiryNotifiedAnnotation = "prelude-expiry-notified"
 
-	var bucketCounts [7]float64 // 0:<1h, 1:1-3h, 2:3-6h, 3:6-12h, 4:12-24h, 5:1d-1w, 6:>1w
+type expiryNotification struct {
+	Phone     string `json:"phone"`
+	Claim     string `json:"claim"`
+	Cluster   string `json:"cluster"`
+	ExpiresAt string `json:"expiresAt"`
+}
 
+// notifyExpiringClaims POSTs an expiryNotification to expiryWebhook for each
+// claimed cluster of the pool within expiryWarning of its spec.lifetime, and
+// annotates the claim so it is notified only once. Claims whose expiry is only
+// estimated from --default-lifetime are skipped.
+func notifyExpiringClaims(ctx context.Context, pool string) {
+	claims, err := claimStore.ListClaims(ctx, pool, metav1.ListOptions{
+		LabelSelector: "prelude",
+	})
+	if err != nil {
+		log.Printf("Expiry notifications: error listing cluster claims: %v", err)
+		return
+	}
 	for _, claim := range claims.Items {
-		if !claimMatchesPool(claim.Object, pool) {
+		if !claimMatchesPool(claim.Object, pool) || claimDeleting(claim.Object) || claim.GetAnnotations()[expiryNotifiedAnnotation] != "" || claimReserved(claim.GetAnnotations()) {
 			continue
 		}
-		s.claims++
-		labels := claim.GetLabels()
-		if labels != nil && labels["prelude-auth"] == "done" {
-			s.ready++
-			phone := labels["prelude"]
-			if phone != "" {
-				s.claimed++
+		expiresAt, estimated := claimExpiry(claim.Object, claim.GetCreationTimestamp().Time)
+		if expiresAt.IsZero() || estimated {
+			continue
+		}
+		remaining := time.Until(expiresAt)
+		if remaining <= 0 || remaining > expiryWarning {
+			continue
+		}
+		cluster, _, _ := unstructured.NestedString(claim.Object, "spec", "namespace")
+		n := expiryNotification{
+			Phone:     claim.GetLabels()["prelude"],
+			Claim:     claim.GetName(),
+			Cluster:   cluster,
+			ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+		}
+		if err := postExpiryNotification(ctx, n); err != nil {
+			log.Printf("Expiry notifications: claim %s (phone %s): %v", n.Claim, n.Phone, err)
+			continue
+		}
+		annotations := map[string]interface{}{expiryNotifiedAnnotation: strconv.FormatInt(time.Now().Unix(), 10)}
+		if err := claimStore.PatchClaim(ctx, pool, n.Claim, nil, annotations); err != nil {
+			log.Printf("Expiry notifications: error annotating claim %s: %v", n.Claim, err)
+			continue
+		}
+		log.Printf("Expiry notifications: notified phone %s that claim %s expires at %s", n.Phone, n.Claim, n.ExpiresAt)
+	}
+}
 
-				// Set info metric
-				clusterNamespace := ""
-				if spec, ok := claim.Object["spec"].(map[string]interface{}); ok {
+// postExpiryNotification delivers n to expiryWebhook, treating any non-2xx
+// response as a failure so the next pass retries.
+func postExpiryNotification(ctx context.Context, n expiryNotification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("encoding notification: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, expiryWebhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("calling webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// handleHealthz reports liveness: GET /healthz. It only shows the process is
+// serving, so a hub outage never gets the pod restarted.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+// readyzTimeout bounds the hub check behind /readyz, below typical probe
+// timeouts.
+const readyzTimeout = 3 * time.Second
+
+// handleReadyz reports readiness: GET /readyz. Not ready while the hub API
+// server can't list the first pool's ClusterClaims, or while --ready-gate is
+// set and no pool has an authenticated cluster yet.
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readyzTimeout)
+	defer cancel()
+	pools := servedPools()
+	pool := ""
+	if len(pools) > 0 {
+		pool = pools[0]
+	}
+	if _, err := claimStore.ListClaims(ctx, pool, metav1.ListOptions{Limit: 1}); err != nil {
+		log.Printf("Readiness: cannot list cluster claims: %v", err)
+		http.Error(w, "hub API server unreachable", http.StatusServiceUnavailable)
+		return
+	}
+	ready := false
+	for _, p := range pools {
+		if _, ok := readyPools.Load(p); ok {
+			ready = true
+			break
+		}
+	}
+	if readyGate && !ready {
+		http.Error(w, "warming up: no authenticated clusters yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// latestStats holds the stats last computed by the metrics goroutine, by pool.
+var latestStats = struct {
+	sync.RWMutex
+	m map[string]statsResponse
+}{m: make(map[string]statsResponse)}
+
+// handleStats serves the latest pool availability: GET /api/stats[?pool=<name>].
+// With several pools and no pool selected it serves the totals across them.
+func handleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	selected := servedPools()
+	if r.URL.Query().Get("pool") != "" || len(selected) == 1 {
+		pool, ok := requestPool(w, r)
+		if !ok {
+			return
+		}
+		selected = []string{pool}
+	}
+
+	var s statsResponse
+	latestStats.RLock()
+	for _, p := range selected {
+		ps, ok := latestStats.m[p]
+		if !ok {
+			latestStats.RUnlock()
+			writeJSONError(w, http.StatusServiceUnavailable, "stats_unavailable", "Stats not computed yet")
+			return
+		}
+		if len(selected) == 1 {
+			s = ps
+			break
+		}
+		s.Deployments += ps.Deployments
+		s.Claims += ps.Claims
+		s.Ready += ps.Ready
+		s.Available += ps.Available
+		s.Claimed += ps.Claimed
+		s.InGrace += ps.InGrace
+		for t, n := range ps.Tracks {
+			if s.Tracks == nil {
+				s.Tracks = map[string]int{}
+			}
+			s.Tracks[t] += n
+		}
+	}
+	latestStats.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s)
+}
+
+type clusterStats struct {
+	deployments int
+	claims      int
+	ready       int
+	available   int
+	claimed     int
+	inGrace     int
+	tracks      map[string]int
+
+	// claimedDurations buckets claimed clusters by time since claim:
+	// <1h, 1-3h, 3-6h, 6-12h, 12-24h, 1d-1w, >1w
+	claimedDurations [7]float64
+}
+
+// computeClusterStats counts the pool's claims and deployments, setting the
+// per-claim info gauges along the way. The caller resets those gauges
+// beforehand and sets the totals from the returned counts.
+func computeClusterStats(dynClient dynamic.Interface, pool string, clusterLifetime string) (clusterStats, error) {
+	ctx := context.Background()
+	var s clusterStats
+
+	configuredDuration, _ := duration.Parse(clusterLifetime)
+
+	claims, err := claimStore.ListClaims(ctx, pool, metav1.ListOptions{})
+	if err != nil {
+		return s, fmt.Errorf("listing ClusterClaims: %w", err)
+	}
+
+	bucketCounts := &s.claimedDurations
+
+	for _, claim := range claims.Items {
+		if !claimMatchesPool(claim.Object, pool) {
+			continue
+		}
+		s.claims++
+		labels := claim.GetLabels()
+		if labels != nil && labels["prelude-auth"] == "done" && !claimDeleting(claim.Object) {
+			s.ready++
+			phone := labels["prelude"]
+			if phone != "" {
+				s.claimed++
+				if claimInGrace(claim.Object, claim.GetCreationTimestamp().Time) {
+					s.inGrace++
+				}
+				if track := labels["prelude-track"]; track != "" {
+					if s.tracks == nil {
+						s.tracks = map[string]int{}
+					}
+					s.tracks[track]++
+					metricClaimedByTrack.WithLabelValues(track).Inc()
+				}
+
+				// Set info metric
+				clusterNamespace := ""
+				if spec, ok := claim.Object["spec"].(map[string]interface{}); ok {
 					if ns, ok := spec["namespace"].(string); ok {
 						clusterNamespace = ns
 					}
@@ -543,7 +2324,7 @@ func computeClusterStats(dynClient dynamic.Interface, pool string, clusterLifeti
 					// Fallback: expiresAt - configuredLifetime
 					if spec, ok := claim.Object["spec"].(map[string]interface{}); ok {
 						if lt, ok := spec["lifetime"].(string); ok {
-							if d, err := parseDuration(lt); err == nil {
+							if d, err := duration.Parse(lt); err == nil {
 								expiresAt := claim.GetCreationTimestamp().Time.Add(d)
 								claimedAt = expiresAt.Add(-configuredDuration)
 							}
@@ -577,49 +2358,62 @@ func computeClusterStats(dynClient dynamic.Interface, pool string, clusterLifeti
 						bucketCounts[6]++
 					}
 				}
-			} else {
+			} else if !claimCoolingDown(claim.GetAnnotations()) && !claimParked(claim.GetAnnotations()) {
 				s.available++
 			}
 		}
 	}
 
-	metricClaimedDuration1h.Set(bucketCounts[0])
-	metricClaimedDuration3h.Set(bucketCounts[1])
-	metricClaimedDuration6h.Set(bucketCounts[2])
-	metricClaimedDuration12h.Set(bucketCounts[3])
-	metricClaimedDuration24h.Set(bucketCounts[4])
-	metricClaimedDuration1w.Set(bucketCounts[5])
-	metricClaimedDurationGt1w.Set(bucketCounts[6])
-
-	deployments, err := dynClient.Resource(clusterDeploymentGVR).Namespace("").List(ctx, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("hive.openshift.io/clusterpool-name=%s", pool),
-	})
+	deployments, err := listClusterDeployments(ctx, dynClient, pool)
 	if err != nil {
 		return s, fmt.Errorf("listing ClusterDeployments: %w", err)
 	}
-	s.deployments = len(deployments.Items)
+	s.deployments = len(deployments)
 
 	return s, nil
 }
 
+// knownPlatforms are the Hive spec.platform keys the admin table recognizes,
+// in priority order. A spec can carry more than one map-valued key (e.g. aws
+// alongside agentBareMetal), so the first match in this order wins rather
+// than whichever key map iteration happens to return first.
+var knownPlatforms = []string{
+	"aws", "azure", "gcp", "ibmcloud", "powervs", "alibabacloud", "nutanix",
+	"openstack", "vsphere", "ovirt", "baremetal", "agentBareMetal", "none",
+}
+
+// deploymentPlatform picks the platform name and region from a
+// ClusterDeployment's spec.platform, ignoring keys not in knownPlatforms.
+func deploymentPlatform(platforms map[string]interface{}) (platform, region string) {
+	for _, name := range knownPlatforms {
+		pm, ok := platforms[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		region, _ = pm["region"].(string)
+		return name, region
+	}
+	return "", ""
+}
+
 func handleAdmin(w http.ResponseWriter, r *http.Request, dynClient dynamic.Interface, pool string) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
-	if !validateAdminToken(r) {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	if !validateAdminToken(r, pool) {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
 		return
 	}
 
 	ctx := context.Background()
 
 	// List ClusterClaims
-	claims, err := dynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).List(ctx, metav1.ListOptions{})
+	claims, err := claimStore.ListClaims(ctx, pool, metav1.ListOptions{})
 	if err != nil {
 		log.Printf("Admin: error listing ClusterClaims: %v", err)
-		http.Error(w, "Failed to list cluster claims", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to list cluster claims")
 		return
 	}
 
@@ -628,65 +2422,25 @@ func handleAdmin(w http.ResponseWriter, r *http.Request, dynClient dynamic.Inter
 		if !claimMatchesPool(claim.Object, pool) {
 			continue
 		}
-		labels := claim.GetLabels()
-		phone := ""
-		authenticated := false
-		if labels != nil {
-			phone = labels["prelude"]
-			authenticated = labels["prelude-auth"] == "done"
-		}
-		ns := ""
-		expiresAt := ""
-		if spec, ok := claim.Object["spec"].(map[string]interface{}); ok {
-			if v, ok := spec["namespace"].(string); ok {
-				ns = v
-			}
-			if phone != "" {
-				if lt, ok := spec["lifetime"].(string); ok {
-					if d, err := parseDuration(lt); err == nil {
-						expiresAt = claim.GetCreationTimestamp().Time.Add(d).UTC().Format(time.RFC3339)
-					}
-				}
-			}
-		}
-		age := formatAge(time.Since(claim.GetCreationTimestamp().Time))
-		claimInfos = append(claimInfos, adminClaimInfo{
-			Name:          claim.GetName(),
-			Pool:          pool,
-			Phone:         phone,
-			Authenticated: authenticated,
-			Namespace:     ns,
-			Age:           age,
-			ExpiresAt:     expiresAt,
-		})
+		claimInfos = append(claimInfos, newAdminClaimInfo(&claim, pool))
 	}
 
 	// List ClusterDeployments across all namespaces filtered by pool label
-	deployments, err := dynClient.Resource(clusterDeploymentGVR).Namespace("").List(ctx, metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("hive.openshift.io/clusterpool-name=%s", pool),
-	})
+	deployments, err := listClusterDeployments(ctx, dynClient, pool)
 	if err != nil {
 		log.Printf("Admin: error listing ClusterDeployments: %v", err)
-		http.Error(w, "Failed to list cluster deployments", http.StatusInternalServerError)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to list cluster deployments")
 		return
 	}
 
 	var deployInfos []adminDeploymentInfo
-	for _, cd := range deployments.Items {
+	for _, cd := range deployments {
 		platform := ""
 		region := ""
 		version := ""
 		if spec, ok := cd.Object["spec"].(map[string]interface{}); ok {
 			if p, ok := spec["platform"].(map[string]interface{}); ok {
-				for k, v := range p {
-					if pm, ok := v.(map[string]interface{}); ok {
-						platform = k
-						if r, ok := pm["region"].(string); ok {
-							region = r
-						}
-						break
-					}
-				}
+				platform, region = deploymentPlatform(p)
 			}
 		}
 
@@ -717,7 +2471,7 @@ func handleAdmin(w http.ResponseWriter, r *http.Request, dynClient dynamic.Inter
 			}
 		}
 
-		age := formatAge(time.Since(cd.GetCreationTimestamp().Time))
+		age := duration.FormatAge(time.Since(cd.GetCreationTimestamp().Time))
 		deployInfos = append(deployInfos, adminDeploymentInfo{
 			Name:            cd.GetName(),
 			Namespace:       cd.GetNamespace(),
@@ -745,347 +2499,2990 @@ func handleAdmin(w http.ResponseWriter, r *http.Request, dynClient dynamic.Inter
 	json.NewEncoder(w).Encode(resp)
 }
 
-// formatAge formats a duration as a human-readable age string (e.g. "67m", "2h30m", "1d3h").
-func formatAge(d time.Duration) string {
-	if d < time.Minute {
-		return fmt.Sprintf("%ds", int(d.Seconds()))
+// newAdminClaimInfo summarizes a pool ClusterClaim for the admin API.
+func newAdminClaimInfo(claim *unstructured.Unstructured, pool string) adminClaimInfo {
+	labels := claim.GetLabels()
+	phone := ""
+	authenticated := false
+	if labels != nil {
+		phone = labels["prelude"]
+		authenticated = labels["prelude-auth"] == "done"
 	}
-	days := int(d.Hours()) / 24
-	hours := int(d.Hours()) % 24
-	minutes := int(d.Minutes()) % 60
-	if days > 0 {
-		if hours > 0 {
-			return fmt.Sprintf("%dd%dh", days, hours)
+	ns := ""
+	if spec, ok := claim.Object["spec"].(map[string]interface{}); ok {
+		if v, ok := spec["namespace"].(string); ok {
+			ns = v
 		}
-		return fmt.Sprintf("%dd", days)
 	}
-	if hours > 0 {
-		if minutes > 0 {
-			return fmt.Sprintf("%dh%dm", hours, minutes)
+	assignedAt := ""
+	if ts, err := strconv.ParseInt(claim.GetAnnotations()["prelude-claimed-at"], 10, 64); err == nil {
+		assignedAt = time.Unix(ts, 0).UTC().Format(time.RFC3339)
+	}
+	expiresAt := ""
+	estimated := false
+	if phone != "" {
+		var t time.Time
+		if t, estimated = claimExpiry(claim.Object, claim.GetCreationTimestamp().Time); !t.IsZero() {
+			expiresAt = t.UTC().Format(time.RFC3339)
 		}
-		return fmt.Sprintf("%dh", hours)
 	}
-	return fmt.Sprintf("%dm", minutes)
+	return adminClaimInfo{
+		Name:          claim.GetName(),
+		Index:         claimIndex(claim.GetName(), labels),
+		Pool:          pool,
+		Phone:         phone,
+		Authenticated: authenticated,
+		Namespace:     ns,
+		Age:           duration.FormatAge(time.Since(claim.GetCreationTimestamp().Time)),
+		AssignedAt:    assignedAt,
+		ExpiresAt:     expiresAt,
+		Estimated:     estimated,
+		InGrace:       phone != "" && claimInGrace(claim.Object, claim.GetCreationTimestamp().Time),
+		Reserved:      phone != "" && claimReserved(claim.GetAnnotations()),
+		Parked:        claimParked(claim.GetAnnotations()),
+		Note:          claim.GetAnnotations()[noteAnnotation],
+		Track:         labels["prelude-track"],
+	}
 }
 
-func handleClaim(w http.ResponseWriter, r *http.Request, dynClient dynamic.Interface, clientset kubernetes.Interface, clusterPool string, clusterLifetime string) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// handleAdminExport streams the pool's active assignments as a CSV download:
+// GET /api/admin/export?format=csv
+func handleAdminExport(w http.ResponseWriter, r *http.Request, pool string) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
-	var req claimRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	operator, ok := adminOperator(r, pool, "")
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
 		return
 	}
 
-	// Verify reCAPTCHA token if secret key is configured
-	if recaptchaSecretKey != "" {
-		if req.RecaptchaToken == "" {
-			http.Error(w, "reCAPTCHA token is required", http.StatusForbidden)
-			return
+	if format := r.URL.Query().Get("format"); format != "" && format != "csv" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_format", "Invalid format, must be csv")
+		return
+	}
+
+	ctx := r.Context()
+	opts := metav1.ListOptions{Limit: 100}
+
+	// Fetch the first page before committing to a 200, so a list failure
+	// can still be reported as an error
+	claims, err := claimStore.ListClaims(ctx, pool, opts)
+	if err != nil {
+		log.Printf("Admin: error listing ClusterClaims for export: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to list cluster claims")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"prelude-%s-%s.csv\"", pool, time.Now().UTC().Format("20060102-150405")))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"phone", "cluster", "assigned_at", "expires_at", "authenticated", "track"})
+
+	rows := 0
+	for {
+		for i := range claims.Items {
+			if !claimMatchesPool(claims.Items[i].Object, pool) {
+				continue
+			}
+			info := newAdminClaimInfo(&claims.Items[i], pool)
+			if info.Phone == "" {
+				continue
+			}
+			cw.Write([]string{info.Phone, info.Namespace, info.AssignedAt, info.ExpiresAt, strconv.FormatBool(info.Authenticated), info.Track})
+			rows++
+		}
+		// Push each page out rather than buffering the whole pool
+		cw.Flush()
+		if claims.GetContinue() == "" {
+			break
 		}
-		if err := verifyRecaptcha(req.RecaptchaToken); err != nil {
-			log.Printf("reCAPTCHA verification failed: %v", err)
-			http.Error(w, "reCAPTCHA verification failed", http.StatusForbidden)
+		opts.Continue = claims.GetContinue()
+		claims, err = claimStore.ListClaims(ctx, pool, opts)
+		if err != nil {
+			// Headers are already sent, so all we can do is cut the download short
+			log.Printf("Admin: error listing ClusterClaims for export after %d rows: %v", rows, err)
 			return
 		}
 	}
+	if err := cw.Error(); err != nil {
+		log.Printf("Admin: error writing export: %v", err)
+		return
+	}
+	log.Printf("Admin: exported %d assignments by %s", rows, operator)
+}
 
-	phone := sanitizePhone(strings.TrimSpace(req.Phone))
-	if phone == "" {
-		http.Error(w, "Phone number is required", http.StatusBadRequest)
+// handleAdminClaimByCluster returns the pool claim bound to a cluster
+// namespace: GET /api/admin/claim-by-cluster?namespace=<cluster>
+func handleAdminClaimByCluster(w http.ResponseWriter, r *http.Request, pool string) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
 		return
 	}
 
-	password := strings.TrimSpace(req.Password)
-	if password == "" {
-		http.Error(w, "Admin password is required", http.StatusBadRequest)
+	if !validateAdminToken(r, pool) {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
 		return
 	}
 
-	fingerprint := sanitizeFingerprint(req.Fingerprint)
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		writeJSONError(w, http.StatusBadRequest, "namespace_required", "Cluster namespace is required")
+		return
+	}
 
 	ctx := context.Background()
 
-	// List all ClusterClaims in cluster-pools namespace
-	claims, err := dynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).List(ctx, metav1.ListOptions{})
+	claims, err := claimStore.ListClaims(ctx, pool, metav1.ListOptions{})
 	if err != nil {
-		log.Printf("Error listing cluster claims: %v", err)
-		http.Error(w, "Failed to list cluster claims", http.StatusInternalServerError)
+		log.Printf("Admin: error listing ClusterClaims: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to list cluster claims")
 		return
 	}
 
-	var claimName string
-	var clusterName string
-	var expiresAt time.Time
-	found := false
-
-	// Check if any ClusterClaim already has this phone number
-	// Only consider claims that have been authenticated (prelude-auth=done)
 	for _, claim := range claims.Items {
-		if !claimMatchesPool(claim.Object, clusterPool) {
+		if !claimMatchesPool(claim.Object, pool) {
 			continue
 		}
-		labels := claim.GetLabels()
-		if labels == nil || labels["prelude-auth"] != "done" {
+		info := newAdminClaimInfo(&claim, pool)
+		if info.Namespace != namespace {
 			continue
 		}
-		if labels["prelude"] == phone {
-			claimName = claim.GetName()
-			spec, ok := claim.Object["spec"].(map[string]interface{})
-			if ok {
-				ns, ok := spec["namespace"].(string)
-				if ok {
-					clusterName = ns
-				}
-			}
-			// Compute expiry from existing spec.lifetime
-			if spec != nil {
-				if lt, ok := spec["lifetime"].(string); ok {
-					if d, err := parseDuration(lt); err == nil {
-						expiresAt = claim.GetCreationTimestamp().Time.Add(d)
-					}
-				}
-			}
-			// Backfill fingerprint label if not already set
-			if fingerprint != "" && labels["prelude-fp"] != fingerprint {
-				labels["prelude-fp"] = fingerprint
-				claim.SetLabels(labels)
-				if _, err := dynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).Update(ctx, &claim, metav1.UpdateOptions{}); err != nil {
-					log.Printf("Warning: failed to backfill fingerprint on claim %s: %v", claimName, err)
-				} else {
-					log.Printf("Backfilled fingerprint %s on claim %s", fingerprint, claimName)
-				}
-			}
-			found = true
-			break
-		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+		return
 	}
 
-	// If phone not found, check if this fingerprint already claimed a different cluster
-	if !found && fingerprint != "" {
-		for _, claim := range claims.Items {
-			if !claimMatchesPool(claim.Object, clusterPool) {
-				continue
-			}
-			labels := claim.GetLabels()
-			if labels == nil || labels["prelude-auth"] != "done" {
-				continue
-			}
-			if labels["prelude-fp"] == fingerprint && labels["prelude"] != "" && labels["prelude"] != phone {
-				log.Printf("Fingerprint %s already claimed by phone %s, rejecting phone %s", fingerprint, labels["prelude"], phone)
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusConflict)
-				json.NewEncoder(w).Encode(map[string]string{
-					"error": "device_already_claimed",
-				})
-				return
-			}
-		}
-	}
+	writeJSONError(w, http.StatusNotFound, "not_found", "No cluster claim references that namespace")
+}
 
-	// If not found, pick a random authenticated but unclaimed ClusterClaim and label it
-	if !found {
-		// Collect all available (authenticated, unclaimed) claim indices
-		var availableIndices []int
-		for i, claim := range claims.Items {
-			if !claimMatchesPool(claim.Object, clusterPool) {
-				continue
-			}
-			labels := claim.GetLabels()
-			if labels == nil || labels["prelude-auth"] != "done" {
-				continue
-			}
-			if labels["prelude"] == "" {
-				availableIndices = append(availableIndices, i)
-			}
-		}
+// handleAdminKubeconfig returns the user (default) or system:admin kubeconfig of
+// a pool claim's cluster: GET /api/admin/kubeconfig?name=<claim>&type=admin|user
+func handleAdminKubeconfig(w http.ResponseWriter, r *http.Request, dynClient dynamic.Interface, clientset kubernetes.Interface, pool string) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
 
-		if len(availableIndices) > 0 {
-			// Pick a random available claim
-			idx := availableIndices[mathrand.IntN(len(availableIndices))]
-			claim := claims.Items[idx]
-			labels := claim.GetLabels()
+	operator, ok := adminOperator(r, pool, "")
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
 
-			claimName = claim.GetName()
-			spec, ok := claim.Object["spec"].(map[string]interface{})
-			if ok {
-				ns, ok := spec["namespace"].(string)
-				if ok {
-					clusterName = ns
-				}
-			}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name_required", "Claim name is required")
+		return
+	}
+	kubeconfigType := r.URL.Query().Get("type")
+	if kubeconfigType == "" {
+		kubeconfigType = "user"
+	}
+	if kubeconfigType != "user" && kubeconfigType != "admin" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_type", "Invalid type, must be admin or user")
+		return
+	}
+	// Without admin auth every caller is anonymous, so system:admin
+	// credentials are never handed out
+	if kubeconfigType == "admin" && adminAuthMode != "oauth" && !adminPasswordAuth() {
+		log.Printf("Admin: refused admin kubeconfig for claim %s, admin authentication is not configured", name)
+		writeJSONError(w, http.StatusForbidden, "admin_auth_required", "The admin kubeconfig requires admin authentication to be configured")
+		return
+	}
 
-			// Label the claim with the phone number and fingerprint
-			labels["prelude"] = phone
-			if fingerprint != "" {
-				labels["prelude-fp"] = fingerprint
-			}
-			claim.SetLabels(labels)
+	ctx := context.Background()
 
-			// Set claimed-at annotation
-			annotations := claim.GetAnnotations()
-			if annotations == nil {
-				annotations = make(map[string]string)
-			}
-			annotations["prelude-claimed-at"] = strconv.FormatInt(time.Now().Unix(), 10)
-			claim.SetAnnotations(annotations)
+	claim, err := claimStore.GetClaim(ctx, pool, name)
+	if k8serrors.IsNotFound(err) || (err == nil && !claimMatchesPool(claim.Object, pool)) {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Cluster claim not found")
+		return
+	} else if err != nil {
+		log.Printf("Admin: error getting ClusterClaim %s: %v", name, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get cluster claim")
+		return
+	}
 
-			// Set spec.lifetime = age + configured lifetime
-			configuredDuration, err := parseDuration(clusterLifetime)
-			if err != nil {
-				log.Printf("Error parsing cluster lifetime %q: %v", clusterLifetime, err)
-				http.Error(w, "Invalid cluster lifetime configuration", http.StatusInternalServerError)
-				return
-			}
-			age := time.Since(claim.GetCreationTimestamp().Time)
-			totalLifetime := age + configuredDuration
-			spec["lifetime"] = formatDuration(totalLifetime)
-			expiresAt = claim.GetCreationTimestamp().Time.Add(totalLifetime)
-			log.Printf("Cluster claim %s age=%s, configured=%s, setting lifetime=%s (picked randomly from %d available)", claimName, formatDuration(age), clusterLifetime, formatDuration(totalLifetime), len(availableIndices))
+	clusterName := ""
+	if spec, ok := claim.Object["spec"].(map[string]interface{}); ok {
+		clusterName, _ = spec["namespace"].(string)
+	}
+	if clusterName == "" {
+		writeJSONError(w, http.StatusNotFound, "not_bound", "Cluster claim is not bound to a cluster")
+		return
+	}
 
-			_, err = dynClient.Resource(clusterClaimGVR).Namespace(clusterPoolNamespace).Update(ctx, &claim, metav1.UpdateOptions{})
-			if err != nil {
-				log.Printf("Error labeling cluster claim %s: %v", claimName, err)
-				http.Error(w, "Failed to assign cluster", http.StatusInternalServerError)
-				return
-			}
-			found = true
+	// system:admin credentials need the same RBAC as reading them directly
+	if kubeconfigType == "admin" && adminAuthMode == "oauth" {
+		if _, ok := adminOAuthReview(r, authorizationv1.ResourceAttributes{Namespace: clusterName, Verb: "get", Resource: "secrets"}); !ok {
+			log.Printf("Admin: refused admin kubeconfig for claim %s to %s, cannot get secrets in %s", name, operator, clusterName)
+			writeJSONError(w, http.StatusForbidden, "forbidden", "Insufficient permissions for the admin kubeconfig")
+			return
 		}
 	}
 
-	if !found || clusterName == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "all_clusters_in_use",
-		})
-		return
-	}
+	cd, err := getClusterDeployment(ctx, dynClient, clusterName)
+	if k8serrors.IsNotFound(err) {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Cluster deployment not found")
+		return
+	} else if err != nil {
+		log.Printf("Admin: error getting cluster deployment %s: %v", clusterName, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get cluster deployment")
+		return
+	}
+
+	secretName := getAdminKubeconfigSecretName(cd.Object)
+	if secretName == "" {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Kubeconfig secret not found")
+		return
+	}
+	if kubeconfigType == "user" {
+		secretName = deriveUserSecretName(secretName)
+	}
+
+	secret, err := clientset.CoreV1().Secrets(clusterName).Get(ctx, secretName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Kubeconfig secret not found")
+		return
+	} else if err != nil {
+		log.Printf("Admin: error getting kubeconfig secret %s/%s: %v", clusterName, secretName, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get kubeconfig")
+		return
+	}
+
+	kubeconfig, err := extractKubeconfig(secret)
+	if err != nil {
+		log.Printf("Admin: error reading %s kubeconfig for claim %s: %v", kubeconfigType, name, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get kubeconfig")
+		return
+	}
+
+	log.Printf("Admin: returned %s kubeconfig for claim %s (cluster %s) by %s", kubeconfigType, name, clusterName, operator)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"name":       name,
+		"type":       kubeconfigType,
+		"kubeconfig": kubeconfig,
+	})
+}
+
+type adminNoteRequest struct {
+	Name string `json:"name"`
+	Note string `json:"note"`
+}
+
+// handleAdminNote sets (or, with an empty note, clears) the operational note
+// on a pool claim: POST /api/admin/note {name, note}
+func handleAdminNote(w http.ResponseWriter, r *http.Request, pool string) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	operator, ok := adminOperator(r, pool, "update")
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	var req adminNoteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name_required", "Claim name is required")
+		return
+	}
+	note := sanitizeNote(req.Note)
+	if len([]rune(note)) > noteMaxLength {
+		writeJSONError(w, http.StatusBadRequest, "note_too_long", fmt.Sprintf("Note must be at most %d characters", noteMaxLength))
+		return
+	}
+
+	ctx := context.Background()
+
+	claim, err := claimStore.GetClaim(ctx, pool, req.Name)
+	if k8serrors.IsNotFound(err) || (err == nil && !claimMatchesPool(claim.Object, pool)) {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Cluster claim not found")
+		return
+	} else if err != nil {
+		log.Printf("Admin: error getting ClusterClaim %s: %v", req.Name, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get cluster claim")
+		return
+	}
+
+	var value interface{} // nil removes the annotation
+	if note != "" {
+		value = note
+	}
+	if err := claimStore.PatchClaim(ctx, pool, req.Name, nil, map[string]interface{}{noteAnnotation: value}); err != nil {
+		log.Printf("Admin: error updating note on ClusterClaim %s: %v", req.Name, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to update cluster claim")
+		return
+	}
+
+	log.Printf("Admin: set note on claim %s to %q by %s", req.Name, note, operator)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"name": req.Name,
+		"note": note,
+	})
+}
+
+type adminParkRequest struct {
+	Name   string `json:"name"`
+	Parked bool   `json:"parked"`
+}
+
+// claimParked reports whether an admin has parked a claim with the
+// prelude.io/reserved=true annotation.
+func claimParked(annotations map[string]string) bool {
+	return annotations[parkedAnnotation] == "true"
+}
+
+// handleAdminPark parks a pool claim, keeping it out of assignment, or
+// returns it to the pool: POST /api/admin/park {name, parked}
+func handleAdminPark(w http.ResponseWriter, r *http.Request, pool string) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	operator, ok := adminOperator(r, pool, "update")
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	var req adminParkRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name_required", "Claim name is required")
+		return
+	}
+
+	ctx := context.Background()
+
+	claim, err := claimStore.GetClaim(ctx, pool, req.Name)
+	if k8serrors.IsNotFound(err) || (err == nil && !claimMatchesPool(claim.Object, pool)) {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Cluster claim not found")
+		return
+	} else if err != nil {
+		log.Printf("Admin: error getting ClusterClaim %s: %v", req.Name, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get cluster claim")
+		return
+	}
+
+	var value interface{} // nil removes the annotation
+	if req.Parked {
+		value = "true"
+	}
+	if err := claimStore.PatchClaim(ctx, pool, req.Name, nil, map[string]interface{}{parkedAnnotation: value}); err != nil {
+		log.Printf("Admin: error updating hold on ClusterClaim %s: %v", req.Name, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to update cluster claim")
+		return
+	}
+
+	if req.Parked {
+		log.Printf("Admin: parked claim %s (phone %q keeps it until released) by %s", req.Name, claim.GetLabels()["prelude"], operator)
+	} else {
+		log.Printf("Admin: returned claim %s to the pool by %s", req.Name, operator)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"name":   req.Name,
+		"parked": req.Parked,
+	})
+}
+
+type adminReleaseRequest struct {
+	Name string `json:"name"`
+}
+
+// handleAdminRelease frees a pool claim from its phone, e.g. when an attendee
+// finishes early or a cluster is stuck: POST /api/admin/release {name}
+func handleAdminRelease(w http.ResponseWriter, r *http.Request, pool string) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	operator, ok := adminOperator(r, pool, "update")
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	var req adminReleaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name_required", "Claim name is required")
+		return
+	}
+
+	ctx := context.Background()
+
+	claim, err := claimStore.GetClaim(ctx, pool, req.Name)
+	if k8serrors.IsNotFound(err) || (err == nil && !claimMatchesPool(claim.Object, pool)) {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Cluster claim not found")
+		return
+	} else if err != nil {
+		log.Printf("Admin: error getting ClusterClaim %s: %v", req.Name, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get cluster claim")
+		return
+	}
+
+	if err := claimStore.ReleaseClaim(ctx, pool, req.Name); err != nil {
+		log.Printf("Admin: error releasing ClusterClaim %s: %v", req.Name, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to release cluster claim")
+		return
+	}
+
+	log.Printf("Admin: released claim %s from phone %q by %s, the authenticator re-verifies it before reuse", req.Name, claim.GetLabels()["prelude"], operator)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"name": req.Name,
+	})
+}
+
+// rebindOfAnnotation marks a claim created by an admin rebind with the name of
+// the claim it replaced. Such claims wait for Hive to bind a fresh cluster for
+// as long as that takes instead of being released as unbound.
+const rebindOfAnnotation = "prelude-rebind-of"
+
+// rebindCarriedAnnotations are copied from a claim to its replacement, so the
+// phone keeps its history, note, hold and remaining lifetime.
+var rebindCarriedAnnotations = []string{"prelude-claimed-at", expiryGraceAnnotation, noteAnnotation, parkedAnnotation}
+
+// handleAdminRebind swaps the cluster behind a phone's claim, keeping the phone
+// assigned: POST /api/admin/rebind {name}. A replacement claim carrying the
+// same phone, fingerprint, track and index is created before the old claim is
+// deleted, so the phone is never unassigned. Until Hive binds the replacement
+// and the authenticator has set it up, the phone's claims answer
+// cluster_authenticating; the next successful claim sets its password.
+func handleAdminRebind(w http.ResponseWriter, r *http.Request, pool string) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	// A rebind creates the replacement claim and deletes the old one
+	operator, ok := adminOperator(r, pool, "delete")
+	if ok {
+		_, ok = adminOperator(r, pool, "create")
+	}
+	if !ok {
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized", "Unauthorized")
+		return
+	}
+
+	var req adminReleaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	if req.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name_required", "Claim name is required")
+		return
+	}
+
+	ctx := context.Background()
+
+	claim, err := claimStore.GetClaim(ctx, pool, req.Name)
+	if k8serrors.IsNotFound(err) || (err == nil && !claimMatchesPool(claim.Object, pool)) {
+		writeJSONError(w, http.StatusNotFound, "not_found", "Cluster claim not found")
+		return
+	} else if err != nil {
+		log.Printf("Admin: error getting ClusterClaim %s: %v", req.Name, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get cluster claim")
+		return
+	}
+	labels := claim.GetLabels()
+	phone := labels["prelude"]
+	if phone == "" {
+		writeJSONError(w, http.StatusConflict, "not_assigned", "Cluster claim is not assigned to a phone, nothing to rebind")
+		return
+	}
+	if claimDeleting(claim.Object) {
+		writeJSONError(w, http.StatusConflict, "claim_deleting", "Cluster claim is already being deleted")
+		return
+	}
+
+	// The replacement expires when the old claim would have, grace included
+	var lifetime string
+	if expiresAt, estimated := claimExpiry(claim.Object, claim.GetCreationTimestamp().Time); !estimated && !expiresAt.IsZero() {
+		remaining := time.Until(expiresAt) + claimGrace(claim.Object)
+		if remaining < time.Minute {
+			writeJSONError(w, http.StatusConflict, "claim_expired", "Cluster claim has expired")
+			return
+		}
+		lifetime = duration.Format(remaining)
+	}
+
+	// Repeated rebinds are named after the original claim, not each other
+	base := claim.GetAnnotations()[rebindOfAnnotation]
+	if base == "" {
+		base = claim.GetName()
+	}
+	newLabels := map[string]interface{}{"prelude": phone}
+	for _, key := range []string{"prelude-fp", "prelude-track", "prelude-index"} {
+		if v := labels[key]; v != "" {
+			newLabels[key] = v
+		}
+	}
+	newAnnotations := map[string]interface{}{rebindOfAnnotation: base}
+	for _, key := range rebindCarriedAnnotations {
+		if v := claim.GetAnnotations()[key]; v != "" {
+			newAnnotations[key] = v
+		}
+	}
+	spec := map[string]interface{}{"clusterPoolName": pool}
+	if subjects, ok, _ := unstructured.NestedSlice(claim.Object, "spec", "subjects"); ok {
+		spec["subjects"] = subjects
+	}
+	if lifetime != "" {
+		spec["lifetime"] = lifetime
+	}
+	replacement := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": clusterClaimGVR.GroupVersion().String(),
+		"kind":       "ClusterClaim",
+		"metadata": map[string]interface{}{
+			"generateName": base + "-",
+			"namespace":    claimNamespace(pool),
+			"labels":       newLabels,
+			"annotations":  newAnnotations,
+		},
+		"spec": spec,
+	}}
+	created, err := claimStore.CreateClaim(ctx, pool, replacement)
+	if err != nil {
+		log.Printf("Admin: error creating replacement for ClusterClaim %s: %v", req.Name, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to create replacement cluster claim")
+		return
+	}
+
+	// Deleting the old claim has Hive deprovision its cluster. It must still
+	// be the claim read above: if it was released or reassigned meanwhile,
+	// the replacement is rolled back rather than deprovision someone else's
+	// cluster.
+	if err := claimStore.DeleteClaim(ctx, pool, req.Name, claim.GetResourceVersion()); err != nil && !k8serrors.IsNotFound(err) {
+		log.Printf("Admin: error deleting ClusterClaim %s after creating %s, rolling back: %v", req.Name, created.GetName(), err)
+		if err := claimStore.DeleteClaim(ctx, pool, created.GetName(), ""); err != nil {
+			log.Printf("Admin: error deleting replacement ClusterClaim %s, phone %s now has two claims: %v", created.GetName(), phone, err)
+		}
+		if k8serrors.IsConflict(err) {
+			writeJSONError(w, http.StatusConflict, "claim_changed", "Cluster claim changed while rebinding, reload and try again")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to delete cluster claim")
+		return
+	}
+
+	oldCluster, _, _ := unstructured.NestedString(claim.Object, "spec", "namespace")
+	log.Printf("Admin: rebinding phone %s from claim %s (cluster %s) to new claim %s by %s", phone, req.Name, oldCluster, created.GetName(), operator)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"name":        req.Name,
+		"replacement": created.GetName(),
+	})
+}
+
+// sanitizeNote keeps an admin note annotation-safe: control characters are
+// replaced by spaces and surrounding whitespace is trimmed.
+func sanitizeNote(note string) string {
+	note = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return ' '
+		}
+		return r
+	}, note)
+	return strings.TrimSpace(note)
+}
+
+// weightMostRemaining favours clusters with the most lifetime left, so users
+// don't land on a cluster about to be deprovisioned.
+func weightMostRemaining(remaining time.Duration) float64 {
+	return math.Max(remaining.Minutes(), 1)
+}
+
+// weightLeastRemaining favours clusters with the least lifetime left, using up
+// old clusters first.
+func weightLeastRemaining(remaining time.Duration) float64 {
+	return 1 / math.Max(remaining.Minutes(), 1)
+}
+
+// orderCandidates shuffles the available claim indices in place. With an
+// --assign-strategy the shuffle is weighted by each candidate's remaining
+// lifetime (weighted sampling without replacement). It stays uniform when
+// any candidate's remaining lifetime is unknown.
+func orderCandidates(ctx context.Context, dynClient dynamic.Interface, pool string, claims []unstructured.Unstructured, indices []int) {
+	mathrand.Shuffle(len(indices), func(i, j int) {
+		indices[i], indices[j] = indices[j], indices[i]
+	})
+	if claimWeight == nil || len(indices) < 2 {
+		return
+	}
+
+	remaining, err := remainingLifetimes(ctx, dynClient, pool)
+	if err != nil {
+		log.Printf("Warning: falling back to uniform selection: %v", err)
+		return
+	}
+	keys := make(map[int]float64, len(indices))
+	for _, i := range indices {
+		ns, _, _ := unstructured.NestedString(claims[i].Object, "spec", "namespace")
+		r, ok := remaining[ns]
+		if !ok {
+			log.Printf("Remaining lifetime of cluster %q unknown, falling back to uniform selection", ns)
+			return
+		}
+		// Efraimidis-Spirakis: sorting by u^(1/w) samples proportionally to w
+		keys[i] = math.Pow(mathrand.Float64(), 1/claimWeight(r))
+	}
+	sort.SliceStable(indices, func(a, b int) bool {
+		return keys[indices[a]] > keys[indices[b]]
+	})
+}
+
+// remainingLifetimes maps each of the pool's ClusterDeployment namespaces to
+// its remaining lifetime, from the hive.openshift.io/delete-after annotation
+// or else --cluster-ttl. Deployments with neither are left out.
+func remainingLifetimes(ctx context.Context, dynClient dynamic.Interface, pool string) (map[string]time.Duration, error) {
+	list, err := listClusterDeployments(ctx, dynClient, pool)
+	if err != nil {
+		return nil, fmt.Errorf("listing cluster deployments: %w", err)
+	}
+	remaining := make(map[string]time.Duration, len(list))
+	for _, cd := range list {
+		ttl := clusterTTL
+		if v := cd.GetAnnotations()["hive.openshift.io/delete-after"]; v != "" {
+			if d, err := time.ParseDuration(v); err == nil {
+				ttl = d
+			}
+		}
+		if ttl <= 0 {
+			continue
+		}
+		remaining[cd.GetNamespace()] = time.Until(cd.GetCreationTimestamp().Add(ttl))
+	}
+	return remaining, nil
+}
+
+// traceClaim logs one step of a claim request's decision when --verbose is set.
+func traceClaim(phone, format string, args ...interface{}) {
+	if !verbose {
+		return
+	}
+	log.Printf("[trace phone=%s] %s", phone, fmt.Sprintf(format, args...))
+}
+
+type validateRequest struct {
+	Phone          string `json:"phone"`
+	Fingerprint    string `json:"fingerprint"`
+	RecaptchaToken string `json:"recaptchaToken"`
+}
+
+type validateResponse struct {
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// handleValidate runs the claim endpoint's request checks without touching
+// any cluster: POST /api/validate {phone}
+func handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	var req validateRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	// Same captcha gate as /api/claim, so this can't be hammered freely
+	if captchaSecretKey != "" {
+		if req.RecaptchaToken == "" {
+			writeJSONError(w, http.StatusForbidden, "captcha_required", "Captcha token is required")
+			return
+		}
+		if _, err := verifyCaptcha(req.RecaptchaToken); err != nil {
+			log.Printf("%s verification failed: %v", captcha.name(), err)
+			writeJSONError(w, http.StatusForbidden, "captcha_failed", "Captcha verification failed")
+			return
+		}
+	}
+
+	phone, reason := validatePhone(req.Phone)
+	if reason == "" {
+		if ok, first := validateLimiter.allow(phone); !ok {
+			if first {
+				log.Printf("Validate: phone %s exceeded %d attempts within %v, rate limiting", phone, validateLimiter.limit, validateLimiter.window)
+			}
+			writeJSONError(w, http.StatusTooManyRequests, "rate_limited", "Too many attempts, try again later")
+			return
+		}
+	}
+	// A phone /api/claim would turn away for now; peek so validating doesn't
+	// use up the claim's own attempts
+	if reason == "" && claimLimiter != nil && claimLimiter.limited(phone) {
+		reason = "rate_limited"
+	}
+	if reason == "" && fingerprintPhoneLimit > 0 && captchaSecretKey != "" {
+		if fingerprint := sanitizeFingerprint(req.Fingerprint); fingerprint != "" {
+			if countFingerprintPhones(fingerprint, phone) > fingerprintPhoneLimit {
+				reason = "suspicious_activity"
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(validateResponse{Valid: reason == "", Reason: reason})
+}
+
+// overflowRedirectURL, set by --overflow-redirect-url, is where the client sends
+// users when no cluster is available, e.g. a waitlist signup form.
+var overflowRedirectURL string
+
+// writeJSONError answers a failed API call with the {"error", "message"}
+// body the client expects: a stable code to branch on and a sentence to show.
+func writeJSONError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error":   code,
+		"message": message,
+	})
+}
+
+// writeAllClustersInUse answers a claim no cluster could be found for,
+// pointing at the overflow redirect when one is configured.
+func writeAllClustersInUse(w http.ResponseWriter) {
+	body := map[string]string{
+		"error":   "all_clusters_in_use",
+		"message": "All clusters are in use",
+	}
+	if overflowRedirectURL != "" {
+		body["redirect"] = overflowRedirectURL
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Each phone may be looked up 10 times a minute on /api/claim/exists.
+var existsLimiter = newRateLimiter(10, time.Minute)
+
+// Each phone may be validated 10 times a minute on /api/validate.
+var validateLimiter = newRateLimiter(10, time.Minute)
+
+// claimLimiter throttles /api/claim attempts per phone, set from
+// --claim-rate-burst and --claim-rate-interval (nil when disabled).
+var claimLimiter *rateLimiter
+
+type claimExistsResponse struct {
+	Exists        bool `json:"exists"`
+	Authenticated bool `json:"authenticated"`
+	Ready         bool `json:"ready"`
+}
+
+// handleClaimExists reports whether a phone already has a cluster, without
+// assigning one or returning anything about it: GET /api/claim/exists?phone=
+// Ready means authenticated, unexpired and with a web console URL.
+func handleClaimExists(w http.ResponseWriter, r *http.Request, dynClient dynamic.Interface, clusterPool string) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	phone, reason := validatePhone(r.URL.Query().Get("phone"))
+	if reason == "phone_required" {
+		writeJSONError(w, http.StatusBadRequest, "phone_required", "Phone number is required")
+		return
+	} else if reason != "" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_phone", "Invalid phone number")
+		return
+	}
+
+	if ok, _ := existsLimiter.allow(phone); !ok {
+		writeJSONError(w, http.StatusTooManyRequests, "rate_limited", "Too many attempts, try again later")
+		return
+	}
+
+	ctx := r.Context()
+	claims, err := claimStore.ListClaims(ctx, clusterPool, metav1.ListOptions{
+		LabelSelector: "prelude=" + phone,
+	})
+	if err != nil {
+		log.Printf("Error listing cluster claims: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to list cluster claims")
+		return
+	}
+
+	var resp claimExistsResponse
+	for _, claim := range claims.Items {
+		if !claimMatchesPool(claim.Object, clusterPool) || claimDeleting(claim.Object) {
+			continue
+		}
+		resp.Exists = true
+		if claim.GetLabels()["prelude-auth"] != "done" {
+			continue
+		}
+		resp.Authenticated = true
+		if claimExpired(claim.Object, claim.GetCreationTimestamp().Time) {
+			continue
+		}
+		if ns, _, _ := unstructured.NestedString(claim.Object, "spec", "namespace"); ns != "" {
+			if cd, err := getClusterDeployment(ctx, dynClient, ns); err == nil && clusterDeploymentConsoleURL(cd.Object) != "" {
+				resp.Ready = true
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// maxLifetime, set by --max-lifetime, enables POST /api/extend and caps how
+// long after assignment a user can keep their cluster.
+var maxLifetime time.Duration
+
+// Each phone may attempt 5 extensions per 15 minutes, so the endpoint can't be
+// used to guess a cluster's admin password.
+var extendLimiter = newRateLimiter(5, 15*time.Minute)
+
+type extendRequest struct {
+	Phone          string `json:"phone"`
+	Password       string `json:"password"`
+	Duration       string `json:"duration"`
+	RecaptchaToken string `json:"recaptchaToken"`
+}
+
+type extendResponse struct {
+	ExpiresAt   string `json:"expiresAt"`
+	GraceEndsAt string `json:"graceEndsAt,omitempty"`
+}
+
+// handleExtend lets a user extend their own claim: POST /api/extend
+// {phone, password, duration}. The password must be the cluster's admin
+// password as set in its Keycloak realm at claim time.
+func handleExtend(w http.ResponseWriter, r *http.Request, clusterPool string) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if maxLifetime == 0 {
+		writeJSONError(w, http.StatusNotFound, "extension_disabled", "Extension not enabled")
+		return
+	}
+
+	var req extendRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	if captchaSecretKey != "" {
+		if req.RecaptchaToken == "" {
+			writeJSONError(w, http.StatusForbidden, "captcha_required", "Captcha token is required")
+			return
+		}
+		if _, err := verifyCaptcha(req.RecaptchaToken); err != nil {
+			log.Printf("%s verification failed: %v", captcha.name(), err)
+			writeJSONError(w, http.StatusForbidden, "captcha_failed", "Captcha verification failed")
+			return
+		}
+	}
+
+	phone, reason := validatePhone(req.Phone)
+	if reason != "" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_phone", "Invalid phone number")
+		return
+	}
+	password := strings.TrimSpace(req.Password)
+	if password == "" {
+		writeJSONError(w, http.StatusBadRequest, "password_required", "Admin password is required")
+		return
+	}
+	extension, err := duration.Parse(req.Duration)
+	if err != nil || extension <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_duration", "Invalid duration")
+		return
+	}
+
+	if ok, first := extendLimiter.allow(phone); !ok {
+		if first {
+			log.Printf("Extend: phone %s exceeded %d attempts within %v, rate limiting", phone, extendLimiter.limit, extendLimiter.window)
+		}
+		writeJSONError(w, http.StatusTooManyRequests, "rate_limited", "Too many attempts, try again later")
+		return
+	}
+
+	ctx := r.Context()
+	claims, err := claimStore.ListClaims(ctx, clusterPool, metav1.ListOptions{
+		LabelSelector: "prelude=" + phone + ",prelude-auth=done",
+	})
+	if err != nil {
+		log.Printf("Error listing cluster claims: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to list cluster claims")
+		return
+	}
+	var claim *unstructured.Unstructured
+	for i := range claims.Items {
+		if claimMatchesPool(claims.Items[i].Object, clusterPool) && !claimDeleting(claims.Items[i].Object) {
+			claim = &claims.Items[i]
+			break
+		}
+	}
+	if claim == nil {
+		writeJSONError(w, http.StatusNotFound, "claim_not_found", "No cluster is assigned to this phone")
+		return
+	}
+
+	clusterName, _, _ := unstructured.NestedString(claim.Object, "spec", "namespace")
+	ok, err := verifyKeycloakPassword(keycloakURL, clusterName, keycloakClientSecret, password)
+	if err != nil {
+		log.Printf("Extend: error verifying password for %s: %v", clusterName, err)
+		writeJSONError(w, http.StatusBadGateway, "verification_failed", "Failed to verify password")
+		return
+	}
+	if !ok {
+		log.Printf("Extend: wrong password for claim %s (phone %s)", claim.GetName(), phone)
+		writeJSONError(w, http.StatusForbidden, "invalid_password", "Invalid admin password")
+		return
+	}
+
+	// Same optimistic locking as assignment: patch with the resourceVersion we
+	// read and, on a 409, re-read and recompute from the fresh claim.
+	var expiresAt time.Time
+	for conflicts := 0; ; conflicts++ {
+		created := claim.GetCreationTimestamp().Time
+		assignedAt := created
+		if ts, err := strconv.ParseInt(claim.GetAnnotations()["prelude-claimed-at"], 10, 64); err == nil {
+			assignedAt = time.Unix(ts, 0)
+		}
+		current, estimated := claimExpiry(claim.Object, created)
+		if current.IsZero() || estimated {
+			current = time.Now()
+		}
+		limit := assignedAt.Add(maxLifetime)
+		expiresAt = current.Add(extension)
+		if expiresAt.After(limit) {
+			expiresAt = limit
+		}
+		if !expiresAt.After(current) {
+			writeJSONError(w, http.StatusConflict, "max_lifetime_reached", "The cluster has reached its maximum lifetime")
+			return
+		}
+
+		// Clearing the notified annotation re-arms the expiry warning
+		annotations := map[string]interface{}{expiryNotifiedAnnotation: nil}
+		err := claimStore.AssignClaim(ctx, clusterPool, claim, nil, annotations, duration.Format(expiresAt.Sub(created)+claimGrace(claim.Object)))
+		if err == nil {
+			break
+		}
+		if !k8serrors.IsConflict(err) || conflicts >= assignRetries {
+			log.Printf("Error extending cluster claim %s: %v", claim.GetName(), err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to extend cluster")
+			return
+		}
+		claim, err = claimStore.GetClaim(ctx, clusterPool, claim.GetName())
+		if err != nil {
+			log.Printf("Error re-reading cluster claim: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to extend cluster")
+			return
+		}
+		if claim.GetLabels()["prelude"] != phone {
+			writeJSONError(w, http.StatusNotFound, "claim_not_found", "No cluster is assigned to this phone")
+			return
+		}
+	}
+
+	log.Printf("Extend: phone %s extended claim %s until %s", phone, claim.GetName(), expiresAt.UTC().Format(time.RFC3339))
+	w.Header().Set("Content-Type", "application/json")
+	resp := extendResponse{ExpiresAt: expiresAt.UTC().Format(time.RFC3339)}
+	if grace := claimGrace(claim.Object); grace > 0 {
+		resp.GraceEndsAt = expiresAt.Add(grace).UTC().Format(time.RFC3339)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// magicLinkEnabled, set by --magic-link, lets /api/claim be called without a
+// password: the server generates one and returns a one-time link to it.
+var magicLinkEnabled bool
+
+// magicLinkTTL is how long a magic link can be redeemed after its claim.
+const magicLinkTTL = 10 * time.Minute
+
+// magicLink is what a magic link token redeems for.
+type magicLink struct {
+	cluster       string
+	password      string
+	webConsoleURL string
+	expires       time.Time
+}
+
+// magicLinks holds unredeemed magic links by token. Like admin sessions they
+// live in memory, so they don't survive a restart or cross replicas.
+var magicLinks = struct {
+	sync.Mutex
+	m map[string]magicLink
+}{m: map[string]magicLink{}}
+
+// newMagicLink stores l under a fresh token, valid for magicLinkTTL, and
+// drops any expired links while it holds the lock.
+func newMagicLink(l magicLink) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	l.expires = now.Add(magicLinkTTL)
+	magicLinks.Lock()
+	defer magicLinks.Unlock()
+	for t, old := range magicLinks.m {
+		if now.After(old.expires) {
+			delete(magicLinks.m, t)
+		}
+	}
+	magicLinks.m[token] = l
+	return token, nil
+}
+
+// redeemMagicLink returns the link for token and forgets it, so each link
+// works once.
+func redeemMagicLink(token string) (magicLink, bool) {
+	magicLinks.Lock()
+	defer magicLinks.Unlock()
+	l, ok := magicLinks.m[token]
+	delete(magicLinks.m, token)
+	if !ok || time.Now().After(l.expires) {
+		return magicLink{}, false
+	}
+	return l, true
+}
+
+// generatePassword returns a random password for magic link mode.
+func generatePassword() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+type magicLinkRequest struct {
+	Token string `json:"token"`
+}
+
+type magicLinkResponse struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	WebConsoleURL string `json:"webConsoleURL"`
+}
+
+// handleMagicLink exchanges a magic link token for the cluster's console URL
+// and the generated admin credentials: POST /api/magic {"token": "..."}
+func handleMagicLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	if !magicLinkEnabled {
+		writeJSONError(w, http.StatusNotFound, "magic_link_disabled", "Magic links are not enabled")
+		return
+	}
+
+	var req magicLinkRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	l, ok := redeemMagicLink(req.Token)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "invalid_magic_link", "The link is invalid or has already been used")
+		return
+	}
+
+	log.Printf("Magic link redeemed for cluster %s", l.cluster)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(magicLinkResponse{
+		Username:      "admin",
+		Password:      l.password,
+		WebConsoleURL: l.webConsoleURL,
+	})
+}
+
+// rateLimiter is a token bucket per key (e.g. a phone): a key may make limit
+// requests at once, and regains the whole limit evenly over window, so its
+// sustained rate is limit per window. State per key is constant however hard
+// a client retries.
+type rateLimiter struct {
+	sync.Mutex
+	limit  int
+	window time.Duration
+	m      map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	// limited is set from a rejection until the next allowed request.
+	limited bool
+}
+
+func newRateLimiter(limit int, window time.Duration) *rateLimiter {
+	return &rateLimiter{limit: limit, window: window, m: make(map[string]*tokenBucket)}
+}
+
+// allow takes a token for key and reports whether the request may go ahead.
+// first is set on the first rejection since key was last allowed, so callers
+// can log once per episode.
+func (rl *rateLimiter) allow(key string) (ok, first bool) {
+	rl.Lock()
+	defer rl.Unlock()
+	now := time.Now()
+	b := rl.m[key]
+	if b == nil {
+		b = &tokenBucket{tokens: float64(rl.limit), updated: now}
+		rl.m[key] = b
+	}
+	refill := now.Sub(b.updated).Seconds() / rl.window.Seconds() * float64(rl.limit)
+	b.tokens = math.Min(float64(rl.limit), b.tokens+refill)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = false
+		return true, false
+	}
+	first = !b.limited
+	b.limited = true
+	return false, first
+}
+
+// limited reports whether a request for key would be rejected now, without
+// taking a token.
+func (rl *rateLimiter) limited(key string) bool {
+	rl.Lock()
+	defer rl.Unlock()
+	b := rl.m[key]
+	if b == nil {
+		return false
+	}
+	refill := time.Since(b.updated).Seconds() / rl.window.Seconds() * float64(rl.limit)
+	return b.tokens+refill < 1
+}
+
+// sweep forgets keys idle for a whole window: their buckets are full again,
+// the same as a new one.
+func (rl *rateLimiter) sweep() {
+	rl.Lock()
+	defer rl.Unlock()
+	now := time.Now()
+	for key, b := range rl.m {
+		if now.Sub(b.updated) >= rl.window {
+			delete(rl.m, key)
+		}
+	}
+}
+
+func handleClaim(w http.ResponseWriter, r *http.Request, dynClient dynamic.Interface, clientset kubernetes.Interface, clusterPool string, clusterLifetime string) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	// Cold start: nothing authenticated yet, so don't tell anyone "all in use"
+	if _, ready := readyPools.Load(clusterPool); readyGate && !ready {
+		writeJSONError(w, http.StatusServiceUnavailable, "warming_up", "Clusters are still being prepared, try again shortly")
+		return
+	}
+
+	// Kubeconfig output format: yaml (default), base64 or login
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "yaml"
+	}
+	if format != "yaml" && format != "base64" && format != "login" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_format", "Invalid format, must be yaml, base64 or login")
+		return
+	}
+
+	// Two-phase claims: reserve holds a cluster without setting credentials,
+	// confirm commits it. No phase is the single-phase claim.
+	phase := r.URL.Query().Get("phase")
+	if phase != "" && phase != "reserve" && phase != "confirm" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_phase", "Invalid phase, must be reserve or confirm")
+		return
+	}
+	if phase != "" && !twoPhaseClaim {
+		writeJSONError(w, http.StatusBadRequest, "two_phase_disabled", "Two-phase claims are not enabled")
+		return
+	}
+
+	var req claimRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+
+	// Verify the captcha token if a secret key is configured
+	captchaScore := ""
+	if captchaSecretKey != "" {
+		if req.RecaptchaToken == "" {
+			writeJSONError(w, http.StatusForbidden, "captcha_required", "Captcha token is required")
+			return
+		}
+		var err error
+		if captchaScore, err = verifyCaptcha(req.RecaptchaToken); err != nil {
+			log.Printf("%s verification failed: %v", captcha.name(), err)
+			writeJSONError(w, http.StatusForbidden, "captcha_failed", "Captcha verification failed")
+			return
+		}
+	}
+
+	phone, reason := validatePhone(req.Phone)
+	if reason == "phone_required" {
+		writeJSONError(w, http.StatusBadRequest, "phone_required", "Phone number is required")
+		return
+	} else if reason != "" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_phone", "Invalid phone number")
+		return
+	}
+
+	if claimLimiter != nil {
+		if ok, first := claimLimiter.allow(phone); !ok {
+			// Log once per episode, a retry loop would otherwise flood the log
+			if first {
+				log.Printf("Claim: phone %s exceeded %d attempts within %v, rate limiting", phone, claimLimiter.limit, claimLimiter.window)
+			}
+			writeJSONError(w, http.StatusTooManyRequests, "rate_limited", "Too many attempts, try again later")
+			return
+		}
+	}
+
+	// Without a password, --magic-link mode generates one and hands it out
+	// through a one-time link instead. A reservation sets no credentials.
+	password := strings.TrimSpace(req.Password)
+	magic := false
+	if password == "" && phase != "reserve" {
+		if !magicLinkEnabled {
+			writeJSONError(w, http.StatusBadRequest, "password_required", "Admin password is required")
+			return
+		}
+		magic = true
+	}
+
+	// Optional attendee track, only when an allowlist is configured
+	track := ""
+	if t := strings.TrimSpace(req.Track); t != "" && len(tracks) > 0 {
+		if !slices.Contains(tracks, t) {
+			writeJSONError(w, http.StatusBadRequest, "invalid_track", "Unknown track")
+			return
+		}
+		track = t
+	}
+
+	fingerprint := sanitizeFingerprint(req.Fingerprint)
+
+	// Reject fingerprints churning through many phones with fresh captcha tokens
+	if fingerprintPhoneLimit > 0 && captchaSecretKey != "" && fingerprint != "" {
+		if n := recordFingerprintPhone(fingerprint, phone); n > fingerprintPhoneLimit {
+			log.Printf("Fingerprint %s presented %d distinct phones within %v, rejecting phone %s as suspicious", fingerprint, n, fingerprintPhoneWindow, phone)
+			writeJSONError(w, http.StatusForbidden, "suspicious_activity", "Too many phone numbers from this device")
+			return
+		}
+	}
+
+	ctx := context.Background()
+
+	// List all ClusterClaims in the pool's claim namespace
+	claims, err := claimStore.ListClaims(ctx, clusterPool, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Error listing cluster claims: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to list cluster claims")
+		return
+	}
+
+	traceClaim(phone, "listed %d claims in %s (fingerprint=%q)", len(claims.Items), claimNamespace(clusterPool), fingerprint)
+
+	var claimName string
+	var clusterName string
+	var unreachableSince string
+	var cd *unstructured.Unstructured
+	var expiresAt time.Time
+	var grace time.Duration
+	var reserved *unstructured.Unstructured
+	var reservation string
+	var reservationUntil time.Time
+	var claimedAt time.Time
+	readyRecorded := false
+	found := false
+	authenticating := false
+
+	// Check if any ClusterClaim already has this phone number
+	// Only consider claims that have been authenticated (prelude-auth=done)
+	// and aren't being deleted, whose cluster is going away
+	for _, claim := range claims.Items {
+		if !claimMatchesPool(claim.Object, clusterPool) || claimDeleting(claim.Object) {
+			continue
+		}
+		labels := claim.GetLabels()
+		if labels == nil {
+			continue
+		}
+		if labels["prelude-auth"] != "done" {
+			if labels["prelude"] == phone {
+				authenticating = true
+			}
+			continue
+		}
+		if labels["prelude"] == phone {
+			claimName = claim.GetName()
+			// A returning phone keeps the track it was assigned with
+			track = labels["prelude-track"]
+			unreachableSince = claim.GetAnnotations()["prelude-unreachable-since"]
+			spec, ok := claim.Object["spec"].(map[string]interface{})
+			if ok {
+				ns, ok := spec["namespace"].(string)
+				if ok {
+					clusterName = ns
+				}
+			}
+			// Compute expiry from existing spec.lifetime
+			if t, estimated := claimExpiry(claim.Object, claim.GetCreationTimestamp().Time); !estimated {
+				expiresAt = t
+				grace = claimGrace(claim.Object)
+			}
+			if claimReserved(claim.GetAnnotations()) {
+				reserved = &claim
+			}
+			if ts, err := strconv.ParseInt(claim.GetAnnotations()["prelude-claimed-at"], 10, 64); err == nil {
+				claimedAt = time.Unix(ts, 0)
+			}
+			readyRecorded = claim.GetAnnotations()[readyAtAnnotation] != ""
+			// Backfill fingerprint label if not already set
+			if fingerprint != "" && labels["prelude-fp"] != fingerprint {
+				if err := claimStore.PatchClaim(ctx, clusterPool, claimName, map[string]interface{}{"prelude-fp": fingerprint}, nil); err != nil {
+					log.Printf("Warning: failed to backfill fingerprint on claim %s: %v", claimName, err)
+				} else {
+					log.Printf("Backfilled fingerprint %s on claim %s", fingerprint, claimName)
+				}
+			}
+			traceClaim(phone, "phone-match: returning existing claim %s (cluster %s)", claimName, clusterName)
+			found = true
+			break
+		}
+	}
+	if !found {
+		traceClaim(phone, "phone-match: no authenticated claim labeled for this phone (authenticating=%v)", authenticating)
+	}
+
+	// The phone's cluster exists but is still being set up by the authenticator
+	if !found && authenticating {
+		log.Printf("Cluster for phone %s is still authenticating", phone)
+		writeJSONError(w, http.StatusAccepted, "cluster_authenticating", "Your cluster is still being set up")
+		return
+	}
+
+	// Two-phase claims for a phone that already holds a claim: a reservation
+	// is renewed by another reserve and committed only by a confirm with its
+	// token. A claim that was already confirmed needs no reservation, and a
+	// confirm without any claim came too late.
+	if phase == "confirm" && !found {
+		writeReservationExpired(w)
+		return
+	}
+	if found && phase == "reserve" {
+		resp := reservationResponse{Confirmed: reserved == nil}
+		if reserved != nil {
+			token, until, err := reserveClaim(ctx, clusterPool, claimName)
+			if err != nil {
+				log.Printf("Error renewing reservation on claim %s: %v", claimName, err)
+				writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to reserve cluster")
+				return
+			}
+			resp.Reservation, resp.ReservedUntil = token, until.UTC().Format(time.RFC3339)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	if found && reserved != nil {
+		// A plain claim must not commit a reservation behind the user's back.
+		// Without --two-phase-claim (turned off since it was reserved) it
+		// still does, so the phone isn't stuck with a claim it can't confirm.
+		if phase != "confirm" && twoPhaseClaim {
+			writeJSONError(w, http.StatusConflict, "reservation_pending", "The cluster is reserved, confirm the reservation to start it")
+			return
+		}
+		if phase == "confirm" {
+			annotations := reserved.GetAnnotations()
+			if reservationExpired(annotations) {
+				log.Printf("Reservation on claim %s for phone %s expired before confirmation", claimName, phone)
+				if err := releaseReservation(ctx, clusterPool, reserved); err != nil {
+					log.Printf("Error releasing reservation on claim %s: %v", claimName, err)
+				}
+				writeReservationExpired(w)
+				return
+			}
+			if !reservationMatches(annotations, req.Reservation) {
+				writeJSONError(w, http.StatusForbidden, "invalid_reservation", "The reservation does not match this phone")
+				return
+			}
+		}
+		t, err := confirmReservation(ctx, clusterPool, reserved, phone, clusterLifetime, captchaScore)
+		if errors.Is(err, errReservationLost) {
+			writeReservationExpired(w)
+			return
+		} else if err != nil {
+			log.Printf("Error confirming reservation on claim %s: %v", claimName, err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to confirm cluster")
+			return
+		}
+		expiresAt, grace, claimedAt = t, expiryGrace, time.Now()
+		traceClaim(phone, "reservation: confirmed claim %s", claimName)
+	}
+
+	// If phone not found, check if this fingerprint already claimed a different cluster.
+	// Only active claims block: released claims lose prelude-fp, and claims
+	// that are past their lifetime and grace or being deleted keep it until
+	// Hive removes them, which would otherwise block the device for the whole
+	// deprovision.
+	if !found && fingerprint != "" {
+		for _, claim := range claims.Items {
+			if !claimMatchesPool(claim.Object, clusterPool) || claimDeleting(claim.Object) {
+				continue
+			}
+			labels := claim.GetLabels()
+			if labels == nil || labels["prelude-auth"] != "done" {
+				continue
+			}
+			if claimExpired(claim.Object, claim.GetCreationTimestamp().Time) {
+				continue
+			}
+			if labels["prelude-fp"] == fingerprint && labels["prelude"] != "" && labels["prelude"] != phone {
+				log.Printf("Fingerprint %s already claimed by phone %s, rejecting phone %s", fingerprint, labels["prelude"], phone)
+				writeJSONError(w, http.StatusConflict, "device_already_claimed", "This device already has a cluster")
+				return
+			}
+		}
+	}
+
+	if !found && fingerprint != "" {
+		traceClaim(phone, "fingerprint-block: fingerprint not held by another phone")
+	}
+
+	// If not found, pick a random authenticated but unclaimed ClusterClaim and label it
+	gaveUp := false // assignment abandoned after too many conflicts
+	if !found {
+		// Collect all available (authenticated, unclaimed) claim indices
+		var availableIndices []int
+		otherPool, deleting, unauthenticated, assigned, cooling, parked := 0, 0, 0, 0, 0, 0
+		for i, claim := range claims.Items {
+			if !claimMatchesPool(claim.Object, clusterPool) {
+				otherPool++
+				continue
+			}
+			if claimDeleting(claim.Object) {
+				deleting++
+				continue
+			}
+			labels := claim.GetLabels()
+			if labels == nil || labels["prelude-auth"] != "done" {
+				unauthenticated++
+				continue
+			}
+			if labels["prelude"] != "" {
+				assigned++
+			} else if claimCoolingDown(claim.GetAnnotations()) {
+				cooling++
+			} else if claimParked(claim.GetAnnotations()) {
+				parked++
+			} else {
+				availableIndices = append(availableIndices, i)
+			}
+		}
+		traceClaim(phone, "random-select: %d candidates (skipped %d other pool, %d deleting, %d not authenticated, %d assigned, %d cooling down, %d parked)", len(availableIndices), otherPool, deleting, unauthenticated, assigned, cooling, parked)
+		// Fresher than the 30s stats loop, which counts the same claims.
+		// With several pools the gauge is a total, so leave it to the loop.
+		if len(servedPools()) == 1 {
+			metricAvailable.Set(float64(len(availableIndices)))
+		}
+
+		configuredDuration, err := duration.Parse(clusterLifetime)
+		if err != nil {
+			log.Printf("Error parsing cluster lifetime %q: %v", clusterLifetime, err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "Invalid cluster lifetime configuration")
+			return
+		}
+
+		if phase == "reserve" {
+			reservation, err = generateToken()
+			if err != nil {
+				log.Printf("Error generating reservation token: %v", err)
+				writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to reserve cluster")
+				return
+			}
+			reservationUntil = time.Now().Add(reservationTTL)
+		}
+
+		// Pick a random available claim, validating its ClusterDeployment still
+		// exists and has a console URL before labeling it, so a vanished
+		// deployment never leaves a phantom assignment behind
+		orderCandidates(ctx, dynClient, clusterPool, claims.Items, availableIndices)
+		conflicts := 0
+	candidates:
+		for _, i := range availableIndices {
+			claim := &claims.Items[i]
+			ns := ""
+			if spec, ok := claim.Object["spec"].(map[string]interface{}); ok {
+				ns, _ = spec["namespace"].(string)
+			}
+			if ns == "" {
+				continue
+			}
+			d, err := getClusterDeployment(ctx, dynClient, ns)
+			if k8serrors.IsNotFound(err) {
+				log.Printf("Skipping claim %s: cluster deployment %s not found", claim.GetName(), ns)
+				continue
+			} else if err != nil {
+				log.Printf("Error getting cluster deployment %s: %v", ns, err)
+				writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get cluster deployment")
+				return
+			}
+			if clusterDeploymentConsoleURL(d.Object) == "" {
+				log.Printf("Skipping claim %s: cluster deployment %s has no web console URL", claim.GetName(), ns)
+				continue
+			}
+
+			// Label the claim with the phone number and fingerprint
+			newLabels := map[string]interface{}{"prelude": phone}
+			if track != "" {
+				newLabels["prelude-track"] = track
+			}
+			if fingerprint != "" {
+				newLabels["prelude-fp"] = fingerprint
+			}
+
+			// Merge-patch only the keys we own, with the claimed-at annotation and
+			// lifetime, which includes any expiry grace. A reservation only
+			// labels the claim and marks it reserved; its lifetime starts on
+			// confirmation. The resourceVersion precondition is optimistic
+			// locking: if another request or replica touched the claim first
+			// the API server answers 409, and we re-read it and either retry
+			// or move on.
+			for {
+				age := time.Since(claim.GetCreationTimestamp().Time)
+				totalLifetime := age + configuredDuration
+				var err error
+				if phase == "reserve" {
+					annotations := reservationAnnotations(reservation, reservationUntil)
+					annotations[releasedAtAnnotation] = nil
+					err = claimStore.AssignClaim(ctx, clusterPool, claim, newLabels, annotations, "")
+				} else {
+					err = claimStore.AssignClaim(ctx, clusterPool, claim, newLabels, assignmentAnnotations(captchaScore), duration.Format(totalLifetime+expiryGrace))
+				}
+				if err == nil && phase == "reserve" {
+					log.Printf("Cluster claim %s reserved for phone %s until %s (picked from %d available)", claim.GetName(), phone, reservationUntil.UTC().Format(time.RFC3339), len(availableIndices))
+					break
+				}
+				if err == nil {
+					expiresAt = claim.GetCreationTimestamp().Time.Add(totalLifetime)
+					grace = expiryGrace
+					claimedAt = time.Now()
+					log.Printf("Cluster claim %s age=%s, configured=%s, setting lifetime=%s (picked randomly from %d available)", claim.GetName(), duration.Format(age), clusterLifetime, duration.Format(totalLifetime), len(availableIndices))
+					break
+				}
+				if !k8serrors.IsConflict(err) {
+					log.Printf("Error labeling cluster claim %s: %v", claim.GetName(), err)
+					writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to assign cluster")
+					return
+				}
+
+				conflicts++
+				if conflicts > assignRetries {
+					log.Printf("Giving up assignment for phone %s after %d conflicts", phone, conflicts)
+					gaveUp = true
+					break candidates
+				}
+				fresh, err := claimStore.GetClaim(ctx, clusterPool, claim.GetName())
+				if k8serrors.IsNotFound(err) {
+					continue candidates
+				} else if err != nil {
+					log.Printf("Error re-reading cluster claim %s: %v", claim.GetName(), err)
+					writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to assign cluster")
+					return
+				}
+				if fresh.GetLabels()["prelude"] != "" {
+					log.Printf("Cluster claim %s was assigned concurrently, trying another (conflict %d/%d)", claim.GetName(), conflicts, assignRetries)
+					continue candidates
+				}
+				log.Printf("Cluster claim %s changed during assignment, retrying (conflict %d/%d)", claim.GetName(), conflicts, assignRetries)
+				claim = fresh
+			}
+
+			claimName = claim.GetName()
+			clusterName = ns
+			cd = d
+			traceClaim(phone, "random-select: assigned claim %s (cluster %s) after %d conflicts", claimName, clusterName, conflicts)
+			metricClaimTransitions.WithLabelValues("assigned").Inc()
+			metricClaimAssignments.WithLabelValues(clusterPool, assignmentTrack(track)).Inc()
+			found = true
+			break
+		}
+	}
+
+	if !found || clusterName == "" {
+		traceClaim(phone, "no cluster assigned (found=%v, cluster=%q), answering all_clusters_in_use", found, clusterName)
+		if gaveUp {
+			metricClaimResults.WithLabelValues("conflict").Inc()
+		} else {
+			metricClaimResults.WithLabelValues("exhausted").Inc()
+		}
+		writeAllClustersInUse(w)
+		return
+	}
+
+	// A new reservation answers here: credentials are only set on confirm
+	if phase == "reserve" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(reservationResponse{
+			Reservation:   reservation,
+			ReservedUntil: reservationUntil.UTC().Format(time.RFC3339),
+		})
+		return
+	}
+
+	// Get ClusterDeployment to find webConsoleURL (already fetched for a new assignment)
+	if cd == nil {
+		cd, err = getClusterDeployment(ctx, dynClient, clusterName)
+		if err != nil {
+			log.Printf("Error getting cluster deployment %s: %v", clusterName, err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get cluster deployment")
+			return
+		}
+	}
+
+	webConsoleURL := clusterDeploymentConsoleURL(cd.Object)
+
+	// A spoke whose circuit is open was unreachable moments ago, so don't make
+	// the client wait on it: answer straight away and let it retry. With
+	// --probe-console the open circuit counts as a failed probe below.
+	if (!probeConsole || webConsoleURL == "") && !spokeBreaker.allow(clusterName) {
+		log.Printf("Spoke circuit for cluster %s open, answering console_not_ready", clusterName)
+		writeJSONError(w, http.StatusAccepted, "console_not_ready", "Your cluster's console is not reachable yet")
+		return
+	}
+
+	// Optionally make sure the console route is actually serving before handing it out
+	if probeConsole && webConsoleURL != "" {
+		probeErr := fmt.Errorf("circuit open after repeated failures")
+		if spokeBreaker.allow(clusterName) {
+			probeErr = probeConsoleURL(webConsoleURL)
+			spokeBreaker.record(clusterName, probeErr)
+		}
+		if probeErr != nil {
+			log.Printf("Web console for cluster %s not ready yet: %v", clusterName, probeErr)
+			// Only give up on the assignment once the cluster has been unreachable
+			// for longer than the grace period, so momentary blips don't lose it
+			reason, message := "console_not_ready", "Your cluster's console is not reachable yet"
+			down, err := markClaimUnreachable(ctx, clusterPool, claimName)
+			if err != nil {
+				log.Printf("Warning: failed to record unreachable cluster on claim %s: %v", claimName, err)
+			} else if down > unreachableGrace {
+				log.Printf("Cluster %s unreachable for %s, releasing claim %s from phone %s", clusterName, duration.Format(down), claimName, phone)
+				if err := claimStore.ReleaseClaim(ctx, clusterPool, claimName); err != nil {
+					log.Printf("Error releasing unreachable claim %s: %v", claimName, err)
+				} else {
+					// The phone is free again: its next request gets a new
+					// cluster with the password it submits, so tell the client
+					// to retry rather than wait for this one
+					metricClaimTransitions.WithLabelValues("unavailable").Inc()
+					metricClaimResults.WithLabelValues("unavailable").Inc()
+					reason, message = "cluster_unavailable", "Your cluster became unavailable, claim again for a new one"
+				}
+			}
+			writeJSONError(w, http.StatusAccepted, reason, message)
+			return
+		}
+		if unreachableSince != "" {
+			if err := clearClaimUnreachable(ctx, clusterPool, claimName); err != nil {
+				log.Printf("Warning: failed to clear unreachable annotation on claim %s: %v", claimName, err)
+			}
+		}
+	}
+
+	// Get kubeconfig secret name from ClusterDeployment
+	kubeconfigSecretName := getAdminKubeconfigSecretName(cd.Object)
+
+	if kubeconfigSecretName == "" {
+		log.Printf("Could not find kubeconfig secret ref for cluster %s", clusterName)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to find kubeconfig secret")
+		return
+	}
+
+	// Get the admin kubeconfig secret
+	adminSecret, err := clientset.CoreV1().Secrets(clusterName).Get(ctx, kubeconfigSecretName, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("Error getting admin kubeconfig secret %s/%s: %v", clusterName, kubeconfigSecretName, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get admin kubeconfig")
+		return
+	}
+
+	adminKubeconfigData, err := extractKubeconfig(adminSecret)
+	if err != nil {
+		log.Printf("Error reading admin kubeconfig for cluster %s: %v", clusterName, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get admin kubeconfig")
+		return
+	}
+
+	// Derive user kubeconfig secret name from admin kubeconfig secret name
+	userKubeconfigSecretName := deriveUserSecretName(kubeconfigSecretName)
+	log.Printf("Looking up user kubeconfig secret %s/%s", clusterName, userKubeconfigSecretName)
+
+	// The user kubeconfig takes precedence; only with --admin-kubeconfig-fallback
+	// is a missing one replaced by the admin kubeconfig
+	var userKubeconfigData string
+	userSecret, err := clientset.CoreV1().Secrets(clusterName).Get(ctx, userKubeconfigSecretName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) && adminKubeconfigFallback {
+		log.Printf("Warning: user kubeconfig secret %s/%s not found, returning the admin kubeconfig to phone %s", clusterName, userKubeconfigSecretName, phone)
+		userKubeconfigData = adminKubeconfigData
+	} else if err != nil {
+		log.Printf("Error getting user kubeconfig secret %s/%s: %v", clusterName, userKubeconfigSecretName, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get user kubeconfig")
+		return
+	} else if userKubeconfigData, err = extractKubeconfig(userSecret); err != nil {
+		log.Printf("Error reading user kubeconfig for cluster %s: %v", clusterName, err)
+		writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to get user kubeconfig")
+		return
+	}
+
+	// Update MaaS credentials on the spoke cluster if configured
+	if maasURL != "" && maasToken != "" {
+		if !spokeBreaker.allow(clusterName) {
+			log.Printf("Warning: skipping MaaS credentials update on %s, spoke circuit open after repeated failures", clusterName)
+		} else {
+			err := updateMaaSCredentials(adminKubeconfigData, maasURL, maasToken, clusterName, clusterLifetime, webConsoleURL)
+			spokeBreaker.record(clusterName, err)
+			if err != nil {
+				log.Printf("Warning: failed to update MaaS credentials on %s: %v", clusterName, err)
+			}
+		}
+	}
+
+	// Update Keycloak admin password if configured. A generated magic link
+	// password is useless unless it was set, so that failure is fatal.
+	if magic {
+		generated, err := generatePassword()
+		if err == nil {
+			err = updateKeycloakPassword(keycloakURL, clusterName, keycloakClientSecret, generated)
+		}
+		if err != nil {
+			log.Printf("Error setting magic link password for %s: %v", clusterName, err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to set cluster credentials")
+			return
+		}
+		password = generated
+	} else if keycloakURL != "" && keycloakClientSecret != "" {
+		if err := updateKeycloakPassword(keycloakURL, clusterName, keycloakClientSecret, password); err != nil {
+			log.Printf("Warning: failed to update Keycloak password for %s: %v", clusterName, err)
+		}
+	}
+
+	// Derive console links from the configured rules. The old quickstart AI
+	// console was {"name":"ai","search":"console-openshift-console","replace":"data-science-gateway","path":"/learning-resources?&keyword=prelude"}
+	resp := claimResponse{
+		Consoles:  deriveConsoles(webConsoleURL, track),
+		ExpiresAt: expiresAt.UTC().Format(time.RFC3339),
+	}
+	if grace > 0 && !expiresAt.IsZero() {
+		resp.GraceEndsAt = expiresAt.Add(grace).UTC().Format(time.RFC3339)
+	}
+	if magic {
+		token, err := newMagicLink(magicLink{cluster: clusterName, password: password, webConsoleURL: webConsoleURL})
+		if err != nil {
+			log.Printf("Error creating magic link for %s: %v", clusterName, err)
+			writeJSONError(w, http.StatusInternalServerError, "internal_error", "Failed to create magic link")
+			return
+		}
+		resp.MagicLink = "/magic?token=" + token
+	}
+	for _, c := range resp.Consoles {
+		switch c.Name {
+		case "web":
+			resp.WebConsoleURL = c.URL
+		case "ai":
+			resp.AIConsoleURL = c.URL
+		}
+	}
+	switch format {
+	case "yaml":
+		resp.Kubeconfig = userKubeconfigData
+	case "base64":
+		resp.Kubeconfig = base64.StdEncoding.EncodeToString([]byte(userKubeconfigData))
+	case "login":
+		resp.LoginCommand, resp.LoginURL, resp.LoginInstructions = loginDetails(userKubeconfigData, webConsoleURL)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("Error encoding response: %v", err)
+	}
+	metricClaimResults.WithLabelValues("success").Inc()
+
+	if !readyRecorded && claimedAt.After(processStart) {
+		recordTimeToReady(ctx, clusterPool, claimName, claimedAt)
+	}
+
+	// Structured so assignments can be queried by field in the log stack;
+	// slog's default handler writes through the standard logger
+	slog.Info("Assigned cluster", "claim", claimName, "cluster", clusterName, "phone", phone, "expiresAt", resp.ExpiresAt, "track", track)
+}
+
+// loginDetails describes how to log in to a claimed cluster without handing
+// out the kubeconfig: an oc login command for the cluster API server and the
+// OAuth token request page derived from the web console URL.
+func loginDetails(kubeconfig, webConsoleURL string) (command, loginURL, instructions string) {
+	if cfg, err := clientcmd.Load([]byte(kubeconfig)); err == nil {
+		if ctx, ok := cfg.Contexts[cfg.CurrentContext]; ok {
+			if cluster, ok := cfg.Clusters[ctx.Cluster]; ok {
+				command = "oc login --web --server=" + cluster.Server
+			}
+		}
+	}
+	if strings.Contains(webConsoleURL, "console-openshift-console.") {
+		loginURL = strings.Replace(webConsoleURL, "console-openshift-console.", "oauth-openshift.", 1) + "/oauth/token/request"
+	}
+	instructions = "Run the login command to authenticate in your browser, or open the login URL, sign in and choose Display Token to get an oc login command with a token."
+	return command, loginURL, instructions
+}
+
+// countFingerprintPhones returns how many distinct phones fingerprint would
+// have presented within fingerprintPhoneWindow if it also presented phone,
+// without recording anything.
+func countFingerprintPhones(fingerprint, phone string) int {
+	fingerprintPhones.Lock()
+	defer fingerprintPhones.Unlock()
+	n := 0
+	seenPhone := false
+	for p, seen := range fingerprintPhones.m[fingerprint] {
+		if time.Since(seen) > fingerprintPhoneWindow {
+			continue
+		}
+		n++
+		if p == phone {
+			seenPhone = true
+		}
+	}
+	if !seenPhone {
+		n++
+	}
+	return n
+}
+
+// recordFingerprintPhone notes that fingerprint presented phone and returns the
+// number of distinct phones seen for that fingerprint within fingerprintPhoneWindow.
+func recordFingerprintPhone(fingerprint, phone string) int {
+	fingerprintPhones.Lock()
+	defer fingerprintPhones.Unlock()
+	phones, ok := fingerprintPhones.m[fingerprint]
+	if !ok {
+		phones = make(map[string]time.Time)
+		fingerprintPhones.m[fingerprint] = phones
+	}
+	now := time.Now()
+	for p, seen := range phones {
+		if now.Sub(seen) > fingerprintPhoneWindow {
+			delete(phones, p)
+		}
+	}
+	phones[phone] = now
+	return len(phones)
+}
+
+// sweepFingerprintPhones drops observations older than fingerprintPhoneWindow
+// so fingerprints that are never seen again don't accumulate.
+func sweepFingerprintPhones() {
+	fingerprintPhones.Lock()
+	defer fingerprintPhones.Unlock()
+	now := time.Now()
+	for fp, phones := range fingerprintPhones.m {
+		for p, seen := range phones {
+			if now.Sub(seen) > fingerprintPhoneWindow {
+				delete(phones, p)
+			}
+		}
+		if len(phones) == 0 {
+			delete(fingerprintPhones.m, fp)
+		}
+	}
+}
+
+// circuitBreaker tracks consecutive failures per spoke cluster so that requests
+// don't keep dialing a cluster that is known to be down.
+type circuitBreaker struct {
+	sync.Mutex
+	failures  map[string]int
+	openUntil map[string]time.Time
+}
+
+func newCircuitBreaker() *circuitBreaker {
+	return &circuitBreaker{
+		failures:  make(map[string]int),
+		openUntil: make(map[string]time.Time),
+	}
+}
+
+// allow reports whether a call to the cluster should be attempted. Once the
+// cooldown has passed a call is let through again to probe the cluster.
+func (cb *circuitBreaker) allow(cluster string) bool {
+	cb.Lock()
+	defer cb.Unlock()
+	until, ok := cb.openUntil[cluster]
+	return !ok || time.Now().After(until)
+}
+
+// record updates the breaker with the result of a call. A success resets it;
+// reaching spokeBreakerThreshold consecutive failures opens it for spokeBreakerCooldown.
+// Only errors that show the spoke unreachable count as failures. Others,
+// such as an HTTP error status or a failing MaaS API, leave it unchanged.
+func (cb *circuitBreaker) record(cluster string, err error) {
+	if err != nil && !spokeUnreachable(err) {
+		return
+	}
+	cb.Lock()
+	defer cb.Unlock()
+	if err == nil {
+		if _, open := cb.openUntil[cluster]; open {
+			log.Printf("Spoke circuit for cluster %s closed, cluster is responding again", cluster)
+		}
+		delete(cb.failures, cluster)
+		delete(cb.openUntil, cluster)
+		return
+	}
+	cb.failures[cluster]++
+	if cb.failures[cluster] >= spokeBreakerThreshold {
+		cb.openUntil[cluster] = time.Now().Add(spokeBreakerCooldown)
+		log.Printf("Spoke circuit for cluster %s open for %v after %d consecutive failures", cluster, spokeBreakerCooldown, cb.failures[cluster])
+	}
+}
+
+// spokeCallError wraps the error of a call made to a spoke cluster itself,
+// as opposed to a shared service like the MaaS API.
+type spokeCallError struct{ err error }
+
+func (e *spokeCallError) Error() string { return e.err.Error() }
+func (e *spokeCallError) Unwrap() error { return e.err }
+
+// spokeUnreachable reports whether err shows that a spoke could not be
+// reached at all: a call to it that failed to dial or timed out. A spoke
+// answering with an error status is reachable.
+func spokeUnreachable(err error) bool {
+	var callErr *spokeCallError
+	if !errors.As(err, &callErr) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(callErr.err, &netErr)
+}
+
+// markClaimUnreachable records the first time a claim's cluster was seen
+// unreachable in the prelude-unreachable-since annotation and returns how long
+// it has been unreachable since.
+func markClaimUnreachable(ctx context.Context, pool, claimName string) (time.Duration, error) {
+	claim, err := claimStore.GetClaim(ctx, pool, claimName)
+	if err != nil {
+		return 0, fmt.Errorf("getting claim: %w", err)
+	}
+	if v, ok := claim.GetAnnotations()["prelude-unreachable-since"]; ok {
+		if ts, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return time.Since(time.Unix(ts, 0)), nil
+		}
+	}
+	since := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := claimStore.PatchClaim(ctx, pool, claimName, nil, map[string]interface{}{"prelude-unreachable-since": since}); err != nil {
+		return 0, fmt.Errorf("patching claim: %w", err)
+	}
+	return 0, nil
+}
+
+// clearClaimUnreachable removes the prelude-unreachable-since annotation once
+// the claim's cluster is reachable again.
+func clearClaimUnreachable(ctx context.Context, pool, claimName string) error {
+	claim, err := claimStore.GetClaim(ctx, pool, claimName)
+	if err != nil {
+		return fmt.Errorf("getting claim: %w", err)
+	}
+	if _, ok := claim.GetAnnotations()["prelude-unreachable-since"]; !ok {
+		return nil
+	}
+	if err := claimStore.PatchClaim(ctx, pool, claimName, nil, map[string]interface{}{"prelude-unreachable-since": nil}); err != nil {
+		return fmt.Errorf("patching claim: %w", err)
+	}
+	return nil
+}
+
+// reassignCooldown, set by --reassign-cooldown, keeps a released claim from
+// being handed to the next phone until this long after releasedAtAnnotation.
+var reassignCooldown time.Duration
+
+const releasedAtAnnotation = "prelude-released-at"
+
+// claimCoolingDown reports whether a claim was released less than
+// reassignCooldown ago.
+func claimCoolingDown(annotations map[string]string) bool {
+	if reassignCooldown <= 0 {
+		return false
+	}
+	ts, err := strconv.ParseInt(annotations[releasedAtAnnotation], 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Since(time.Unix(ts, 0)) < reassignCooldown
+}
+
+// twoPhaseClaim, set by --two-phase-claim, enables the reserve and confirm
+// phases of /api/claim.
+var twoPhaseClaim bool
+
+// reservationTTL is how long a reserved claim waits for its confirmation.
+const reservationTTL = 2 * time.Minute
+
+// A reserved claim carries the SHA-256 of its reservation token and the Unix
+// time it is held until. Only the hash is stored, so reading the claim isn't
+// enough to confirm it.
+const (
+	reservationAnnotation   = "prelude-reservation"
+	reservedUntilAnnotation = "prelude-reserved-until"
+)
+
+// errReservationLost means a reservation was released or taken over before it
+// could be confirmed.
+var errReservationLost = errors.New("reservation no longer held")
+
+type reservationResponse struct {
+	Reservation   string `json:"reservation,omitempty"`
+	ReservedUntil string `json:"reservedUntil,omitempty"`
+
+	// Confirmed is set when the phone's claim was already committed, so
+	// there is nothing to reserve and the client can go straight to confirm
+	Confirmed bool `json:"confirmed,omitempty"`
+}
+
+// claimReserved reports whether a claim is reserved but not yet confirmed.
+func claimReserved(annotations map[string]string) bool {
+	return annotations[reservationAnnotation] != ""
+}
+
+// reservationExpired reports whether a reservation's hold has run out.
+func reservationExpired(annotations map[string]string) bool {
+	ts, err := strconv.ParseInt(annotations[reservedUntilAnnotation], 10, 64)
+	return err != nil || time.Now().After(time.Unix(ts, 0))
+}
+
+// reservationMatches reports whether token is the one the claim was reserved
+// with.
+func reservationMatches(annotations map[string]string, token string) bool {
+	sum := sha256.Sum256([]byte(token))
+	return token != "" && subtle.ConstantTimeCompare([]byte(hex.EncodeToString(sum[:])), []byte(annotations[reservationAnnotation])) == 1
+}
+
+// reservationAnnotations marks a claim reserved by token until until.
+func reservationAnnotations(token string, until time.Time) map[string]interface{} {
+	sum := sha256.Sum256([]byte(token))
+	return map[string]interface{}{
+		reservationAnnotation:   hex.EncodeToString(sum[:]),
+		reservedUntilAnnotation: strconv.FormatInt(until.Unix(), 10),
+	}
+}
+
+// assignmentAnnotations are the annotations written when a claim is
+// committed to a phone: the claimed-at time, the expiry grace and the
+// reCAPTCHA score (if any), clearing a previous release time.
+func assignmentAnnotations(captchaScore string) map[string]interface{} {
+	var graceValue interface{}
+	if expiryGrace > 0 {
+		graceValue = duration.Format(expiryGrace)
+	}
+	var scoreValue interface{}
+	if captchaScore != "" {
+		scoreValue = captchaScore
+	}
+	return map[string]interface{}{
+		"prelude-claimed-at":     strconv.FormatInt(time.Now().Unix(), 10),
+		releasedAtAnnotation:     nil,
+		expiryGraceAnnotation:    graceValue,
+		recaptchaScoreAnnotation: scoreValue,
+	}
+}
+
+// reserveClaim renews the reservation on a phone's claim with a fresh token,
+// returning the token and the new hold time.
+func reserveClaim(ctx context.Context, pool, claimName string) (string, time.Time, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	until := time.Now().Add(reservationTTL)
+	if err := claimStore.PatchClaim(ctx, pool, claimName, nil, reservationAnnotations(token, until)); err != nil {
+		return "", time.Time{}, fmt.Errorf("patching claim: %w", err)
+	}
+	return token, until, nil
+}
+
+// confirmReservation commits a reserved claim to phone: its lifetime starts
+// now, as for a single-phase assignment, and the reservation is dropped. The
+// patch carries the resourceVersion so it can't race the release loop; on a
+// conflict the claim is re-read and the confirmation retried while it is
+// still reserved for phone. Returns the claim's expiry.
+func confirmReservation(ctx context.Context, pool string, claim *unstructured.Unstructured, phone, clusterLifetime, captchaScore string) (time.Time, error) {
+	configured, err := duration.Parse(clusterLifetime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parsing cluster lifetime: %w", err)
+	}
+	for conflicts := 0; ; conflicts++ {
+		totalLifetime := time.Since(claim.GetCreationTimestamp().Time) + configured
+		annotations := assignmentAnnotations(captchaScore)
+		annotations[reservationAnnotation] = nil
+		annotations[reservedUntilAnnotation] = nil
+		err := claimStore.AssignClaim(ctx, pool, claim, nil, annotations, duration.Format(totalLifetime+expiryGrace))
+		if err == nil {
+			log.Printf("Cluster claim %s confirmed for phone %s, setting lifetime=%s", claim.GetName(), phone, duration.Format(totalLifetime))
+			return claim.GetCreationTimestamp().Time.Add(totalLifetime), nil
+		}
+		if !k8serrors.IsConflict(err) || conflicts >= assignRetries {
+			return time.Time{}, err
+		}
+		claim, err = claimStore.GetClaim(ctx, pool, claim.GetName())
+		if k8serrors.IsNotFound(err) {
+			return time.Time{}, errReservationLost
+		} else if err != nil {
+			return time.Time{}, err
+		}
+		if claim.GetLabels()["prelude"] != phone || !claimReserved(claim.GetAnnotations()) {
+			return time.Time{}, errReservationLost
+		}
+	}
+}
+
+// releaseReservation returns a reserved claim to the pool. Nothing was set on
+// the cluster yet, so unlike ReleaseClaim it keeps prelude-auth and records no
+// release time. The resourceVersion precondition keeps it from undoing a
+// confirmation that landed first.
+func releaseReservation(ctx context.Context, pool string, claim *unstructured.Unstructured) error {
+	labels := map[string]interface{}{"prelude": nil, "prelude-fp": nil, "prelude-track": nil}
+	annotations := map[string]interface{}{reservationAnnotation: nil, reservedUntilAnnotation: nil}
+	return claimStore.AssignClaim(ctx, pool, claim, labels, annotations, "")
+}
+
+// releaseExpiredReservations releases the pool's reservations that weren't
+// confirmed within reservationTTL. A claim that changed since it was listed
+// (e.g. just confirmed) is left for the next pass.
+func releaseExpiredReservations(ctx context.Context, pool string) {
+	claims, err := claimStore.ListClaims(ctx, pool, metav1.ListOptions{
+		LabelSelector: "prelude",
+	})
+	if err != nil {
+		log.Printf("Reservations: error listing cluster claims: %v", err)
+		return
+	}
+	for i := range claims.Items {
+		claim := &claims.Items[i]
+		annotations := claim.GetAnnotations()
+		if !claimMatchesPool(claim.Object, pool) || !claimReserved(annotations) || !reservationExpired(annotations) {
+			continue
+		}
+		if err := releaseReservation(ctx, pool, claim); k8serrors.IsConflict(err) {
+			continue
+		} else if err != nil {
+			log.Printf("Reservations: error releasing claim %s: %v", claim.GetName(), err)
+			continue
+		}
+		log.Printf("Reservations: released unconfirmed claim %s (phone %s)", claim.GetName(), claim.GetLabels()["prelude"])
+		metricClaimTransitions.WithLabelValues("released").Inc()
+	}
+}
+
+// writeReservationExpired answers a confirm whose reservation is gone.
+func writeReservationExpired(w http.ResponseWriter) {
+	writeJSONError(w, http.StatusConflict, "reservation_expired", "The reservation expired, claim again")
+}
+
+// processStart bounds time-to-ready measurements to claims assigned while
+// this process runs; older claims may have been ready long before the
+// annotation existed.
+var processStart = time.Now()
+
+// readyAtAnnotation marks when a claim first returned credentials to its
+// phone, so its time to ready is observed only once.
+const readyAtAnnotation = "prelude-ready-at"
+
+// recordTimeToReady observes the time from claimedAt until now in
+// prelude_time_to_ready_seconds, after marking the claim with
+// readyAtAnnotation. If the mark can't be written the observation is skipped
+// rather than risk counting the claim again on its next request.
+func recordTimeToReady(ctx context.Context, pool, claimName string, claimedAt time.Time) {
+	now := time.Now()
+	if err := claimStore.PatchClaim(ctx, pool, claimName, nil, map[string]interface{}{readyAtAnnotation: strconv.FormatInt(now.Unix(), 10)}); err != nil {
+		log.Printf("Warning: failed to record ready time on claim %s: %v", claimName, err)
+		return
+	}
+	metricTimeToReady.Observe(now.Sub(claimedAt).Seconds())
+}
+
+// unboundClaimThreshold is how long a phone-labeled claim may go without a
+// spec.namespace before reconcileUnboundClaims releases it.
+const unboundClaimThreshold = 10 * time.Minute
+
+// reconcileUnboundClaims releases claims labeled with a phone whose
+// spec.namespace is empty, i.e. labeled before Hive bound them and never
+// bound since. They look claimed but are unusable, and hold the phone (and
+// its fingerprint) away from a working cluster. Only claims that have been
+// in that state for unboundClaimThreshold, counted from creation or
+// assignment whichever is later, are released.
+func reconcileUnboundClaims(ctx context.Context, pool string) {
+	claims, err := claimStore.ListClaims(ctx, pool, metav1.ListOptions{
+		LabelSelector: "prelude",
+	})
+	if err != nil {
+		log.Printf("Unbound claims: error listing cluster claims: %v", err)
+		return
+	}
+	for _, claim := range claims.Items {
+		if !claimMatchesPool(claim.Object, pool) || claimDeleting(claim.Object) {
+			continue
+		}
+		if ns, _, _ := unstructured.NestedString(claim.Object, "spec", "namespace"); ns != "" {
+			continue
+		}
+		// An admin rebind waits for a fresh cluster however long the pool takes
+		if claim.GetAnnotations()[rebindOfAnnotation] != "" {
+			continue
+		}
+		since := claim.GetCreationTimestamp().Time
+		if ts, err := strconv.ParseInt(claim.GetAnnotations()["prelude-claimed-at"], 10, 64); err == nil && time.Unix(ts, 0).After(since) {
+			since = time.Unix(ts, 0)
+		}
+		if time.Since(since) < unboundClaimThreshold {
+			continue
+		}
+		// The release patch carries the listed resourceVersion: if Hive bound
+		// the claim since, it conflicts and the phone keeps its cluster
+		phone := claim.GetLabels()["prelude"]
+		err := claimStore.AssignClaim(ctx, pool, &claim, releaseLabels(), releaseAnnotations(), "")
+		if k8serrors.IsConflict(err) {
+			log.Printf("Unbound claims: claim %s (phone %s) changed since listed, leaving it", claim.GetName(), phone)
+			continue
+		} else if err != nil {
+			log.Printf("Unbound claims: error releasing claim %s (phone %s): %v", claim.GetName(), phone, err)
+			continue
+		}
+		log.Printf("Unbound claims: released claim %s from phone %s, no spec.namespace after %s", claim.GetName(), phone, duration.FormatAge(time.Since(since)))
+		metricClaimTransitions.WithLabelValues("unbound").Inc()
+	}
+}
+
+// ClaimStore is every ClusterClaim read and write the server makes, keyed by
+// pool rather than namespace. dynamicClaimStore serves it from Hive and
+// memClaimStore from memory.
+type ClaimStore interface {
+	// ListClaims lists the claims in the pool's claim namespace. opts
+	// carries the label selector and paging, if any.
+	ListClaims(ctx context.Context, pool string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error)
+	// GetClaim reads one claim from the pool's claim namespace.
+	GetClaim(ctx context.Context, pool, name string) (*unstructured.Unstructured, error)
+	// CreateClaim creates claim in the pool's claim namespace, honouring
+	// metadata.generateName, and returns it as stored.
+	CreateClaim(ctx context.Context, pool string, claim *unstructured.Unstructured) (*unstructured.Unstructured, error)
+	// DeleteClaim deletes a claim, which has Hive deprovision its cluster.
+	// Unless resourceVersion is empty, it fails with a conflict if the claim
+	// changed since it was read.
+	DeleteClaim(ctx context.Context, pool, name, resourceVersion string) error
+	// AssignClaim merge-patches labels, annotations and, unless lifetime is
+	// empty, spec.lifetime onto claim. The patch carries claim's
+	// resourceVersion, so it fails with a conflict if the claim changed since
+	// it was read.
+	AssignClaim(ctx context.Context, pool string, claim *unstructured.Unstructured, labels, annotations map[string]interface{}, lifetime string) error
+	// PatchClaim merge-patches only the given label and annotation keys (a
+	// nil value removes the key), whatever the claim's resourceVersion.
+	// Writers touching different keys (server, authenticator) can't clobber
+	// each other.
+	PatchClaim(ctx context.Context, pool, name string, labels, annotations map[string]interface{}) error
+	// ReleaseClaim drops a claim's assignment so it can be handed out again.
+	ReleaseClaim(ctx context.Context, pool, name string) error
+	// SetAuthenticated marks a claim's cluster as set up by the
+	// authenticator (prelude-auth=done), making it assignable.
+	SetAuthenticated(ctx context.Context, pool, name string) error
+}
+
+// claimStore is set in main once the hub client (or the fake backend) is
+// built.
+var claimStore ClaimStore
+
+// releaseLabels and releaseAnnotations are what ReleaseClaim patches: the
+// phone and fingerprint assignment is dropped, and prelude-auth is removed so
+// the authenticator re-verifies the cluster before it is handed out again.
+// The release time is recorded for --reassign-cooldown.
+func releaseLabels() map[string]interface{} {
+	return map[string]interface{}{"prelude": nil, "prelude-fp": nil, "prelude-auth": nil, "prelude-track": nil}
+}
+
+func releaseAnnotations() map[string]interface{} {
+	return map[string]interface{}{"prelude-claimed-at": nil, "prelude-unreachable-since": nil, expiryNotifiedAnnotation: nil, readyAtAnnotation: nil, releasedAtAnnotation: strconv.FormatInt(time.Now().Unix(), 10), recaptchaScoreAnnotation: nil}
+}
+
+// dynamicClaimStore is a ClaimStore over Hive's ClusterClaims.
+type dynamicClaimStore struct {
+	dynClient dynamic.Interface
+}
+
+func (c dynamicClaimStore) claims(pool string) dynamic.ResourceInterface {
+	return c.dynClient.Resource(clusterClaimGVR).Namespace(claimNamespace(pool))
+}
+
+func (c dynamicClaimStore) ListClaims(ctx context.Context, pool string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	return c.claims(pool).List(ctx, opts)
+}
+
+func (c dynamicClaimStore) GetClaim(ctx context.Context, pool, name string) (*unstructured.Unstructured, error) {
+	return c.claims(pool).Get(ctx, name, metav1.GetOptions{})
+}
+
+func (c dynamicClaimStore) CreateClaim(ctx context.Context, pool string, claim *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	return c.claims(pool).Create(ctx, claim, metav1.CreateOptions{})
+}
+
+func (c dynamicClaimStore) DeleteClaim(ctx context.Context, pool, name, resourceVersion string) error {
+	var opts metav1.DeleteOptions
+	if resourceVersion != "" {
+		opts.Preconditions = &metav1.Preconditions{ResourceVersion: &resourceVersion}
+	}
+	return c.claims(pool).Delete(ctx, name, opts)
+}
+
+func (c dynamicClaimStore) AssignClaim(ctx context.Context, pool string, claim *unstructured.Unstructured, labels, annotations map[string]interface{}, lifetime string) error {
+	metadata := map[string]interface{}{
+		"resourceVersion": claim.GetResourceVersion(),
+	}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	body := map[string]interface{}{"metadata": metadata}
+	if lifetime != "" {
+		body["spec"] = map[string]interface{}{"lifetime": lifetime}
+	}
+	patch, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	_, err = c.claims(pool).Patch(ctx, claim.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+func (c dynamicClaimStore) PatchClaim(ctx context.Context, pool, name string, labels, annotations map[string]interface{}) error {
+	metadata := map[string]interface{}{}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	patch, err := json.Marshal(map[string]interface{}{"metadata": metadata})
+	if err != nil {
+		return err
+	}
+	_, err = c.claims(pool).Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+func (c dynamicClaimStore) ReleaseClaim(ctx context.Context, pool, name string) error {
+	if err := c.PatchClaim(ctx, pool, name, releaseLabels(), releaseAnnotations()); err != nil {
+		return fmt.Errorf("patching claim: %w", err)
+	}
+	return nil
+}
+
+func (c dynamicClaimStore) SetAuthenticated(ctx context.Context, pool, name string) error {
+	return c.PatchClaim(ctx, pool, name, map[string]interface{}{"prelude-auth": "done"}, nil)
+}
+
+// memClaimStore is a ClaimStore held in memory, for --fake-backend and tests.
+// It keeps the API server semantics the handlers rely on: resourceVersion
+// preconditions, NotFound/AlreadyExists/Conflict errors, merge patches where
+// nil removes a key, and copies in and out so callers can't alias its state.
+type memClaimStore struct {
+	sync.Mutex
+	claims  map[string]*unstructured.Unstructured // by namespace/name
+	version int
+}
+
+func newMemClaimStore() *memClaimStore {
+	return &memClaimStore{claims: make(map[string]*unstructured.Unstructured)}
+}
+
+func (m *memClaimStore) key(pool, name string) string {
+	return claimNamespace(pool) + "/" + name
+}
+
+// bump gives claim a fresh resourceVersion. The caller holds m.
+func (m *memClaimStore) bump(claim *unstructured.Unstructured) {
+	m.version++
+	claim.SetResourceVersion(strconv.Itoa(m.version))
+}
+
+func (m *memClaimStore) ListClaims(ctx context.Context, pool string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	selector, err := labels.Parse(opts.LabelSelector)
+	if err != nil {
+		return nil, k8serrors.NewBadRequest(err.Error())
+	}
+	m.Lock()
+	defer m.Unlock()
+	list := &unstructured.UnstructuredList{}
+	prefix := claimNamespace(pool) + "/"
+	for key, claim := range m.claims {
+		if strings.HasPrefix(key, prefix) && selector.Matches(labels.Set(claim.GetLabels())) {
+			list.Items = append(list.Items, *claim.DeepCopy())
+		}
+	}
+	sort.Slice(list.Items, func(i, j int) bool { return list.Items[i].GetName() < list.Items[j].GetName() })
+	return list, nil
+}
+
+func (m *memClaimStore) GetClaim(ctx context.Context, pool, name string) (*unstructured.Unstructured, error) {
+	m.Lock()
+	defer m.Unlock()
+	claim, ok := m.claims[m.key(pool, name)]
+	if !ok {
+		return nil, k8serrors.NewNotFound(clusterClaimGVR.GroupResource(), name)
+	}
+	return claim.DeepCopy(), nil
+}
+
+func (m *memClaimStore) CreateClaim(ctx context.Context, pool string, claim *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	m.Lock()
+	defer m.Unlock()
+	claim = claim.DeepCopy()
+	if claim.GetName() == "" {
+		if claim.GetGenerateName() == "" {
+			return nil, k8serrors.NewBadRequest("name or generateName is required")
+		}
+		suffix, err := generateToken()
+		if err != nil {
+			return nil, err
+		}
+		claim.SetName(claim.GetGenerateName() + strings.ToLower(suffix[:5]))
+	}
+	key := m.key(pool, claim.GetName())
+	if _, ok := m.claims[key]; ok {
+		return nil, k8serrors.NewAlreadyExists(clusterClaimGVR.GroupResource(), claim.GetName())
+	}
+	claim.SetNamespace(claimNamespace(pool))
+	claim.SetCreationTimestamp(metav1.Now())
+	m.bump(claim)
+	m.claims[key] = claim
+	return claim.DeepCopy(), nil
+}
+
+func (m *memClaimStore) DeleteClaim(ctx context.Context, pool, name, resourceVersion string) error {
+	m.Lock()
+	defer m.Unlock()
+	key := m.key(pool, name)
+	claim, ok := m.claims[key]
+	if !ok {
+		return k8serrors.NewNotFound(clusterClaimGVR.GroupResource(), name)
+	}
+	if resourceVersion != "" && resourceVersion != claim.GetResourceVersion() {
+		return k8serrors.NewConflict(clusterClaimGVR.GroupResource(), name, errors.New("the object has been modified"))
+	}
+	delete(m.claims, key)
+	return nil
+}
+
+func (m *memClaimStore) AssignClaim(ctx context.Context, pool string, claim *unstructured.Unstructured, labels, annotations map[string]interface{}, lifetime string) error {
+	return m.patch(pool, claim.GetName(), claim.GetResourceVersion(), labels, annotations, lifetime)
+}
+
+func (m *memClaimStore) PatchClaim(ctx context.Context, pool, name string, labels, annotations map[string]interface{}) error {
+	return m.patch(pool, name, "", labels, annotations, "")
+}
+
+func (m *memClaimStore) ReleaseClaim(ctx context.Context, pool, name string) error {
+	if err := m.patch(pool, name, "", releaseLabels(), releaseAnnotations(), ""); err != nil {
+		return fmt.Errorf("patching claim: %w", err)
+	}
+	return nil
+}
+
+func (m *memClaimStore) SetAuthenticated(ctx context.Context, pool, name string) error {
+	return m.patch(pool, name, "", map[string]interface{}{"prelude-auth": "done"}, nil, "")
+}
+
+// patch applies a merge patch to a stored claim, failing with a conflict when
+// resourceVersion is set and stale.
+func (m *memClaimStore) patch(pool, name, resourceVersion string, labels, annotations map[string]interface{}, lifetime string) error {
+	m.Lock()
+	defer m.Unlock()
+	claim, ok := m.claims[m.key(pool, name)]
+	if !ok {
+		return k8serrors.NewNotFound(clusterClaimGVR.GroupResource(), name)
+	}
+	if resourceVersion != "" && resourceVersion != claim.GetResourceVersion() {
+		return k8serrors.NewConflict(clusterClaimGVR.GroupResource(), name, errors.New("the object has been modified"))
+	}
+	claim.SetLabels(mergeStrings(claim.GetLabels(), labels))
+	claim.SetAnnotations(mergeStrings(claim.GetAnnotations(), annotations))
+	if lifetime != "" {
+		unstructured.SetNestedField(claim.Object, lifetime, "spec", "lifetime")
+	}
+	m.bump(claim)
+	return nil
+}
+
+// mergeStrings applies a JSON merge patch of string values to m: nil values
+// remove their key.
+func mergeStrings(m map[string]string, patch map[string]interface{}) map[string]string {
+	if m == nil {
+		m = map[string]string{}
+	}
+	for k, v := range patch {
+		if v == nil {
+			delete(m, k)
+		} else {
+			m[k] = fmt.Sprint(v)
+		}
+	}
+	return m
+}
+
+// probeConsoleURL sends a quick HEAD request to the web console and returns an
+// error if it cannot be reached or responds with a 5xx (e.g. the route is still
+// returning 503 while ingress propagates).
+func probeConsoleURL(consoleURL string) error {
+	httpClient := &http.Client{
+		Timeout: 3 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		// The console redirects to OAuth; any response from the route is enough
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := httpClient.Head(consoleURL)
+	if err != nil {
+		return &spokeCallError{fmt.Errorf("console probe failed: %w", err)}
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("console returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// migrateLabels renames the <oldPrefix>, <oldPrefix>-auth and <oldPrefix>-fp
+// labels on all claims of the pool to prelude, prelude-auth and prelude-fp.
+// Claims that already carry the new label keep its value. Running it again is a no-op.
+func migrateLabels(ctx context.Context, pool, oldPrefix string, dryRun bool) error {
+	if oldPrefix == "prelude" {
+		return fmt.Errorf("old label prefix is the same as the current prefix")
+	}
+	claims, err := claimStore.ListClaims(ctx, pool, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("listing ClusterClaims: %w", err)
+	}
+
+	migrated := 0
+	for _, claim := range claims.Items {
+		if !claimMatchesPool(claim.Object, pool) {
+			continue
+		}
+		labels := claim.GetLabels()
+		patch := map[string]interface{}{}
+		for _, suffix := range []string{"", "-auth", "-fp"} {
+			oldKey := oldPrefix + suffix
+			newKey := "prelude" + suffix
+			value, ok := labels[oldKey]
+			if !ok {
+				continue
+			}
+			if existing, ok := labels[newKey]; ok && existing != value {
+				log.Printf("Migrate: claim %s already has %s=%s, dropping %s=%s", claim.GetName(), newKey, existing, oldKey, value)
+			} else {
+				patch[newKey] = value
+				log.Printf("Migrate: claim %s %s=%s -> %s=%s", claim.GetName(), oldKey, value, newKey, value)
+			}
+			patch[oldKey] = nil
+		}
+		if len(patch) == 0 {
+			continue
+		}
+		migrated++
+		if dryRun {
+			continue
+		}
+		if err := claimStore.PatchClaim(ctx, pool, claim.GetName(), patch, nil); err != nil {
+			return fmt.Errorf("patching claim %s: %w", claim.GetName(), err)
+		}
+		log.Printf("Migrated labels on claim %s", claim.GetName())
+	}
+
+	if dryRun {
+		log.Printf("Migrate (dry run): %d claim(s) would be migrated from prefix %q", migrated, oldPrefix)
+	} else {
+		log.Printf("Migrate: %d claim(s) migrated from prefix %q", migrated, oldPrefix)
+	}
+	return nil
+}
+
+// claimNamespaces returns the hub namespaces of the pools and their claims.
+func claimNamespaces() pools.Namespaces {
+	return pools.Namespaces{Default: clusterPoolNamespace, Mapped: poolNamespaces}
+}
+
+// claimNamespace returns the hub namespace holding ClusterClaims for a pool.
+func claimNamespace(pool string) string {
+	return claimNamespaces().Of(pool)
+}
+
+// requestPool returns the pool selected by the request's pool query
+// parameter, which defaults to the only pool when just one is configured. It
+// answers 400 and returns false when the pool is missing or not served here.
+func requestPool(w http.ResponseWriter, r *http.Request) (string, bool) {
+	pools := servedPools()
+	pool := r.URL.Query().Get("pool")
+	if pool == "" && len(pools) == 1 {
+		return pools[0], true
+	}
+	if slices.Contains(pools, pool) {
+		return pool, true
+	}
+	if pool == "" {
+		writeJSONError(w, http.StatusBadRequest, "pool_required", "This server runs several cluster pools, pass ?pool=")
+	} else {
+		writeJSONError(w, http.StatusBadRequest, "unknown_pool", "Unknown cluster pool")
+	}
+	return "", false
+}
+
+// servedPools returns the pools currently served. The slice is replaced on
+// change, never modified in place, so callers may range over it freely.
+func servedPools() []string {
+	poolSet.RLock()
+	defer poolSet.RUnlock()
+	return poolSet.list
+}
+
+// setServedPools replaces the served pools, setting up per-pool state for
+// pools that are new and dropping it for pools that are gone.
+func setServedPools(list []string) {
+	poolSet.Lock()
+	old := poolSet.list
+	poolSet.list = list
+	poolSet.Unlock()
+
+	for _, p := range list {
+		if slices.Contains(old, p) {
+			continue
+		}
+		log.Printf("Claiming from namespace %s for pool %s", claimNamespace(p), p)
+		// Pre-create the assignment series for every allowed track so a
+		// track nobody picked yet still shows up at zero
+		for _, t := range append([]string{noTrack}, tracks...) {
+			metricClaimAssignments.WithLabelValues(p, t)
+		}
+		if readyGate {
+			go waitForPoolReady(p)
+		}
+	}
+	for _, p := range old {
+		if slices.Contains(list, p) {
+			continue
+		}
+		log.Printf("No longer serving pool %s", p)
+		latestStats.Lock()
+		delete(latestStats.m, p)
+		latestStats.Unlock()
+		readyPools.Delete(p)
+	}
+}
+
+// resolvePools lists the ClusterPools matching selector in the claim
+// namespaces (--claim-namespace and those mapped by --pool-namespace),
+// keeping each pool only if its claims are read from its own namespace.
+func resolvePools(ctx context.Context, dynClient dynamic.Interface, selector string) ([]string, error) {
+	poolGVR := schema.GroupVersionResource{
+		Group:    clusterClaimGVR.Group,
+		Version:  clusterClaimGVR.Version,
+		Resource: "clusterpools",
+	}
+	return pools.Matching(ctx, dynClient, poolGVR, claimNamespaces(), selector)
+}
+
+// checkClusterPool verifies the ClusterPool exists in the hub namespace.
+func checkClusterPool(ctx context.Context, dynClient dynamic.Interface, namespace, pool string) error {
+	poolGVR := schema.GroupVersionResource{
+		Group:    clusterClaimGVR.Group,
+		Version:  clusterClaimGVR.Version,
+		Resource: "clusterpools",
+	}
+	if _, err := dynClient.Resource(poolGVR).Namespace(namespace).Get(ctx, pool, metav1.GetOptions{}); err != nil {
+		if k8serrors.IsNotFound(err) {
+			return fmt.Errorf("ClusterPool %s not found in namespace %s", pool, namespace)
+		}
+		return fmt.Errorf("checking ClusterPool %s: %w", pool, err)
+	}
+	return nil
+}
+
+// claimMatchesPool checks if a ClusterClaim belongs to the specified ClusterPool.
+func claimMatchesPool(obj map[string]interface{}, poolName string) bool {
+	spec, ok := obj["spec"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	name, ok := spec["clusterPoolName"].(string)
+	if !ok {
+		return false
+	}
+	return name == poolName
+}
+
+// claimDeleting reports whether a ClusterClaim has a deletionTimestamp, i.e. it
+// is being torn down and must not be handed out or acted on.
+func claimDeleting(obj map[string]interface{}) bool {
+	metadata, ok := obj["metadata"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, ok = metadata["deletionTimestamp"]
+	return ok
+}
+
+// expiryGrace, set by --expiry-grace, is added to spec.lifetime at assignment
+// so a claim stays usable for a while past the expiry shown to the user.
+var expiryGrace time.Duration
 
-	// Get ClusterDeployment to find webConsoleURL
-	cd, err := dynClient.Resource(clusterDeploymentGVR).Namespace(clusterName).Get(ctx, clusterName, metav1.GetOptions{})
+// expiryGraceAnnotation records the grace included in a claim's spec.lifetime,
+// so later changes to --expiry-grace don't shift existing claims' expiry.
+const expiryGraceAnnotation = "prelude-expiry-grace"
+
+// claimGrace returns the expiry grace recorded on a claim, 0 if none.
+func claimGrace(obj map[string]interface{}) time.Duration {
+	v, _, _ := unstructured.NestedString(obj, "metadata", "annotations", expiryGraceAnnotation)
+	d, err := duration.Parse(v)
 	if err != nil {
-		log.Printf("Error getting cluster deployment %s: %v", clusterName, err)
-		http.Error(w, "Failed to get cluster deployment", http.StatusInternalServerError)
-		return
+		return 0
 	}
+	return d
+}
 
-	webConsoleURL := ""
-	if status, ok := cd.Object["status"].(map[string]interface{}); ok {
-		if url, ok := status["webConsoleURL"].(string); ok {
-			webConsoleURL = url
-		}
+// claimInGrace reports whether a claim is past its expiry but still inside
+// its grace window. Estimated expiries are never in grace.
+func claimInGrace(obj map[string]interface{}, created time.Time) bool {
+	t, estimated := claimExpiry(obj, created)
+	if t.IsZero() || estimated {
+		return false
 	}
+	now := time.Now()
+	return now.After(t) && !now.After(t.Add(claimGrace(obj)))
+}
 
-	// Get kubeconfig secret name from ClusterDeployment
-	kubeconfigSecretName := ""
-	if spec, ok := cd.Object["spec"].(map[string]interface{}); ok {
-		if meta, ok := spec["clusterMetadata"].(map[string]interface{}); ok {
-			if ref, ok := meta["adminKubeconfigSecretRef"].(map[string]interface{}); ok {
-				if name, ok := ref["name"].(string); ok {
-					kubeconfigSecretName = name
-				}
+// claimExpired reports whether a claim is past its expiry and any grace, so
+// Hive is about to delete it. Estimated expiries never count.
+func claimExpired(obj map[string]interface{}, created time.Time) bool {
+	t, estimated := claimExpiry(obj, created)
+	return !t.IsZero() && !estimated && time.Now().After(t.Add(claimGrace(obj)))
+}
+
+// claimExpiry returns when a claim expires from its spec.lifetime, less any
+// grace recorded at assignment. Claims without one fall back to
+// defaultLifetime, flagged as estimated since the pool's actual default isn't
+// visible on the claim. Returns the zero time if neither is available.
+func claimExpiry(obj map[string]interface{}, created time.Time) (time.Time, bool) {
+	if spec, ok := obj["spec"].(map[string]interface{}); ok {
+		if lt, ok := spec["lifetime"].(string); ok {
+			if d, err := duration.Parse(lt); err == nil {
+				return created.Add(d - claimGrace(obj)), false
 			}
 		}
 	}
-
-	if kubeconfigSecretName == "" {
-		log.Printf("Could not find kubeconfig secret ref for cluster %s", clusterName)
-		http.Error(w, "Failed to find kubeconfig secret", http.StatusInternalServerError)
-		return
+	if defaultLifetime > 0 {
+		return created.Add(defaultLifetime), true
 	}
+	return time.Time{}, false
+}
 
-	// Get the admin kubeconfig secret
-	adminSecret, err := clientset.CoreV1().Secrets(clusterName).Get(ctx, kubeconfigSecretName, metav1.GetOptions{})
-	if err != nil {
-		log.Printf("Error getting admin kubeconfig secret %s/%s: %v", clusterName, kubeconfigSecretName, err)
-		http.Error(w, "Failed to get admin kubeconfig", http.StatusInternalServerError)
-		return
+// deploymentLister serves the pools' ClusterDeployments from a shared informer
+// started by startDeploymentCache; nil when reads should go to the API server.
+var deploymentLister cache.GenericLister
+
+// startDeploymentCache starts an informer on the pool's ClusterDeployments
+// (selected by the hive.openshift.io/clusterpool-name label) and waits for its
+// initial sync, so handlers read from memory instead of the hub API server.
+func startDeploymentCache(dynClient dynamic.Interface, pools []string) error {
+	selector := "hive.openshift.io/clusterpool-name"
+	if len(pools) > 0 {
+		selector = fmt.Sprintf("hive.openshift.io/clusterpool-name in (%s)", strings.Join(pools, ","))
 	}
+	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(dynClient, 10*time.Minute, metav1.NamespaceAll, func(opts *metav1.ListOptions) {
+		opts.LabelSelector = selector
+	})
+	informer := factory.ForResource(clusterDeploymentGVR)
+	factory.Start(wait.NeverStop)
 
-	adminKubeconfigData := extractKubeconfig(adminSecret)
-
-	// Derive user kubeconfig secret name from admin kubeconfig secret name
-	userKubeconfigSecretName := strings.Replace(kubeconfigSecretName, "-admin-kubeconfig", "-user-kubeconfig", 1)
-	log.Printf("Looking up user kubeconfig secret %s/%s", clusterName, userKubeconfigSecretName)
-
-	userSecret, err := clientset.CoreV1().Secrets(clusterName).Get(ctx, userKubeconfigSecretName, metav1.GetOptions{})
-	if err != nil {
-		log.Printf("Error getting user kubeconfig secret %s/%s: %v", clusterName, userKubeconfigSecretName, err)
-		http.Error(w, "Failed to get user kubeconfig", http.StatusInternalServerError)
-		return
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), informer.Informer().HasSynced) {
+		return fmt.Errorf("timed out waiting for ClusterDeployments (%s) to sync", selector)
 	}
+	deploymentLister = informer.Lister()
+	log.Printf("ClusterDeployment cache synced (%s)", selector)
+	return nil
+}
 
-	userKubeconfigData := extractKubeconfig(userSecret)
-
-	// Update MaaS credentials on the spoke cluster if configured
-	if maasURL != "" && maasToken != "" {
-		if err := updateMaaSCredentials(adminKubeconfigData, maasURL, maasToken, clusterName, clusterLifetime, webConsoleURL); err != nil {
-			log.Printf("Warning: failed to update MaaS credentials on %s: %v", clusterName, err)
+// getClusterDeployment returns the ClusterDeployment named name in the
+// namespace of the same name, from the cache when it holds it and otherwise
+// from the API server (e.g. a deployment created moments ago).
+func getClusterDeployment(ctx context.Context, dynClient dynamic.Interface, name string) (*unstructured.Unstructured, error) {
+	if deploymentLister != nil {
+		if obj, err := deploymentLister.ByNamespace(name).Get(name); err == nil {
+			if cd, ok := obj.(*unstructured.Unstructured); ok {
+				return cd, nil
+			}
 		}
 	}
+	return dynClient.Resource(clusterDeploymentGVR).Namespace(name).Get(ctx, name, metav1.GetOptions{})
+}
 
-	// Update Keycloak admin password if configured
-	if keycloakURL != "" && keycloakClientSecret != "" {
-		if err := updateKeycloakPassword(keycloakURL, clusterName, keycloakClientSecret, password); err != nil {
-			log.Printf("Warning: failed to update Keycloak password for %s: %v", clusterName, err)
+// listClusterDeployments returns the pool's ClusterDeployments. Cached objects
+// are shared with the informer and must not be modified.
+func listClusterDeployments(ctx context.Context, dynClient dynamic.Interface, pool string) ([]*unstructured.Unstructured, error) {
+	if deploymentLister != nil {
+		objs, err := deploymentLister.List(labels.SelectorFromSet(labels.Set{"hive.openshift.io/clusterpool-name": pool}))
+		if err != nil {
+			return nil, err
+		}
+		deployments := make([]*unstructured.Unstructured, 0, len(objs))
+		for _, obj := range objs {
+			if cd, ok := obj.(*unstructured.Unstructured); ok {
+				deployments = append(deployments, cd)
+			}
 		}
+		return deployments, nil
 	}
-
-	// Old quickstart - Derive AI console URL by replacing console-openshift-console with data-science-gateway
-	//aiConsoleURL := strings.Replace(webConsoleURL, "console-openshift-console", "data-science-gateway", 1) + "/learning-resources?&keyword=prelude"
-	// New workshop path
-	aiConsoleURL :=webConsoleURL +  "/rhai-workshop"
-
-	resp := claimResponse{
-		WebConsoleURL: webConsoleURL,
-		AIConsoleURL:  aiConsoleURL,
-		Kubeconfig:    userKubeconfigData,
-		ExpiresAt:     expiresAt.UTC().Format(time.RFC3339),
+	list, err := dynClient.Resource(clusterDeploymentGVR).Namespace("").List(ctx, metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("hive.openshift.io/clusterpool-name=%s", pool),
+	})
+	if err != nil {
+		return nil, err
 	}
+	deployments := make([]*unstructured.Unstructured, len(list.Items))
+	for i := range list.Items {
+		deployments[i] = &list.Items[i]
+	}
+	return deployments, nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		log.Printf("Error encoding response: %v", err)
+// clusterDeploymentConsoleURL returns status.webConsoleURL of a ClusterDeployment.
+func clusterDeploymentConsoleURL(obj map[string]interface{}) string {
+	if status, ok := obj["status"].(map[string]interface{}); ok {
+		if url, ok := status["webConsoleURL"].(string); ok {
+			return url
+		}
 	}
+	return ""
+}
 
-	log.Printf("Assigned cluster %s (claim: %s) to phone %s", clusterName, claimName, phone)
+// claimIndex returns the stable cluster number of a claim from its prelude-index
+// label, falling back to the numeric suffix of claims named prelude<N> that were
+// created before the label existed.
+func claimIndex(name string, labels map[string]string) string {
+	if idx := labels["prelude-index"]; idx != "" {
+		return idx
+	}
+	if suffix := strings.TrimPrefix(name, "prelude"); suffix != name {
+		if _, err := strconv.Atoi(suffix); err == nil {
+			return suffix
+		}
+	}
+	return ""
 }
 
-// claimMatchesPool checks if a ClusterClaim belongs to the specified ClusterPool.
-func claimMatchesPool(obj map[string]interface{}, poolName string) bool {
+// getAdminKubeconfigSecretName extracts spec.clusterMetadata.adminKubeconfigSecretRef.name
+// from a ClusterDeployment object.
+func getAdminKubeconfigSecretName(obj map[string]interface{}) string {
 	spec, ok := obj["spec"].(map[string]interface{})
 	if !ok {
-		return false
+		return ""
 	}
-	name, ok := spec["clusterPoolName"].(string)
+	meta, ok := spec["clusterMetadata"].(map[string]interface{})
 	if !ok {
-		return false
+		return ""
 	}
-	return name == poolName
+	ref, ok := meta["adminKubeconfigSecretRef"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	name, ok := ref["name"].(string)
+	if !ok {
+		return ""
+	}
+	return name
+}
+
+// deriveUserSecretName derives the user kubeconfig secret name from the
+// admin kubeconfig secret name.
+func deriveUserSecretName(adminSecretName string) string {
+	return strings.Replace(adminSecretName, "-admin-kubeconfig", "-user-kubeconfig", 1)
 }
 
 // extractKubeconfig reads kubeconfig data from a Secret, handling common key names
-// and base64-encoded values.
-func extractKubeconfig(secret *corev1.Secret) string {
-	var data string
-	if raw, ok := secret.Data["kubeconfig"]; ok {
-		data = string(raw)
-	} else if raw, ok := secret.Data["raw-kubeconfig"]; ok {
-		data = string(raw)
+// and base64-encoded values. The error names the Secret and key it read, so a
+// bad Secret is identifiable from the log.
+func extractKubeconfig(secret *corev1.Secret) (string, error) {
+	key := ""
+	if _, ok := secret.Data["kubeconfig"]; ok {
+		key = "kubeconfig"
+	} else if _, ok := secret.Data["raw-kubeconfig"]; ok {
+		key = "raw-kubeconfig"
 	} else {
-		for _, v := range secret.Data {
-			data = string(v)
-			break
+		// Lowest key, so the same Secret always yields the same one
+		for k := range secret.Data {
+			if key == "" || k < key {
+				key = k
+			}
 		}
 	}
+	if key == "" {
+		return "", fmt.Errorf("secret %s/%s has no data", secret.Namespace, secret.Name)
+	}
+	data := string(secret.Data[key])
 	if decoded, err := base64.StdEncoding.DecodeString(data); err == nil && len(decoded) > 0 && strings.Contains(string(decoded), "apiVersion") {
 		data = string(decoded)
 	}
-	return data
+	cfg, err := clientcmd.Load([]byte(data))
+	if err != nil {
+		return "", fmt.Errorf("secret %s/%s key %s isn't a valid kubeconfig: %w", secret.Namespace, secret.Name, key, err)
+	}
+	if len(cfg.Clusters) == 0 {
+		return "", fmt.Errorf("secret %s/%s key %s isn't a valid kubeconfig: no clusters", secret.Namespace, secret.Name, key)
+	}
+	return data, nil
 }
 
 // updateMaaSCredentials obtains a MaaS token, lists available models, and
@@ -1095,11 +5492,11 @@ func updateMaaSCredentials(spokeKubeconfig, maasBaseURL, maasUserToken, clusterN
 	maasHost := strings.TrimRight(maasBaseURL, "/")
 
 	// Convert cluster lifetime to MaaS token expiration
-	lifetimeDuration, err := parseDuration(clusterLifetime)
+	lifetimeDuration, err := duration.Parse(clusterLifetime)
 	if err != nil {
 		return fmt.Errorf("parsing cluster lifetime for MaaS token expiry: %w", err)
 	}
-	tokenExpiry := formatDuration(lifetimeDuration)
+	tokenExpiry := duration.Format(lifetimeDuration)
 
 	httpClient := &http.Client{
 		Transport: &http.Transport{
@@ -1190,6 +5587,10 @@ func updateMaaSCredentials(spokeKubeconfig, maasBaseURL, maasUserToken, clusterN
 	if err != nil {
 		return fmt.Errorf("building spoke kubeconfig: %w", err)
 	}
+	spokeConfig.UserAgent = userAgent
+	if err := withSpokeCA(spokeConfig); err != nil {
+		return err
+	}
 	spokeClient, err := kubernetes.NewForConfig(spokeConfig)
 	if err != nil {
 		return fmt.Errorf("creating spoke client: %w", err)
@@ -1210,18 +5611,18 @@ func updateMaaSCredentials(spokeKubeconfig, maasBaseURL, maasUserToken, clusterN
 			},
 		}
 		if _, err := spokeClient.CoreV1().ConfigMaps("chat").Create(ctx, cm, metav1.CreateOptions{}); err != nil {
-			return fmt.Errorf("creating chat-openwebui configmap: %w", err)
+			return &spokeCallError{fmt.Errorf("creating chat-openwebui configmap: %w", err)}
 		}
 		log.Printf("[%s] Created chat-openwebui configmap in chat namespace", clusterName)
 	} else if err != nil {
-		return fmt.Errorf("checking chat-openwebui configmap: %w", err)
+		return &spokeCallError{fmt.Errorf("checking chat-openwebui configmap: %w", err)}
 	} else {
 		if cm.Data == nil {
 			cm.Data = make(map[string]string)
 		}
 		cm.Data["OPENAI_API_BASE_URLS"] = apiBaseURLs
 		if _, err := spokeClient.CoreV1().ConfigMaps("chat").Update(ctx, cm, metav1.UpdateOptions{}); err != nil {
-			return fmt.Errorf("updating chat-openwebui configmap: %w", err)
+			return &spokeCallError{fmt.Errorf("updating chat-openwebui configmap: %w", err)}
 		}
 		log.Printf("[%s] Updated chat-openwebui configmap in chat namespace", clusterName)
 	}
@@ -1241,17 +5642,17 @@ func updateMaaSCredentials(spokeKubeconfig, maasBaseURL, maasUserToken, clusterN
 				Description string `json:"description"`
 			}
 			type toolConfigEnabled struct {
-				Enabled bool   `json:"enable"`
+				Enabled bool `json:"enable"`
 			}
 			type toolServerConnection struct {
-				Type     string         `json:"type"`
-				URL      string         `json:"url"`
-				SpecType string         `json:"spec_type"`
-				Spec     string         `json:"spec"`
-				Path     string         `json:"path"`
-				AuthType string         `json:"auth_type"`
-				Key      string         `json:"key"`
-				Info     toolServerInfo `json:"info"`
+				Type     string            `json:"type"`
+				URL      string            `json:"url"`
+				SpecType string            `json:"spec_type"`
+				Spec     string            `json:"spec"`
+				Path     string            `json:"path"`
+				AuthType string            `json:"auth_type"`
+				Key      string            `json:"key"`
+				Info     toolServerInfo    `json:"info"`
 				Config   toolConfigEnabled `json:"config"`
 			}
 			connections := []toolServerConnection{
@@ -1301,11 +5702,11 @@ func updateMaaSCredentials(spokeKubeconfig, maasBaseURL, maasUserToken, clusterN
 			Data: secretData,
 		}
 		if _, err := spokeClient.CoreV1().Secrets("chat").Create(ctx, secret, metav1.CreateOptions{}); err != nil {
-			return fmt.Errorf("creating chat-openwebui secret: %w", err)
+			return &spokeCallError{fmt.Errorf("creating chat-openwebui secret: %w", err)}
 		}
 		log.Printf("[%s] Created chat-openwebui secret in chat namespace", clusterName)
 	} else if err != nil {
-		return fmt.Errorf("checking chat-openwebui secret: %w", err)
+		return &spokeCallError{fmt.Errorf("checking chat-openwebui secret: %w", err)}
 	} else {
 		if secret.Data == nil {
 			secret.Data = make(map[string][]byte)
@@ -1315,7 +5716,7 @@ func updateMaaSCredentials(spokeKubeconfig, maasBaseURL, maasUserToken, clusterN
 			secret.Data["TOOL_SERVER_CONNECTIONS"] = []byte(toolServerConnections)
 		}
 		if _, err := spokeClient.CoreV1().Secrets("chat").Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
-			return fmt.Errorf("updating chat-openwebui secret: %w", err)
+			return &spokeCallError{fmt.Errorf("updating chat-openwebui secret: %w", err)}
 		}
 		log.Printf("[%s] Updated chat-openwebui secret in chat namespace", clusterName)
 	}
@@ -1519,9 +5920,45 @@ func updateKeycloakPassword(kcURL, realmName, clientSecret, newPassword string)
 	return nil
 }
 
+// verifyKeycloakPassword reports whether password is the admin user's password
+// in the Keycloak realm, by attempting a password grant with the ocp-idp client.
+func verifyKeycloakPassword(kcURL, realmName, clientSecret, password string) (bool, error) {
+	httpClient := &http.Client{
+		Timeout: 15 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	tokenURL := fmt.Sprintf("%s/realms/%s/protocol/openid-connect/token", strings.TrimRight(kcURL, "/"), realmName)
+	resp, err := httpClient.PostForm(tokenURL, url.Values{
+		"grant_type":    {"password"},
+		"client_id":     {"ocp-idp"},
+		"client_secret": {clientSecret},
+		"username":      {"admin"},
+		"password":      {password},
+	})
+	if err != nil {
+		return false, fmt.Errorf("requesting token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusUnauthorized:
+		return false, nil
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("token request failed (status %d): %s", resp.StatusCode, string(body))
+	}
+}
+
 // buildConfig returns a Kubernetes REST config. It uses the KUBECONFIG env var
 // or ~/.kube/config if available, otherwise falls back to in-cluster config.
 func buildConfig() (*rest.Config, error) {
+	if hubServer != "" {
+		return hub.CertConfig(hubServer, hubClientCert, hubClientKey, hubCA)
+	}
 	kubeconfig := os.Getenv("KUBECONFIG")
 	if kubeconfig == "" {
 		home, err := os.UserHomeDir()
@@ -1539,3 +5976,421 @@ func buildConfig() (*rest.Config, error) {
 	log.Printf("Using in-cluster config")
 	return rest.InClusterConfig()
 }
+
+// loadSpokeCA reads and validates the --spoke-ca-file bundle.
+func loadSpokeCA(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading spoke CA file: %w", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(data) {
+		return fmt.Errorf("no certificates found in spoke CA file %s", path)
+	}
+	spokeCAData = data
+	return nil
+}
+
+// withSpokeCA adds the --spoke-ca-file bundle to the CAs a spoke REST config
+// already trusts. Configs that skip verification are left alone.
+func withSpokeCA(cfg *rest.Config) error {
+	if len(spokeCAData) == 0 || cfg.TLSClientConfig.Insecure {
+		return nil
+	}
+	caData := cfg.TLSClientConfig.CAData
+	if len(caData) == 0 && cfg.TLSClientConfig.CAFile != "" {
+		data, err := os.ReadFile(cfg.TLSClientConfig.CAFile)
+		if err != nil {
+			return fmt.Errorf("reading spoke CA: %w", err)
+		}
+		caData = data
+	}
+	merged := append([]byte{}, caData...)
+	if len(merged) > 0 && merged[len(merged)-1] != '\n' {
+		merged = append(merged, '\n')
+	}
+	cfg.TLSClientConfig.CAData = append(merged, spokeCAData...)
+	cfg.TLSClientConfig.CAFile = ""
+	return nil
+}
+
+// serve runs the API server on listenAddr until SIGINT/SIGTERM, then stops
+// accepting connections and gives in-flight requests up to shutdownGrace to
+// finish, so rolling deploys don't cut claims off mid-request.
+func serve(handler http.Handler) {
+	srv := &http.Server{Addr: listenAddr, Handler: handler}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		log.Printf("Received shutdown signal, draining requests for up to %v", shutdownGrace)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownGrace)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Grace period over, closing remaining connections: %v", err)
+			srv.Close()
+		}
+	}()
+
+	log.Printf("Server listening on %s", listenAddr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+	// ListenAndServe returns as soon as Shutdown starts; wait for the drain
+	<-drained
+	log.Printf("Server shut down")
+}
+
+// federatedStats is the aggregated /api/stats response in federation mode.
+type federatedStats struct {
+	statsResponse
+	Upstreams []upstreamStats `json:"upstreams"`
+}
+
+type upstreamStats struct {
+	URL   string         `json:"url"`
+	Stats *statsResponse `json:"stats,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+// federatedPhones remembers which upstream assigned each phone, so returning
+// users are sent back to the hub holding their cluster.
+var federatedPhones = struct {
+	sync.Mutex
+	m map[string]string
+}{m: make(map[string]string)}
+
+// federatedMagicLinks remembers which upstream issued each magic link token
+// and until when it is valid, so POST /api/magic reaches that upstream.
+var federatedMagicLinks = struct {
+	sync.Mutex
+	m map[string]federatedMagicLink
+}{m: make(map[string]federatedMagicLink)}
+
+type federatedMagicLink struct {
+	upstream string
+	expires  time.Time
+}
+
+var upstreamClient = &http.Client{Timeout: 2 * time.Minute}
+
+// runFederation serves the client against several downstream prelude servers
+// instead of a Hive pool: /api/stats merges their availability and /api/claim
+// is proxied to the upstream with the most available clusters.
+func runFederation(upstreams []string) {
+	if len(upstreams) == 0 {
+		log.Fatalf("--upstream requires at least one URL")
+	}
+	log.Printf("Federation mode, upstreams: %s", strings.Join(upstreams, ", "))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/api/config", handleConfig)
+	mux.HandleFunc("/api/stats", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(fetchFederatedStats(upstreams))
+	})
+	mux.HandleFunc("/api/validate", handleValidate)
+	mux.HandleFunc("/api/claim", func(w http.ResponseWriter, r *http.Request) {
+		handleFederatedClaim(w, r, upstreams)
+	})
+	mux.HandleFunc("/api/claim/exists", func(w http.ResponseWriter, r *http.Request) {
+		handleFederatedClaimExists(w, r, upstreams)
+	})
+	mux.HandleFunc("/api/extend", func(w http.ResponseWriter, r *http.Request) {
+		handleFederatedExtend(w, r, upstreams)
+	})
+	mux.HandleFunc("/api/magic", handleFederatedMagicLink)
+
+	staticDir := filepath.Join("..", "client", "out")
+	mux.Handle("/", http.FileServer(http.Dir(staticDir)))
+
+	serve(mux)
+}
+
+// fetchFederatedStats queries every upstream's /api/stats concurrently and
+// sums the results. Unreachable upstreams are reported but not counted.
+func fetchFederatedStats(upstreams []string) federatedStats {
+	results := make([]upstreamStats, len(upstreams))
+	var wg sync.WaitGroup
+	for i, u := range upstreams {
+		wg.Add(1)
+		go func(i int, u string) {
+			defer wg.Done()
+			results[i] = upstreamStats{URL: u}
+			s, err := fetchUpstreamStats(u)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].Stats = s
+		}(i, u)
+	}
+	wg.Wait()
+
+	agg := federatedStats{Upstreams: results}
+	for _, r := range results {
+		if r.Stats == nil {
+			continue
+		}
+		agg.Deployments += r.Stats.Deployments
+		agg.Claims += r.Stats.Claims
+		agg.Ready += r.Stats.Ready
+		agg.Available += r.Stats.Available
+		agg.Claimed += r.Stats.Claimed
+		agg.InGrace += r.Stats.InGrace
+		for track, n := range r.Stats.Tracks {
+			if agg.Tracks == nil {
+				agg.Tracks = map[string]int{}
+			}
+			agg.Tracks[track] += n
+		}
+	}
+	return agg
+}
+
+func fetchUpstreamStats(upstream string) (*statsResponse, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(upstream + "/api/stats")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var s statsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		return nil, fmt.Errorf("decoding stats: %w", err)
+	}
+	return &s, nil
+}
+
+// handleFederatedClaim proxies POST /api/claim to the upstream that already
+// holds this phone's cluster, or else the one with the most available clusters.
+func handleFederatedClaim(w http.ResponseWriter, r *http.Request, upstreams []string) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	var req claimRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	phone := sanitizePhone(strings.TrimSpace(req.Phone))
+
+	target := federatedUpstream(r, phone, upstreams)
+	if target == "" {
+		best := 0
+		for _, u := range fetchFederatedStats(upstreams).Upstreams {
+			if u.Stats != nil && u.Stats.Available > best {
+				best = u.Stats.Available
+				target = u.URL
+			}
+		}
+	}
+	if target == "" {
+		writeAllClustersInUse(w)
+		return
+	}
+
+	status, respBody, ok := proxyToUpstream(w, r, target, body)
+	if !ok {
+		return
+	}
+
+	// The phone now has a cluster there, being set up or ready, or its device
+	// does: either way another upstream must not hand it a second one.
+	var answer struct {
+		Error     string `json:"error"`
+		MagicLink string `json:"magicLink"`
+	}
+	json.Unmarshal(respBody, &answer)
+	if phone != "" && (status == http.StatusOK || status == http.StatusAccepted ||
+		status == http.StatusConflict && answer.Error == "device_already_claimed") {
+		rememberUpstream(phone, target)
+	}
+	if link, err := url.Parse(answer.MagicLink); status == http.StatusOK && err == nil {
+		if token := link.Query().Get("token"); token != "" {
+			federatedMagicLinks.Lock()
+			for t, l := range federatedMagicLinks.m {
+				if time.Now().After(l.expires) {
+					delete(federatedMagicLinks.m, t)
+				}
+			}
+			federatedMagicLinks.m[token] = federatedMagicLink{upstream: target, expires: time.Now().Add(magicLinkTTL)}
+			federatedMagicLinks.Unlock()
+		}
+	}
+}
+
+// handleFederatedClaimExists proxies GET /api/claim/exists to the upstream
+// holding the phone's cluster. Phones no upstream has are answered by the
+// first one.
+func handleFederatedClaimExists(w http.ResponseWriter, r *http.Request, upstreams []string) {
+	if r.Method != http.MethodGet {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+	phone := sanitizePhone(strings.TrimSpace(r.URL.Query().Get("phone")))
+	target := federatedUpstream(r, phone, upstreams)
+	if target == "" {
+		target = upstreams[0]
+	}
+	proxyToUpstream(w, r, target, nil)
+}
+
+// handleFederatedExtend proxies POST /api/extend to the upstream holding the
+// phone's cluster.
+func handleFederatedExtend(w http.ResponseWriter, r *http.Request, upstreams []string) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	var req extendRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	target := federatedUpstream(r, sanitizePhone(strings.TrimSpace(req.Phone)), upstreams)
+	if target == "" {
+		writeJSONError(w, http.StatusNotFound, "claim_not_found", "No cluster is assigned to this phone")
+		return
+	}
+	proxyToUpstream(w, r, target, body)
+}
+
+// handleFederatedMagicLink proxies POST /api/magic to the upstream that issued
+// the token. Each token is forwarded once, as it can only be redeemed once.
+func handleFederatedMagicLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	var req magicLinkRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "Invalid request body")
+		return
+	}
+	federatedMagicLinks.Lock()
+	l, ok := federatedMagicLinks.m[req.Token]
+	delete(federatedMagicLinks.m, req.Token)
+	federatedMagicLinks.Unlock()
+	if !ok || time.Now().After(l.expires) {
+		writeJSONError(w, http.StatusNotFound, "invalid_magic_link", "The link is invalid or has already been used")
+		return
+	}
+	proxyToUpstream(w, r, l.upstream, body)
+}
+
+// rememberUpstream records that phone's cluster is on upstream.
+func rememberUpstream(phone, upstream string) {
+	federatedPhones.Lock()
+	previous := federatedPhones.m[phone]
+	federatedPhones.m[phone] = upstream
+	federatedPhones.Unlock()
+	if previous != upstream {
+		log.Printf("Phone %s claimed via upstream %s", phone, upstream)
+	}
+}
+
+// federatedUpstream returns the upstream holding phone's cluster: the one
+// remembered from its claim, or else, as after a restart, the first upstream
+// whose /api/claim/exists reports the phone, which is then remembered. It
+// returns "" when no upstream has the phone.
+func federatedUpstream(r *http.Request, phone string, upstreams []string) string {
+	if phone == "" {
+		return ""
+	}
+	federatedPhones.Lock()
+	target := federatedPhones.m[phone]
+	federatedPhones.Unlock()
+	if target != "" {
+		return target
+	}
+
+	query := url.Values{"phone": {phone}}
+	if pool := r.URL.Query().Get("pool"); pool != "" {
+		query.Set("pool", pool)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, u := range upstreams {
+		resp, err := client.Get(u + "/api/claim/exists?" + query.Encode())
+		if err != nil {
+			log.Printf("Error looking up phone %s on %s: %v", phone, u, err)
+			continue
+		}
+		var exists claimExistsResponse
+		err = json.NewDecoder(resp.Body).Decode(&exists)
+		resp.Body.Close()
+		if err == nil && resp.StatusCode == http.StatusOK && exists.Exists {
+			rememberUpstream(phone, u)
+			return u
+		}
+	}
+	return ""
+}
+
+// proxyToUpstream forwards r, with body, to the same path and query on target
+// and relays the answer. It returns the upstream's status and body, or false
+// when target couldn't be reached and a 502 was written instead.
+func proxyToUpstream(w http.ResponseWriter, r *http.Request, target string, body []byte) (int, []byte, bool) {
+	u := target + r.URL.Path
+	if r.URL.RawQuery != "" {
+		u += "?" + r.URL.RawQuery
+	}
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, u, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Error building request to %s: %v", target, err)
+		writeJSONError(w, http.StatusBadGateway, "upstream_unavailable", "Failed to reach upstream")
+		return 0, nil, false
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := upstreamClient.Do(req)
+	if err != nil {
+		log.Printf("Error proxying %s to %s: %v", r.URL.Path, target, err)
+		writeJSONError(w, http.StatusBadGateway, "upstream_unavailable", "Failed to reach upstream")
+		return 0, nil, false
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		log.Printf("Error reading answer to %s from %s: %v", r.URL.Path, target, err)
+		writeJSONError(w, http.StatusBadGateway, "upstream_unavailable", "Failed to reach upstream")
+		return 0, nil, false
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		w.Header().Set("Content-Type", ct)
+	}
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+	return resp.StatusCode, respBody, true
+}
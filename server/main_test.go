@@ -0,0 +1,2007 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/dynamic"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+const testPool = "prelude-test"
+
+// testKubeconfig is a minimal kubeconfig that clientcmd.Load accepts.
+func testKubeconfig(server string) string {
+	return `apiVersion: v1
+kind: Config
+clusters:
+- name: spoke
+  cluster:
+    server: ` + server + `
+contexts:
+- name: spoke
+  context:
+    cluster: spoke
+    user: admin
+current-context: spoke
+users:
+- name: admin
+  user:
+    token: test
+`
+}
+
+// testClaim builds a pool claim bound to cluster (empty for unbound) with the
+// given labels.
+func testClaim(name, cluster string, labels map[string]string) *unstructured.Unstructured {
+	claim := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": clusterClaimGVR.GroupVersion().String(),
+		"kind":       "ClusterClaim",
+		"metadata": map[string]interface{}{
+			"name": name,
+		},
+		"spec": map[string]interface{}{
+			"clusterPoolName": testPool,
+		},
+	}}
+	if cluster != "" {
+		unstructured.SetNestedField(claim.Object, cluster, "spec", "namespace")
+	}
+	claim.SetLabels(labels)
+	return claim
+}
+
+// testDeployment builds a ready ClusterDeployment for cluster, with a console
+// URL and an admin kubeconfig secret ref.
+func testDeployment(cluster string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": clusterDeploymentGVR.GroupVersion().String(),
+		"kind":       "ClusterDeployment",
+		"metadata": map[string]interface{}{
+			"name":      cluster,
+			"namespace": cluster,
+			"labels": map[string]interface{}{
+				"hive.openshift.io/clusterpool-name": testPool,
+			},
+		},
+		"spec": map[string]interface{}{
+			"clusterMetadata": map[string]interface{}{
+				"adminKubeconfigSecretRef": map[string]interface{}{
+					"name": cluster + "-admin-kubeconfig",
+				},
+			},
+		},
+		"status": map[string]interface{}{
+			"webConsoleURL": "https://console-openshift-console.apps." + cluster + ".example.com",
+		},
+	}}
+}
+
+// testSecrets returns the admin and user kubeconfig secrets of cluster.
+func testSecrets(cluster string) []runtime.Object {
+	var objs []runtime.Object
+	for _, kind := range []string{"admin", "user"} {
+		objs = append(objs, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: cluster + "-" + kind + "-kubeconfig", Namespace: cluster},
+			Data:       map[string][]byte{"kubeconfig": []byte(testKubeconfig("https://api." + cluster + ".example.com:6443"))},
+		})
+	}
+	return objs
+}
+
+// testDynamicClient is a fake dynamic client holding objs, which knows the
+// Hive list kinds the server uses.
+func testDynamicClient(objs ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		clusterClaimGVR:      "ClusterClaimList",
+		clusterDeploymentGVR: "ClusterDeploymentList",
+	}, objs...)
+}
+
+// claimFixture is a hub with ready clusters: claims in a memClaimStore, which
+// is installed as claimStore for the test, and their deployments and
+// kubeconfig secrets in fake clients.
+type claimFixture struct {
+	store     *memClaimStore
+	dynClient dynamic.Interface
+	clientset kubernetes.Interface
+}
+
+// newClaimFixture creates one claim per cluster. Claims are authenticated and
+// unassigned unless labels overrides them by claim name.
+func newClaimFixture(t *testing.T, clusters []string, labels map[string]map[string]string) *claimFixture {
+	t.Helper()
+	store := newMemClaimStore()
+	var deployments, secrets []runtime.Object
+	for i, cluster := range clusters {
+		name := "prelude" + strconv.Itoa(i+1)
+		l, ok := labels[name]
+		if !ok {
+			l = map[string]string{"prelude-auth": "done"}
+		}
+		if _, err := store.CreateClaim(context.Background(), testPool, testClaim(name, cluster, l)); err != nil {
+			t.Fatalf("creating claim %s: %v", name, err)
+		}
+		deployments = append(deployments, testDeployment(cluster))
+		secrets = append(secrets, testSecrets(cluster)...)
+	}
+	useClaimStore(t, store)
+	return &claimFixture{
+		store:     store,
+		dynClient: testDynamicClient(deployments...),
+		clientset: kubefake.NewSimpleClientset(secrets...),
+	}
+}
+
+// useClaimStore installs store as claimStore for the test, with the flag
+// defaults handleClaim depends on.
+func useClaimStore(t *testing.T, store ClaimStore) {
+	previous, previousLength, previousRetries := claimStore, fingerprintLength, assignRetries
+	claimStore, fingerprintLength, assignRetries = store, 16, 5
+	t.Cleanup(func() { claimStore, fingerprintLength, assignRetries = previous, previousLength, previousRetries })
+}
+
+// claim posts a claim request for phone and returns the recorded response.
+func (f *claimFixture) claim(t *testing.T, phone, fingerprint string) *httptest.ResponseRecorder {
+	t.Helper()
+	body, _ := json.Marshal(claimRequest{Phone: phone, Password: "secret", Fingerprint: fingerprint})
+	req := httptest.NewRequest(http.MethodPost, "/api/claim", strings.NewReader(string(body)))
+	w := httptest.NewRecorder()
+	handleClaim(w, req, f.dynClient, f.clientset, testPool, "2h")
+	return w
+}
+
+// errorCode decodes the error code of a writeJSONError response.
+func errorCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error body %q: %v", w.Body.String(), err)
+	}
+	return body.Error
+}
+
+// assignedClaim returns the name of the claim labeled with phone, or "".
+func (f *claimFixture) assignedClaim(t *testing.T, phone string) string {
+	t.Helper()
+	list, err := f.store.ListClaims(context.Background(), testPool, metav1.ListOptions{LabelSelector: "prelude=" + phone})
+	if err != nil {
+		t.Fatalf("listing claims: %v", err)
+	}
+	if len(list.Items) > 1 {
+		t.Fatalf("phone %s holds %d claims", phone, len(list.Items))
+	}
+	if len(list.Items) == 0 {
+		return ""
+	}
+	return list.Items[0].GetName()
+}
+
+func TestHandleClaimAssignsAndReturns(t *testing.T) {
+	f := newClaimFixture(t, []string{"cluster-a"}, nil)
+
+	w := f.claim(t, "15551230001", "abcdef")
+	if w.Code != http.StatusOK {
+		t.Fatalf("first claim: status %d, body %s", w.Code, w.Body.String())
+	}
+	var resp claimResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if !strings.Contains(resp.WebConsoleURL, "cluster-a") || !strings.Contains(resp.Kubeconfig, "api.cluster-a.example.com") {
+		t.Errorf("response is for the wrong cluster: %+v", resp)
+	}
+	if resp.ExpiresAt == "" {
+		t.Error("response has no expiry")
+	}
+
+	claim, err := f.store.GetClaim(context.Background(), testPool, "prelude1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := claim.GetLabels()["prelude"]; got != "15551230001" {
+		t.Errorf("prelude label = %q, want the phone", got)
+	}
+	if got := claim.GetLabels()["prelude-fp"]; got != "abcdef" {
+		t.Errorf("prelude-fp label = %q, want the fingerprint", got)
+	}
+	if lifetime, _, _ := unstructured.NestedString(claim.Object, "spec", "lifetime"); lifetime == "" {
+		t.Error("spec.lifetime was not set")
+	}
+
+	// The same phone gets the same cluster back
+	if w := f.claim(t, "15551230001", "abcdef"); w.Code != http.StatusOK {
+		t.Fatalf("returning claim: status %d, body %s", w.Code, w.Body.String())
+	}
+	if got := f.assignedClaim(t, "15551230001"); got != "prelude1" {
+		t.Errorf("returning phone holds %q, want prelude1", got)
+	}
+}
+
+func TestHandleClaimAllInUse(t *testing.T) {
+	f := newClaimFixture(t, []string{"cluster-a"}, map[string]map[string]string{
+		"prelude1": {"prelude-auth": "done", "prelude": "15551230001"},
+	})
+
+	w := f.claim(t, "15551230002", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want %d, body %s", w.Code, http.StatusNotFound, w.Body.String())
+	}
+	if code := errorCode(t, w); code != "all_clusters_in_use" {
+		t.Errorf("error code %q, want all_clusters_in_use", code)
+	}
+	if got := f.assignedClaim(t, "15551230002"); got != "" {
+		t.Errorf("phone was assigned %s with no cluster free", got)
+	}
+}
+
+func TestHandleClaimAuthenticating(t *testing.T) {
+	f := newClaimFixture(t, []string{"cluster-a", "cluster-b"}, map[string]map[string]string{
+		"prelude1": {"prelude": "15551230001"},
+	})
+
+	w := f.claim(t, "15551230001", "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body.String())
+	}
+	if code := errorCode(t, w); code != "cluster_authenticating" {
+		t.Errorf("error code %q, want cluster_authenticating", code)
+	}
+	// The phone must not be handed the free cluster as well
+	claim, err := f.store.GetClaim(context.Background(), testPool, "prelude2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if phone := claim.GetLabels()["prelude"]; phone != "" {
+		t.Errorf("free claim was assigned to %s", phone)
+	}
+}
+
+func TestHandleClaimSkipsUnauthenticated(t *testing.T) {
+	f := newClaimFixture(t, []string{"cluster-a", "cluster-b"}, map[string]map[string]string{
+		"prelude1": {},
+	})
+
+	if w := f.claim(t, "15551230001", ""); w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	if got := f.assignedClaim(t, "15551230001"); got != "prelude2" {
+		t.Errorf("phone holds %q, want the authenticated prelude2", got)
+	}
+
+	// Once the authenticator is done the other cluster is handed out too
+	if err := f.store.SetAuthenticated(context.Background(), testPool, "prelude1"); err != nil {
+		t.Fatal(err)
+	}
+	if w := f.claim(t, "15551230002", ""); w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	if got := f.assignedClaim(t, "15551230002"); got != "prelude1" {
+		t.Errorf("second phone holds %q, want prelude1", got)
+	}
+}
+
+func TestMemClaimStoreConflict(t *testing.T) {
+	store := newMemClaimStore()
+	ctx := context.Background()
+	if _, err := store.CreateClaim(ctx, testPool, testClaim("prelude1", "cluster-a", nil)); err != nil {
+		t.Fatal(err)
+	}
+	stale, _ := store.GetClaim(ctx, testPool, "prelude1")
+	if err := store.PatchClaim(ctx, testPool, "prelude1", map[string]interface{}{"prelude-auth": "done"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	err := store.AssignClaim(ctx, testPool, stale, map[string]interface{}{"prelude": "15551230001"}, nil, "")
+	if !k8serrors.IsConflict(err) {
+		t.Errorf("AssignClaim with a stale resourceVersion: err = %v, want a conflict", err)
+	}
+}
+
+// bindOnList is a claim store where Hive binds the named claim just after
+// every list, before the lister gets to patch it.
+type bindOnList struct {
+	*memClaimStore
+	name, cluster string
+}
+
+func (b bindOnList) ListClaims(ctx context.Context, pool string, opts metav1.ListOptions) (*unstructured.UnstructuredList, error) {
+	list, err := b.memClaimStore.ListClaims(ctx, pool, opts)
+	b.Lock()
+	defer b.Unlock()
+	if claim, ok := b.claims[b.key(pool, b.name)]; ok {
+		unstructured.SetNestedField(claim.Object, b.cluster, "spec", "namespace")
+		b.bump(claim)
+	}
+	return list, err
+}
+
+func TestReconcileUnboundClaimsSkipsJustBound(t *testing.T) {
+	store := newMemClaimStore()
+	ctx := context.Background()
+	for i, name := range []string{"prelude1", "prelude2"} {
+		phone := map[string]string{"prelude": "1555123000" + strconv.Itoa(i+1)}
+		if _, err := store.CreateClaim(ctx, testPool, testClaim(name, "", phone)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Lock()
+	for _, claim := range store.claims {
+		claim.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-2 * unboundClaimThreshold)))
+	}
+	store.Unlock()
+	useClaimStore(t, bindOnList{memClaimStore: store, name: "prelude2", cluster: "cluster-b"})
+
+	reconcileUnboundClaims(ctx, testPool)
+
+	if claim, _ := store.GetClaim(ctx, testPool, "prelude1"); claim.GetLabels()["prelude"] != "" {
+		t.Error("claim left unbound past the threshold kept its phone")
+	}
+	if claim, _ := store.GetClaim(ctx, testPool, "prelude2"); claim.GetLabels()["prelude"] == "" {
+		t.Error("claim bound after the list lost its phone")
+	}
+}
+
+// TestHandleClaimConflictRetries races a second replica against the handler on
+// the Hive path: the first assignment Patch finds the claim already taken by
+// the other replica and answers 409, so the handler must re-read it and move
+// on to the other free claim rather than fail or double-assign.
+func TestHandleClaimConflictRetries(t *testing.T) {
+	var objs []runtime.Object
+	for i, cluster := range []string{"cluster-a", "cluster-b"} {
+		claim := testClaim("prelude"+strconv.Itoa(i+1), cluster, map[string]string{"prelude-auth": "done"})
+		claim.SetNamespace(clusterPoolNamespace)
+		objs = append(objs, claim, testDeployment(cluster))
+	}
+	dynClient := testDynamicClient(objs...)
+
+	raced := ""
+	dynClient.PrependReactor("patch", "clusterclaims", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if raced != "" {
+			return false, nil, nil
+		}
+		// The other replica wins the claim between our read and our write
+		raced = action.(clienttesting.PatchAction).GetName()
+		obj, err := dynClient.Tracker().Get(clusterClaimGVR, clusterPoolNamespace, raced)
+		if err != nil {
+			return true, nil, err
+		}
+		claim := obj.(*unstructured.Unstructured)
+		labels := claim.GetLabels()
+		labels["prelude"] = "15551239999"
+		claim.SetLabels(labels)
+		if err := dynClient.Tracker().Update(clusterClaimGVR, claim, clusterPoolNamespace); err != nil {
+			return true, nil, err
+		}
+		return true, nil, k8serrors.NewConflict(clusterClaimGVR.GroupResource(), raced, errors.New("the object has been modified"))
+	})
+
+	useClaimStore(t, dynamicClaimStore{dynClient})
+	f := &claimFixture{dynClient: dynClient, clientset: kubefake.NewSimpleClientset(append(testSecrets("cluster-a"), testSecrets("cluster-b")...)...)}
+
+	if w := f.claim(t, "15551230001", ""); w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	if raced == "" {
+		t.Fatal("the assignment never patched a claim")
+	}
+
+	list, err := claimStore.ListClaims(context.Background(), testPool, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, claim := range list.Items {
+		phone := claim.GetLabels()["prelude"]
+		if claim.GetName() == raced && phone != "15551239999" {
+			t.Errorf("raced claim %s was taken from the other replica's phone by %q", raced, phone)
+		}
+		if claim.GetName() != raced && phone != "15551230001" {
+			t.Errorf("claim %s holds %q, want the phone that lost the race", claim.GetName(), phone)
+		}
+	}
+}
+
+// TestHandleClaimConcurrent has two requests race for the last free cluster.
+// Exactly one may win it; the other is told all clusters are in use.
+func TestHandleClaimConcurrent(t *testing.T) {
+	f := newClaimFixture(t, []string{"cluster-a"}, nil)
+
+	codes := make(chan int, 2)
+	var wg sync.WaitGroup
+	for _, phone := range []string{"15551230001", "15551230002"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- f.claim(t, phone, "").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	won := 0
+	for code := range codes {
+		switch code {
+		case http.StatusOK:
+			won++
+		case http.StatusNotFound:
+		default:
+			t.Errorf("unexpected status %d", code)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d requests won the only cluster, want 1", won)
+	}
+	claim, err := f.store.GetClaim(context.Background(), testPool, "prelude1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claim.GetLabels()["prelude"] == "" {
+		t.Error("the cluster was not assigned")
+	}
+}
+
+// TestHandleClaimStatusCodes keeps the three ways a claim can fail apart: a
+// phone whose cluster is still being set up, no free cluster, and a device
+// that already holds another phone's cluster.
+func TestHandleClaimStatusCodes(t *testing.T) {
+	tests := []struct {
+		name     string
+		labels   map[string]map[string]string
+		phone    string
+		want     int
+		wantCode string
+	}{
+		{
+			name:     "authenticating",
+			labels:   map[string]map[string]string{"prelude1": {"prelude": "15551230001"}},
+			phone:    "15551230001",
+			want:     http.StatusAccepted,
+			wantCode: "cluster_authenticating",
+		},
+		{
+			name:     "all in use",
+			labels:   map[string]map[string]string{"prelude1": {"prelude-auth": "done", "prelude": "15551230002"}},
+			phone:    "15551230001",
+			want:     http.StatusNotFound,
+			wantCode: "all_clusters_in_use",
+		},
+		{
+			name:     "device already claimed",
+			labels:   map[string]map[string]string{"prelude1": {"prelude-auth": "done", "prelude": "15551230002", "prelude-fp": "abcdef"}},
+			phone:    "15551230001",
+			want:     http.StatusConflict,
+			wantCode: "device_already_claimed",
+		},
+		{
+			name:  "assigned",
+			phone: "15551230001",
+			want:  http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newClaimFixture(t, []string{"cluster-a"}, tt.labels)
+			w := f.claim(t, tt.phone, "abcdef")
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d, body %s", w.Code, tt.want, w.Body.String())
+			}
+			if tt.wantCode != "" {
+				if code := errorCode(t, w); code != tt.wantCode {
+					t.Errorf("error code %q, want %q", code, tt.wantCode)
+				}
+			}
+		})
+	}
+}
+
+// TestHandleClaimDeploymentGetFails checks a claim is never labeled when its
+// ClusterDeployment can't be read after the claim was selected: a vanished
+// deployment moves on to another claim, any other error fails the request,
+// and neither leaves a phantom assignment behind.
+func TestHandleClaimDeploymentGetFails(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		want     int
+		wantCode string
+	}{
+		{name: "not found", err: k8serrors.NewNotFound(clusterDeploymentGVR.GroupResource(), "cluster-a"), want: http.StatusNotFound, wantCode: "all_clusters_in_use"},
+		{name: "server error", err: k8serrors.NewInternalError(errors.New("etcd unavailable")), want: http.StatusInternalServerError, wantCode: "internal_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newClaimFixture(t, []string{"cluster-a"}, nil)
+			f.dynClient.(*dynamicfake.FakeDynamicClient).PrependReactor("get", "clusterdeployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+				return true, nil, tt.err
+			})
+
+			w := f.claim(t, "15551230001", "abcdef")
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d, body %s", w.Code, tt.want, w.Body.String())
+			}
+			if code := errorCode(t, w); code != tt.wantCode {
+				t.Errorf("error code %q, want %q", code, tt.wantCode)
+			}
+			claim, err := f.store.GetClaim(context.Background(), testPool, "prelude1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if labels := claim.GetLabels(); labels["prelude"] != "" || labels["prelude-fp"] != "" {
+				t.Errorf("claim was labeled %v despite the failed deployment read", labels)
+			}
+			if lifetime, _, _ := unstructured.NestedString(claim.Object, "spec", "lifetime"); lifetime != "" {
+				t.Errorf("claim lifetime was set to %s despite the failed deployment read", lifetime)
+			}
+		})
+	}
+}
+
+func TestHandleClaimSkipsVanishedDeployment(t *testing.T) {
+	f := newClaimFixture(t, []string{"cluster-a", "cluster-b"}, nil)
+	f.dynClient.(*dynamicfake.FakeDynamicClient).PrependReactor("get", "clusterdeployments", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		if name := action.(clienttesting.GetAction).GetName(); name == "cluster-a" {
+			return true, nil, k8serrors.NewNotFound(clusterDeploymentGVR.GroupResource(), name)
+		}
+		return false, nil, nil
+	})
+
+	if w := f.claim(t, "15551230001", ""); w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	if got := f.assignedClaim(t, "15551230001"); got != "prelude2" {
+		t.Errorf("phone holds %q, want prelude2 whose deployment exists", got)
+	}
+}
+
+// TestConcurrentClaimLabelWrites has the server's and the authenticator's
+// label writers hit the same claim at once. Each merge-patches only its own
+// keys, so none of them may be lost.
+func TestConcurrentClaimLabelWrites(t *testing.T) {
+	claim := testClaim("prelude1", "cluster-a", map[string]string{"prelude-index": "1"})
+	claim.SetNamespace(clusterPoolNamespace)
+	store := dynamicClaimStore{testDynamicClient(claim)}
+	ctx := context.Background()
+
+	writes := []func() error{
+		func() error { return store.SetAuthenticated(ctx, testPool, "prelude1") },
+		func() error {
+			return store.PatchClaim(ctx, testPool, "prelude1", map[string]interface{}{"prelude": "15551230001"}, nil)
+		},
+		func() error {
+			return store.PatchClaim(ctx, testPool, "prelude1", map[string]interface{}{"prelude-fp": "abcdef"}, nil)
+		},
+		func() error {
+			return store.PatchClaim(ctx, testPool, "prelude1", nil, map[string]interface{}{"prelude-claimed-at": "1700000000"})
+		},
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(writes)*10)
+	for i := 0; i < 10; i++ {
+		for _, write := range writes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- write()
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("label write failed: %v", err)
+		}
+	}
+
+	got, err := store.GetClaim(ctx, testPool, "prelude1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"prelude-index": "1", "prelude-auth": "done", "prelude": "15551230001", "prelude-fp": "abcdef"}
+	for key, value := range want {
+		if got.GetLabels()[key] != value {
+			t.Errorf("label %s = %q, want %q; labels %v", key, got.GetLabels()[key], value, got.GetLabels())
+		}
+	}
+	if got.GetAnnotations()["prelude-claimed-at"] != "1700000000" {
+		t.Errorf("annotation lost: %v", got.GetAnnotations())
+	}
+}
+
+func TestSanitizeFingerprint(t *testing.T) {
+	hex40 := strings.Repeat("0123456789", 4)
+	tests := []struct {
+		length int
+		in     string
+		want   string
+	}{
+		{length: 16, in: "", want: ""},
+		{length: 16, in: hex40[:15], want: hex40[:15]},
+		{length: 16, in: hex40[:16], want: hex40[:16]},
+		{length: 16, in: hex40[:17], want: hex40[:16]},
+		{length: 32, in: hex40[:31], want: hex40[:31]},
+		{length: 32, in: hex40[:32], want: hex40[:32]},
+		{length: 32, in: hex40, want: hex40[:32]},
+		{length: 1, in: "abc", want: "a"},
+		// Non-hex characters are dropped, not counted
+		{length: 4, in: "a-b_c.dEf", want: "abcd"},
+		{length: 16, in: "zz<script>", want: "c"},
+	}
+	previous := fingerprintLength
+	t.Cleanup(func() { fingerprintLength = previous })
+	for _, tt := range tests {
+		fingerprintLength = tt.length
+		got := sanitizeFingerprint(tt.in)
+		if got != tt.want {
+			t.Errorf("sanitizeFingerprint(%q) with length %d = %q, want %q", tt.in, tt.length, got, tt.want)
+		}
+		if errs := validation.IsValidLabelValue(got); len(errs) > 0 {
+			t.Errorf("sanitizeFingerprint(%q) = %q is not a valid label value: %v", tt.in, got, errs)
+		}
+	}
+}
+
+// fakeKeycloak serves the Keycloak calls updateKeycloakPassword makes and
+// records the password set in each realm.
+func fakeKeycloak(t *testing.T) (*httptest.Server, func() map[string]string) {
+	var mu sync.Mutex
+	passwords := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/protocol/openid-connect/token"):
+			json.NewEncoder(w).Encode(map[string]string{"access_token": "token"})
+		case strings.HasSuffix(r.URL.Path, "/users") && r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode([]map[string]string{{"id": "admin-id"}})
+		case strings.HasSuffix(r.URL.Path, "/reset-password"):
+			var body struct {
+				Value string `json:"value"`
+			}
+			json.NewDecoder(r.Body).Decode(&body)
+			mu.Lock()
+			passwords[parts[2]] = body.Value // admin/realms/<realm>/...
+			mu.Unlock()
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() map[string]string {
+		mu.Lock()
+		defer mu.Unlock()
+		return maps.Clone(passwords)
+	}
+}
+
+// TestHandleClaimReassignKeepsPassword follows a phone whose cluster died:
+// the claim is released with cluster_unavailable, and the client's retry with
+// the same password lands on a replacement cluster that gets that password.
+func TestHandleClaimReassignKeepsPassword(t *testing.T) {
+	f := newClaimFixture(t, []string{"cluster-a", "cluster-b"}, map[string]map[string]string{
+		"prelude1": {"prelude-auth": "done", "prelude": "15551230001"},
+	})
+	// cluster-a's console has been unreachable for longer than the grace
+	since := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	if err := f.store.PatchClaim(context.Background(), testPool, "prelude1", nil, map[string]interface{}{"prelude-unreachable-since": since}); err != nil {
+		t.Fatal(err)
+	}
+	console := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(console.Close)
+	deployments := f.dynClient.(*dynamicfake.FakeDynamicClient)
+	for cluster, url := range map[string]string{"cluster-a": "https://127.0.0.1:1", "cluster-b": console.URL} {
+		cd := testDeployment(cluster)
+		unstructured.SetNestedField(cd.Object, url, "status", "webConsoleURL")
+		if err := deployments.Tracker().Update(clusterDeploymentGVR, cd, cluster); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keycloak, passwords := fakeKeycloak(t)
+
+	previousProbe, previousGrace, previousURL, previousSecret := probeConsole, unreachableGrace, keycloakURL, keycloakClientSecret
+	probeConsole, unreachableGrace, keycloakURL, keycloakClientSecret = true, 2*time.Minute, keycloak.URL, "client-secret"
+	t.Cleanup(func() {
+		probeConsole, unreachableGrace, keycloakURL, keycloakClientSecret = previousProbe, previousGrace, previousURL, previousSecret
+	})
+
+	w := f.claim(t, "15551230001", "")
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want %d, body %s", w.Code, http.StatusAccepted, w.Body.String())
+	}
+	if code := errorCode(t, w); code != "cluster_unavailable" {
+		t.Fatalf("error code %q, want cluster_unavailable", code)
+	}
+	if got := f.assignedClaim(t, "15551230001"); got != "" {
+		t.Fatalf("phone still holds %s after its cluster was released", got)
+	}
+
+	// The client retries with the password it still holds
+	if w := f.claim(t, "15551230001", ""); w.Code != http.StatusOK {
+		t.Fatalf("retry: status %d, body %s", w.Code, w.Body.String())
+	}
+	if got := f.assignedClaim(t, "15551230001"); got != "prelude2" {
+		t.Errorf("phone holds %q after the retry, want the replacement prelude2", got)
+	}
+	if got := passwords()["cluster-b"]; got != "secret" {
+		t.Errorf("replacement cluster password = %q, want the password the user submitted", got)
+	}
+}
+
+// markDeleting sets a deletionTimestamp on a stored claim, as Hive does while
+// it deprovisions the cluster.
+func (f *claimFixture) markDeleting(name string) {
+	f.store.Lock()
+	defer f.store.Unlock()
+	now := metav1.Now()
+	f.store.claims[f.store.key(testPool, name)].SetDeletionTimestamp(&now)
+}
+
+func TestHandleClaimSkipsDeletingClaims(t *testing.T) {
+	f := newClaimFixture(t, []string{"cluster-a", "cluster-b", "cluster-c"}, map[string]map[string]string{
+		"prelude1": {"prelude-auth": "done", "prelude": "15551230001"},
+	})
+	f.markDeleting("prelude1")
+	f.markDeleting("prelude2")
+
+	// The phone's own cluster is going away, so it gets a fresh one
+	if w := f.claim(t, "15551230001", ""); w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	var resp claimResponse
+	json.NewDecoder(f.claim(t, "15551230001", "").Body).Decode(&resp)
+	if !strings.Contains(resp.WebConsoleURL, "cluster-c") {
+		t.Errorf("phone was handed %s, want cluster-c, the only claim not being deleted", resp.WebConsoleURL)
+	}
+
+	// The free claim being deleted is never handed out
+	w := f.claim(t, "15551230002", "")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want %d, body %s", w.Code, http.StatusNotFound, w.Body.String())
+	}
+	claim, err := f.store.GetClaim(context.Background(), testPool, "prelude2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if phone := claim.GetLabels()["prelude"]; phone != "" {
+		t.Errorf("claim being deleted was assigned to %s", phone)
+	}
+}
+
+// TestHandleClaimFingerprintFreedAfterReap checks a device isn't blocked by a
+// claim that no longer counts as active: one past its lifetime that Hive is
+// about to reap, or one the server released, which drops prelude-fp.
+func TestHandleClaimFingerprintFreedAfterReap(t *testing.T) {
+	tests := []struct {
+		name string
+		reap func(f *claimFixture)
+	}{
+		{
+			name: "expired",
+			reap: func(f *claimFixture) {
+				f.store.Lock()
+				defer f.store.Unlock()
+				claim := f.store.claims[f.store.key(testPool, "prelude1")]
+				claim.SetCreationTimestamp(metav1.NewTime(time.Now().Add(-3 * time.Hour)))
+				unstructured.SetNestedField(claim.Object, "2h", "spec", "lifetime")
+			},
+		},
+		{
+			name: "released",
+			reap: func(f *claimFixture) {
+				if err := f.store.ReleaseClaim(context.Background(), testPool, "prelude1"); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newClaimFixture(t, []string{"cluster-a", "cluster-b"}, map[string]map[string]string{
+				"prelude1": {"prelude-auth": "done", "prelude": "15551230001", "prelude-fp": "abcdef"},
+			})
+
+			// While the first claim is active the device can't take a second phone
+			if w := f.claim(t, "15551230002", "abcdef"); w.Code != http.StatusConflict {
+				t.Fatalf("active claim: status %d, want %d", w.Code, http.StatusConflict)
+			}
+
+			tt.reap(f)
+			if w := f.claim(t, "15551230002", "abcdef"); w.Code != http.StatusOK {
+				t.Fatalf("after reaping: status %d, body %s", w.Code, w.Body.String())
+			}
+			if got := f.assignedClaim(t, "15551230002"); got != "prelude2" {
+				t.Errorf("new phone holds %q, want prelude2", got)
+			}
+		})
+	}
+}
+
+func TestHandleClaimMissingUserKubeconfig(t *testing.T) {
+	for _, fallback := range []bool{false, true} {
+		t.Run(fmt.Sprintf("fallback=%v", fallback), func(t *testing.T) {
+			f := newClaimFixture(t, []string{"cluster-a"}, nil)
+			// The authenticator hasn't written the user kubeconfig yet. The
+			// admin one points at its own server so the two can be told apart.
+			ctx := context.Background()
+			if err := f.clientset.CoreV1().Secrets("cluster-a").Delete(ctx, "cluster-a-user-kubeconfig", metav1.DeleteOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.clientset.CoreV1().Secrets("cluster-a").Update(ctx, &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "cluster-a-admin-kubeconfig", Namespace: "cluster-a"},
+				Data:       map[string][]byte{"kubeconfig": []byte(testKubeconfig("https://admin-api.cluster-a.example.com:6443"))},
+			}, metav1.UpdateOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			previous := adminKubeconfigFallback
+			adminKubeconfigFallback = fallback
+			t.Cleanup(func() { adminKubeconfigFallback = previous })
+
+			w := f.claim(t, "15551230001", "")
+			if !fallback {
+				if w.Code != http.StatusInternalServerError {
+					t.Fatalf("status %d, want %d without the fallback", w.Code, http.StatusInternalServerError)
+				}
+				if code := errorCode(t, w); code != "internal_error" {
+					t.Errorf("error code %q, want internal_error", code)
+				}
+				return
+			}
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+			}
+			var resp claimResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(resp.Kubeconfig, "admin-api.cluster-a.example.com") {
+				t.Errorf("kubeconfig = %q, want the admin kubeconfig", resp.Kubeconfig)
+			}
+		})
+	}
+}
+
+func TestSpokeBreakerIgnoresMaaSFailures(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := dead.URL
+	dead.Close()
+	maas := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "maas down", http.StatusInternalServerError)
+	}))
+	defer maas.Close()
+
+	breaker := newCircuitBreaker()
+	for _, maasURL := range []string{maas.URL, deadURL} {
+		for i := 0; i <= spokeBreakerThreshold; i++ {
+			err := updateMaaSCredentials(testKubeconfig(deadURL), maasURL, "token", "spoke", "2h", "")
+			if err == nil {
+				t.Fatalf("MaaS at %s: update succeeded", maasURL)
+			}
+			breaker.record("spoke", err)
+		}
+		if !breaker.allow("spoke") {
+			t.Fatalf("MaaS at %s failing opened the spoke circuit", maasURL)
+		}
+	}
+
+	// The console failing to dial does count
+	for i := 0; i < spokeBreakerThreshold; i++ {
+		breaker.record("spoke", probeConsoleURL(deadURL))
+	}
+	if breaker.allow("spoke") {
+		t.Error("spoke circuit still closed after the console failed to dial")
+	}
+}
+
+func TestDeploymentPlatform(t *testing.T) {
+	tests := []struct {
+		name       string
+		platforms  map[string]interface{}
+		wantName   string
+		wantRegion string
+	}{
+		{name: "empty", platforms: nil},
+		{name: "single", platforms: map[string]interface{}{"aws": map[string]interface{}{"region": "us-east-1"}}, wantName: "aws", wantRegion: "us-east-1"},
+		{
+			name: "aws alongside agentBareMetal",
+			platforms: map[string]interface{}{
+				"agentBareMetal": map[string]interface{}{},
+				"aws":            map[string]interface{}{"region": "eu-west-1"},
+			},
+			wantName:   "aws",
+			wantRegion: "eu-west-1",
+		},
+		{
+			name: "unknown keys ignored",
+			platforms: map[string]interface{}{
+				"aaaCustom": map[string]interface{}{"region": "nowhere"},
+				"vsphere":   map[string]interface{}{},
+			},
+			wantName: "vsphere",
+		},
+		{
+			name:       "non-map values ignored",
+			platforms:  map[string]interface{}{"aws": "us-east-1", "gcp": map[string]interface{}{"region": "us-central1"}},
+			wantName:   "gcp",
+			wantRegion: "us-central1",
+		},
+		{name: "only unknown", platforms: map[string]interface{}{"custom": map[string]interface{}{}}},
+	}
+	for _, tt := range tests {
+		// Map iteration order varies between runs; repeat so an order
+		// dependence shows up
+		for i := 0; i < 20; i++ {
+			name, region := deploymentPlatform(tt.platforms)
+			if name != tt.wantName || region != tt.wantRegion {
+				t.Errorf("%s: deploymentPlatform = %q, %q, want %q, %q", tt.name, name, region, tt.wantName, tt.wantRegion)
+				break
+			}
+		}
+	}
+}
+
+func TestAPIErrorsAreJSON(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  http.HandlerFunc
+		request  *http.Request
+		want     int
+		wantCode string
+	}{
+		{
+			name: "claim exists without phone",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				handleClaimExists(w, r, nil, testPool)
+			},
+			request:  httptest.NewRequest(http.MethodGet, "/api/claim/exists", nil),
+			want:     http.StatusBadRequest,
+			wantCode: "phone_required",
+		},
+		{
+			name: "admin note without name",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				handleAdminNote(w, r, testPool)
+			},
+			request:  httptest.NewRequest(http.MethodPost, "/api/admin/note", strings.NewReader(`{}`)),
+			want:     http.StatusBadRequest,
+			wantCode: "name_required",
+		},
+		{
+			name:     "magic wrong method",
+			handler:  handleMagicLink,
+			request:  httptest.NewRequest(http.MethodGet, "/api/magic", nil),
+			want:     http.StatusMethodNotAllowed,
+			wantCode: "method_not_allowed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, tt.request)
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d", w.Code, tt.want)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Content-Type %q, want application/json", ct)
+			}
+			if code := errorCode(t, w); code != tt.wantCode {
+				t.Errorf("error %q, want %q", code, tt.wantCode)
+			}
+		})
+	}
+}
+
+// fakeUpstream serves /api/stats with available clusters and answers
+// /api/claim with claimStatus and claimBody, recording the paths it was asked.
+func fakeUpstream(t *testing.T, available, claimStatus int, claimBody string) (*httptest.Server, *[]string) {
+	var mu sync.Mutex
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/api/stats":
+			json.NewEncoder(w).Encode(statsResponse{Available: available})
+		case "/api/claim":
+			w.WriteHeader(claimStatus)
+			fmt.Fprint(w, claimBody)
+		case "/api/claim/exists":
+			fmt.Fprint(w, `{"exists":false}`)
+		default:
+			fmt.Fprint(w, `{}`)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &paths
+}
+
+func TestFederatedClaimRemembersUpstream(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+	}{
+		{name: "authenticating", status: http.StatusAccepted, body: `{"error":"cluster_authenticating"}`},
+		{name: "device already claimed", status: http.StatusConflict, body: `{"error":"device_already_claimed"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() {
+				federatedPhones.Lock()
+				clear(federatedPhones.m)
+				federatedPhones.Unlock()
+			})
+			busy, busyPaths := fakeUpstream(t, 0, http.StatusOK, `{}`)
+			free, freePaths := fakeUpstream(t, 3, tt.status, tt.body)
+			upstreams := []string{busy.URL, free.URL}
+
+			w := httptest.NewRecorder()
+			handleFederatedClaim(w, httptest.NewRequest(http.MethodPost, "/api/claim", strings.NewReader(`{"phone":"15551230001"}`)), upstreams)
+			if w.Code != tt.status {
+				t.Fatalf("claim status %d, want %d relayed", w.Code, tt.status)
+			}
+
+			w = httptest.NewRecorder()
+			handleFederatedClaimExists(w, httptest.NewRequest(http.MethodGet, "/api/claim/exists?phone=15551230001", nil), upstreams)
+			w = httptest.NewRecorder()
+			handleFederatedExtend(w, httptest.NewRequest(http.MethodPost, "/api/extend", strings.NewReader(`{"phone":"15551230001"}`)), upstreams)
+			if w.Code != http.StatusOK {
+				t.Fatalf("extend status %d, want the upstream's 200", w.Code)
+			}
+
+			// The busy upstream is only asked for stats and, before the
+			// phone was known, whether it held it
+			for _, p := range *busyPaths {
+				if p != "/api/stats" && p != "/api/claim/exists" {
+					t.Errorf("busy upstream was asked %s", p)
+				}
+			}
+			if got := strings.Join(*freePaths, " "); !strings.HasSuffix(got, "/api/claim /api/claim/exists /api/extend") {
+				t.Errorf("upstream holding the phone was asked %q, want claim, exists and extend", got)
+			}
+		})
+	}
+}
+
+func TestFederatedExtendUnknownPhone(t *testing.T) {
+	up, _ := fakeUpstream(t, 1, http.StatusOK, `{}`)
+	w := httptest.NewRecorder()
+	handleFederatedExtend(w, httptest.NewRequest(http.MethodPost, "/api/extend", strings.NewReader(`{"phone":"15551239999"}`)), []string{up.URL})
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want 404", w.Code)
+	}
+	if code := errorCode(t, w); code != "claim_not_found" {
+		t.Errorf("error %q, want claim_not_found", code)
+	}
+}
+
+func TestFederatedMagicLinkRoutesToIssuer(t *testing.T) {
+	t.Cleanup(func() {
+		federatedPhones.Lock()
+		clear(federatedPhones.m)
+		federatedPhones.Unlock()
+	})
+	up, paths := fakeUpstream(t, 1, http.StatusOK, `{"magicLink":"/magic?token=abc123"}`)
+	upstreams := []string{up.URL}
+
+	w := httptest.NewRecorder()
+	handleFederatedClaim(w, httptest.NewRequest(http.MethodPost, "/api/claim", strings.NewReader(`{"phone":"15551230001"}`)), upstreams)
+	if w.Code != http.StatusOK {
+		t.Fatalf("claim status %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handleFederatedMagicLink(w, httptest.NewRequest(http.MethodPost, "/api/magic", strings.NewReader(`{"token":"abc123"}`)))
+	if w.Code != http.StatusOK || (*paths)[len(*paths)-1] != "/api/magic" {
+		t.Fatalf("redeem status %d, paths %v, want it proxied to the issuer", w.Code, *paths)
+	}
+
+	// Tokens are single use
+	w = httptest.NewRecorder()
+	handleFederatedMagicLink(w, httptest.NewRequest(http.MethodPost, "/api/magic", strings.NewReader(`{"token":"abc123"}`)))
+	if code := errorCode(t, w); w.Code != http.StatusNotFound || code != "invalid_magic_link" {
+		t.Errorf("second redeem %d %q, want 404 invalid_magic_link", w.Code, code)
+	}
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	rl := newRateLimiter(3, 3*time.Second)
+	for i := 0; i < 3; i++ {
+		if ok, _ := rl.allow("phone"); !ok {
+			t.Fatalf("attempt %d of the burst rejected", i+1)
+		}
+	}
+	if ok, first := rl.allow("phone"); ok || !first {
+		t.Fatalf("attempt past the burst = %v, first %v, want the first rejection", ok, first)
+	}
+	if ok, first := rl.allow("phone"); ok || first {
+		t.Fatalf("second rejection = %v, first %v, want a repeat rejection", ok, first)
+	}
+	if ok, _ := rl.allow("other"); !ok {
+		t.Fatal("another key shares the bucket")
+	}
+
+	// A third of the window refills one token
+	rl.m["phone"].updated = rl.m["phone"].updated.Add(-time.Second)
+	if ok, _ := rl.allow("phone"); !ok {
+		t.Fatal("refilled token not granted")
+	}
+	if ok, first := rl.allow("phone"); ok || !first {
+		t.Fatalf("after the refilled token = %v, first %v, want a new episode", ok, first)
+	}
+
+	// Buckets idle for a window are full again and swept
+	rl.m["phone"].updated = rl.m["phone"].updated.Add(-3 * time.Second)
+	rl.sweep()
+	if _, ok := rl.m["phone"]; ok {
+		t.Error("idle bucket not swept")
+	}
+	if _, ok := rl.m["other"]; !ok {
+		t.Error("recent bucket swept")
+	}
+}
+
+func TestOAuthAdminScopedToPool(t *testing.T) {
+	previousMode, previousClient, previousVerb, previousResource := adminAuthMode, adminAuthClient, adminVerb, adminResource
+	previousPools := servedPools()
+	poolNamespaces["pool-a"], poolNamespaces["pool-b"] = "ns-a", "ns-b"
+	t.Cleanup(func() {
+		adminAuthMode, adminAuthClient, adminVerb, adminResource = previousMode, previousClient, previousVerb, previousResource
+		setServedPools(previousPools)
+		delete(poolNamespaces, "pool-a")
+		delete(poolNamespaces, "pool-b")
+		oauthReviews.Lock()
+		clear(oauthReviews.m)
+		oauthReviews.Unlock()
+	})
+	setServedPools([]string{"pool-a", "pool-b"})
+
+	// The user may only view pool-b's claims
+	clientset := kubefake.NewSimpleClientset()
+	clientset.PrependReactor("create", "tokenreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		review := action.(clienttesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		review.Status.Authenticated = true
+		review.Status.User.Username = "alice"
+		return true, review, nil
+	})
+	clientset.PrependReactor("create", "subjectaccessreviews", func(action clienttesting.Action) (bool, runtime.Object, error) {
+		sar := action.(clienttesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		access := sar.Spec.ResourceAttributes
+		sar.Status.Allowed = access.Namespace == "ns-b" && access.Verb == "get" && access.Resource == "clusterclaims"
+		return true, sar, nil
+	})
+	adminAuthMode, adminAuthClient, adminVerb, adminResource = "oauth", clientset, "get", "clusterclaims"
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/note", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	if _, ok := adminOperator(req, "pool-a", ""); ok {
+		t.Error("user allowed on pool-a without access to its namespace")
+	}
+	if user, ok := adminOperator(req, "pool-b", ""); !ok || user != "alice" {
+		t.Errorf("adminOperator on pool-b = %q, %v, want alice allowed", user, ok)
+	}
+	// Viewing claims doesn't allow changing them
+	for _, verb := range []string{"update", "delete"} {
+		if _, ok := adminOperator(req, "pool-b", verb); ok {
+			t.Errorf("user allowed to %s pool-b's claims with only get", verb)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleAdminLogin(w, httptest.NewRequest(http.MethodPost, "/api/admin/login", strings.NewReader(`{"password":"token"}`)))
+	if w.Code != http.StatusOK {
+		t.Errorf("login status %d, want access to one pool to be enough", w.Code)
+	}
+}
+
+func TestAdminKubeconfigRequiresAdminAuth(t *testing.T) {
+	adminUsers.Lock()
+	previous := adminUsers.m
+	adminUsers.m = map[string]string{}
+	adminUsers.Unlock()
+	previousMode := adminAuthMode
+	adminAuthMode = "password"
+	t.Cleanup(func() {
+		adminUsers.Lock()
+		adminUsers.m = previous
+		adminUsers.Unlock()
+		adminAuthMode = previousMode
+	})
+
+	w := httptest.NewRecorder()
+	handleAdminKubeconfig(w, httptest.NewRequest(http.MethodGet, "/api/admin/kubeconfig?name=claim-1&type=admin", nil), nil, kubefake.NewSimpleClientset(), testPool)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("status %d, want 403 without admin auth configured", w.Code)
+	}
+	if code := errorCode(t, w); code != "admin_auth_required" {
+		t.Errorf("error %q, want admin_auth_required", code)
+	}
+}
+
+func TestAdminLoginPassword(t *testing.T) {
+	adminUsers.Lock()
+	previous := adminUsers.m
+	adminUsers.m = map[string]string{defaultAdminUser: "secret", "ops": "ops-pass"}
+	adminUsers.Unlock()
+	t.Cleanup(func() {
+		adminUsers.Lock()
+		adminUsers.m = previous
+		adminUsers.Unlock()
+	})
+
+	tests := []struct {
+		body string
+		want int
+	}{
+		{body: `{"password":"secret"}`, want: http.StatusOK},
+		{body: `{"username":"ops","password":"ops-pass"}`, want: http.StatusOK},
+		{body: `{"password":"secre"}`, want: http.StatusUnauthorized},
+		{body: `{"username":"ops","password":"secret"}`, want: http.StatusUnauthorized},
+		// Unknown operators fail even with the dummy compared against
+		{body: `{"username":"nobody","password":"` + unknownAdminPassword + `"}`, want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handleAdminLogin(w, httptest.NewRequest(http.MethodPost, "/api/admin/login", strings.NewReader(tt.body)))
+		if w.Code != tt.want {
+			t.Errorf("login %s: status %d, want %d", tt.body, w.Code, tt.want)
+		}
+	}
+}
+
+// useAdminPasswordAuth enables password-mode admin auth for "admin" with the
+// tokens persisted to a Secret on a fake clientset, which it returns.
+func useAdminPasswordAuth(t *testing.T) *kubefake.Clientset {
+	t.Helper()
+	clientset := kubefake.NewSimpleClientset()
+	adminUsers.Lock()
+	prevUsers := adminUsers.m
+	adminUsers.m = map[string]string{defaultAdminUser: "secret"}
+	adminUsers.Unlock()
+	adminTokens.Lock()
+	prevTokens := adminTokens.m
+	adminTokens.m = map[string]adminToken{}
+	adminTokens.Unlock()
+	prevMode, prevClient := adminAuthMode, adminAuthClient
+	prevName, prevNamespace := adminTokenSecretName, adminTokenSecretNamespace
+	adminAuthMode, adminAuthClient = "password", clientset
+	adminTokenSecretName, adminTokenSecretNamespace = "prelude-admin-tokens", "prelude"
+	t.Cleanup(func() {
+		adminUsers.Lock()
+		adminUsers.m = prevUsers
+		adminUsers.Unlock()
+		adminTokens.Lock()
+		adminTokens.m = prevTokens
+		adminTokens.Unlock()
+		adminAuthMode, adminAuthClient = prevMode, prevClient
+		adminTokenSecretName, adminTokenSecretNamespace = prevName, prevNamespace
+	})
+	return clientset
+}
+
+// adminLogin logs in as "admin" and returns the issued token.
+func adminLogin(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleAdminLogin(w, httptest.NewRequest(http.MethodPost, "/api/admin/login", strings.NewReader(`{"password":"secret"}`)))
+	var resp map[string]string
+	if w.Code != http.StatusOK || json.NewDecoder(w.Body).Decode(&resp) != nil || resp["token"] == "" {
+		t.Fatalf("login: status %d, body %s", w.Code, w.Body.String())
+	}
+	return resp["token"]
+}
+
+// adminRequest is a request carrying token as its bearer token.
+func adminRequest(method, target, token string) *http.Request {
+	r := httptest.NewRequest(method, target, nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// secretHasAdminToken reports whether the token Secret holds token.
+func secretHasAdminToken(t *testing.T, clientset kubernetes.Interface, token string) bool {
+	t.Helper()
+	secret, err := clientset.CoreV1().Secrets(adminTokenSecretNamespace).Get(context.Background(), adminTokenSecretName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get token secret: %v", err)
+	}
+	_, ok := secret.Data[adminTokenKey(token)]
+	return ok
+}
+
+func TestAdminTokenExpiry(t *testing.T) {
+	useAdminPasswordAuth(t)
+	token := adminLogin(t)
+	if user, ok := adminOperator(adminRequest(http.MethodGet, "/api/admin/claims", token), testPool, ""); !ok || user != defaultAdminUser {
+		t.Fatalf("fresh token: operator %q, ok %v", user, ok)
+	}
+
+	key := adminTokenKey(token)
+	adminTokens.Lock()
+	adminTokens.m[key] = adminToken{user: defaultAdminUser, issued: time.Now().Add(-adminTokenTTL - time.Minute)}
+	adminTokens.Unlock()
+	if _, ok := adminOperator(adminRequest(http.MethodGet, "/api/admin/claims", token), testPool, ""); ok {
+		t.Error("expired token accepted")
+	}
+	adminTokens.RLock()
+	_, kept := adminTokens.m[key]
+	adminTokens.RUnlock()
+	if kept {
+		t.Error("expired token not removed on use")
+	}
+}
+
+func TestSweepAdminTokens(t *testing.T) {
+	clientset := useAdminPasswordAuth(t)
+	expired, fresh := adminLogin(t), adminLogin(t)
+	stale := adminToken{user: defaultAdminUser, issued: time.Now().Add(-adminTokenTTL - time.Minute)}
+	adminTokens.Lock()
+	adminTokens.m[adminTokenKey(expired)] = stale
+	adminTokens.Unlock()
+	// Rewrite the persisted issue time too, as if another replica saw it expire
+	if err := persistAdminToken(context.Background(), clientset, adminTokenKey(expired), stale); err != nil {
+		t.Fatalf("persist: %v", err)
+	}
+
+	sweepAdminTokens(context.Background(), clientset)
+
+	adminTokens.RLock()
+	_, expiredKept := adminTokens.m[adminTokenKey(expired)]
+	_, freshKept := adminTokens.m[adminTokenKey(fresh)]
+	adminTokens.RUnlock()
+	if expiredKept || !freshKept {
+		t.Errorf("in memory: expired kept %v, fresh kept %v; want false, true", expiredKept, freshKept)
+	}
+	if secretHasAdminToken(t, clientset, expired) || !secretHasAdminToken(t, clientset, fresh) {
+		t.Error("secret: want the expired token pruned and the fresh one kept")
+	}
+}
+
+func TestAdminLogout(t *testing.T) {
+	clientset := useAdminPasswordAuth(t)
+	token := adminLogin(t)
+	if !secretHasAdminToken(t, clientset, token) {
+		t.Fatal("login did not persist the token")
+	}
+
+	w := httptest.NewRecorder()
+	handleAdminLogout(w, adminRequest(http.MethodPost, "/api/admin/logout", token))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("logout: status %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if _, ok := adminOperator(adminRequest(http.MethodGet, "/api/admin/claims", token), testPool, ""); ok {
+		t.Error("token still accepted after logout")
+	}
+	if secretHasAdminToken(t, clientset, token) {
+		t.Error("token still in the secret after logout")
+	}
+
+	// Logging out again, or with an unknown token, still succeeds
+	w = httptest.NewRecorder()
+	handleAdminLogout(w, adminRequest(http.MethodPost, "/api/admin/logout", token))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("repeat logout: status %d, want %d", w.Code, http.StatusNoContent)
+	}
+}
+
+// claimPhase posts a two-phase claim request for phone with the given
+// reservation token and returns the recorded response.
+func (f *claimFixture) claimPhase(t *testing.T, phone, phase, reservation string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := claimRequest{Phone: phone, Reservation: reservation}
+	if phase != "reserve" {
+		req.Password = "secret"
+	}
+	body, _ := json.Marshal(req)
+	target := "/api/claim"
+	if phase != "" {
+		target += "?phase=" + phase
+	}
+	w := httptest.NewRecorder()
+	handleClaim(w, httptest.NewRequest(http.MethodPost, target, strings.NewReader(string(body))), f.dynClient, f.clientset, testPool, "2h")
+	return w
+}
+
+// reserve reserves a cluster for phone and returns the reservation token.
+func (f *claimFixture) reserve(t *testing.T, phone string) string {
+	t.Helper()
+	w := f.claimPhase(t, phone, "reserve", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("reserve: status %d, body %s", w.Code, w.Body.String())
+	}
+	var resp reservationResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding reservation: %v", err)
+	}
+	if resp.Reservation == "" || resp.ReservedUntil == "" {
+		t.Fatalf("reservation response %+v has no token or hold time", resp)
+	}
+	return resp.Reservation
+}
+
+// useTwoPhaseClaim turns on --two-phase-claim for the test.
+func useTwoPhaseClaim(t *testing.T) {
+	previous := twoPhaseClaim
+	twoPhaseClaim = true
+	t.Cleanup(func() { twoPhaseClaim = previous })
+}
+
+func TestTwoPhaseClaimReserveAndConfirm(t *testing.T) {
+	useTwoPhaseClaim(t)
+	f := newClaimFixture(t, []string{"cluster-a"}, nil)
+
+	token := f.reserve(t, "15551230001")
+	claim, err := f.store.GetClaim(context.Background(), testPool, "prelude1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claim.GetLabels()["prelude"] != "15551230001" || !claimReserved(claim.GetAnnotations()) {
+		t.Fatalf("claim is not reserved for the phone: labels %v, annotations %v", claim.GetLabels(), claim.GetAnnotations())
+	}
+	if lifetime, _, _ := unstructured.NestedString(claim.Object, "spec", "lifetime"); lifetime != "" {
+		t.Errorf("reservation set spec.lifetime=%s", lifetime)
+	}
+
+	w := f.claimPhase(t, "15551230001", "confirm", token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("confirm: status %d, body %s", w.Code, w.Body.String())
+	}
+	claim, _ = f.store.GetClaim(context.Background(), testPool, "prelude1")
+	if claimReserved(claim.GetAnnotations()) {
+		t.Error("claim is still reserved after the confirm")
+	}
+	if lifetime, _, _ := unstructured.NestedString(claim.Object, "spec", "lifetime"); lifetime == "" {
+		t.Error("confirm did not set spec.lifetime")
+	}
+
+	// Reserving a confirmed claim has nothing left to hold
+	w = f.claimPhase(t, "15551230001", "reserve", "")
+	var resp reservationResponse
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusOK || !resp.Confirmed {
+		t.Errorf("reserve after confirm: status %d, response %+v, want confirmed", w.Code, resp)
+	}
+}
+
+func TestTwoPhaseClaimWrongToken(t *testing.T) {
+	useTwoPhaseClaim(t)
+	f := newClaimFixture(t, []string{"cluster-a"}, nil)
+	f.reserve(t, "15551230001")
+
+	for _, token := range []string{"", "not-the-token"} {
+		w := f.claimPhase(t, "15551230001", "confirm", token)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("confirm with token %q: status %d, want 403, body %s", token, w.Code, w.Body.String())
+		}
+		if code := errorCode(t, w); code != "invalid_reservation" {
+			t.Errorf("confirm with token %q: error %q, want invalid_reservation", token, code)
+		}
+	}
+	if claim, _ := f.store.GetClaim(context.Background(), testPool, "prelude1"); !claimReserved(claim.GetAnnotations()) {
+		t.Error("a wrong token committed the reservation")
+	}
+}
+
+func TestTwoPhaseClaimSinglePhaseKeepsReservation(t *testing.T) {
+	useTwoPhaseClaim(t)
+	f := newClaimFixture(t, []string{"cluster-a"}, nil)
+	f.reserve(t, "15551230001")
+
+	w := f.claim(t, "15551230001", "")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("single-phase claim: status %d, want 409, body %s", w.Code, w.Body.String())
+	}
+	if code := errorCode(t, w); code != "reservation_pending" {
+		t.Errorf("error %q, want reservation_pending", code)
+	}
+	claim, _ := f.store.GetClaim(context.Background(), testPool, "prelude1")
+	if !claimReserved(claim.GetAnnotations()) {
+		t.Error("a single-phase claim committed the reservation")
+	}
+	if lifetime, _, _ := unstructured.NestedString(claim.Object, "spec", "lifetime"); lifetime != "" {
+		t.Errorf("a single-phase claim set spec.lifetime=%s", lifetime)
+	}
+}
+
+func TestTwoPhaseClaimExpiry(t *testing.T) {
+	useTwoPhaseClaim(t)
+	f := newClaimFixture(t, []string{"cluster-a", "cluster-b"}, nil)
+	ctx := context.Background()
+	expire := func(name string) {
+		t.Helper()
+		past := strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)
+		if err := f.store.PatchClaim(ctx, testPool, name, nil, map[string]interface{}{reservedUntilAnnotation: past}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A confirm after the hold ran out releases the claim itself
+	token := f.reserve(t, "15551230001")
+	expire(f.assignedClaim(t, "15551230001"))
+	w := f.claimPhase(t, "15551230001", "confirm", token)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("late confirm: status %d, want 409, body %s", w.Code, w.Body.String())
+	}
+	if code := errorCode(t, w); code != "reservation_expired" {
+		t.Errorf("late confirm: error %q, want reservation_expired", code)
+	}
+	if got := f.assignedClaim(t, "15551230001"); got != "" {
+		t.Errorf("expired reservation still holds %s", got)
+	}
+
+	// The release loop returns an unconfirmed claim to the pool, keeping
+	// prelude-auth, and leaves live reservations alone
+	f.reserve(t, "15551230002")
+	f.reserve(t, "15551230003")
+	expired := f.assignedClaim(t, "15551230002")
+	expire(expired)
+	releaseExpiredReservations(ctx, testPool)
+
+	claim, _ := f.store.GetClaim(ctx, testPool, expired)
+	if claim.GetLabels()["prelude"] != "" || claimReserved(claim.GetAnnotations()) {
+		t.Errorf("expired reservation was not released: labels %v, annotations %v", claim.GetLabels(), claim.GetAnnotations())
+	}
+	if claim.GetLabels()["prelude-auth"] != "done" {
+		t.Error("releasing a reservation dropped prelude-auth")
+	}
+	if f.assignedClaim(t, "15551230003") == "" {
+		t.Error("the release loop dropped a live reservation")
+	}
+}
+
+// TestConfirmReservationLost races the release loop against a confirm: the
+// claim was released after the handler read it, so the confirm must report
+// the reservation lost rather than commit the claim.
+func TestConfirmReservationLost(t *testing.T) {
+	useTwoPhaseClaim(t)
+	f := newClaimFixture(t, []string{"cluster-a"}, nil)
+	ctx := context.Background()
+	f.reserve(t, "15551230001")
+	stale, err := f.store.GetClaim(ctx, testPool, "prelude1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := releaseReservation(ctx, testPool, stale.DeepCopy()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := confirmReservation(ctx, testPool, stale, "15551230001", "2h", ""); !errors.Is(err, errReservationLost) {
+		t.Errorf("confirming a released reservation: err = %v, want errReservationLost", err)
+	}
+	if got := f.assignedClaim(t, "15551230001"); got != "" {
+		t.Errorf("released reservation was committed to %s", got)
+	}
+}
+
+// redeem posts a magic link token and returns the recorded response.
+func redeem(token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handleMagicLink(w, httptest.NewRequest(http.MethodPost, "/api/magic", strings.NewReader(`{"token":"`+token+`"}`)))
+	return w
+}
+
+func TestMagicLinkSingleUse(t *testing.T) {
+	previous := magicLinkEnabled
+	magicLinkEnabled = true
+	t.Cleanup(func() { magicLinkEnabled = previous })
+
+	token, err := newMagicLink(magicLink{cluster: "cluster-a", password: "generated", webConsoleURL: "https://console.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := redeem(token)
+	if w.Code != http.StatusOK {
+		t.Fatalf("redeem: status %d, body %s", w.Code, w.Body.String())
+	}
+	var resp magicLinkResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Username != "admin" || resp.Password != "generated" || resp.WebConsoleURL != "https://console.example.com" {
+		t.Errorf("redeemed %+v, want the stored credentials", resp)
+	}
+
+	w = redeem(token)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("second redeem: status %d, want 404", w.Code)
+	}
+	if code := errorCode(t, w); code != "invalid_magic_link" {
+		t.Errorf("second redeem: error %q, want invalid_magic_link", code)
+	}
+}
+
+func TestMagicLinkExpires(t *testing.T) {
+	previous := magicLinkEnabled
+	magicLinkEnabled = true
+	t.Cleanup(func() { magicLinkEnabled = previous })
+
+	token, err := newMagicLink(magicLink{cluster: "cluster-a", password: "generated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	magicLinks.Lock()
+	l := magicLinks.m[token]
+	l.expires = time.Now().Add(-time.Second)
+	magicLinks.m[token] = l
+	magicLinks.Unlock()
+
+	w := redeem(token)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expired link: status %d, want 404, body %s", w.Code, w.Body.String())
+	}
+	if code := errorCode(t, w); code != "invalid_magic_link" {
+		t.Errorf("expired link: error %q, want invalid_magic_link", code)
+	}
+}
+
+func TestMagicLinkDisabled(t *testing.T) {
+	previous := magicLinkEnabled
+	magicLinkEnabled = false
+	t.Cleanup(func() { magicLinkEnabled = previous })
+
+	token, err := newMagicLink(magicLink{cluster: "cluster-a", password: "generated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := redeem(token)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want 404 with magic links disabled", w.Code)
+	}
+	if code := errorCode(t, w); code != "magic_link_disabled" {
+		t.Errorf("error %q, want magic_link_disabled", code)
+	}
+}
+
+func TestClaimNamespaceFallback(t *testing.T) {
+	previous, previousMapped := clusterPoolNamespace, poolNamespaces
+	t.Cleanup(func() { clusterPoolNamespace, poolNamespaces = previous, previousMapped })
+	// As set by --claim-namespace events and --pool-namespace pool-a=ns-a
+	clusterPoolNamespace, poolNamespaces = "events", map[string]string{"pool-a": "ns-a"}
+
+	if ns := claimNamespace("pool-a"); ns != "ns-a" {
+		t.Errorf("mapped pool reads claims from %s, want ns-a", ns)
+	}
+	if ns := claimNamespace("pool-b"); ns != "events" {
+		t.Errorf("unmapped pool reads claims from %s, want the --claim-namespace events", ns)
+	}
+}
+
+// validate posts a validation request for phone and returns the recorded
+// response.
+func validate(phone string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handleValidate(w, httptest.NewRequest(http.MethodPost, "/api/validate", strings.NewReader(`{"phone":"`+phone+`"}`)))
+	return w
+}
+
+func TestHandleValidate(t *testing.T) {
+	previousClaim, previousValidate, previousSecret := claimLimiter, validateLimiter, captchaSecretKey
+	claimLimiter, validateLimiter, captchaSecretKey = newRateLimiter(2, time.Minute), newRateLimiter(10, time.Minute), ""
+	t.Cleanup(func() {
+		claimLimiter, validateLimiter, captchaSecretKey = previousClaim, previousValidate, previousSecret
+	})
+
+	result := func(phone string) validateResponse {
+		t.Helper()
+		w := validate(phone)
+		if w.Code != http.StatusOK {
+			t.Fatalf("validate %q: status %d, body %s", phone, w.Code, w.Body.String())
+		}
+		var resp validateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := result("+1 555 123 0001"); !resp.Valid || resp.Reason != "" {
+		t.Errorf("valid phone: %+v", resp)
+	}
+	if resp := result("---"); resp.Valid || resp.Reason != "phone_required" {
+		t.Errorf("empty phone: %+v, want phone_required", resp)
+	}
+	if resp := result(strings.Repeat("1", 70)); resp.Valid || resp.Reason != "invalid_phone" {
+		t.Errorf("overlong phone: %+v, want invalid_phone", resp)
+	}
+
+	// Validating never uses up the claim's attempts
+	for i := 0; i < 3; i++ {
+		result("15551230002")
+	}
+	if claimLimiter.limited("15551230002") {
+		t.Fatal("validating used up the claim attempts")
+	}
+	// A phone the claim endpoint would turn away is reported as such
+	claimLimiter.allow("15551230002")
+	claimLimiter.allow("15551230002")
+	if resp := result("15551230002"); resp.Valid || resp.Reason != "rate_limited" {
+		t.Errorf("phone past the claim limit: %+v, want rate_limited", resp)
+	}
+}
+
+func TestHandleValidateRateLimited(t *testing.T) {
+	previousValidate, previousSecret := validateLimiter, captchaSecretKey
+	validateLimiter, captchaSecretKey = newRateLimiter(2, time.Minute), ""
+	t.Cleanup(func() { validateLimiter, captchaSecretKey = previousValidate, previousSecret })
+
+	for i := 0; i < 2; i++ {
+		if w := validate("15551230001"); w.Code != http.StatusOK {
+			t.Fatalf("attempt %d: status %d", i+1, w.Code)
+		}
+	}
+	w := validate("15551230001")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("attempt past the limit: status %d, want 429", w.Code)
+	}
+	if code := errorCode(t, w); code != "rate_limited" {
+		t.Errorf("error %q, want rate_limited", code)
+	}
+	if w := validate("15551230002"); w.Code != http.StatusOK {
+		t.Errorf("another phone: status %d, want its own limit", w.Code)
+	}
+}
+
+// rebind posts an admin rebind of the named claim and returns the recorded
+// response.
+func rebind(name string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handleAdminRebind(w, httptest.NewRequest(http.MethodPost, "/api/admin/rebind", strings.NewReader(`{"name":"`+name+`"}`)), testPool)
+	return w
+}
+
+func TestAdminRebind(t *testing.T) {
+	f := newClaimFixture(t, []string{"cluster-a", "cluster-b"}, map[string]map[string]string{
+		"prelude1": {"prelude-auth": "done", "prelude": "15551230001", "prelude-fp": "abcdef"},
+	})
+	ctx := context.Background()
+
+	w := rebind("prelude1")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", w.Code, w.Body.String())
+	}
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.store.GetClaim(ctx, testPool, "prelude1"); !k8serrors.IsNotFound(err) {
+		t.Errorf("old claim still exists: %v", err)
+	}
+	replacement, err := f.store.GetClaim(ctx, testPool, resp["replacement"])
+	if err != nil {
+		t.Fatalf("replacement %q: %v", resp["replacement"], err)
+	}
+	if !strings.HasPrefix(replacement.GetName(), "prelude1-") {
+		t.Errorf("replacement named %s, want it based on prelude1", replacement.GetName())
+	}
+	labels := replacement.GetLabels()
+	if labels["prelude"] != "15551230001" || labels["prelude-fp"] != "abcdef" || labels["prelude-auth"] != "" {
+		t.Errorf("replacement labels %v, want the phone and fingerprint, not yet authenticated", labels)
+	}
+	if got := replacement.GetAnnotations()[rebindOfAnnotation]; got != "prelude1" {
+		t.Errorf("%s = %q, want prelude1", rebindOfAnnotation, got)
+	}
+	// The phone waits for the replacement rather than getting the free cluster
+	if w := f.claim(t, "15551230001", "abcdef"); w.Code != http.StatusAccepted {
+		t.Errorf("claim after rebind: status %d, want 202 while the replacement is set up", w.Code)
+	}
+}
+
+func TestAdminRebindRejects(t *testing.T) {
+	newClaimFixture(t, []string{"cluster-a", "cluster-b"}, map[string]map[string]string{
+		"prelude1": {"prelude-auth": "done", "prelude": "15551230001"},
+	})
+
+	tests := []struct {
+		name       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "unknown", wantStatus: http.StatusNotFound, wantCode: "not_found"},
+		{name: "prelude2", wantStatus: http.StatusConflict, wantCode: "not_assigned"},
+	}
+	for _, tt := range tests {
+		w := rebind(tt.name)
+		if w.Code != tt.wantStatus {
+			t.Errorf("rebind %s: status %d, want %d", tt.name, w.Code, tt.wantStatus)
+			continue
+		}
+		if code := errorCode(t, w); code != tt.wantCode {
+			t.Errorf("rebind %s: error %q, want %s", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+// releaseOnCreate is a claim store where the named claim is released just
+// after any claim is created, as if the server released it mid-rebind.
+type releaseOnCreate struct {
+	*memClaimStore
+	name string
+}
+
+func (r releaseOnCreate) CreateClaim(ctx context.Context, pool string, claim *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	created, err := r.memClaimStore.CreateClaim(ctx, pool, claim)
+	if err == nil {
+		err = r.ReleaseClaim(ctx, pool, r.name)
+	}
+	return created, err
+}
+
+func TestAdminRebindConflict(t *testing.T) {
+	f := newClaimFixture(t, []string{"cluster-a", "cluster-b"}, map[string]map[string]string{
+		"prelude1": {"prelude-auth": "done", "prelude": "15551230001"},
+	})
+	useClaimStore(t, releaseOnCreate{memClaimStore: f.store, name: "prelude1"})
+
+	w := rebind("prelude1")
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status %d, want 409, body %s", w.Code, w.Body.String())
+	}
+	if code := errorCode(t, w); code != "claim_changed" {
+		t.Errorf("error %q, want claim_changed", code)
+	}
+	// The released claim keeps its cluster and the replacement is gone
+	list, err := f.store.ListClaims(context.Background(), testPool, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, claim := range list.Items {
+		names = append(names, claim.GetName())
+	}
+	if len(names) != 2 || !slices.Contains(names, "prelude1") || !slices.Contains(names, "prelude2") {
+		t.Errorf("claims after the conflict = %v, want only prelude1 and prelude2", names)
+	}
+}
+
+func TestOrderCandidates(t *testing.T) {
+	// cluster-short has 10 minutes left, cluster-long a week;
+	// cluster-unknown has no delete-after and there is no --cluster-ttl
+	deployment := func(cluster, deleteAfter string) runtime.Object {
+		cd := testDeployment(cluster)
+		cd.SetCreationTimestamp(metav1.Now())
+		if deleteAfter != "" {
+			cd.SetAnnotations(map[string]string{"hive.openshift.io/delete-after": deleteAfter})
+		}
+		return cd
+	}
+	dynClient := testDynamicClient(deployment("cluster-short", "10m"), deployment("cluster-long", "168h"), deployment("cluster-unknown", ""))
+	claims := []unstructured.Unstructured{
+		*testClaim("prelude1", "cluster-short", nil),
+		*testClaim("prelude2", "cluster-long", nil),
+		*testClaim("prelude3", "cluster-unknown", nil),
+	}
+	previousWeight, previousTTL := claimWeight, clusterTTL
+	t.Cleanup(func() { claimWeight, clusterTTL = previousWeight, previousTTL })
+	clusterTTL = 0
+
+	const trials = 400
+	tests := []struct {
+		name       string
+		weight     func(time.Duration) float64
+		candidates []int
+		// wantFirst is the candidate that should nearly always come first,
+		// or -1 when each should come first about half the time
+		wantFirst int
+	}{
+		{name: "uniform", weight: nil, candidates: []int{0, 1}, wantFirst: -1},
+		{name: "most-remaining", weight: weightMostRemaining, candidates: []int{0, 1}, wantFirst: 1},
+		{name: "least-remaining", weight: weightLeastRemaining, candidates: []int{0, 1}, wantFirst: 0},
+		{name: "unknown lifetime falls back to uniform", weight: weightMostRemaining, candidates: []int{1, 2}, wantFirst: -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claimWeight = tt.weight
+			first := map[int]int{}
+			for i := 0; i < trials; i++ {
+				indices := slices.Clone(tt.candidates)
+				orderCandidates(context.Background(), dynClient, testPool, claims, indices)
+				if !slices.Equal(slices.Sorted(slices.Values(indices)), tt.candidates) {
+					t.Fatalf("ordering %v lost or added candidates of %v", indices, tt.candidates)
+				}
+				first[indices[0]]++
+			}
+			if tt.wantFirst >= 0 {
+				if first[tt.wantFirst] < trials*95/100 {
+					t.Errorf("candidate %d first in %d of %d orderings, want nearly all", tt.wantFirst, first[tt.wantFirst], trials)
+				}
+				return
+			}
+			for _, c := range tt.candidates {
+				if first[c] < trials/4 {
+					t.Errorf("candidate %d first in %d of %d orderings, want about half", c, first[c], trials)
+				}
+			}
+		})
+	}
+}
+
+func TestNotifyExpiringClaims(t *testing.T) {
+	store := newMemClaimStore()
+	ctx := context.Background()
+	for name, lifetime := range map[string]string{"prelude1": "10m", "prelude2": "5h"} {
+		claim := testClaim(name, "cluster-"+name, map[string]string{"prelude-auth": "done", "prelude": "1555123000" + name[len(name)-1:]})
+		unstructured.SetNestedField(claim.Object, lifetime, "spec", "lifetime")
+		if _, err := store.CreateClaim(ctx, testPool, claim); err != nil {
+			t.Fatal(err)
+		}
+	}
+	useClaimStore(t, store)
+
+	var mu sync.Mutex
+	var received []expiryNotification
+	status := http.StatusInternalServerError
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n expiryNotification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("decoding notification: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, n)
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(webhook.Close)
+	previousWarning, previousWebhook := expiryWarning, expiryWebhook
+	expiryWarning, expiryWebhook = 15*time.Minute, webhook.URL
+	t.Cleanup(func() { expiryWarning, expiryWebhook = previousWarning, previousWebhook })
+
+	notified := func() string {
+		t.Helper()
+		claim, err := store.GetClaim(ctx, testPool, "prelude1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return claim.GetAnnotations()[expiryNotifiedAnnotation]
+	}
+
+	// A failed delivery leaves the claim to be retried
+	notifyExpiringClaims(ctx, testPool)
+	if len(received) != 1 || received[0].Claim != "prelude1" || received[0].Phone != "15551230001" || received[0].Cluster != "cluster-prelude1" {
+		t.Fatalf("notifications %+v, want one for prelude1", received)
+	}
+	if got := notified(); got != "" {
+		t.Fatalf("claim marked notified (%s) after the webhook failed", got)
+	}
+
+	mu.Lock()
+	status = http.StatusNoContent
+	mu.Unlock()
+	notifyExpiringClaims(ctx, testPool)
+	if len(received) != 2 {
+		t.Fatalf("%d notifications, want the failed one retried", len(received))
+	}
+	if notified() == "" {
+		t.Fatal("claim not marked notified after delivery")
+	}
+
+	// Notified once only
+	notifyExpiringClaims(ctx, testPool)
+	if len(received) != 2 {
+		t.Errorf("%d notifications, want no repeat once delivered", len(received))
+	}
+}